@@ -0,0 +1,283 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/appconf"
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/plan"
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/resources"
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient"
+	"github.com/TwiN/go-color"
+	"github.com/spf13/viper"
+)
+
+// pendingApiInstanceReference matches a reference to an API instance that is kept because the ID is not known yet
+var pendingApiInstanceReference = regexp.MustCompile(`\$\{apiInstance\(([^()]*)\)\}`)
+
+// pendingApiInstance returns the reference kept in place of the ID of an API instance that is not known yet
+func pendingApiInstance(name string) string {
+	return "${apiInstance(" + name + ")}"
+}
+
+// apiInstanceRegistry maps the names of the ApiInstance descriptors of a run to the IDs of their instances
+type apiInstanceRegistry struct {
+	mu  sync.Mutex
+	ids map[string]int
+}
+
+func newApiInstanceRegistry() *apiInstanceRegistry {
+	return &apiInstanceRegistry{ids: map[string]int{}}
+}
+
+// register records the ID of a deployed API instance. The ID is 0 when a dry run would create the instance.
+func (registry *apiInstanceRegistry) register(name string, id int) error {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if _, ok := registry.ids[name]; ok {
+		return fmt.Errorf("API instance %s is declared by more than one ApiInstance descriptor", name)
+	}
+	registry.ids[name] = id
+	return nil
+}
+
+// lookup returns the ID of a deployed API instance for ${apiInstance(name)}. The reference to an instance that a
+// dry run would create is kept, it is planned as pending and resolved when the plan is applied.
+func (registry *apiInstanceRegistry) lookup(name string) (string, error) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	id, ok := registry.ids[name]
+	switch {
+	case !ok:
+		return "", fmt.Errorf("API instance %s is not deployed by an ApiInstance descriptor of this run", name)
+	case id == 0:
+		return pendingApiInstance(name), nil
+	}
+	return strconv.Itoa(id), nil
+}
+
+// resolvePending replaces the references to pending API instances in the payload of a planned change with the
+// IDs of the instances created since
+func (registry *apiInstanceRegistry) resolvePending(payload json.RawMessage) (json.RawMessage, error) {
+	var err error
+	resolved := pendingApiInstanceReference.ReplaceAllStringFunc(string(payload), func(reference string) string {
+		name := pendingApiInstanceReference.FindStringSubmatch(reference)[1]
+		id, lookupErr := registry.lookup(name)
+		if lookupErr == nil && id == reference {
+			lookupErr = fmt.Errorf("API instance %s has not been created", name)
+		}
+		if lookupErr != nil {
+			err = lookupErr
+		}
+		return id
+	})
+	return json.RawMessage(resolved), err
+}
+
+// planPendingApiPolicies records the policies of an API instance that a dry run would create. The instance has
+// no policies yet, so every policy is created.
+func planPendingApiPolicies(name string, apipolicies resources.ApiPoliciesV1, changes *plan.Recorder) {
+	for _, apipolicy := range apipolicies.Spec.Policies {
+		changes.Add(plan.Change{
+			Kind:        "ApiPolicy",
+			Name:        fmt.Sprintf("%s/%s:%s", name, apipolicy.GroupID, apipolicy.AssetID),
+			Action:      plan.ActionCreate,
+			Fingerprint: plan.Fingerprint(nil),
+			Payload:     plan.NewPayload(apiPolicyPayload{ApiInstance: name, Policy: apipolicy}),
+		})
+		log.Println(color.Colorize(color.Yellow, fmt.Sprintf("[DRY-RUN] Would CREATE API Policy %s:%s:%s for API instance %s once it is created", apipolicy.GroupID, apipolicy.AssetID, apipolicy.AssetVersion, name)))
+	}
+}
+
+// deployApiInstance creates or updates the API instance of an ApiInstance descriptor and registers its ID,
+// so that the descriptors deployed after it can refer to it
+func deployApiInstance(ctx context.Context, apiInstance resources.ApiInstanceV1, client anypointclient.ApiManager, organization anypointclient.Organization, environment anypointclient.Environment, apiInstances *apiInstanceRegistry, changes *plan.Recorder) error {
+	spec := apiInstance.Spec
+	if err := validateApiInstance(apiInstance); err != nil {
+		return err
+	}
+	dryRun := viper.GetBool("dry-run")
+	request := anypointclient.ApiInstanceRequest{
+		Spec:          anypointclient.ApiInstanceAsset{GroupID: spec.GroupID, AssetID: spec.AssetID, Version: spec.Version},
+		Endpoint:      anypointclient.ApiInstanceEndpoint{DeploymentType: spec.DeploymentType, URI: spec.EndpointURI},
+		Technology:    spec.Technology,
+		InstanceLabel: spec.InstanceLabel,
+	}
+
+	existing, err := findDeclaredApiInstance(ctx, client, organization, environment, request)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		log.Println(color.Colorize(color.Yellow, fmt.Sprintf("API instance %s of %s:%s not found", spec.Name, spec.GroupID, spec.AssetID)))
+		changes.Add(plan.Change{
+			Kind:        "ApiInstance",
+			Name:        spec.Name,
+			Action:      plan.ActionCreate,
+			Fingerprint: plan.Fingerprint(nil),
+			Payload:     plan.NewPayload(apiInstancePayload{Instance: request}),
+		})
+		if dryRun {
+			log.Println(color.Colorize(color.Yellow, fmt.Sprintf("[DRY-RUN] Would CREATE API instance %s of %s:%s:%s", spec.Name, spec.GroupID, spec.AssetID, spec.Version)))
+			return apiInstances.register(spec.Name, 0)
+		}
+		created, err := client.CreateApiInstance(organization.ID, environment.ID, request)
+		if err != nil {
+			return fmt.Errorf("failed to create API instance %s: %v", spec.Name, err)
+		}
+		log.Println(color.Colorize(color.Green, fmt.Sprintf("API instance %s successfully created with ID %d", spec.Name, created.ID)))
+		return apiInstances.register(spec.Name, created.ID)
+	}
+
+	// The list of API instances has no endpoint, fetch the instance itself
+	current, err := client.GetApiInstance(organization.ID, environment.ID, existing.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get API instance %d: %v", existing.ID, err)
+	}
+	if current.Technology != "" && current.Technology != spec.Technology {
+		return fmt.Errorf("API instance %s is a %s instance and can not be changed to %s", spec.Name, current.Technology, spec.Technology)
+	}
+	fieldChanges := apiInstanceChanges(request, *current)
+	payload := plan.NewPayload(apiInstancePayload{ApiInstanceID: current.ID, Instance: request})
+	if len(fieldChanges) == 0 && !viper.GetBool("force-update") {
+		changes.Add(plan.Change{Kind: "ApiInstance", Name: spec.Name, Action: plan.ActionNone, Fingerprint: apiInstanceFingerprint(current), Payload: payload})
+		log.Println(color.Colorize(color.Blue, fmt.Sprintf("API instance %s (%d) already configured correctly", spec.Name, current.ID)))
+		return apiInstances.register(spec.Name, current.ID)
+	}
+	changes.Add(plan.Change{
+		Kind:        "ApiInstance",
+		Name:        spec.Name,
+		Action:      plan.ActionUpdate,
+		Forced:      len(fieldChanges) == 0,
+		Fields:      fieldChanges,
+		Fingerprint: apiInstanceFingerprint(current),
+		Payload:     payload,
+	})
+	if dryRun {
+		log.Println(color.Colorize(color.Yellow, fmt.Sprintf("[DRY-RUN] Would UPDATE API instance %s (%d)", spec.Name, current.ID)))
+		return apiInstances.register(spec.Name, current.ID)
+	}
+	if _, err := client.UpdateApiInstance(organization.ID, environment.ID, current.ID, request); err != nil {
+		return fmt.Errorf("failed to update API instance %s (%d): %v", spec.Name, current.ID, err)
+	}
+	log.Println(color.Colorize(color.Green, fmt.Sprintf("API instance %s (%d) successfully updated", spec.Name, current.ID)))
+	return apiInstances.register(spec.Name, current.ID)
+}
+
+func validateApiInstance(apiInstance resources.ApiInstanceV1) error {
+	spec := apiInstance.Spec
+	switch {
+	case spec.Name == "":
+		return fmt.Errorf("an ApiInstance needs a name for other descriptors to refer to it")
+	case spec.GroupID == "" || spec.AssetID == "" || spec.Version == "":
+		return fmt.Errorf("API instance %s needs the groupId, assetId and version of its Exchange asset", spec.Name)
+	case spec.Technology != "mule4" && spec.Technology != "flexGateway":
+		return fmt.Errorf("API instance %s has technology %q, expected mule4 or flexGateway", spec.Name, spec.Technology)
+	}
+	return nil
+}
+
+// findDeclaredApiInstance returns the API instance of the environment with the asset and instance label of the
+// request, or nil if there is none
+func findDeclaredApiInstance(ctx context.Context, client anypointclient.ApiManager, organization anypointclient.Organization, environment anypointclient.Environment, request anypointclient.ApiInstanceRequest) (*anypointclient.ApiInstance, error) {
+	var matches []anypointclient.ApiInstance
+	for instance, err := range client.ApisPaginator(organization.ID, environment.ID).All(ctx) {
+		if err != nil {
+			return nil, fmt.Errorf("failed to list API instances: %w", err)
+		}
+		if instance.GroupID == request.Spec.GroupID && instance.AssetID == request.Spec.AssetID && instance.InstanceLabel == request.InstanceLabel {
+			matches = append(matches, instance)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, nil
+	case 1:
+		return &matches[0], nil
+	}
+	return nil, fmt.Errorf("%d API instances of %s:%s have the instance label %q, give the instance a label of its own",
+		len(matches), request.Spec.GroupID, request.Spec.AssetID, request.InstanceLabel)
+}
+
+// apiInstanceChanges returns the fields where the existing API instance differs from the desired one
+func apiInstanceChanges(desired anypointclient.ApiInstanceRequest, current anypointclient.ApiInstance) []appconf.FieldChange {
+	var changes fieldChanges
+	currentRequest := apiInstanceRequest(current)
+	changes.compare("version", currentRequest.Spec.Version, desired.Spec.Version)
+	changes.compare("endpointUri", currentRequest.Endpoint.URI, desired.Endpoint.URI)
+	changes.compare("deploymentType", currentRequest.Endpoint.DeploymentType, desired.Endpoint.DeploymentType)
+	return changes
+}
+
+// apiInstanceRequest returns the request that gives the API instance its current state
+func apiInstanceRequest(instance anypointclient.ApiInstance) anypointclient.ApiInstanceRequest {
+	request := anypointclient.ApiInstanceRequest{
+		Spec:          anypointclient.ApiInstanceAsset{GroupID: instance.GroupID, AssetID: instance.AssetID, Version: instance.AssetVersion},
+		Endpoint:      anypointclient.ApiInstanceEndpoint{URI: instance.EndpointURI},
+		Technology:    instance.Technology,
+		InstanceLabel: instance.InstanceLabel,
+	}
+	if instance.Endpoint != nil {
+		request.Endpoint = *instance.Endpoint
+	}
+	return request
+}
+
+// apiInstanceFingerprint fingerprints the API instance while ignoring the fields that change while it is in use,
+// such as its last activity. An instance that does not exist has the same fingerprint as nil.
+func apiInstanceFingerprint(instance *anypointclient.ApiInstance) string {
+	if instance == nil {
+		return plan.Fingerprint(nil)
+	}
+	return plan.Fingerprint(apiInstanceRequest(*instance))
+}
+
+// resolveApiInstanceID returns the ID of the API instance of an ApiPolicies descriptor, given either by its ID or by a selector
+func resolveApiInstanceID(ctx context.Context, apipolicies resources.ApiPoliciesV1, client anypointclient.ApiManager, organization anypointclient.Organization, environment anypointclient.Environment) (int, error) {
+	spec := apipolicies.Spec
+	switch {
+	case spec.ApiInstanceID != "" && spec.ApiInstance != nil:
+		return 0, fmt.Errorf("set either apiInstanceId or apiInstance, not both")
+	case spec.ApiInstance != nil:
+		return findApiInstance(ctx, *spec.ApiInstance, client, organization, environment)
+	}
+	id, err := strconv.Atoi(spec.ApiInstanceID)
+	if err != nil {
+		return 0, fmt.Errorf("invalid API instance ID: %s, error: %v", spec.ApiInstanceID, err)
+	}
+	return id, nil
+}
+
+// findApiInstance returns the ID of the only API instance of the environment matching the selector
+func findApiInstance(ctx context.Context, selector anypointclient.ApiInstanceSelector, client anypointclient.ApiManager, organization anypointclient.Organization, environment anypointclient.Environment) (int, error) {
+	if err := selector.Validate(); err != nil {
+		return 0, err
+	}
+	var matches []anypointclient.ApiInstance
+	for instance, err := range client.ApisPaginator(organization.ID, environment.ID).All(ctx) {
+		if err != nil {
+			return 0, fmt.Errorf("failed to list API instances: %w", err)
+		}
+		if selector.Matches(instance) {
+			matches = append(matches, instance)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return 0, fmt.Errorf("no API instance in environment %s matches %s", environment.Name, selector)
+	case 1:
+		return matches[0].ID, nil
+	}
+	candidates := make([]string, 0, len(matches))
+	for _, instance := range matches {
+		candidates = append(candidates, fmt.Sprintf("%d (productVersion=%s instanceLabel=%s)", instance.ID, instance.ProductVersion, instance.InstanceLabel))
+	}
+	return 0, fmt.Errorf("%d API instances in environment %s match %s, narrow the selector down to one of: %s",
+		len(matches), environment.Name, selector, strings.Join(candidates, ", "))
+}
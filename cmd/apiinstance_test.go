@@ -0,0 +1,290 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/plan"
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/resources"
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient"
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient/anypointclienttest"
+)
+
+// selectorFake returns a fake with more API instances than fit on a page, and two instances of orders-api
+// with the labels blue and green
+func selectorFake() (*anypointclienttest.Fake, anypointclient.ApiInstance) {
+	fake := anypointclienttest.NewFake(testOrganization)
+	for i := range anypointclient.DefaultPageSize {
+		fake.AddApi(testEnvironment, anypointclient.ApiInstance{GroupID: "org-id", AssetID: fmt.Sprintf("api-%d", i), ProductVersion: "v1"})
+	}
+	fake.AddApi(testEnvironment, anypointclient.ApiInstance{GroupID: "org-id", AssetID: "orders-api", ProductVersion: "v1", InstanceLabel: "blue"})
+	green := fake.AddApi(testEnvironment, anypointclient.ApiInstance{GroupID: "org-id", AssetID: "orders-api", ProductVersion: "v1", InstanceLabel: "green", AutodiscoveryInstanceName: "v1:orders-green"})
+	return fake, green
+}
+
+func TestResolveApiInstanceID(t *testing.T) {
+	fake, green := selectorFake()
+	for name, selector := range map[string]anypointclient.ApiInstanceSelector{
+		"asset and label":    {GroupID: "org-id", AssetID: "orders-api", InstanceLabel: "green"},
+		"autodiscovery name": {AutodiscoveryName: "v1:orders-green"},
+	} {
+		var apipolicies resources.ApiPoliciesV1
+		apipolicies.Spec.ApiInstance = &selector
+		id, err := resolveApiInstanceID(context.Background(), apipolicies, fake, testOrganization, testEnvironment)
+		if err != nil || id != green.ID {
+			t.Errorf("%s: expected API instance %d, got %d: %v", name, green.ID, id, err)
+		}
+	}
+	if calls := slices.DeleteFunc(fake.Calls(), func(call string) bool { return call != "GetApis" }); len(calls) != 4 {
+		t.Errorf("expected two pages of API instances per selector, got %v", calls)
+	}
+}
+
+func TestResolveApiInstanceIDFailures(t *testing.T) {
+	fake, _ := selectorFake()
+	cases := []struct {
+		expected      string
+		apiInstanceID string
+		selector      *anypointclient.ApiInstanceSelector
+	}{
+		{"no API instance in environment Sandbox matches asset=org-id:orders-api instanceLabel=red", "", &anypointclient.ApiInstanceSelector{GroupID: "org-id", AssetID: "orders-api", InstanceLabel: "red"}},
+		{"2 API instances in environment Sandbox match asset=org-id:orders-api productVersion=v1", "", &anypointclient.ApiInstanceSelector{GroupID: "org-id", AssetID: "orders-api", ProductVersion: "v1"}},
+		{"needs autodiscoveryName, or groupId and assetId", "", &anypointclient.ApiInstanceSelector{AssetID: "orders-api"}},
+		{"either apiInstanceId or apiInstance", "42", &anypointclient.ApiInstanceSelector{AutodiscoveryName: "v1:orders-green"}},
+		{"invalid API instance ID", "orders-api", nil},
+	}
+	for _, c := range cases {
+		var apipolicies resources.ApiPoliciesV1
+		apipolicies.Spec.ApiInstanceID = c.apiInstanceID
+		apipolicies.Spec.ApiInstance = c.selector
+		_, err := resolveApiInstanceID(context.Background(), apipolicies, fake, testOrganization, testEnvironment)
+		if err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("expected an error containing %q, got %v", c.expected, err)
+		}
+	}
+}
+
+func TestResolveApiInstanceIDIsCancelled(t *testing.T) {
+	fake, _ := selectorFake()
+	var apipolicies resources.ApiPoliciesV1
+	apipolicies.Spec.ApiInstance = &anypointclient.ApiInstanceSelector{AutodiscoveryName: "v1:orders-green"}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := resolveApiInstanceID(ctx, apipolicies, fake, testOrganization, testEnvironment); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the search to stop with the run, got %v", err)
+	}
+}
+
+func testApiInstance() resources.ApiInstanceV1 {
+	var apiInstance resources.ApiInstanceV1
+	apiInstance.Spec.Name = "orders"
+	apiInstance.Spec.GroupID = "org-id"
+	apiInstance.Spec.AssetID = "orders-api"
+	apiInstance.Spec.Version = "1.0.0"
+	apiInstance.Spec.Technology = "mule4"
+	apiInstance.Spec.InstanceLabel = "orders"
+	apiInstance.Spec.EndpointURI = "https://orders.example.com"
+	apiInstance.Spec.DeploymentType = "CH2"
+	return apiInstance
+}
+
+func TestDeployApiInstance(t *testing.T) {
+	setFlag(t, "dry-run", false)
+	fake := anypointclienttest.NewFake(testOrganization)
+	fake.AddApi(testEnvironment, anypointclient.ApiInstance{GroupID: "org-id", AssetID: "orders-api", InstanceLabel: "orders-legacy"})
+
+	apiInstances := newApiInstanceRegistry()
+	if err := deployApiInstance(context.Background(), testApiInstance(), fake, testOrganization, testEnvironment, apiInstances, nil); err != nil {
+		t.Fatal(err)
+	}
+	if calls := mutatingCalls(fake); !slices.Equal(calls, []string{"CreateApiInstance"}) {
+		t.Errorf("expected the API instance to be created, got %v", calls)
+	}
+	created, err := fake.GetApis("org-id", "env-id", 0, 10)
+	if err != nil || len(created.Instances) != 2 || created.Instances[1].EndpointURI != "https://orders.example.com" {
+		t.Fatalf("unexpected API instances %+v: %v", created, err)
+	}
+	if id, err := apiInstances.lookup("orders"); err != nil || id != strconv.Itoa(created.Instances[1].ID) {
+		t.Errorf("expected the ID of the new instance to be registered, got %s: %v", id, err)
+	}
+
+	// A new version is an update of the same instance
+	apiInstances = newApiInstanceRegistry()
+	fake.Reset()
+	updated := testApiInstance()
+	updated.Spec.Version = "1.1.0"
+	changes := plan.New(testOrganization, testEnvironment)
+	if err := deployApiInstance(context.Background(), updated, fake, testOrganization, testEnvironment, apiInstances, changes.Source("orders.json")); err != nil {
+		t.Fatal(err)
+	}
+	if calls := mutatingCalls(fake); !slices.Equal(calls, []string{"UpdateApiInstance"}) {
+		t.Errorf("expected the API instance to be updated, got %v", calls)
+	}
+	if fields := changes.Changes[0].Fields; len(fields) != 1 || fields[0].Field != "version" {
+		t.Errorf("expected only the version to change, got %+v", fields)
+	}
+
+	apiInstances = newApiInstanceRegistry()
+	fake.Reset()
+	if err := deployApiInstance(context.Background(), updated, fake, testOrganization, testEnvironment, apiInstances, nil); err != nil {
+		t.Fatal(err)
+	}
+	if calls := mutatingCalls(fake); len(calls) != 0 {
+		t.Errorf("expected the API instance to be up to date, got %v", calls)
+	}
+
+	flexGateway := updated
+	flexGateway.Spec.Technology = "flexGateway"
+	apiInstances = newApiInstanceRegistry()
+	if err := deployApiInstance(context.Background(), flexGateway, fake, testOrganization, testEnvironment, apiInstances, nil); err == nil || !strings.Contains(err.Error(), "can not be changed to flexGateway") {
+		t.Errorf("expected the technology change to be refused, got %v", err)
+	}
+}
+
+func TestDeployApiInstanceDryRun(t *testing.T) {
+	setFlag(t, "dry-run", true)
+	fake := anypointclienttest.NewFake(testOrganization)
+	apiInstances := newApiInstanceRegistry()
+
+	if err := deployApiInstance(context.Background(), testApiInstance(), fake, testOrganization, testEnvironment, apiInstances, nil); err != nil {
+		t.Fatal(err)
+	}
+	if calls := mutatingCalls(fake); len(calls) != 0 {
+		t.Errorf("expected nothing to be changed in a dry run, got %v", calls)
+	}
+	if reference, err := apiInstances.lookup("orders"); err != nil || reference != "${apiInstance(orders)}" {
+		t.Errorf("expected the reference to an instance that is not created to be kept, got %s: %v", reference, err)
+	}
+
+	// Applying the plan creates the instance first and then resolves the references to it
+	payload := json.RawMessage(`{"properties": {"api.id": "${apiInstance(orders)}"}}`)
+	if _, err := apiInstances.resolvePending(payload); err == nil {
+		t.Errorf("expected the reference to an instance that is not created to fail")
+	}
+	created := newApiInstanceRegistry()
+	if err := created.register("orders", 42); err != nil {
+		t.Fatal(err)
+	}
+	if resolved, err := created.resolvePending(payload); err != nil || string(resolved) != `{"properties": {"api.id": "42"}}` {
+		t.Errorf("expected the reference to be resolved, got %s: %v", resolved, err)
+	}
+}
+
+func TestPlanPoliciesOfPendingApiInstance(t *testing.T) {
+	setFlag(t, "dry-run", true)
+	setFlag(t, "strict", true)
+	dir := t.TempDir()
+	descriptors := map[string]string{
+		"a-policies.json": `{"kind": "ApiPolicies", "version": "v1", "spec": {
+			"apiInstanceId": "${apiInstance(orders)}",
+			"policy": [{"groupId": "mulesoft", "assetId": "rate-limiting", "assetVersion": "1.0.0"}]}}`,
+		"b-instance.json": `{"kind": "ApiInstance", "version": "v1", "spec": {
+			"name": "orders", "groupId": "org-id", "assetId": "orders-api", "version": "1.0.0", "technology": "mule4"}}`,
+	}
+	for name, descriptor := range descriptors {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(descriptor), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake := anypointclienttest.NewFake(testOrganization)
+	changes := plan.New(testOrganization, testEnvironment)
+
+	if faults := processFiles(context.Background(), fake, []string{dir}, testOrganization, testEnvironment, anypointclient.PrivateSpace{}, changes); len(faults) > 0 {
+		t.Fatalf("unexpected faults %v", faults)
+	}
+	if calls := mutatingCalls(fake); len(calls) != 0 {
+		t.Errorf("expected nothing to be changed in a dry run, got %v", calls)
+	}
+	var kinds []string
+	for _, change := range changes.Changes {
+		kinds = append(kinds, fmt.Sprintf("%s %s %s", change.Action, change.Kind, change.Name))
+	}
+	if expected := []string{"create ApiInstance orders", "create ApiPolicy orders/mulesoft:rate-limiting"}; !slices.Equal(kinds, expected) {
+		t.Fatalf("expected %v, got %v", expected, kinds)
+	}
+	var payload apiPolicyPayload
+	if err := json.Unmarshal(changes.Changes[1].Payload, &payload); err != nil || payload.ApiInstance != "orders" {
+		t.Errorf("expected the policy to be planned for the pending instance, got %+v: %v", payload, err)
+	}
+}
+
+func TestProcessFilesDeploysApiInstancesFirst(t *testing.T) {
+	setFlag(t, "dry-run", false)
+	setFlag(t, "strict", true)
+	dir := t.TempDir()
+	// The policies are read first, but refer to the instance declared in the other file
+	descriptors := map[string]string{
+		"a-policies.json": `{"kind": "ApiPolicies", "version": "v1", "spec": {
+			"apiInstanceId": "${apiInstance(orders)}",
+			"policy": [{"groupId": "mulesoft", "assetId": "rate-limiting", "assetVersion": "1.0.0"}]}}`,
+		"b-instance.json": `{"kind": "ApiInstance", "version": "v1", "spec": {
+			"name": "orders", "groupId": "org-id", "assetId": "orders-api", "version": "1.0.0", "technology": "mule4"}}`,
+	}
+	for name, descriptor := range descriptors {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(descriptor), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake := anypointclienttest.NewFake(testOrganization)
+
+	faults := processFiles(context.Background(), fake, []string{dir}, testOrganization, testEnvironment, anypointclient.PrivateSpace{}, plan.New(testOrganization, testEnvironment))
+	if len(faults) > 0 {
+		t.Fatalf("unexpected faults %v", faults)
+	}
+	if calls := mutatingCalls(fake); !slices.Equal(calls, []string{"CreateApiInstance", "CreateApiInstancePolicies"}) {
+		t.Errorf("expected the instance to be created before its policies, got %v", calls)
+	}
+	instances, _ := fake.GetApis("org-id", "env-id", 0, 10)
+	if policies := fake.Policies(instances.Instances[0].ID); len(policies) != 1 {
+		t.Errorf("expected the policy on the new instance, got %+v", policies)
+	}
+}
+
+func TestProcessFilesReportsUnreadableApiInstanceFiles(t *testing.T) {
+	setFlag(t, "dry-run", false)
+	setFlag(t, "strict", false)
+	dir := t.TempDir()
+	descriptors := map[string]string{
+		"a-policies.json": `{"kind": "ApiPolicies", "version": "v1", "spec": {"apiInstanceId": "${apiInstance(orders)}"}}`,
+		"b-instance.json": `{"kind": "ApiInstance", "version": "v1", "spec": {"name": "orders", "version": "${UNDEFINED_VERSION}"}}`,
+	}
+	for name, descriptor := range descriptors {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(descriptor), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake := anypointclienttest.NewFake(testOrganization)
+
+	faults := processFiles(context.Background(), fake, []string{dir}, testOrganization, testEnvironment, anypointclient.PrivateSpace{}, plan.New(testOrganization, testEnvironment))
+	if len(faults) != 2 ||
+		!strings.Contains(faults[0].Error(), "UNDEFINED_VERSION") ||
+		!strings.Contains(faults[1].Error(), "API instances orders are not deployed by an ApiInstance descriptor") {
+		t.Errorf("expected the instance file and the file referring to it to fail, got %v", faults)
+	}
+	if calls := mutatingCalls(fake); len(calls) != 0 {
+		t.Errorf("expected nothing to be deployed, got %v", calls)
+	}
+}
+
+func TestApiInstanceReferenceOutsideOfRun(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "policies.json")
+	descriptor := `{"kind": "ApiPolicies", "version": "v1", "spec": {"apiInstanceId": "${apiInstance(orders)}"}}`
+	if err := os.WriteFile(file, []byte(descriptor), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Rendering keeps the reference, the ID is only known when the descriptors are deployed
+	data, err := readDescriptor(file, "Sandbox", nil)
+	if err != nil || string(data) != descriptor {
+		t.Errorf("expected the reference to be kept, got %s: %v", data, err)
+	}
+}
@@ -0,0 +1,474 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/appconf"
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/plan"
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/resources"
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient"
+	"github.com/TwiN/go-color"
+	"github.com/spf13/cobra"
+)
+
+var applyCmd = &cobra.Command{
+	Use:   "apply <planfile>",
+	Short: "Execute the changes of a saved plan",
+	Long: `Executes exactly the changes recorded in a plan file written by "plan --out".
+
+	Before anything is changed the live state of every resource in the plan is fetched again and compared
+	with the fingerprint recorded when the plan was made. If any resource has changed since, nothing is applied.
+	`,
+	Example: "./chdeploy apply -o <organizationname> -e <environment> plan.json",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		savedPlan, err := plan.Load(args[0])
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+
+		ctx, cancel := runContext()
+		defer cancel()
+		client, organization, environment, privateSpace := connect(ctx)
+		if organization.ID != savedPlan.OrganizationID || environment.ID != savedPlan.EnvironmentID || privateSpace.ID != savedPlan.PrivateSpaceID {
+			log.Fatalf("plan was made for environment %s in organization %s and can not be applied to environment %s in organization %s",
+				savedPlan.Environment, savedPlan.Organization, environment.Name, organization.Name)
+		}
+
+		drifted, err := checkDrift(ctx, client, savedPlan, environment)
+		if err != nil {
+			log.Fatalf("failed to verify live state %+v", err)
+		}
+		if len(drifted) > 0 {
+			for _, change := range drifted {
+				log.Println(color.Colorize(color.Red, fmt.Sprintf("%s [%s] (%s) has changed since the plan was made", change.Kind, change.Name, change.Source)))
+			}
+			log.Println(color.Colorize(color.Red, "Live state has drifted since the plan was made. Create a new plan."))
+			os.Exit(11)
+		}
+
+		if faults := applyPlan(ctx, client, savedPlan, environment, privateSpace); len(faults) > 0 {
+			printFaults(faults)
+			os.Exit(10)
+		}
+		log.Println(color.Colorize(color.Green, "All planned changes applied successfully!\n"))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(applyCmd)
+}
+
+// planClient is the part of Anypoint Platform a plan is checked against and applied to
+type planClient interface {
+	anypointclient.CloudHubDeployments
+	anypointclient.ApiManager
+	anypointclient.MqAdmin
+}
+
+// applyPlan executes the changes of a plan whose live state has been checked with checkDrift. It stops at the first
+// change that fails, as later changes may depend on it, and returns the faults.
+func applyPlan(ctx context.Context, client planClient, savedPlan *plan.Plan, environment anypointclient.Environment, privateSpace anypointclient.PrivateSpace) []error {
+	// API instances are created first, like the files declaring them are deployed first, so that the changes
+	// planned for an instance that did not exist yet get its ID
+	apiInstances := newApiInstanceRegistry()
+	ordered := slices.Concat(
+		slices.DeleteFunc(slices.Clone(savedPlan.Changes), func(change plan.Change) bool { return change.Kind != "ApiInstance" }),
+		slices.DeleteFunc(slices.Clone(savedPlan.Changes), func(change plan.Change) bool { return change.Kind == "ApiInstance" }))
+
+	var faults []error
+	for _, change := range ordered {
+		if change.Action == plan.ActionNone {
+			continue
+		}
+		source := fmt.Sprintf("%s [%s] (%s)", change.Kind, change.Name, change.Source)
+		if ctx.Err() != nil {
+			faults = append(faults, interrupted(ctx, source, false, nil))
+			continue
+		}
+		if err := applyChange(ctx, client, savedPlan, environment, privateSpace, apiInstances, change); err != nil {
+			if ctx.Err() != nil {
+				faults = append(faults, interrupted(ctx, source, true, err))
+				continue
+			}
+			faults = append(faults, fmt.Errorf("%s: %w", source, err))
+			// Later changes may depend on this one, e.g. a binding on a queue, so stop here
+			break
+		}
+	}
+	return faults
+}
+
+// applicationPayload is what a plan records to create or update a deployment
+type applicationPayload struct {
+	DeploymentID string                               `json:"deploymentId,omitempty"`
+	Deployment   anypointclient.CloudhubDeploymentReq `json:"deployment"`
+}
+
+// apiInstancePayload is what a plan records to create or update an API instance
+type apiInstancePayload struct {
+	ApiInstanceID int                               `json:"apiInstanceId,omitempty"`
+	Instance      anypointclient.ApiInstanceRequest `json:"instance"`
+}
+
+// apiPolicyPayload is what a plan records to create or update an API policy. ApiInstance is the name of the
+// instance when the policy is planned for an instance that is created by the same plan.
+type apiPolicyPayload struct {
+	ApiInstanceID int                             `json:"apiInstanceId"`
+	ApiInstance   string                          `json:"apiInstance,omitempty"`
+	PolicyID      int                             `json:"policyId,omitempty"`
+	Policy        anypointclient.ApiPolicyRequest `json:"policy"`
+}
+
+// apiPolicyOrderPayload is what a plan records to put the policies of an API instance in the declared order
+type apiPolicyOrderPayload struct {
+	ApiInstanceID int                               `json:"apiInstanceId"`
+	Policies      []anypointclient.ApiPolicyRequest `json:"policies"`
+}
+
+// mqBindingPayload is what a plan records to create or update an exchange binding
+type mqBindingPayload struct {
+	ExchangeID   string                         `json:"exchangeId"`
+	QueueID      string                         `json:"queueId"`
+	RoutingRules []anypointclient.MqRoutingRule `json:"routingRules,omitempty"`
+}
+
+// maskedDeployment returns a copy of the deployment with the values of its secure properties masked, so that
+// secrets are never written to a plan
+func maskedDeployment(deployment anypointclient.CloudhubDeploymentReq) anypointclient.CloudhubDeploymentReq {
+	propertiesService := &deployment.Application.Configuration.MuleAgentApplicationPropertiesService
+	propertiesService.SecureProperties = appconf.MaskSecureProperties(propertiesService.SecureProperties)
+	return deployment
+}
+
+// restoreSecureProperties returns the planned deployment with the secure property values read again from the
+// descriptor the change was planned from and resolved. It fails if the secure properties are no longer those of the plan.
+func restoreSecureProperties(ctx context.Context, change plan.Change, planned anypointclient.CloudhubDeploymentReq, environment anypointclient.Environment) (anypointclient.CloudhubDeploymentReq, error) {
+	plannedProperties := planned.Application.Configuration.MuleAgentApplicationPropertiesService.SecureProperties
+	if len(plannedProperties) == 0 {
+		return planned, nil
+	}
+	file, index := plan.SplitSource(change.Source)
+	data, err := readDescriptor(file, environment.Name, nil)
+	if err != nil {
+		return planned, fmt.Errorf("failed to read the secure properties of %s: %w", change.Name, err)
+	}
+	descriptors, errs := decodeDescriptors(file, data)
+	if len(errs) > 0 {
+		return planned, errors.Join(errs...)
+	}
+	var application resources.ApplicationV1
+	if index < len(descriptors) {
+		application, _ = descriptors[index].(resources.ApplicationV1)
+	}
+	if application.Spec.Name != change.Name {
+		return planned, fmt.Errorf("%s no longer declares the application %s", change.Source, change.Name)
+	}
+	secureProperties := application.Spec.Application.Configuration.MuleAgentApplicationPropertiesService.SecureProperties
+	if !slices.Equal(slices.Sorted(maps.Keys(secureProperties)), slices.Sorted(maps.Keys(plannedProperties))) {
+		return planned, fmt.Errorf("the secure properties of %s have changed since the plan was made", change.Name)
+	}
+
+	deployment := withSecureProperties(planned, maps.Clone(secureProperties))
+	// The label records the hash of the values the plan was made with
+	resolved, err := resolveSecureProperties(ctx, deployment, filepath.Dir(file))
+	if err != nil {
+		return planned, err
+	}
+	label, err := securePropertiesLabel(resolved, planned.Labels)
+	if err != nil {
+		return planned, err
+	}
+	if !slices.Contains(planned.Labels, label) {
+		return planned, fmt.Errorf("the secure property values of %s have changed since the plan was made", change.Name)
+	}
+	return resolved, nil
+}
+
+// deploymentFingerprint fingerprints a deployment while ignoring the fields that change while it is running,
+// such as replica states. A deployment that does not exist has the same fingerprint as nil.
+func deploymentFingerprint(deployment anypointclient.CloudhubDeploymentResp) string {
+	if deployment.Name == "" {
+		return plan.Fingerprint(nil)
+	}
+	deployment.Status = ""
+	deployment.Application.Status = ""
+	deployment.Replicas = nil
+	return plan.Fingerprint(deployment)
+}
+
+// checkDrift fetches the live state of every resource in the plan and returns the changes whose
+// fingerprint no longer matches
+func checkDrift(ctx context.Context, client planClient, savedPlan *plan.Plan, environment anypointclient.Environment) ([]plan.Change, error) {
+	var drifted []plan.Change
+	for _, change := range savedPlan.Changes {
+		fingerprint, err := liveFingerprint(ctx, client, savedPlan, environment, change)
+		if err != nil {
+			return nil, fmt.Errorf("%s [%s]: %w", change.Kind, change.Name, err)
+		}
+		if fingerprint != change.Fingerprint {
+			drifted = append(drifted, change)
+		}
+	}
+	return drifted, nil
+}
+
+// liveFingerprint fetches the current state of the resource a change applies to and returns its fingerprint
+func liveFingerprint(ctx context.Context, client planClient, savedPlan *plan.Plan, environment anypointclient.Environment, change plan.Change) (string, error) {
+	switch change.Kind {
+	case "Application":
+		deployment, err := client.GetDeployment(environment, change.Name)
+		if err != nil {
+			return "", err
+		}
+		return deploymentFingerprint(deployment), nil
+	case "ApiInstance":
+		var payload apiInstancePayload
+		if err := json.Unmarshal(change.Payload, &payload); err != nil {
+			return "", err
+		}
+		organization := anypointclient.Organization{ID: savedPlan.OrganizationID}
+		instance, err := findDeclaredApiInstance(ctx, client, organization, environment, payload.Instance)
+		if err != nil || instance == nil {
+			return apiInstanceFingerprint(nil), err
+		}
+		current, err := client.GetApiInstance(savedPlan.OrganizationID, savedPlan.EnvironmentID, instance.ID)
+		if err != nil {
+			return "", err
+		}
+		return apiInstanceFingerprint(current), nil
+	case "ApiPolicy":
+		var payload apiPolicyPayload
+		if err := json.Unmarshal(change.Payload, &payload); err != nil {
+			return "", err
+		}
+		if payload.ApiInstance != "" {
+			// The instance is created by the plan, its own change tells if it exists already
+			return plan.Fingerprint(nil), nil
+		}
+		existingPolicies, err := client.GetApiInstancePolicies(savedPlan.OrganizationID, savedPlan.EnvironmentID, payload.ApiInstanceID)
+		if err != nil {
+			return "", err
+		}
+		if change.Action == plan.ActionDelete {
+			return plan.Fingerprint(findPolicyByID(*existingPolicies, payload.PolicyID)), nil
+		}
+		return plan.Fingerprint(findMatchingPolicy(*existingPolicies, payload.Policy)), nil
+	case "ApiPolicyOrder":
+		var payload apiPolicyOrderPayload
+		if err := json.Unmarshal(change.Payload, &payload); err != nil {
+			return "", err
+		}
+		existingPolicies, err := client.GetApiInstancePolicies(savedPlan.OrganizationID, savedPlan.EnvironmentID, payload.ApiInstanceID)
+		if err != nil {
+			return "", err
+		}
+		return plan.Fingerprint(policyIDs(sortedByOrder(*existingPolicies))), nil
+	case "MqQueue":
+		queue, err := client.GetMqQueue(savedPlan.OrganizationID, savedPlan.EnvironmentID, savedPlan.MqRegion, change.Name)
+		if err != nil {
+			return "", err
+		}
+		return plan.Fingerprint(queue), nil
+	case "MqExchange":
+		exchange, err := client.GetMqExchange(savedPlan.OrganizationID, savedPlan.EnvironmentID, savedPlan.MqRegion, change.Name)
+		if err != nil {
+			return "", err
+		}
+		if change.Action == plan.ActionDelete {
+			bindings, err := client.GetMqExchangeBindings(savedPlan.OrganizationID, savedPlan.EnvironmentID, savedPlan.MqRegion, change.Name)
+			if err != nil {
+				return "", err
+			}
+			return exchangeDeletionFingerprint(exchange, bindings), nil
+		}
+		return plan.Fingerprint(exchange), nil
+	case "MqBinding":
+		var payload mqBindingPayload
+		if err := json.Unmarshal(change.Payload, &payload); err != nil {
+			return "", err
+		}
+		bindings, err := client.GetMqExchangeBindings(savedPlan.OrganizationID, savedPlan.EnvironmentID, savedPlan.MqRegion, payload.ExchangeID)
+		if err != nil {
+			return "", err
+		}
+		for _, binding := range bindings {
+			if binding.QueueID == payload.QueueID {
+				return plan.Fingerprint(binding), nil
+			}
+		}
+		return plan.Fingerprint(nil), nil
+	default:
+		return "", fmt.Errorf("unknown kind: %s", change.Kind)
+	}
+}
+
+// applyChange executes a single change recorded in a plan. The API instances created or updated are registered in
+// apiInstances, and the references to them in the payloads of later changes are replaced by their IDs.
+func applyChange(ctx context.Context, client planClient, savedPlan *plan.Plan, environment anypointclient.Environment, privateSpace anypointclient.PrivateSpace, apiInstances *apiInstanceRegistry, change plan.Change) error {
+	orgID, envID, region := savedPlan.OrganizationID, savedPlan.EnvironmentID, savedPlan.MqRegion
+	resolved, err := apiInstances.resolvePending(change.Payload)
+	if err != nil {
+		return err
+	}
+	change.Payload = resolved
+
+	switch change.Kind {
+	case "Application":
+		var payload applicationPayload
+		if err := json.Unmarshal(change.Payload, &payload); err != nil {
+			return fmt.Errorf("failed to decode payload: %w", err)
+		}
+		// The plan only has the keys of the secure properties, the values are read from the descriptor again
+		deployment, err := restoreSecureProperties(ctx, change, payload.Deployment, environment)
+		if err != nil {
+			return err
+		}
+		if change.Action == plan.ActionCreate {
+			return createApplication(client, environment, privateSpace, deployment)
+		}
+		// The previous state is needed to roll back a failed rollout
+		previous, err := client.GetDeployment(environment, change.Name)
+		if err != nil {
+			return err
+		}
+		if previous.ID != payload.DeploymentID {
+			return fmt.Errorf("deployment %s was replaced since the plan was made", change.Name)
+		}
+		return updateApplication(client, environment, privateSpace, deployment, previous)
+
+	case "ApiInstance":
+		var payload apiInstancePayload
+		if err := json.Unmarshal(change.Payload, &payload); err != nil {
+			return fmt.Errorf("failed to decode payload: %w", err)
+		}
+		if change.Action == plan.ActionCreate {
+			created, err := client.CreateApiInstance(orgID, envID, payload.Instance)
+			if err != nil {
+				return err
+			}
+			payload.ApiInstanceID = created.ID
+		} else if _, err := client.UpdateApiInstance(orgID, envID, payload.ApiInstanceID, payload.Instance); err != nil {
+			return err
+		}
+		if err := apiInstances.register(change.Name, payload.ApiInstanceID); err != nil {
+			return err
+		}
+
+	case "ApiPolicy":
+		var payload apiPolicyPayload
+		if err := json.Unmarshal(change.Payload, &payload); err != nil {
+			return fmt.Errorf("failed to decode payload: %w", err)
+		}
+		if payload.ApiInstance != "" {
+			id, err := apiInstances.lookup(payload.ApiInstance)
+			if err != nil {
+				return err
+			}
+			if payload.ApiInstanceID, err = strconv.Atoi(id); err != nil {
+				return fmt.Errorf("API instance %s has not been created", payload.ApiInstance)
+			}
+		}
+		switch change.Action {
+		case plan.ActionCreate:
+			if err := client.CreateApiInstancePolicies(orgID, envID, payload.ApiInstanceID, payload.Policy); err != nil {
+				return err
+			}
+		case plan.ActionDelete:
+			if err := client.DeleteApiInstancePolicy(orgID, envID, payload.ApiInstanceID, payload.PolicyID); err != nil {
+				return err
+			}
+		default:
+			if err := client.UpdateApiInstancePolicies(orgID, envID, payload.ApiInstanceID, payload.PolicyID, payload.Policy); err != nil {
+				return err
+			}
+		}
+
+	case "ApiPolicyOrder":
+		var payload apiPolicyOrderPayload
+		if err := json.Unmarshal(change.Payload, &payload); err != nil {
+			return fmt.Errorf("failed to decode payload: %w", err)
+		}
+		// The policies created by earlier changes of the plan only have an ID now
+		existingPolicies, err := client.GetApiInstancePolicies(orgID, envID, payload.ApiInstanceID)
+		if err != nil {
+			return err
+		}
+		if ordered, reordered := declaredPolicyOrder(*existingPolicies, payload.Policies); reordered {
+			if err := client.ReorderApiInstancePolicies(orgID, envID, payload.ApiInstanceID, policyOrder(ordered)); err != nil {
+				return err
+			}
+		}
+
+	case "MqQueue":
+		if change.Action == plan.ActionDelete {
+			if err := deleteMqQueue(client, orgID, envID, region, change.Name); err != nil {
+				return err
+			}
+			break
+		}
+		var queue anypointclient.MqQueue
+		if err := json.Unmarshal(change.Payload, &queue); err != nil {
+			return fmt.Errorf("failed to decode payload: %w", err)
+		}
+		if change.Action == plan.ActionCreate {
+			if err := client.CreateMqQueue(orgID, envID, region, queue); err != nil {
+				return err
+			}
+		} else if err := client.UpdateMqQueue(orgID, envID, region, queue); err != nil {
+			return err
+		}
+
+	case "MqExchange":
+		if change.Action == plan.ActionDelete {
+			if err := deleteMqExchange(client, orgID, envID, region, change.Name); err != nil {
+				return err
+			}
+			break
+		}
+		var exchange anypointclient.MqExchange
+		if err := json.Unmarshal(change.Payload, &exchange); err != nil {
+			return fmt.Errorf("failed to decode payload: %w", err)
+		}
+		if err := client.CreateMqExchange(orgID, envID, region, exchange); err != nil {
+			return err
+		}
+
+	case "MqBinding":
+		var payload mqBindingPayload
+		if err := json.Unmarshal(change.Payload, &payload); err != nil {
+			return fmt.Errorf("failed to decode payload: %w", err)
+		}
+		if change.Action == plan.ActionDelete {
+			if err := client.DeleteMqBinding(orgID, envID, region, payload.ExchangeID, payload.QueueID); err != nil {
+				return err
+			}
+			break
+		}
+		if change.Action == plan.ActionCreate {
+			if err := client.CreateMqBinding(orgID, envID, region, payload.ExchangeID, payload.QueueID); err != nil {
+				return err
+			}
+		}
+		if change.Action == plan.ActionUpdate || len(payload.RoutingRules) > 0 {
+			if err := client.UpdateMqBindingRoutingRules(orgID, envID, region, payload.ExchangeID, payload.QueueID, payload.RoutingRules); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return fmt.Errorf("unknown kind: %s", change.Kind)
+	}
+
+	log.Println(color.Colorize(color.Green, fmt.Sprintf("%s [%s] successfully %sd", change.Kind, change.Name, change.Action)))
+	return nil
+}
@@ -0,0 +1,201 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/plan"
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient"
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient/anypointclienttest"
+)
+
+func TestDeploymentFingerprint(t *testing.T) {
+	if deploymentFingerprint(anypointclient.CloudhubDeploymentResp{}) != plan.Fingerprint(nil) {
+		t.Errorf("expected a missing deployment to have the fingerprint of nil")
+	}
+
+	deployment := anypointclient.CloudhubDeploymentResp{ID: "id", Name: "app", Status: "APPLYING"}
+	deployment.Application.Ref.Version = "1.0.0"
+	planned := deploymentFingerprint(deployment)
+
+	// Status changes while a deployment is rolled out and is not drift
+	deployment.Status = "APPLIED"
+	deployment.Application.Status = "RUNNING"
+	if deploymentFingerprint(deployment) != planned {
+		t.Errorf("expected status changes to be ignored")
+	}
+
+	deployment.Application.Ref.Version = "1.0.1"
+	if deploymentFingerprint(deployment) == planned {
+		t.Errorf("expected a version change to change the fingerprint")
+	}
+}
+
+func TestPlanDoesNotRecordSecureProperties(t *testing.T) {
+	setFlag(t, "dry-run", true)
+	t.Setenv("DB_PASSWORD", "s3cret")
+	dir := t.TempDir()
+	file := filepath.Join(dir, "orders.json")
+	descriptor := `{"kind": "Application", "version": "v1", "spec": {"name": "orders",
+		"application": {"ref": {"version": "1.0.0"}, "configuration": {"mule.agent.application.properties.service": {
+			"applicationName": "orders", "secureProperties": {"db.password": "${DB_PASSWORD}"}}}}}}`
+	if err := os.WriteFile(file, []byte(descriptor), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fake := anypointclienttest.NewFake(testOrganization)
+	changes := plan.New(testOrganization, testEnvironment)
+	if faults := newRun(fake, testOrganization, testEnvironment, anypointclient.PrivateSpace{}, changes).processFile(context.Background(), file); len(faults) > 0 {
+		t.Fatal(faults)
+	}
+	change := changes.Changes[0]
+	if strings.Contains(string(change.Payload), "s3cret") {
+		t.Fatalf("expected the secure property to be masked in the plan, got %s", change.Payload)
+	}
+
+	var payload applicationPayload
+	if err := json.Unmarshal(change.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	deployment, err := restoreSecureProperties(context.Background(), change, payload.Deployment, testEnvironment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := deployment.Application.Configuration.MuleAgentApplicationPropertiesService.SecureProperties["db.password"]; value != "s3cret" {
+		t.Errorf("expected the secure property to be read again from the descriptor, got %q", value)
+	}
+
+	t.Setenv("DB_PASSWORD", "changed")
+	if _, err := restoreSecureProperties(context.Background(), change, payload.Deployment, testEnvironment); err == nil || !strings.Contains(err.Error(), "changed since the plan was made") {
+		t.Errorf("expected a changed secret to be refused, got %v", err)
+	}
+}
+
+// applyDescriptors are descriptors of every kind, deployed by a first plan and changed by a second one
+func applyDescriptors(version string, policies string, queues string, exchanges string) map[string]string {
+	deployment, _ := json.Marshal(testDeployment(version))
+	return map[string]string{
+		"orders.json": `{"kind": "Application", "version": "v1", "spec": ` + string(deployment) + `}`,
+		"orders-api.json": `{"kind": "ApiInstance", "version": "v1", "spec": {"name": "orders", "groupId": "org-id",
+			"assetId": "orders-api", "version": "` + version + `", "technology": "mule4"}}`,
+		"orders-policies.json": `{"kind": "ApiPolicies", "version": "v1", "spec": {"apiInstanceId": "${apiInstance(orders)}",
+			"authoritative": true, "policy": ` + policies + `}}`,
+		"orders-mq.json": `{"kind": "MqDestinations", "version": "v1", "spec": {"owns": ["orders*", "old-events"],
+			"queues": ` + queues + `, "exchanges": ` + exchanges + `}}`,
+	}
+}
+
+// savedPlan plans the descriptors in dir and returns the plan as apply loads it from a plan file
+func savedPlan(t *testing.T, fake *anypointclienttest.Fake, dir string) *plan.Plan {
+	t.Helper()
+	setFlag(t, "dry-run", true)
+	changes := plan.New(testOrganization, testEnvironment)
+	changes.MqRegion = "us-east-1"
+	if faults := processFiles(context.Background(), fake, []string{dir}, testOrganization, testEnvironment, anypointclient.PrivateSpace{}, changes); len(faults) > 0 {
+		t.Fatal(faults)
+	}
+	file := filepath.Join(t.TempDir(), "plan.json")
+	if err := changes.Save(file); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := plan.Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setFlag(t, "dry-run", false)
+	return loaded
+}
+
+// applySavedPlan checks the plan for drift and applies it like the apply command
+func applySavedPlan(t *testing.T, fake *anypointclienttest.Fake, savedPlan *plan.Plan) {
+	t.Helper()
+	drifted, err := checkDrift(context.Background(), fake, savedPlan, testEnvironment)
+	if err != nil || len(drifted) > 0 {
+		t.Fatalf("expected no drift, got %+v: %v", drifted, err)
+	}
+	fake.Reset()
+	if faults := applyPlan(context.Background(), fake, savedPlan, testEnvironment, anypointclient.PrivateSpace{}); len(faults) > 0 {
+		t.Fatal(faults)
+	}
+}
+
+func TestApplyPlan(t *testing.T) {
+	setFlag(t, "mq-region", "us-east-1")
+	setFlag(t, "strict", true)
+	setFlag(t, "prune-mq", true)
+	setFlag(t, "prune-mq-allow", []string{"*", "*/*"})
+	fake := anypointclienttest.NewFake(testOrganization)
+	dir := t.TempDir()
+
+	writeFiles(t, dir, applyDescriptors("1.0.0",
+		`[{"groupId": "mulesoft", "assetId": "ip-allowlist", "assetVersion": "1.0.0"},
+			{"groupId": "mulesoft", "assetId": "rate-limiting", "assetVersion": "1.0.0"},
+			{"groupId": "mulesoft", "assetId": "client-id-enforcement", "assetVersion": "1.0.0"}]`,
+		`[{"queueId": "orders"}, {"queueId": "orders-old"}]`,
+		`[{"exchangeId": "events", "bindings": [{"queueId": "orders"}]},
+			{"exchangeId": "old-events", "bindings": [{"queueId": "orders-old"}]}]`))
+	applySavedPlan(t, fake, savedPlan(t, fake, dir))
+	calls := mutatingCalls(fake)
+	for _, call := range []string{"CreateDeployment", "CreateApiInstance", "CreateApiInstancePolicies", "CreateMqQueue", "CreateMqExchange", "CreateMqBinding"} {
+		if !slices.Contains(calls, call) {
+			t.Errorf("expected %s when applying the first plan, got %v", call, calls)
+		}
+	}
+
+	writeFiles(t, dir, applyDescriptors("1.1.0",
+		`[{"groupId": "mulesoft", "assetId": "rate-limiting", "assetVersion": "1.0.0", "configurationData": {"maximumRequests": 10}},
+			{"groupId": "mulesoft", "assetId": "ip-allowlist", "assetVersion": "1.0.0"}]`,
+		`[{"queueId": "orders", "maxDeliveries": 5}]`,
+		`[{"exchangeId": "events", "bindings": [{"queueId": "orders", "routingRules": [
+			{"propertyName": "type", "propertyType": "STRING", "matcherType": "EQ", "value": "order"}]}]}]`))
+	changes := savedPlan(t, fake, dir)
+	var planned []string
+	for _, change := range changes.Changes {
+		if change.Action != plan.ActionNone {
+			planned = append(planned, change.Kind+" "+string(change.Action))
+		}
+	}
+	for _, change := range []string{"Application update", "ApiInstance update", "ApiPolicy update", "ApiPolicy delete", "ApiPolicyOrder update",
+		"MqQueue update", "MqQueue delete", "MqExchange delete", "MqBinding update", "MqBinding delete"} {
+		if !slices.Contains(planned, change) {
+			t.Errorf("expected %s to be planned, got %v", change, planned)
+		}
+	}
+	applySavedPlan(t, fake, changes)
+	calls = mutatingCalls(fake)
+	for _, call := range []string{"UpdateDeployment", "UpdateApiInstance", "UpdateApiInstancePolicies", "DeleteApiInstancePolicy", "ReorderApiInstancePolicies",
+		"UpdateMqQueue", "DeleteMqQueue", "DeleteMqExchange", "UpdateMqBindingRoutingRules", "DeleteMqBinding"} {
+		if !slices.Contains(calls, call) {
+			t.Errorf("expected %s when applying the second plan, got %v", call, calls)
+		}
+	}
+
+	// Once applied, planning the same descriptors again changes nothing
+	if changes := savedPlan(t, fake, dir); changes.HasChanges() {
+		t.Errorf("expected the applied plan to leave nothing to change, got %+v", changes.Changes)
+	}
+}
+
+func TestApplyPlanRefusesDrift(t *testing.T) {
+	setFlag(t, "mq-region", "us-east-1")
+	fake := anypointclienttest.NewFake(testOrganization)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"orders-mq.json": `{"kind": "MqDestinations", "version": "v1", "spec": {"queues": [{"queueId": "orders"}, {"queueId": "billing"}]}}`,
+	})
+	savedPlan := savedPlan(t, fake, dir)
+
+	// The queue is created by someone else after the plan was made
+	fake.AddMqDestination("org-id", "env-id", "us-east-1", anypointclient.MqDestination{Type: "queue", QueueID: "orders", MaxDeliveries: 3})
+	drifted, err := checkDrift(context.Background(), fake, savedPlan, testEnvironment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drifted) != 1 || drifted[0].Kind != "MqQueue" || drifted[0].Name != "orders" {
+		t.Errorf("expected the queue to have drifted, got %+v", drifted)
+	}
+}
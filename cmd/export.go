@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/export"
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient"
+	"github.com/TwiN/go-color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Generate descriptors from the live state of an environment",
+	Long: `Fetches the deployments, API instance policies and MQ destinations of an environment and writes
+	one descriptor file per resource that can be deployed with this tool.
+
+	Fields that are only set by Anypoint Platform, such as ids, status and generated CloudHub URLs, are left out.
+	Secure properties can not be read back, they are exported as environment variable placeholders, e.g. ${DB_PASSWORD}.
+	MQ destinations are only exported when --mq-region is given.
+	`,
+	Example: "./chdeploy export -o <organizationname> -e <environment> --dir descriptors",
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := runContext()
+		defer cancel()
+		client, organization, environment, privateSpace := connect(ctx)
+
+		dir := viper.GetString("dir")
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			log.Fatalf("failed to create directory %s %+v", dir, err)
+		}
+
+		var faults []error
+		faults = append(faults, exportApplications(client, environment, privateSpace, dir)...)
+		faults = append(faults, exportApiPolicies(ctx, client, organization, environment, dir)...)
+		if region := viper.GetString("mq-region"); region != "" {
+			faults = append(faults, exportMqDestinations(client, organization, environment, region, dir)...)
+		}
+		if len(faults) > 0 {
+			printFaults(faults)
+			os.Exit(10)
+		}
+		log.Println(color.Colorize(color.Green, "Export completed successfully!\n"))
+	},
+}
+
+func init() {
+	exportCmd.Flags().String("dir", ".", "directory to write the descriptors to")
+	exportCmd.Flags().Bool("overwrite", false, "overwrite descriptor files that already exist")
+	exportCmd.Flags().VisitAll(func(f *pflag.Flag) {
+		viper.BindPFlag(f.Name, f)
+	})
+	rootCmd.AddCommand(exportCmd)
+}
+
+// exportApplications writes an Application descriptor for every deployment in the environment,
+// limited to the private space if one is given
+func exportApplications(client *anypointclient.AnypointClient, environment anypointclient.Environment, privateSpace anypointclient.PrivateSpace, dir string) []error {
+	deployments, err := client.GetDeployments(environment)
+	if err != nil {
+		return []error{fmt.Errorf("failed to list deployments: %w", err)}
+	}
+
+	var faults []error
+	for _, deployment := range deployments {
+		if privateSpace.ID != "" && deployment.Target.TargetID != privateSpace.ID {
+			continue
+		}
+		current, err := client.GetDeployment(environment, deployment.Name)
+		if err != nil {
+			faults = append(faults, fmt.Errorf("failed to get deployment %s: %w", deployment.Name, err))
+			continue
+		}
+		if err := writeDescriptor(dir, deployment.Name+".json", export.Application(current)); err != nil {
+			faults = append(faults, err)
+		}
+	}
+	return faults
+}
+
+// exportApiPolicies writes an ApiPolicies descriptor for every API instance in the environment that has policies
+func exportApiPolicies(ctx context.Context, client *anypointclient.AnypointClient, organization anypointclient.Organization, environment anypointclient.Environment, dir string) []error {
+	var faults []error
+	for instance, err := range client.ApisPaginator(organization.ID, environment.ID).All(ctx) {
+		if err != nil {
+			return append(faults, fmt.Errorf("failed to list API instances: %w", err))
+		}
+		policies, err := client.GetApiInstancePolicies(organization.ID, environment.ID, instance.ID)
+		if err != nil {
+			faults = append(faults, fmt.Errorf("failed to get policies of API instance %d: %w", instance.ID, err))
+			continue
+		}
+		if len(*policies) == 0 {
+			continue
+		}
+		filename := fmt.Sprintf("api-%d-policies.json", instance.ID)
+		if err := writeDescriptor(dir, filename, export.ApiPolicies(instance.ID, *policies)); err != nil {
+			faults = append(faults, err)
+		}
+	}
+	return faults
+}
+
+// exportMqDestinations writes a single MqDestinations descriptor with all queues, exchanges and bindings of the region
+func exportMqDestinations(client *anypointclient.AnypointClient, organization anypointclient.Organization, environment anypointclient.Environment, region string, dir string) []error {
+	destinations, err := client.GetMqDestinations(organization.ID, environment.ID, region)
+	if err != nil {
+		return []error{fmt.Errorf("failed to list MQ destinations: %w", err)}
+	}
+
+	bindings := map[string][]anypointclient.MqBinding{}
+	for _, destination := range destinations {
+		if destination.Type != "exchange" {
+			continue
+		}
+		exchangeBindings, err := client.GetMqExchangeBindings(organization.ID, environment.ID, region, destination.ExchangeID)
+		if err != nil {
+			return []error{fmt.Errorf("failed to get bindings of exchange %s: %w", destination.ExchangeID, err)}
+		}
+		bindings[destination.ExchangeID] = exchangeBindings
+	}
+
+	if err := writeDescriptor(dir, "mq-destinations.json", export.MqDestinations(destinations, bindings)); err != nil {
+		return []error{err}
+	}
+	return nil
+}
+
+// writeDescriptor writes a descriptor as indented JSON. Existing files are only replaced when --overwrite is given.
+func writeDescriptor(dir string, filename string, descriptor any) error {
+	path := filepath.Join(dir, filename)
+	if _, err := os.Stat(path); err == nil && !viper.GetBool("overwrite") {
+		return fmt.Errorf("%s already exists, use --overwrite to replace it", path)
+	}
+
+	data, err := json.MarshalIndent(descriptor, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", path, err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	log.Println(color.Colorize(color.Green, fmt.Sprintf("Exported %s", path)))
+	return nil
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// descriptorExtensions are the file extensions picked up when walking a directory without include globs
+var descriptorExtensions = []string{".json", ".yaml", ".yml"}
+
+// expandArgs returns the descriptor files given on the command line. Directories are walked recursively and
+// the files in them are filtered with the include and exclude globs. Globs are matched against both the file
+// name and the path relative to the directory, e.g. "*.yaml" or "prod/*.json". Files given explicitly are
+// always included. Overlays of other files in the list for any of the environments are left out, they are merged
+// into the file they belong to.
+func expandArgs(args []string, include []string, exclude []string, environments []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil || !info.IsDir() {
+			// Missing files are reported when they are read, together with the other faults
+			files = append(files, arg)
+			continue
+		}
+
+		var found []string
+		err = filepath.WalkDir(arg, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				return nil
+			}
+			relative, err := filepath.Rel(arg, path)
+			if err != nil {
+				return err
+			}
+			selected, err := selectFile(filepath.ToSlash(relative), include, exclude)
+			if err != nil {
+				return err
+			}
+			if selected {
+				found = append(found, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read directory %s: %w", arg, err)
+		}
+		sort.Strings(found)
+		files = append(files, found...)
+	}
+	return filterOverlays(files, environments), nil
+}
+
+// selectFile returns true if the file matches any include glob, or is a descriptor when there are none,
+// and does not match any exclude glob
+func selectFile(path string, include []string, exclude []string) (bool, error) {
+	excluded, err := matchesAny(path, exclude)
+	if err != nil || excluded {
+		return false, err
+	}
+	if len(include) == 0 {
+		return isDescriptor(path), nil
+	}
+	return matchesAny(path, include)
+}
+
+// isDescriptor returns true if the file has one of the descriptor file extensions
+func isDescriptor(path string) bool {
+	for _, extension := range descriptorExtensions {
+		if strings.EqualFold(filepath.Ext(path), extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAny returns true if the slash separated path or its base name matches any of the globs
+func matchesAny(path string, globs []string) (bool, error) {
+	for _, glob := range globs {
+		for _, candidate := range []string{path, filepath.Base(path)} {
+			matched, err := filepath.Match(glob, candidate)
+			if err != nil {
+				return false, fmt.Errorf("invalid glob %s: %w", glob, err)
+			}
+			if matched {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// resourceSource identifies a resource by its file and, for files holding several resources, its index in the file
+func resourceSource(file string, index int, count int) string {
+	if count <= 1 {
+		return file
+	}
+	return fmt.Sprintf("%s[%d]", file, index)
+}
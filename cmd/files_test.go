@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpandArgs(t *testing.T) {
+	dir := t.TempDir()
+	for _, file := range []string{"app.json", "mq.yaml", "README.md", "prod/app.yml", "prod/skip.json"} {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("{}"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		expected []string
+	}{
+		{
+			name:     "all descriptors",
+			expected: []string{"app.json", "mq.yaml", "prod/app.yml", "prod/skip.json"},
+		},
+		{
+			name:     "exclude by name",
+			exclude:  []string{"skip.json"},
+			expected: []string{"app.json", "mq.yaml", "prod/app.yml"},
+		},
+		{
+			name:     "include by relative path",
+			include:  []string{"prod/*"},
+			expected: []string{"prod/app.yml", "prod/skip.json"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			files, err := expandArgs([]string{dir}, test.include, test.exclude, nil)
+			if err != nil {
+				t.Fatalf("failed to expand args: %v", err)
+			}
+			var expected []string
+			for _, file := range test.expected {
+				expected = append(expected, filepath.Join(dir, file))
+			}
+			if !reflect.DeepEqual(files, expected) {
+				t.Errorf("expected %v, got %v", expected, files)
+			}
+		})
+	}
+}
+
+func TestExpandArgsKeepsFiles(t *testing.T) {
+	files, err := expandArgs([]string{"missing.json", "notes.txt"}, nil, []string{"*.txt"}, nil)
+	if err != nil {
+		t.Fatalf("failed to expand args: %v", err)
+	}
+	if !reflect.DeepEqual(files, []string{"missing.json", "notes.txt"}) {
+		t.Errorf("expected files to be kept as given, got %v", files)
+	}
+}
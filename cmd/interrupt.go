@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/TwiN/go-color"
+	"github.com/spf13/viper"
+)
+
+// runContext returns the context of a run. It is cancelled when the process receives SIGINT or SIGTERM, or when
+// --timeout has passed. After the first signal the default handling is restored, so a second Ctrl-C stops at once.
+func runContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Println(color.Colorize(color.Yellow, fmt.Sprintf("Received %s, stopping. Press Ctrl-C again to stop at once.", sig)))
+			cancel(fmt.Errorf("interrupted by %s", sig))
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+
+	if timeout := viper.GetDuration("timeout"); timeout > 0 {
+		timeoutCtx, cancelTimeout := context.WithTimeoutCause(ctx, timeout, fmt.Errorf("run timed out after %s", timeout))
+		return timeoutCtx, func() {
+			cancelTimeout()
+			cancel(context.Canceled)
+		}
+	}
+	return ctx, func() { cancel(context.Canceled) }
+}
+
+// interruptedError is the fault of a resource that was left half-done or not started because the run was interrupted
+type interruptedError struct {
+	Source  string
+	Started bool
+	Err     error
+}
+
+func (e *interruptedError) Error() string {
+	if e.Started {
+		return fmt.Sprintf("%s: interrupted, may be left half-done: %v", e.Source, e.Err)
+	}
+	return fmt.Sprintf("%s: not started: %v", e.Source, e.Err)
+}
+
+func (e *interruptedError) Unwrap() error {
+	return e.Err
+}
+
+// interrupted returns the fault of a resource that was not started, or did not finish, because the run was
+// interrupted. Otherwise err is returned as is.
+func interrupted(ctx context.Context, source string, started bool, err error) error {
+	if ctx.Err() == nil || (started && err == nil) {
+		return err
+	}
+	if err == nil {
+		err = context.Cause(ctx)
+	}
+	return &interruptedError{Source: source, Started: started, Err: err}
+}
+
+// printInterruption summarizes the resources left half-done and not started when a run was interrupted
+func printInterruption(faults []error) {
+	var halfDone, notStarted []string
+	for _, fault := range faults {
+		var interruption *interruptedError
+		if !errors.As(fault, &interruption) {
+			continue
+		}
+		if interruption.Started {
+			halfDone = append(halfDone, interruption.Source)
+		} else {
+			notStarted = append(notStarted, interruption.Source)
+		}
+	}
+	if len(halfDone) == 0 && len(notStarted) == 0 {
+		return
+	}
+
+	log.Println(color.Colorize(color.Red, "The run was interrupted."))
+	for _, source := range halfDone {
+		log.Println(color.Colorize(color.Red, fmt.Sprintf("  Left half-done, check its state: %s", source)))
+	}
+	for _, source := range notStarted {
+		log.Println(color.Colorize(color.Yellow, fmt.Sprintf("  Not started: %s", source)))
+	}
+}
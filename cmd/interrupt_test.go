@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/plan"
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient"
+)
+
+func TestInterrupted(t *testing.T) {
+	failure := errors.New("failure")
+	if err := interrupted(context.Background(), "app.json", true, failure); err != failure {
+		t.Errorf("expected fault to be kept when not interrupted, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cause := errors.New("interrupted by interrupt")
+	cancel(cause)
+	if err := interrupted(ctx, "app.json", true, nil); err != nil {
+		t.Errorf("expected finished resource not to be reported, got %v", err)
+	}
+
+	var interruption *interruptedError
+	err := interrupted(ctx, "app.json", false, nil)
+	if !errors.As(err, &interruption) || interruption.Started || !errors.Is(err, cause) {
+		t.Errorf("expected not started resource with the cause, got %v", err)
+	}
+	err = interrupted(ctx, "app.json", true, failure)
+	if !errors.As(err, &interruption) || !interruption.Started || !errors.Is(err, failure) {
+		t.Errorf("expected half-done resource with its fault, got %v", err)
+	}
+}
+
+func TestProcessFileReportsResourcesNotStarted(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "resources.yaml")
+	descriptors := `kind: Application
+version: v1
+spec:
+  name: first
+---
+kind: Application
+version: v1
+spec:
+  name: second
+`
+	if err := os.WriteFile(file, []byte(descriptors), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	environment := anypointclient.Environment{Name: "Sandbox"}
+	faults := newRun(nil, anypointclient.Organization{}, environment, anypointclient.PrivateSpace{}, plan.New(anypointclient.Organization{}, environment)).processFile(ctx, file)
+
+	if len(faults) != 2 {
+		t.Fatalf("expected both resources to be reported, got %v", faults)
+	}
+	for i, fault := range faults {
+		var interruption *interruptedError
+		if !errors.As(fault, &interruption) || interruption.Started || interruption.Source != resourceSource(file, i, 2) {
+			t.Errorf("expected resource %d not to be started, got %v", i, fault)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/overlay"
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/templating"
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.yaml.in/yaml/v3"
+)
+
+var renderCmd = &cobra.Command{
+	Use:   "render",
+	Short: "Print descriptors with the overlay of the environment applied",
+	Long: `Prints every descriptor as it would be deployed to the environment given with --environment, i.e. with
+	template references expanded and the overlay of the environment merged into it. Nothing is read from or
+	changed in Anypoint Platform.
+	`,
+	Example:   "./chdeploy render -e Production app.yaml",
+	ValidArgs: []string{"*.json", "*.yaml", "*.yml"},
+	Args:      cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		environment := viper.GetString("environment")
+		if environment == "" {
+			log.Fatalf("--environment is required to pick the overlays")
+		}
+		environments := append([]string{environment}, viper.GetStringSlice("overlay-environments")...)
+		files, err := expandArgs(args, viper.GetStringSlice("include"), viper.GetStringSlice("exclude"), environments)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+
+		var faults []error
+		for _, file := range files {
+			data, err := readDescriptor(file, environment, nil)
+			if err != nil {
+				faults = append(faults, err)
+				continue
+			}
+			if _, errs := decodeDescriptors(file, data); len(errs) > 0 {
+				faults = append(faults, errs...)
+				continue
+			}
+			log.Printf("Rendered %s", file)
+			os.Stdout.Write(data)
+			if !bytes.HasSuffix(data, []byte("\n")) {
+				fmt.Println()
+			}
+		}
+		if len(faults) > 0 {
+			printFaults(faults)
+			os.Exit(10)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(renderCmd)
+}
+
+// readDescriptor reads a descriptor file with its template references expanded. If there is an overlay for the
+// environment it is merged into the descriptor, and the result is returned in the format of the descriptor file.
+// References to API instances are resolved with apiInstance, or kept as they are if it is nil.
+func readDescriptor(file string, environment string, apiInstance func(name string) (string, error)) ([]byte, error) {
+	fileData, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %s. Error: %v", file, err)
+	}
+	data, err := expandTemplate(file, fileData, apiInstance)
+	if err != nil {
+		return nil, err
+	}
+
+	overlayFile, err := findOverlay(file, environment)
+	if err != nil || overlayFile == "" {
+		return data, err
+	}
+	log.Printf("Applying overlay %s to %s", overlayFile, file)
+
+	overlayData, err := os.ReadFile(overlayFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open overlay: %s. Error: %v", overlayFile, err)
+	}
+	base, err := decodeDocument(file, data)
+	if err != nil {
+		return nil, err
+	}
+	overlayData, err = expandTemplate(overlayFile, overlayData, apiInstance)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := decodeDocument(overlayFile, overlayData)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range []string{"kind", "version"} {
+		patchValue, found := patch.(map[string]any)[field]
+		if found && patchValue != base.(map[string]any)[field] {
+			return nil, fmt.Errorf("overlay %s has %s %v but %s has %v", overlayFile, field, patchValue, file, base.(map[string]any)[field])
+		}
+	}
+
+	merged := overlay.MergePatch(base, patch)
+	if isYAML(file) {
+		return yaml.Marshal(merged)
+	}
+	return json.MarshalIndent(merged, "", "  ")
+}
+
+// expandTemplate replaces the variable and function references in a descriptor file
+func expandTemplate(file string, data []byte, apiInstance func(name string) (string, error)) ([]byte, error) {
+	if apiInstance == nil {
+		apiInstance = func(name string) (string, error) { return "${apiInstance(" + name + ")}", nil }
+	}
+	expander := templating.New(filepath.Dir(file))
+	expander.Functions = map[string]func(string) (string, error){"apiInstance": apiInstance}
+	// Values are inserted into double-quoted strings, in JSON as well as in YAML
+	expander.Quote = templating.QuoteString
+	expanded, err := expander.Expand(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return []byte(expanded), nil
+}
+
+// findOverlay returns the overlay of a descriptor file for the environment, or an empty string if there is none.
+// The overlay of app.json for the environment Production is a file named app.Production.json, app.Production.yaml
+// or app.Production.yml next to it. The environment name is matched case-insensitively.
+func findOverlay(file string, environment string) (string, error) {
+	if environment == "" {
+		return "", nil
+	}
+	dir := filepath.Dir(file)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to look for overlays of %s: %w", file, err)
+	}
+
+	stem := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	var overlays []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !isDescriptor(name) {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSuffix(name, filepath.Ext(name)), stem+"."+environment) {
+			overlays = append(overlays, filepath.Join(dir, name))
+		}
+	}
+	if len(overlays) > 1 {
+		return "", fmt.Errorf("found several overlays of %s for environment %s: %s", file, environment, strings.Join(overlays, ", "))
+	}
+	if len(overlays) == 0 {
+		return "", nil
+	}
+	return overlays[0], nil
+}
+
+// filterOverlays removes overlays from a list of descriptor files. A file is an overlay if it is named after one of
+// the environments and another file in the list has the same name without the environment, e.g. app.Production.json
+// is an overlay of app.json or app.yaml. Other dotted names, like app.v2.json, are descriptors of their own.
+func filterOverlays(files []string, environments []string) []string {
+	stems := map[string]bool{}
+	for _, file := range files {
+		stems[filepath.Clean(strings.TrimSuffix(file, filepath.Ext(file)))] = true
+	}
+
+	var descriptors []string
+	for _, file := range files {
+		stem := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		if dot := strings.LastIndex(stem, "."); dot > 0 {
+			base := filepath.Join(filepath.Dir(file), stem[:dot])
+			environment := stem[dot+1:]
+			if stems[base] && slices.ContainsFunc(environments, func(name string) bool { return strings.EqualFold(name, environment) }) {
+				log.Printf("Skipping %s, it is an overlay for environment %s", file, environment)
+				continue
+			}
+		}
+		descriptors = append(descriptors, file)
+	}
+	return descriptors
+}
+
+// overlayEnvironments returns the names of the environments that files may be overlays for: the environment
+// deployed to, the ones given with --overlay-environments and every environment of the organization
+func overlayEnvironments(ctx context.Context, client anypointclient.AnypointAPI, organization anypointclient.Organization, environment anypointclient.Environment) ([]string, error) {
+	environments := append([]string{environment.Name}, viper.GetStringSlice("overlay-environments")...)
+	for known, err := range client.EnvironmentsPaginator(organization).All(ctx) {
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the environments of organization %s: %w", organization.Name, err)
+		}
+		environments = append(environments, known.Name)
+	}
+	return environments, nil
+}
+
+// decodeDocument decodes a descriptor file holding a single resource into generic values
+func decodeDocument(file string, data []byte) (any, error) {
+	var documents []any
+	if isYAML(file) {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		for {
+			var document yaml.Node
+			err := decoder.Decode(&document)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse YAML %s: %w", file, err)
+			}
+			if len(document.Content) == 0 || document.Content[0].ShortTag() == "!!null" {
+				continue
+			}
+			value, err := yamlValue(&document)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode %s: %w", file, err)
+			}
+			documents = append(documents, value)
+		}
+	} else {
+		var value any
+		if err := json.Unmarshal(data, &value); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", file, err)
+		}
+		if array, ok := value.([]any); ok {
+			documents = array
+		} else {
+			documents = []any{value}
+		}
+	}
+
+	if len(documents) != 1 {
+		return nil, fmt.Errorf("%s holds %d resources, overlays are only supported for files with a single resource", file, len(documents))
+	}
+	if _, ok := documents[0].(map[string]any); !ok {
+		return nil, fmt.Errorf("%s does not hold a resource", file)
+	}
+	return documents[0], nil
+}
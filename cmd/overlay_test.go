@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/resources"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadDescriptorWithOverlay(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"app.yaml": `kind: Application
+version: v1
+spec:
+  name: app
+  target:
+    replicas: 1
+  application:
+    vCores: 0.1
+    configuration:
+      mule.agent.application.properties.service:
+        properties:
+          env: dev
+          debug: "true"
+`,
+		"app.production.json": `{"spec": {"target": {"replicas": 3}, "application": {"configuration": {
+			"mule.agent.application.properties.service": {"properties": {"env": "prod", "debug": null}}}}}}`,
+	})
+
+	data, err := readDescriptor(filepath.Join(dir, "app.yaml"), "Production", nil)
+	if err != nil {
+		t.Fatalf("failed to read descriptor: %v", err)
+	}
+	descriptors, errs := decodeDescriptors(filepath.Join(dir, "app.yaml"), data)
+	if len(errs) > 0 {
+		t.Fatalf("failed to decode rendered descriptor: %v\n%s", errs, data)
+	}
+	app := descriptors[0].(resources.ApplicationV1)
+	if app.Spec.Target.Replicas != 3 || app.Spec.Application.VCores != 0.1 {
+		t.Errorf("unexpected spec %+v", app.Spec)
+	}
+	properties := app.Spec.Application.Configuration.MuleAgentApplicationPropertiesService.Properties
+	if !reflect.DeepEqual(properties, map[string]string{"env": "prod"}) {
+		t.Errorf("unexpected properties %v", properties)
+	}
+
+	// Other environments get the base descriptor
+	data, err = readDescriptor(filepath.Join(dir, "app.yaml"), "Sandbox", nil)
+	if err != nil {
+		t.Fatalf("failed to read descriptor: %v", err)
+	}
+	descriptors, _ = decodeDescriptors(filepath.Join(dir, "app.yaml"), data)
+	if replicas := descriptors[0].(resources.ApplicationV1).Spec.Target.Replicas; replicas != 1 {
+		t.Errorf("expected base replicas for environment without overlay, got %d", replicas)
+	}
+}
+
+func TestReadDescriptorWithFile(t *testing.T) {
+	dir := t.TempDir()
+	certificate := "-----BEGIN CERTIFICATE-----\nMIIC\"x\"\n-----END CERTIFICATE-----\n"
+	password := "pa\"ss\\wo\nrd"
+	t.Setenv("DB_PASSWORD", password)
+	writeFiles(t, dir, map[string]string{
+		"ca.pem": certificate,
+		"app.json": `{"kind": "Application", "version": "v1", "spec": {"name": "${UNSET_NAME:-{}}", "ca": "${file(ca.pem)}",
+			"password": "${DB_PASSWORD}", "fallback": "${UNSET_PASSWORD:-a"b\c}"}}`,
+		"app.yaml": "kind: Application\nversion: v1\nspec:\n  ca: \"${file(ca.pem)}\"\n  password: \"${DB_PASSWORD}\"\n" +
+			"  fallback: \"${UNSET_PASSWORD:-a\"b\\c}\"\n",
+	})
+	for _, name := range []string{"app.json", "app.yaml"} {
+		file := filepath.Join(dir, name)
+		data, err := readDescriptor(file, "", nil)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		document, err := decodeDocument(file, data)
+		if err != nil {
+			t.Fatalf("%s: expected the file content to keep the descriptor valid: %v", name, err)
+		}
+		spec := document.(map[string]any)["spec"].(map[string]any)
+		if spec["ca"] != certificate {
+			t.Errorf("%s: expected the certificate, got %q", name, spec["ca"])
+		}
+		if spec["password"] != password {
+			t.Errorf("%s: expected the password, got %q", name, spec["password"])
+		}
+		if spec["fallback"] != `a"b\c` {
+			t.Errorf("%s: expected the default value, got %q", name, spec["fallback"])
+		}
+	}
+}
+
+func TestOverlayMustMatchKind(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"app.json":      `{"kind": "Application", "version": "v1", "spec": {"name": "app"}}`,
+		"app.prod.json": `{"kind": "ApiPolicies"}`,
+	})
+	if _, err := readDescriptor(filepath.Join(dir, "app.json"), "prod", nil); err == nil {
+		t.Errorf("expected error for overlay of another kind")
+	}
+}
+
+func TestExpandArgsSkipsOverlays(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"app.json":      "{}",
+		"app.prod.json": "{}",
+		"mq.v2.yaml":    "{}",
+	})
+	files, err := expandArgs([]string{dir}, nil, nil, []string{"Prod"})
+	if err != nil {
+		t.Fatalf("failed to expand args: %v", err)
+	}
+	expected := []string{filepath.Join(dir, "app.json"), filepath.Join(dir, "mq.v2.yaml")}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected %v, got %v", expected, files)
+	}
+}
+
+func TestExpandArgsKeepsDottedDescriptors(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"orders.json":        "{}",
+		"orders.backup.json": "{}",
+		"orders.prod.json":   "{}",
+		"orders.v2.json":     "{}",
+	})
+	files, err := expandArgs([]string{dir}, nil, nil, []string{"Sandbox", "Prod"})
+	if err != nil {
+		t.Fatalf("failed to expand args: %v", err)
+	}
+	expected := []string{filepath.Join(dir, "orders.backup.json"), filepath.Join(dir, "orders.json"), filepath.Join(dir, "orders.v2.json")}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected %v, got %v", expected, files)
+	}
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/flagvalidator"
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/plan"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Show the changes that would be made to Anypoint Platform",
+	Long: `Loads every descriptor, fetches the live state from Anypoint Platform and prints a per-field diff
+	of the changes that a deployment of the same descriptors would make. No changes are made.
+
+	Use --output json to get the plan in a machine-readable format and --out to save the plan to a file
+	that can later be executed exactly as reviewed with the apply subcommand.
+	`,
+	Example:   "./chdeploy plan -o <organizationname> -e <environment> --output json *.json",
+	ValidArgs: []string{"*.json", "*.yaml", "*.yml"},
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := runContext()
+		defer cancel()
+		client, organization, environment, privateSpace := connect(ctx)
+
+		// Planning never changes anything in Anypoint Platform
+		viper.Set("dry-run", true)
+
+		changes := plan.New(organization, environment)
+		changes.PrivateSpaceID = privateSpace.ID
+		changes.MqRegion = viper.GetString("mq-region")
+		faults := processFiles(ctx, client, args, organization, environment, privateSpace, changes)
+		if len(faults) > 0 {
+			printFaults(faults)
+			os.Exit(10)
+		}
+
+		switch viper.GetString("output") {
+		case "json":
+			if err := changes.WriteJSON(os.Stdout); err != nil {
+				log.Fatalf("failed to write plan %+v", err)
+			}
+		default:
+			changes.WriteText(os.Stdout)
+		}
+
+		if out := viper.GetString("out"); out != "" {
+			if err := changes.Save(out); err != nil {
+				log.Fatalf("%+v", err)
+			}
+			log.Printf("Plan saved to %s. Execute it with: apply %s", out, out)
+		}
+	},
+}
+
+func init() {
+	planCmd.Flags().String("output", "text", "output format of the plan. Use text for a colorized diff and json for machine-readable output")
+	planCmd.Flags().String("out", "", "save the plan to this file so that it can be executed with the apply subcommand")
+	planCmd.Flags().VisitAll(func(f *pflag.Flag) {
+		viper.BindPFlag(f.Name, f)
+	})
+	flagvalidator.AddFlagSetValidator("output", []any{"text", "json"})
+	rootCmd.AddCommand(planCmd)
+}
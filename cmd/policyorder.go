@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/appconf"
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/plan"
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/resources"
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient"
+	"github.com/TwiN/go-color"
+)
+
+// orderApiPolicies puts the policies of an API instance in the declared order, moving them between the positions
+// they already hold so that undeclared policies keep their place. In a dry run new policies are expected at the end.
+func orderApiPolicies(client anypointclient.ApiManager, organization anypointclient.Organization, environment anypointclient.Environment, apiInstanceID int, apipolicies resources.ApiPoliciesV1, existingPolicies []anypointclient.ApiPolicyResponse, dryRun bool, changes *plan.Recorder) error {
+	var policies []anypointclient.ApiPolicyResponse
+	if dryRun {
+		policies = expectedPolicies(apipolicies, existingPolicies)
+	} else {
+		current, err := client.GetApiInstancePolicies(organization.ID, environment.ID, apiInstanceID)
+		if err != nil {
+			return fmt.Errorf("failed to get API instance policies: %v", err)
+		}
+		policies = *current
+	}
+
+	ordered, reordered := declaredPolicyOrder(policies, apipolicies.Spec.Policies)
+	if !reordered {
+		return nil
+	}
+	changes.Add(plan.Change{
+		Kind:   "ApiPolicyOrder",
+		Name:   strconv.Itoa(apiInstanceID),
+		Action: plan.ActionUpdate,
+		Fields: []appconf.FieldChange{
+			{Field: "order", Current: policyNames(sortedByOrder(policies)), Desired: policyNames(ordered)},
+		},
+		// The order of the policies when the plan is made, before any is created or deleted
+		Fingerprint: plan.Fingerprint(policyIDs(sortedByOrder(existingPolicies))),
+		Payload:     plan.NewPayload(apiPolicyOrderPayload{ApiInstanceID: apiInstanceID, Policies: apipolicies.Spec.Policies}),
+	})
+	if dryRun {
+		log.Println(color.Colorize(color.Yellow, fmt.Sprintf("[DRY-RUN] Would REORDER API Policies for instance %d: %v", apiInstanceID, policyNames(ordered))))
+		return nil
+	}
+	if err := client.ReorderApiInstancePolicies(organization.ID, environment.ID, apiInstanceID, policyOrder(ordered)); err != nil {
+		return fmt.Errorf("failed to reorder API policies of API instance %d: %v", apiInstanceID, err)
+	}
+	log.Println(color.Colorize(color.Green, fmt.Sprintf("API Policies for instance %d successfully reordered: %v", apiInstanceID, policyNames(ordered))))
+	return nil
+}
+
+// expectedPolicies returns the policies an API instance has once the descriptor is deployed, for a dry run
+func expectedPolicies(apipolicies resources.ApiPoliciesV1, existingPolicies []anypointclient.ApiPolicyResponse) []anypointclient.ApiPolicyResponse {
+	var policies []anypointclient.ApiPolicyResponse
+	var declared []int
+	for _, apipolicy := range apipolicies.Spec.Policies {
+		if matchingPolicy := findMatchingPolicy(existingPolicies, apipolicy); matchingPolicy != nil {
+			declared = append(declared, matchingPolicy.PolicyID)
+		}
+	}
+	lastOrder := 0
+	for _, policy := range existingPolicies {
+		lastOrder = max(lastOrder, policy.Order)
+		if apipolicies.Spec.Authoritative && !slices.Contains(declared, policy.PolicyID) {
+			continue
+		}
+		policies = append(policies, policy)
+	}
+	for _, apipolicy := range apipolicies.Spec.Policies {
+		if findMatchingPolicy(existingPolicies, apipolicy) != nil {
+			continue
+		}
+		lastOrder++
+		policy := anypointclient.ApiPolicyResponse{Order: lastOrder, PointcutData: apipolicy.PointcutData}
+		policy.Template.GroupID = apipolicy.GroupID
+		policy.Template.AssetID = apipolicy.AssetID
+		policy.Template.AssetVersion = apipolicy.AssetVersion
+		policies = append(policies, policy)
+	}
+	return policies
+}
+
+// declaredPolicyOrder returns the policies sorted by their order, with the declared policies rearranged in the
+// sequence in which they are declared, and true if any policy has moved
+func declaredPolicyOrder(policies []anypointclient.ApiPolicyResponse, declared []anypointclient.ApiPolicyRequest) ([]anypointclient.ApiPolicyResponse, bool) {
+	sorted := sortedByOrder(policies)
+	// The positions of the declared policies in the chain, in the sequence in which they are declared
+	var declaredPositions []int
+	for _, apipolicy := range declared {
+		position := slices.IndexFunc(sorted, func(policy anypointclient.ApiPolicyResponse) bool {
+			return findMatchingPolicy([]anypointclient.ApiPolicyResponse{policy}, apipolicy) != nil
+		})
+		if position >= 0 && !slices.Contains(declaredPositions, position) {
+			declaredPositions = append(declaredPositions, position)
+		}
+	}
+	positions := slices.Sorted(slices.Values(declaredPositions))
+	if slices.Equal(positions, declaredPositions) {
+		return sorted, false
+	}
+	ordered := slices.Clone(sorted)
+	for i, position := range positions {
+		ordered[position] = sorted[declaredPositions[i]]
+	}
+	return ordered, true
+}
+
+// sortedByOrder returns a copy of the policies sorted by their order in the chain
+func sortedByOrder(policies []anypointclient.ApiPolicyResponse) []anypointclient.ApiPolicyResponse {
+	sorted := slices.Clone(policies)
+	slices.SortStableFunc(sorted, func(a, b anypointclient.ApiPolicyResponse) int { return cmp.Compare(a.Order, b.Order) })
+	return sorted
+}
+
+// policyOrder numbers the policies in the sequence they are given, starting at 1
+func policyOrder(policies []anypointclient.ApiPolicyResponse) []anypointclient.ApiPolicyOrder {
+	order := make([]anypointclient.ApiPolicyOrder, 0, len(policies))
+	for i, policy := range policies {
+		order = append(order, anypointclient.ApiPolicyOrder{ID: policy.PolicyID, Order: i + 1})
+	}
+	return order
+}
+
+func policyIDs(policies []anypointclient.ApiPolicyResponse) []int {
+	ids := make([]int, 0, len(policies))
+	for _, policy := range policies {
+		ids = append(ids, policy.PolicyID)
+	}
+	return ids
+}
+
+func policyNames(policies []anypointclient.ApiPolicyResponse) []string {
+	names := make([]string, 0, len(policies))
+	for _, policy := range policies {
+		names = append(names, policy.Template.AssetID)
+	}
+	return names
+}
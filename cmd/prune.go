@@ -0,0 +1,262 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/plan"
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/resources"
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient"
+	"github.com/TwiN/go-color"
+	"github.com/spf13/viper"
+)
+
+// mqPruner deletes the bindings of the declared exchanges, and the queues and exchanges matching the owns list,
+// that a descriptor no longer declares. Destinations declared by any descriptor of the run, names not matching
+// --prune-mq-allow and queues with messages are never deleted.
+type mqPruner struct {
+	client   anypointclient.MqAdmin
+	orgID    string
+	envID    string
+	region   string
+	declared map[string]bool
+	allow    []string
+	dryRun   bool
+	changes  *plan.Recorder
+}
+
+// newMqPruner returns the pruner of the region, or nil if prune mode is off. The destinations declared by the
+// descriptors of the run are never pruned.
+func newMqPruner(client anypointclient.MqAdmin, orgID, envID, region string, declared map[string]bool, dryRun bool, changes *plan.Recorder) *mqPruner {
+	if !viper.GetBool("prune-mq") {
+		return nil
+	}
+	return &mqPruner{
+		client:   client,
+		orgID:    orgID,
+		envID:    envID,
+		region:   region,
+		declared: declared,
+		allow:    viper.GetStringSlice("prune-mq-allow"),
+		dryRun:   dryRun,
+		changes:  changes,
+	}
+}
+
+// allowed returns true if the name matches a glob of the allow-list, and otherwise tells how to allow it
+func (pruner *mqPruner) allowed(kind, name string) bool {
+	for _, pattern := range pruner.allow {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	log.Println(color.Colorize(color.Yellow, fmt.Sprintf("%s [%s] is no longer declared. Add it to --prune-mq-allow to delete it", kind, name)))
+	return false
+}
+
+// pruneBinding deletes a binding of a declared exchange that is no longer declared
+func (pruner *mqPruner) pruneBinding(exchangeID string, binding anypointclient.MqBinding) error {
+	if !pruner.allowed("Binding", exchangeID+"/"+binding.QueueID) {
+		return nil
+	}
+	pruner.changes.Add(bindingDeletion(exchangeID, binding))
+	if pruner.dryRun {
+		log.Println(color.Colorize(color.Yellow, fmt.Sprintf("[DRY-RUN] Would DELETE binding: [%s -> %s]", exchangeID, binding.QueueID)))
+		return nil
+	}
+	log.Printf("Deleting binding: %s -> %s\n", exchangeID, binding.QueueID)
+	if err := pruner.client.DeleteMqBinding(pruner.orgID, pruner.envID, pruner.region, exchangeID, binding.QueueID); err != nil {
+		return fmt.Errorf("failed to delete binding %s -> %s: %v", exchangeID, binding.QueueID, err)
+	}
+	log.Println(color.Colorize(color.Green, fmt.Sprintf("Binding [%s -> %s] successfully deleted", exchangeID, binding.QueueID)))
+	return nil
+}
+
+// bindingDeletion returns the planned deletion of a binding
+func bindingDeletion(exchangeID string, binding anypointclient.MqBinding) plan.Change {
+	return plan.Change{
+		Kind:        "MqBinding",
+		Name:        fmt.Sprintf("%s -> %s", exchangeID, binding.QueueID),
+		Action:      plan.ActionDelete,
+		Fingerprint: plan.Fingerprint(binding),
+		Payload:     plan.NewPayload(mqBindingPayload{ExchangeID: exchangeID, QueueID: binding.QueueID}),
+	}
+}
+
+// pruneDestinations deletes the exchanges and then the queues that are owned by the descriptor but no longer
+// declared in it or any other descriptor of the run
+func (pruner *mqPruner) pruneDestinations(mqDestinations resources.MqDestinationsV1) error {
+	if len(mqDestinations.Spec.Owns) == 0 {
+		return nil
+	}
+	declared := map[string]bool{}
+	for _, id := range mqDestinationIDs(mqDestinations) {
+		declared[id] = true
+	}
+
+	destinations, err := pruner.client.GetMqDestinations(pruner.orgID, pruner.envID, pruner.region)
+	if err != nil {
+		return fmt.Errorf("failed to list destinations: %v", err)
+	}
+	// Queues referencing a dead letter queue are deleted before the dead letter queues
+	var exchanges, queues, deadLetterQueues []anypointclient.MqDestination
+	for _, destination := range destinations {
+		id := destination.QueueID
+		if destination.Type == "exchange" {
+			id = destination.ExchangeID
+		}
+		if declared[id] || pruner.declared[id] || !owns(mqDestinations.Spec.Owns, id) {
+			continue
+		}
+		switch {
+		case destination.Type == "exchange":
+			exchanges = append(exchanges, destination)
+		case destination.DeadLetterQueueID != "":
+			queues = append(queues, destination)
+		default:
+			deadLetterQueues = append(deadLetterQueues, destination)
+		}
+	}
+	queues = append(queues, deadLetterQueues...)
+
+	for _, exchange := range exchanges {
+		if err := pruner.pruneExchange(exchange.ExchangeID); err != nil {
+			return err
+		}
+	}
+	for _, queue := range queues {
+		if err := pruner.pruneQueue(queue.QueueID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mqDestinationIDs returns the IDs of the queues and exchanges declared by the descriptor
+func mqDestinationIDs(mqDestinations resources.MqDestinationsV1) []string {
+	var ids []string
+	for _, queue := range mqDestinations.Spec.Queues {
+		ids = append(ids, queue.QueueID)
+	}
+	for _, exchange := range mqDestinations.Spec.Exchanges {
+		ids = append(ids, exchange.ExchangeID)
+	}
+	return ids
+}
+
+// owns returns true if the destination ID matches one of the globs
+func owns(patterns []string, id string) bool {
+	return slices.ContainsFunc(patterns, func(pattern string) bool {
+		matched, _ := path.Match(pattern, id)
+		return matched
+	})
+}
+
+func (pruner *mqPruner) pruneExchange(exchangeID string) error {
+	if !pruner.allowed("Exchange", exchangeID) {
+		return nil
+	}
+	existingExchange, err := pruner.client.GetMqExchange(pruner.orgID, pruner.envID, pruner.region, exchangeID)
+	if err != nil {
+		return fmt.Errorf("failed to get exchange %s: %v", exchangeID, err)
+	}
+	bindings, err := pruner.client.GetMqExchangeBindings(pruner.orgID, pruner.envID, pruner.region, exchangeID)
+	if err != nil {
+		return fmt.Errorf("failed to get bindings of exchange %s: %v", exchangeID, err)
+	}
+	// The bindings are deleted before the exchange, each of them is planned as a deletion of its own
+	for _, binding := range bindings {
+		pruner.changes.Add(bindingDeletion(exchangeID, binding))
+	}
+	pruner.changes.Add(plan.Change{Kind: "MqExchange", Name: exchangeID, Action: plan.ActionDelete, Fingerprint: exchangeDeletionFingerprint(existingExchange, bindings)})
+	if pruner.dryRun {
+		log.Println(color.Colorize(color.Yellow, fmt.Sprintf("[DRY-RUN] Would DELETE exchange and its bindings: [%s]", exchangeID)))
+		return nil
+	}
+	log.Printf("Deleting exchange: %s\n", exchangeID)
+	if err := deleteMqExchange(pruner.client, pruner.orgID, pruner.envID, pruner.region, exchangeID); err != nil {
+		return err
+	}
+	log.Println(color.Colorize(color.Green, fmt.Sprintf("Exchange [%s] successfully deleted", exchangeID)))
+	return nil
+}
+
+func (pruner *mqPruner) pruneQueue(queueID string) error {
+	if !pruner.allowed("Queue", queueID) {
+		return nil
+	}
+	existingQueue, err := pruner.client.GetMqQueue(pruner.orgID, pruner.envID, pruner.region, queueID)
+	if err != nil {
+		return fmt.Errorf("failed to get queue %s: %v", queueID, err)
+	}
+	pruner.changes.Add(plan.Change{Kind: "MqQueue", Name: queueID, Action: plan.ActionDelete, Fingerprint: plan.Fingerprint(existingQueue)})
+	if pruner.dryRun {
+		// Checked in a dry run as well, so that a plan only has deletions that can be made
+		if err := checkQueueEmpty(pruner.client, pruner.orgID, pruner.envID, pruner.region, queueID); err != nil {
+			return err
+		}
+		log.Println(color.Colorize(color.Yellow, fmt.Sprintf("[DRY-RUN] Would DELETE queue: [%s]", queueID)))
+		return nil
+	}
+	log.Printf("Deleting queue: %s\n", queueID)
+	if err := deleteMqQueue(pruner.client, pruner.orgID, pruner.envID, pruner.region, queueID); err != nil {
+		return err
+	}
+	log.Println(color.Colorize(color.Green, fmt.Sprintf("Queue [%s] successfully deleted", queueID)))
+	return nil
+}
+
+// checkQueueEmpty returns an error if the queue has messages waiting or in flight
+func checkQueueEmpty(client anypointclient.MqAdmin, orgID, envID, region, queueID string) error {
+	stats, err := client.GetMqQueueStats(orgID, envID, region, queueID)
+	if err != nil {
+		return fmt.Errorf("failed to get the messages in queue %s: %v", queueID, err)
+	}
+	if !stats.IsEmpty() {
+		return fmt.Errorf("queue %s is no longer declared but has %d messages and %d in flight, refusing to delete it", queueID, stats.Messages, stats.InflightMessages)
+	}
+	return nil
+}
+
+// deleteMqQueue deletes a queue after checking again that it is empty
+func deleteMqQueue(client anypointclient.MqAdmin, orgID, envID, region, queueID string) error {
+	if err := checkQueueEmpty(client, orgID, envID, region, queueID); err != nil {
+		return err
+	}
+	if err := client.DeleteMqQueue(orgID, envID, region, queueID); err != nil {
+		return fmt.Errorf("failed to delete queue %s: %v", queueID, err)
+	}
+	return nil
+}
+
+// exchangeDeletionFingerprint fingerprints an exchange together with its bindings, so that a binding added since
+// the deletion of the exchange was planned is detected as drift
+func exchangeDeletionFingerprint(exchange *anypointclient.MqDestination, bindings []anypointclient.MqBinding) string {
+	bindings = slices.SortedFunc(slices.Values(bindings), func(a, b anypointclient.MqBinding) int {
+		return strings.Compare(a.QueueID, b.QueueID)
+	})
+	return plan.Fingerprint(struct {
+		Exchange *anypointclient.MqDestination `json:"exchange"`
+		Bindings []anypointclient.MqBinding    `json:"bindings"`
+	}{exchange, bindings})
+}
+
+// deleteMqExchange deletes the bindings of an exchange and then the exchange
+func deleteMqExchange(client anypointclient.MqAdmin, orgID, envID, region, exchangeID string) error {
+	bindings, err := client.GetMqExchangeBindings(orgID, envID, region, exchangeID)
+	if err != nil {
+		return fmt.Errorf("failed to get bindings of exchange %s: %v", exchangeID, err)
+	}
+	for _, binding := range bindings {
+		if err := client.DeleteMqBinding(orgID, envID, region, exchangeID, binding.QueueID); err != nil {
+			return fmt.Errorf("failed to delete binding %s -> %s: %v", exchangeID, binding.QueueID, err)
+		}
+	}
+	if err := client.DeleteMqExchange(orgID, envID, region, exchangeID); err != nil {
+		return fmt.Errorf("failed to delete exchange %s: %v", exchangeID, err)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/plan"
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/resources"
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient"
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient/anypointclienttest"
+)
+
+// pruneFake returns a fake with the declared queue orders and exchange events, the owned but undeclared queue
+// orders-old and exchange orders-archive, and the queue billing that is not owned
+func pruneFake(t *testing.T) *anypointclienttest.Fake {
+	fake := anypointclienttest.NewFake(testOrganization)
+	for _, queueID := range []string{"orders", "orders-old", "billing"} {
+		fake.AddMqDestination("org-id", "env-id", "us-east-1", anypointclient.MqDestination{Type: "queue", QueueID: queueID, Encrypted: true})
+	}
+	for _, exchangeID := range []string{"events", "orders-archive"} {
+		fake.AddMqDestination("org-id", "env-id", "us-east-1", anypointclient.MqDestination{Type: "exchange", ExchangeID: exchangeID, Encrypted: true})
+	}
+	for _, binding := range [][2]string{{"events", "orders"}, {"events", "billing"}, {"orders-archive", "orders-old"}} {
+		if err := fake.CreateMqBinding("org-id", "env-id", "us-east-1", binding[0], binding[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake.Reset()
+	return fake
+}
+
+func pruneDestinations() resources.MqDestinationsV1 {
+	var destinations resources.MqDestinationsV1
+	destinations.Spec.Queues = []anypointclient.MqQueue{{QueueID: "orders"}}
+	exchange := resources.MqExchangeWithBindings{MqExchange: anypointclient.MqExchange{ExchangeID: "events"}}
+	exchange.Bindings = []anypointclient.MqBinding{{QueueID: "orders"}}
+	destinations.Spec.Exchanges = []resources.MqExchangeWithBindings{exchange}
+	destinations.Spec.Owns = []string{"orders*"}
+	return destinations
+}
+
+func TestPruneMqDestinations(t *testing.T) {
+	setFlag(t, "dry-run", false)
+	setFlag(t, "mq-region", "us-east-1")
+	setFlag(t, "prune-mq", true)
+	setFlag(t, "prune-mq-allow", []string{"*", "*/*"})
+	fake := pruneFake(t)
+
+	if err := deployMqDestinations(pruneDestinations(), fake, testOrganization, testEnvironment, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"DeleteMqBinding", "DeleteMqBinding", "DeleteMqExchange", "DeleteMqQueue"}
+	if calls := mutatingCalls(fake); !slices.Equal(calls, expected) {
+		t.Errorf("expected the undeclared binding, exchange and queue to be deleted, got %v", calls)
+	}
+	if bindings := fake.MqBindings("org-id", "env-id", "us-east-1", "events"); len(bindings) != 1 || bindings[0].QueueID != "orders" {
+		t.Errorf("expected only the declared binding to remain, got %+v", bindings)
+	}
+	for _, id := range []string{"orders-old", "orders-archive"} {
+		if _, ok := fake.MqDestination("org-id", "env-id", "us-east-1", id); ok {
+			t.Errorf("expected %s to be deleted", id)
+		}
+	}
+	if _, ok := fake.MqDestination("org-id", "env-id", "us-east-1", "billing"); !ok {
+		t.Errorf("expected the queue billing, not owned by the descriptor, to be kept")
+	}
+}
+
+func TestPruneMqDestinationsNeedsAllowList(t *testing.T) {
+	setFlag(t, "dry-run", false)
+	setFlag(t, "mq-region", "us-east-1")
+	setFlag(t, "prune-mq", true)
+	setFlag(t, "prune-mq-allow", []string{"orders-archive"})
+	fake := pruneFake(t)
+
+	if err := deployMqDestinations(pruneDestinations(), fake, testOrganization, testEnvironment, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if calls := mutatingCalls(fake); !slices.Equal(calls, []string{"DeleteMqBinding", "DeleteMqExchange"}) {
+		t.Errorf("expected only the allowed exchange to be deleted, got %v", calls)
+	}
+}
+
+func TestPruneMqDestinationsRefusesNonEmptyQueue(t *testing.T) {
+	setFlag(t, "dry-run", false)
+	setFlag(t, "mq-region", "us-east-1")
+	setFlag(t, "prune-mq", true)
+	setFlag(t, "prune-mq-allow", []string{"orders-old"})
+	fake := pruneFake(t)
+	fake.SetMqQueueMessages("org-id", "env-id", "us-east-1", "orders-old", 0, 2)
+
+	err := deployMqDestinations(pruneDestinations(), fake, testOrganization, testEnvironment, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "refusing to delete") {
+		t.Fatalf("expected the queue with messages in flight to be refused, got %v", err)
+	}
+	if calls := mutatingCalls(fake); len(calls) != 0 {
+		t.Errorf("expected nothing to be deleted, got %v", calls)
+	}
+}
+
+func TestPruneMqDestinationsDryRun(t *testing.T) {
+	setFlag(t, "dry-run", true)
+	setFlag(t, "mq-region", "us-east-1")
+	setFlag(t, "prune-mq", true)
+	setFlag(t, "prune-mq-allow", []string{"*", "*/*"})
+	fake := pruneFake(t)
+	changes := plan.New(testOrganization, testEnvironment)
+
+	if err := deployMqDestinations(pruneDestinations(), fake, testOrganization, testEnvironment, nil, changes.Source("mq.json")); err != nil {
+		t.Fatal(err)
+	}
+	if calls := mutatingCalls(fake); len(calls) != 0 {
+		t.Errorf("expected nothing to be changed in a dry run, got %v", calls)
+	}
+	var deletions []string
+	for _, change := range changes.Changes {
+		if change.Action == plan.ActionDelete {
+			deletions = append(deletions, change.Kind+" "+change.Name)
+		}
+	}
+	expected := []string{"MqBinding events -> billing", "MqBinding orders-archive -> orders-old", "MqExchange orders-archive", "MqQueue orders-old"}
+	if !slices.Equal(deletions, expected) {
+		t.Errorf("expected the deletions to be planned, got %v", deletions)
+	}
+}
+
+func TestPruneMqDestinationsKeepsDestinationsOfOtherDescriptors(t *testing.T) {
+	setFlag(t, "dry-run", false)
+	setFlag(t, "mq-region", "us-east-1")
+	setFlag(t, "prune-mq", true)
+	setFlag(t, "prune-mq-allow", []string{"*", "*/*"})
+	fake := pruneFake(t)
+	dir := t.TempDir()
+	// orders-old matches the owns list of the first descriptor, but is declared by the second one
+	writeFiles(t, dir, map[string]string{
+		"a-orders.json": `{"kind": "MqDestinations", "version": "v1", "spec": {
+			"queues": [{"queueId": "orders"}], "owns": ["orders*"]}}`,
+		"b-archive.json": `{"kind": "MqDestinations", "version": "v1", "spec": {
+			"queues": [{"queueId": "orders-old"}]}}`,
+	})
+
+	faults := processFiles(context.Background(), fake, []string{dir}, testOrganization, testEnvironment, anypointclient.PrivateSpace{}, plan.New(testOrganization, testEnvironment))
+	if len(faults) > 0 {
+		t.Fatalf("unexpected faults %v", faults)
+	}
+	if _, ok := fake.MqDestination("org-id", "env-id", "us-east-1", "orders-old"); !ok {
+		t.Errorf("expected the queue declared by the other descriptor to be kept")
+	}
+	if _, ok := fake.MqDestination("org-id", "env-id", "us-east-1", "orders-archive"); ok {
+		t.Errorf("expected the owned exchange declared by no descriptor to be deleted")
+	}
+}
+
+func TestExchangeDeletionFingerprint(t *testing.T) {
+	exchange := &anypointclient.MqDestination{Type: "exchange", ExchangeID: "orders-archive"}
+	bindings := []anypointclient.MqBinding{{QueueID: "orders-old"}, {QueueID: "orders-older"}}
+	fingerprint := exchangeDeletionFingerprint(exchange, bindings)
+
+	if reordered := exchangeDeletionFingerprint(exchange, []anypointclient.MqBinding{bindings[1], bindings[0]}); reordered != fingerprint {
+		t.Errorf("expected the order of the bindings not to matter")
+	}
+	if added := exchangeDeletionFingerprint(exchange, append(bindings, anypointclient.MqBinding{QueueID: "billing"})); added == fingerprint {
+		t.Errorf("expected a binding added since the plan was made to change the fingerprint")
+	}
+}
@@ -1,17 +1,23 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"reflect"
-	"strconv"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/Redpill-Linpro/anypointchdeployer/internal/appconf"
 	"github.com/Redpill-Linpro/anypointchdeployer/internal/flagvalidator"
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/plan"
 	"github.com/Redpill-Linpro/anypointchdeployer/internal/resources"
 	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient"
 	"github.com/TwiN/go-color"
@@ -20,6 +26,9 @@ import (
 	"github.com/spf13/viper"
 )
 
+// rolloutPollInterval is how often the deployment is fetched while waiting for a rollout
+const rolloutPollInterval = 10 * time.Second
+
 var rootCmd = &cobra.Command{
 	Use:   "anypointchdeployer",
 	Short: "CLI tool to deploy and update resources in Anypoint Platform",
@@ -28,37 +37,51 @@ var rootCmd = &cobra.Command{
 	The tool supports 
 	* Mule application running in Anypoint CloudHub 2.0 using application artifacts stored in Exchange
 	* API instance policies for APIs managed in Anypoint API manager
+	* Anypoint MQ queues, exchanges and bindings
+
+	Descriptors can be written in JSON or, using the .yaml or .yml file extension, in YAML.
 	`,
 	Example:   "./chdeploy -u <username> -p <password> -o <organizationname> -e <environment> *.json",
-	ValidArgs: []string{"*.json"},
+	ValidArgs: []string{"*.json", "*.yaml", "*.yml"},
+	Args:      cobra.ArbitraryArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := flagvalidator.ValidateFlags(); err != nil {
-			log.Fatalf("%+v\n", err)
-		}
-		client := appconf.GetAnypointClient()
+		ctx, cancel := runContext()
+		defer cancel()
+		client, organization, environment, privateSpace := connect(ctx)
+		changes := plan.New(organization, environment)
+		deployConfig(ctx, client, args, organization, environment, privateSpace, changes)
+	},
+}
 
-		err := client.Login()
-		if err != nil {
-			log.Fatalf("Fail to login to anypoint platform %+v\n", err)
-		}
-		organization, err := client.ResolveOrganization(viper.GetString("organization"))
-		if err != nil {
-			log.Fatalf("failed to get organization %+v", err)
-		}
+// connect validates the flags, logs in to Anypoint Platform and resolves the organization, environment and private space.
+// Every call made by the returned client is cancelled when ctx is done.
+func connect(ctx context.Context) (*anypointclient.AnypointClient, anypointclient.Organization, anypointclient.Environment, anypointclient.PrivateSpace) {
+	if err := flagvalidator.ValidateFlags(); err != nil {
+		log.Fatalf("%+v\n", err)
+	}
+	client := appconf.GetAnypointClient().WithContext(ctx)
+
+	err := client.Login()
+	if err != nil {
+		log.Fatalf("Fail to login to anypoint platform %+v\n", err)
+	}
+	organization, err := client.ResolveOrganization(viper.GetString("organization"))
+	if err != nil {
+		log.Fatalf("failed to get organization %+v", err)
+	}
 
-		environment, err := client.ResolveEnvironment(organization, viper.GetString("environment"))
+	environment, err := client.ResolveEnvironment(organization, viper.GetString("environment"))
+	if err != nil {
+		log.Fatalf("failed to get environment %+v", err)
+	}
+	var privateSpace anypointclient.PrivateSpace = anypointclient.PrivateSpace{}
+	if viper.GetString("private-space") != "" {
+		privateSpace, err = client.ResolvePrivateSpace(organization, viper.GetString("private-space"))
 		if err != nil {
-			log.Fatalf("failed to get environment %+v", err)
+			log.Fatalf("failed to get private space %+v", err)
 		}
-		var privateSpace anypointclient.PrivateSpace = anypointclient.PrivateSpace{}
-		if viper.GetString("private-space") != "" {
-			privateSpace, err = client.ResolvePrivateSpace(organization, viper.GetString("private-space"))
-			if err != nil {
-				log.Fatalf("failed to get private space %+v", err)
-			}
-		}
-		deployConfig(client, args, organization, environment, privateSpace)
-	},
+	}
+	return client, organization, environment, privateSpace
 }
 
 func Execute() {
@@ -69,22 +92,41 @@ func Execute() {
 }
 
 func init() {
-	rootCmd.Flags().StringP("region", "r", "US", "region for Anypoint. Use US for US control plane and EU for EU control plane")
-	rootCmd.Flags().StringP("base-url", "l", "", "base url for Anypoint platform")
-	rootCmd.Flags().StringP("proxy", "x", "", "HTTP proxy URL (e.g., http://proxy:8080)")
-	rootCmd.Flags().StringP("authtype", "a", "connectedapp", "authentication method towards Anypoint Platform")
-	rootCmd.Flags().StringP("bearer", "b", "", "authentication bearer token used to authenticate with Anypoint")
-	rootCmd.Flags().StringP("user", "u", "", "user to use to login to Anypoint if token is not provided")
-	rootCmd.Flags().StringP("password", "p", "", "password for the Anypoint user")
-	rootCmd.Flags().StringP("client-id", "i", "", "client id for the Anypoint connected app")
-	rootCmd.Flags().StringP("client-secret", "s", "", "client secret for the Anypoint connected app")
-	rootCmd.Flags().StringP("organization", "o", "", "organization within Anypoint Platform")
-	rootCmd.Flags().StringP("environment", "e", "", "environment within Anypoint Platform")
-	rootCmd.Flags().StringP("private-space", "v", "", "private space within Anypint Platform")
-	rootCmd.Flags().BoolP("force-update", "f", false, "force update even if no changes are detected")
+	rootCmd.PersistentFlags().StringP("region", "r", "US", "region for Anypoint. Use US for US control plane and EU for EU control plane")
+	rootCmd.PersistentFlags().StringP("base-url", "l", "", "base url for Anypoint platform")
+	rootCmd.PersistentFlags().StringP("proxy", "x", "", "HTTP proxy URL (e.g., http://proxy:8080)")
+	rootCmd.PersistentFlags().Int("max-retries", 3, "max number of retries of a request failing with a network error, 429 or 5xx")
+	rootCmd.PersistentFlags().Duration("max-retry-backoff", 30*time.Second, "max wait between two attempts of a request, including waits asked for with Retry-After")
+	rootCmd.PersistentFlags().Duration("timeout", 0, "max time of the whole run, after which running deployments are cancelled. 0 means no limit")
+	rootCmd.PersistentFlags().Duration("request-timeout", 2*time.Minute, "max time of each request to Anypoint Platform, including its retries. 0 means no limit")
+	rootCmd.PersistentFlags().StringP("authtype", "a", "connectedapp", "authentication method towards Anypoint Platform")
+	rootCmd.PersistentFlags().StringP("bearer", "b", "", "authentication bearer token used to authenticate with Anypoint")
+	rootCmd.PersistentFlags().StringP("user", "u", "", "user to use to login to Anypoint if token is not provided")
+	rootCmd.PersistentFlags().StringP("password", "p", "", "password for the Anypoint user")
+	rootCmd.PersistentFlags().StringP("client-id", "i", "", "client id for the Anypoint connected app")
+	rootCmd.PersistentFlags().StringP("client-secret", "s", "", "client secret for the Anypoint connected app")
+	rootCmd.PersistentFlags().StringP("organization", "o", "", "organization within Anypoint Platform")
+	rootCmd.PersistentFlags().StringP("environment", "e", "", "environment within Anypoint Platform")
+	rootCmd.PersistentFlags().StringP("private-space", "v", "", "private space within Anypint Platform")
+	rootCmd.PersistentFlags().BoolP("force-update", "f", false, "force update even if no changes are detected")
 	rootCmd.Flags().Bool("dry-run", false, "show what would be done without making any changes")
-	rootCmd.Flags().IntP("concurrent-deployments", "c", 1, "max number of concurrent deploys")
-	rootCmd.Flags().StringP("mq-region", "m", "", "MQ region for Anypoint MQ destinations (e.g., eu-west-1, us-east-1)")
+	rootCmd.PersistentFlags().IntP("concurrent-deployments", "c", 1, "max number of concurrent deploys")
+	rootCmd.PersistentFlags().StringP("mq-region", "m", "", "MQ region for Anypoint MQ destinations (e.g., eu-west-1, us-east-1)")
+	rootCmd.PersistentFlags().Bool("prune-mq", false, "delete the MQ bindings of declared exchanges, and the queues and exchanges owned by a descriptor, that are no longer declared")
+	rootCmd.PersistentFlags().StringSlice("prune-mq-allow", nil, "globs of the queue and exchange IDs, and exchangeID/queueID bindings, that --prune-mq may delete")
+	rootCmd.PersistentFlags().StringSlice("include", nil, "globs selecting the files read from directories. Defaults to all .json, .yaml and .yml files")
+	rootCmd.PersistentFlags().StringSlice("exclude", nil, "globs excluding files read from directories")
+	rootCmd.PersistentFlags().StringSlice("overlay-environments", nil, "names of environments, besides the ones of the organization, that descriptors have overlays for")
+	rootCmd.PersistentFlags().String("vault-address", "", "address of the Vault server resolving vault:// secure properties. Defaults to VAULT_ADDR")
+	rootCmd.PersistentFlags().String("vault-token", "", "token used to read vault:// secure properties. Defaults to VAULT_TOKEN")
+	rootCmd.PersistentFlags().String("secure-properties-key", "", "key of the HMAC recording the secure property values in a deployment label. Defaults to SECURE_PROPERTIES_KEY")
+	rootCmd.PersistentFlags().Bool("strict", false, "check that every template reference in every file can be resolved before anything is deployed")
+	rootCmd.PersistentFlags().Bool("wait", false, "wait for every deployment rollout to finish and fail if a replica does not start")
+	rootCmd.PersistentFlags().Bool("rollback", false, "roll back to the previous application version, properties and runtime when a rollout fails. Implies --wait")
+	rootCmd.PersistentFlags().Duration("wait-timeout", 10*time.Minute, "max time to wait for a deployment rollout to finish")
+	rootCmd.PersistentFlags().VisitAll(func(f *pflag.Flag) {
+		viper.BindPFlag(f.Name, f)
+	})
 	rootCmd.Flags().VisitAll(func(f *pflag.Flag) {
 		viper.BindPFlag(f.Name, f)
 	})
@@ -93,11 +135,182 @@ func init() {
 	flagvalidator.AddFlagSetValidator("concurrent-deployments", []any{1, 2, 3, 4, 5})
 }
 
-func deployConfig(client *anypointclient.AnypointClient, files []string, organization anypointclient.Organization, environment anypointclient.Environment, privateSpace anypointclient.PrivateSpace) {
+func deployConfig(ctx context.Context, client anypointclient.AnypointAPI, files []string, organization anypointclient.Organization, environment anypointclient.Environment, privateSpace anypointclient.PrivateSpace, changes *plan.Plan) {
+	faults := processFiles(ctx, client, files, organization, environment, privateSpace, changes)
+	if len(faults) > 0 {
+		printFaults(faults)
+		os.Exit(10)
+	}
+	log.Println(color.Colorize(color.Green, "All deployments handled successfully!\n"))
+}
+
+// printFaults logs every fault collected while processing the descriptor files
+func printFaults(faults []error) {
+	for _, fault := range faults {
+		log.Println(color.Colorize(color.Red, fmt.Sprintf("%+v\n", fault)))
+	}
+	printInterruption(faults)
+}
+
+// processFiles reads every descriptor file, walking directories, and deploys, or in dry-run mode plans,
+// the resources they contain. Every change found is recorded in changes. Files not started when ctx is done
+// are reported as interrupted.
+func processFiles(ctx context.Context, client anypointclient.AnypointAPI, args []string, organization anypointclient.Organization, environment anypointclient.Environment, privateSpace anypointclient.PrivateSpace, changes *plan.Plan) []error {
+	environments, err := overlayEnvironments(ctx, client, organization, environment)
+	if err != nil {
+		return []error{err}
+	}
+	files, err := expandArgs(args, viper.GetStringSlice("include"), viper.GetStringSlice("exclude"), environments)
+	if err != nil {
+		return []error{err}
+	}
+
+	run := newRun(client, organization, environment, privateSpace, changes)
+	apiInstanceFiles, otherFiles, faults := run.readFiles(ctx, files)
+	if len(faults) > 0 && viper.GetBool("strict") {
+		return faults
+	}
+	// The files declaring API instances are deployed first, so that the others can refer to the instances
+	faults = append(faults, run.deployFiles(ctx, apiInstanceFiles)...)
+	return append(faults, run.deployFiles(ctx, otherFiles)...)
+}
+
+// run deploys the descriptor files of one invocation. The files share where they are deployed, the plan their
+// changes are recorded in, the API instances deployed by their ApiInstance descriptors, the MQ destinations
+// declared by their MqDestinations descriptors, which are never pruned, and the resolved secure properties of
+// their applications by source.
+type run struct {
+	client           anypointclient.AnypointAPI
+	organization     anypointclient.Organization
+	environment      anypointclient.Environment
+	privateSpace     anypointclient.PrivateSpace
+	changes          *plan.Plan
+	apiInstances     *apiInstanceRegistry
+	mqDestinations   map[string]bool
+	secureProperties map[string]map[string]string
+}
+
+func newRun(client anypointclient.AnypointAPI, organization anypointclient.Organization, environment anypointclient.Environment, privateSpace anypointclient.PrivateSpace, changes *plan.Plan) *run {
+	return &run{
+		client:           client,
+		organization:     organization,
+		environment:      environment,
+		privateSpace:     privateSpace,
+		changes:          changes,
+		apiInstances:     newApiInstanceRegistry(),
+		mqDestinations:   map[string]bool{},
+		secureProperties: map[string]map[string]string{},
+	}
+}
+
+// descriptorFile is a descriptor file read before anything is deployed. The descriptors of a file referring to
+// API instances are nil, the file is read again once the IDs of the instances are known.
+type descriptorFile struct {
+	name        string
+	descriptors []any
+}
+
+// readFiles reads and decodes every descriptor file once and resolves the secrets of its applications, so that
+// no file is deployed with --strict unless all files can be read, and collects the MQ destinations declared by all
+// files. It splits the files into those declaring API instances and the others. Every file that can not be read,
+// has a secret that can not be resolved, or refers to an API instance not declared by any of the files,
+// is returned as a fault.
+func (run *run) readFiles(ctx context.Context, files []string) ([]descriptorFile, []descriptorFile, []error) {
+	type readFile struct {
+		descriptorFile
+		declares   bool
+		references []string
+	}
+	var read []readFile
+	var faults []error
+	declared := map[string]bool{}
+	for _, file := range files {
+		log.Printf("Reading file: %s", file)
+		var references []string
+		data, err := readDescriptor(file, run.environment.Name, func(name string) (string, error) {
+			references = append(references, name)
+			return pendingApiInstance(name), nil
+		})
+		if err != nil {
+			faults = append(faults, err)
+			continue
+		}
+		descriptors, errs := decodeDescriptors(file, data)
+		if len(errs) > 0 {
+			faults = append(faults, errs...)
+			continue
+		}
+		if errs := run.resolveSecrets(ctx, file, descriptors); len(errs) > 0 {
+			faults = append(faults, errs...)
+			continue
+		}
+		entry := readFile{descriptorFile: descriptorFile{name: file, descriptors: descriptors}, references: references}
+		for _, descriptor := range descriptors {
+			switch r := descriptor.(type) {
+			case resources.ApiInstanceV1:
+				declared[r.Spec.Name] = true
+				entry.declares = true
+			case resources.MqDestinationsV1:
+				for _, id := range mqDestinationIDs(r) {
+					run.mqDestinations[id] = true
+				}
+			}
+		}
+		read = append(read, entry)
+	}
+
+	var declaring, others []descriptorFile
+	for _, entry := range read {
+		if undeclared := slices.DeleteFunc(slices.Clone(entry.references), func(name string) bool { return declared[name] }); len(undeclared) > 0 {
+			faults = append(faults, fmt.Errorf("%s: API instances %s are not deployed by an ApiInstance descriptor of this run", entry.name, strings.Join(undeclared, ", ")))
+			continue
+		}
+		if len(entry.references) > 0 {
+			entry.descriptors = nil
+		}
+		if entry.declares {
+			declaring = append(declaring, entry.descriptorFile)
+		} else {
+			others = append(others, entry.descriptorFile)
+		}
+	}
+	return declaring, others, faults
+}
+
+// resolveSecrets resolves the secret references in the secure properties of the applications of a file,
+// so that they are resolved once and a missing secret is reported before anything is deployed
+func (run *run) resolveSecrets(ctx context.Context, file string, descriptors []any) []error {
+	var faults []error
+	for i, descriptor := range descriptors {
+		application, ok := descriptor.(resources.ApplicationV1)
+		if !ok {
+			continue
+		}
+		source := resourceSource(file, i, len(descriptors))
+		resolved, err := resolveSecureProperties(ctx, application.Spec, filepath.Dir(file))
+		if err != nil {
+			faults = append(faults, fmt.Errorf("%s: %w", source, err))
+			continue
+		}
+		run.secureProperties[source] = resolved.Application.Configuration.MuleAgentApplicationPropertiesService.SecureProperties
+	}
+	return faults
+}
+
+// withSecrets returns the application declared at source in file with its secure properties resolved by readFiles,
+// or resolved now if the file was not read by readFiles
+func (run *run) withSecrets(ctx context.Context, file string, source string, application anypointclient.CloudhubDeploymentReq) (anypointclient.CloudhubDeploymentReq, error) {
+	if secureProperties, found := run.secureProperties[source]; found {
+		return withSecureProperties(application, secureProperties), nil
+	}
+	return resolveSecureProperties(ctx, application, filepath.Dir(file))
+}
 
+// deployFiles processes the files, as many at a time as --concurrent-deployments allows
+func (run *run) deployFiles(ctx context.Context, files []descriptorFile) []error {
 	var wg sync.WaitGroup
 	guard := make(chan struct{}, viper.GetInt("concurrent-deployments"))
-	faults := make(chan error, len(files))
+	faults := make(chan []error, len(files))
 
 	defer func() {
 		close(guard)
@@ -105,59 +318,85 @@ func deployConfig(client *anypointclient.AnypointClient, files []string, organiz
 
 	for _, file := range files {
 		wg.Add(1)
-		go func(file string) {
+		go func(file descriptorFile) {
 			guard <- struct{}{}
 			defer func() {
 				wg.Done()
 				<-guard
 			}()
-
-			log.Printf("Reading file: %s", file)
-
-			fileData, err := os.ReadFile(file)
-			if err != nil {
-				faults <- fmt.Errorf("failed to open file: %s. Error: %v", file, err)
+			if ctx.Err() != nil {
+				faults <- []error{interrupted(ctx, file.name, false, nil)}
 				return
 			}
-			expandedData := os.ExpandEnv(string(fileData))
-
-			resource, err := unmarshalResource([]byte(expandedData))
-			if err != nil {
-				faults <- fmt.Errorf("failed to decode %v %+v", flag.Arg(0), err)
+			if file.descriptors == nil {
+				faults <- run.processFile(ctx, file.name)
 				return
 			}
-			switch r := resource.(type) {
-			case resources.ApplicationV1:
-				err = deployApplication(r.Spec, client, organization, environment, privateSpace)
-				if err != nil {
-					faults <- err
-					return
-				}
-			case resources.ApiPoliciesV1:
-				err = deployApiPolicy(r, client, organization, environment)
-				if err != nil {
-					faults <- err
-					return
-				}
-			case resources.MqDestinationsV1:
-				err = deployMqDestinations(r, client, organization, environment)
-				if err != nil {
-					faults <- err
-					return
-				}
-			}
-
+			faults <- run.deployDescriptors(ctx, file.name, file.descriptors)
 		}(file)
 	}
 	wg.Wait()
 	close(faults)
-	if len(faults) > 0 {
-		for fault := range faults {
-			log.Println(color.Colorize(color.Red, fmt.Sprintf("%+v\n", fault)))
+
+	var errs []error
+	for fileFaults := range faults {
+		errs = append(errs, fileFaults...)
+	}
+	return errs
+}
+
+// processFile reads a descriptor file, resolving the references to the API instances deployed so far,
+// and deploys its resources
+func (run *run) processFile(ctx context.Context, file string) []error {
+	log.Printf("Reading file: %s", file)
+
+	data, err := readDescriptor(file, run.environment.Name, run.apiInstances.lookup)
+	if err != nil {
+		return []error{err}
+	}
+
+	descriptors, errs := decodeDescriptors(file, data)
+	if len(errs) > 0 {
+		return errs
+	}
+	return run.deployDescriptors(ctx, file, descriptors)
+}
+
+// deployDescriptors deploys the resources of a descriptor file in the order they are declared.
+// A resource that fails does not stop the following ones, every fault is reported with the resource index.
+// Once ctx is done the remaining resources are reported as interrupted.
+func (run *run) deployDescriptors(ctx context.Context, file string, descriptors []any) []error {
+	var faults []error
+	for i, resource := range descriptors {
+		source := resourceSource(file, i, len(descriptors))
+		if ctx.Err() != nil {
+			faults = append(faults, interrupted(ctx, source, false, nil))
+			continue
+		}
+		recorder := run.changes.Source(source)
+		var err error
+		switch r := resource.(type) {
+		case resources.ApplicationV1:
+			var application anypointclient.CloudhubDeploymentReq
+			if application, err = run.withSecrets(ctx, file, source, r.Spec); err == nil {
+				err = deployApplication(application, run.client, run.organization, run.environment, run.privateSpace, recorder)
+			}
+		case resources.ApiInstanceV1:
+			err = deployApiInstance(ctx, r, run.client, run.organization, run.environment, run.apiInstances, recorder)
+		case resources.ApiPoliciesV1:
+			err = deployApiPolicy(ctx, r, run.client, run.organization, run.environment, recorder)
+		case resources.MqDestinationsV1:
+			err = deployMqDestinations(r, run.client, run.organization, run.environment, run.mqDestinations, recorder)
+		}
+		if err = interrupted(ctx, source, true, err); err != nil {
+			var interruption *interruptedError
+			if !errors.As(err, &interruption) {
+				err = fmt.Errorf("%s: %w", source, err)
+			}
+			faults = append(faults, err)
 		}
-		os.Exit(10)
 	}
-	log.Println(color.Colorize(color.Green, "All deployments handled successfully!\n"))
+	return faults
 }
 
 func unmarshalResource(data []byte) (any, error) {
@@ -193,6 +432,18 @@ func unmarshalResource(data []byte) (any, error) {
 			return nil, fmt.Errorf("unknown api policies version: %s", vr.Version)
 		}
 
+	case "ApiInstance":
+		switch vr.Version {
+		case "v1":
+			var r resources.ApiInstanceV1
+			if err := json.Unmarshal(data, &r); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal ApiInstanceV1: %w", err)
+			}
+			return r, nil
+		default:
+			return nil, fmt.Errorf("unknown API instance version: %s", vr.Version)
+		}
+
 	case "MqDestinations":
 		switch vr.Version {
 		case "v1":
@@ -209,14 +460,19 @@ func unmarshalResource(data []byte) (any, error) {
 	}
 }
 
-func deployApplication(newDeployment anypointclient.CloudhubDeploymentReq, client *anypointclient.AnypointClient, organization anypointclient.Organization, environment anypointclient.Environment, privateSpace anypointclient.PrivateSpace) error {
+// deployApplication creates or updates the deployment. Its secure properties must already be resolved.
+func deployApplication(newDeployment anypointclient.CloudhubDeploymentReq, client anypointclient.CloudHubDeployments, organization anypointclient.Organization, environment anypointclient.Environment, privateSpace anypointclient.PrivateSpace, changes *plan.Recorder) error {
 	// Update the deployment to match latest schema version
 	updatedDeployment, err := appconf.UpdateDeploymentToLatestSchema(newDeployment)
 	if err != nil {
 		return fmt.Errorf("failed to update deployment schema: %v", err)
 	}
 
-	client.UpdateScheduleNames(updatedDeployment.Application.Configuration.MuleAgentScheduleService.Schedulers)
+	anypointclient.SetScheduleNames(updatedDeployment.Application.Configuration.MuleAgentScheduleService.Schedulers)
+
+	for _, property := range appconf.DuplicatePropertyKeys(updatedDeployment) {
+		log.Println(color.Colorize(color.Yellow, fmt.Sprintf("Deployment: [%s] property [%s] is set in both properties and secureProperties", updatedDeployment.Name, property)))
+	}
 
 	log.Println(color.Colorize(color.Green, fmt.Sprintf("Will deploy version [%s]", updatedDeployment.Application.Ref.Version)))
 
@@ -225,69 +481,162 @@ func deployApplication(newDeployment anypointclient.CloudhubDeploymentReq, clien
 		return fmt.Errorf("failed to get deployment %+v", err)
 	}
 
+	// Record the salted hash of the secure properties, reusing the salt of the running deployment,
+	// so that a changed secret is detected even though Anypoint Platform masks the values
+	label, err := securePropertiesLabel(updatedDeployment, deployment.Labels)
+	if err != nil {
+		return err
+	}
+	updatedDeployment.Labels = appconf.WithSecurePropertiesLabel(updatedDeployment.Labels, label)
+
 	dryRun := viper.GetBool("dry-run")
 
 	if deployment.Name == "" {
+		changes.Add(plan.Change{
+			Kind:        "Application",
+			Name:        updatedDeployment.Name,
+			Action:      plan.ActionCreate,
+			Fingerprint: deploymentFingerprint(deployment),
+			Payload:     plan.NewPayload(applicationPayload{Deployment: maskedDeployment(updatedDeployment)}),
+		})
 		if dryRun {
 			log.Println(color.Colorize(color.Yellow, fmt.Sprintf("[DRY-RUN] Would CREATE deployment: [%s]", updatedDeployment.Name)))
 			return nil
 		}
-		deployment, err := client.CreateDeployment(environment, privateSpace, updatedDeployment)
-		if err != nil {
-			return fmt.Errorf("failed to create deployment %+v", err)
-		}
-		log.Println(color.Colorize(color.Green, fmt.Sprintf("Deployment: [%s] successfully created", updatedDeployment.Name)))
-
-		if len(updatedDeployment.Application.Configuration.MuleAgentScheduleService.Schedulers) > 0 {
-			log.Println(color.Colorize(color.Green, "Schedulers Configuration: Check that FlowName and Type match source code"))
-			err = client.SchedulesDiffFromSourceCode(environment, updatedDeployment, deployment.ID)
-			if err != nil {
-				return fmt.Errorf("%s\ncause: %+v", updatedDeployment.Name, err)
-			}
-			log.Println(color.Colorize(color.Green, "Scheduler Configuration: Configurations match successfully"))
-		}
-		return nil
+		return createApplication(client, environment, privateSpace, updatedDeployment)
 	}
 
-	updatedDeployment, shouldUpdate := appconf.PrepareDeploymentAndCheckChanges(updatedDeployment, deployment)
-	if shouldUpdate || viper.GetBool("force-update") {
+	updatedDeployment, fieldChanges := appconf.PrepareDeploymentAndListChanges(updatedDeployment, deployment)
+	for _, change := range fieldChanges {
+		log.Printf("%s for deployment %s", change, deployment.Name)
+	}
+	if len(fieldChanges) > 0 || viper.GetBool("force-update") {
+		changes.Add(plan.Change{
+			Kind:        "Application",
+			Name:        updatedDeployment.Name,
+			Action:      plan.ActionUpdate,
+			Forced:      len(fieldChanges) == 0,
+			Fields:      fieldChanges,
+			Fingerprint: deploymentFingerprint(deployment),
+			Payload:     plan.NewPayload(applicationPayload{DeploymentID: deployment.ID, Deployment: maskedDeployment(updatedDeployment)}),
+		})
 		if dryRun {
 			log.Println(color.Colorize(color.Yellow, fmt.Sprintf("[DRY-RUN] Would UPDATE deployment: [%s]", updatedDeployment.Name)))
 			return nil
 		}
-		err := client.UpdateDeployment(environment, privateSpace, updatedDeployment, deployment.ID)
-		if err != nil {
-			return fmt.Errorf("failed to update application: %s\ncause: %+v", updatedDeployment.Name, err)
-		}
-		if viper.GetBool("force-update") {
-			log.Println(color.Colorize(color.Green, fmt.Sprintf("Deployment: [%s] successfully forced updated", deployment.Name)))
-		} else {
-			log.Println(color.Colorize(color.Green, fmt.Sprintf("Deployment: [%s] successfully updated", deployment.Name)))
+		return updateApplication(client, environment, privateSpace, updatedDeployment, deployment)
+	}
+	changes.Add(plan.Change{Kind: "Application", Name: updatedDeployment.Name, Action: plan.ActionNone, Fingerprint: deploymentFingerprint(deployment)})
+	log.Println(color.Colorize(color.Blue, fmt.Sprintf("Deployment: [%s] already deployed with correct configuration", deployment.Name)))
+	return nil
+}
+
+// createApplication creates a new deployment and verifies that its schedulers match the source code.
+// The secure properties of the deployment must already be resolved.
+func createApplication(client anypointclient.CloudHubDeployments, environment anypointclient.Environment, privateSpace anypointclient.PrivateSpace, newDeployment anypointclient.CloudhubDeploymentReq) error {
+	deployment, err := client.CreateDeployment(environment, privateSpace, newDeployment)
+	if err != nil {
+		return fmt.Errorf("failed to create deployment %+v", err)
+	}
+	log.Println(color.Colorize(color.Green, fmt.Sprintf("Deployment: [%s] successfully created", newDeployment.Name)))
+
+	if err := waitForRollout(client, environment, newDeployment.Name); err != nil {
+		return err
+	}
+	return checkSchedulers(client, environment, newDeployment, deployment.ID)
+}
+
+// updateApplication updates an existing deployment and verifies that its schedulers match the source code.
+// If the rollout fails and --rollback is given, the previous deployment is restored.
+// The secure properties of the deployment must already be resolved.
+func updateApplication(client anypointclient.CloudHubDeployments, environment anypointclient.Environment, privateSpace anypointclient.PrivateSpace, updatedDeployment anypointclient.CloudhubDeploymentReq, previous anypointclient.CloudhubDeploymentResp) error {
+	err := client.UpdateDeployment(environment, privateSpace, updatedDeployment, previous.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update application: %s\ncause: %+v", updatedDeployment.Name, err)
+	}
+	if viper.GetBool("force-update") {
+		log.Println(color.Colorize(color.Green, fmt.Sprintf("Deployment: [%s] successfully forced updated", updatedDeployment.Name)))
+	} else {
+		log.Println(color.Colorize(color.Green, fmt.Sprintf("Deployment: [%s] successfully updated", updatedDeployment.Name)))
+	}
+
+	if err := waitForRollout(client, environment, updatedDeployment.Name); err != nil {
+		if !viper.GetBool("rollback") {
+			return err
 		}
+		return rollbackApplication(client, environment, privateSpace, updatedDeployment, previous, err)
+	}
+	return checkSchedulers(client, environment, updatedDeployment, previous.ID)
+}
 
-		if len(updatedDeployment.Application.Configuration.MuleAgentScheduleService.Schedulers) > 0 {
-			log.Println(color.Colorize(color.Green, "Schedulers Configuration: Check that FlowName and Type match source code"))
-			err = client.SchedulesDiffFromSourceCode(environment, updatedDeployment, deployment.ID)
-			if err != nil {
-				return fmt.Errorf("%s\ncause: %+v", updatedDeployment.Name, err)
-			}
-			log.Println(color.Colorize(color.Green, "Scheduler Configuration: Configurations match successfully"))
+// rollbackApplication re-applies the application ref, properties and runtime of the previous deployment after
+// a failed rollout. The returned error always includes the cause of the rollback and whether the rollback succeeded.
+func rollbackApplication(client anypointclient.CloudHubDeployments, environment anypointclient.Environment, privateSpace anypointclient.PrivateSpace, failedDeployment anypointclient.CloudhubDeploymentReq, previous anypointclient.CloudhubDeploymentResp, cause error) error {
+	rollback := appconf.RollbackDeployment(failedDeployment, previous)
+	log.Println(color.Colorize(color.Yellow, fmt.Sprintf("Deployment: [%s] rolling back to version [%s]", rollback.Name, rollback.Application.Ref.Version)))
+
+	if err := client.UpdateDeployment(environment, privateSpace, rollback, previous.ID); err != nil {
+		return fmt.Errorf("%v\nrollback of %s to version %s failed: %+v", cause, rollback.Name, rollback.Application.Ref.Version, err)
+	}
+	if err := waitForRollout(client, environment, rollback.Name); err != nil {
+		return fmt.Errorf("%v\nrollback of %s to version %s failed: %v", cause, rollback.Name, rollback.Application.Ref.Version, err)
+	}
+	log.Println(color.Colorize(color.Yellow, fmt.Sprintf("Deployment: [%s] rolled back to version [%s]", rollback.Name, rollback.Application.Ref.Version)))
+	return fmt.Errorf("%v\nrolled back %s to version %s", cause, rollback.Name, rollback.Application.Ref.Version)
+}
+
+// waitForRollout waits, when --wait is given, until every replica of the deployment runs the new version.
+// If the rollout fails or times out the reasons reported by the replicas are logged and included in the error.
+func waitForRollout(client anypointclient.CloudHubDeployments, environment anypointclient.Environment, deploymentName string) error {
+	// Rolling back is only possible when the rollout is watched
+	if !viper.GetBool("wait") && !viper.GetBool("rollback") {
+		return nil
+	}
+	log.Printf("Waiting for rollout of deployment [%s]", deploymentName)
+	deployment, err := client.WaitForRollout(environment, deploymentName, viper.GetDuration("wait-timeout"), rolloutPollInterval)
+	if err != nil {
+		reasons := deployment.ReplicaReasons()
+		for _, reason := range reasons {
+			log.Println(color.Colorize(color.Red, fmt.Sprintf("Deployment: [%s] replica reason: %s", deploymentName, reason)))
+		}
+		if len(reasons) > 0 {
+			return fmt.Errorf("%v\nreplica reasons: %s", err, strings.Join(reasons, "; "))
 		}
+		return err
+	}
+	log.Println(color.Colorize(color.Green, fmt.Sprintf("Deployment: [%s] rollout finished, all replicas running version %s", deploymentName, deployment.DesiredVersion)))
+	return nil
+}
+
+// checkSchedulers verifies that the schedulers in the deployment match the ones defined in the source code
+func checkSchedulers(client anypointclient.CloudHubDeployments, environment anypointclient.Environment, deployment anypointclient.CloudhubDeploymentReq, deploymentID string) error {
+	if len(deployment.Application.Configuration.MuleAgentScheduleService.Schedulers) == 0 {
 		return nil
 	}
-	log.Println(color.Colorize(color.Blue, fmt.Sprintf("Deployment: [%s] already deployed with correct configuration", deployment.Name)))
+	log.Println(color.Colorize(color.Green, "Schedulers Configuration: Check that FlowName and Type match source code"))
+	err := client.SchedulesDiffFromSourceCode(environment, deployment, deploymentID)
+	if err != nil {
+		return fmt.Errorf("%s\ncause: %+v", deployment.Name, err)
+	}
+	log.Println(color.Colorize(color.Green, "Scheduler Configuration: Configurations match successfully"))
 	return nil
 }
 
-func deployApiPolicy(apipolicies resources.ApiPoliciesV1, client *anypointclient.AnypointClient, organization anypointclient.Organization, environment anypointclient.Environment) error {
-	log.Printf("Deploying API policies on API instance %s\n", apipolicies.Spec.ApiInstanceID)
+func deployApiPolicy(ctx context.Context, apipolicies resources.ApiPoliciesV1, client anypointclient.ApiManager, organization anypointclient.Organization, environment anypointclient.Environment, changes *plan.Recorder) error {
 	dryRun := viper.GetBool("dry-run")
 
+	// An instance that the dry run would create is referred to by name
+	if pending := pendingApiInstanceReference.FindStringSubmatch(apipolicies.Spec.ApiInstanceID); dryRun && pending != nil && pending[0] == apipolicies.Spec.ApiInstanceID {
+		planPendingApiPolicies(pending[1], apipolicies, changes)
+		return nil
+	}
+
 	// Get API instance ID from the spec
-	apiInstanceID, err := strconv.Atoi(apipolicies.Spec.ApiInstanceID)
+	apiInstanceID, err := resolveApiInstanceID(ctx, apipolicies, client, organization, environment)
 	if err != nil {
-		return fmt.Errorf("invalid API instance ID: %s, error: %v", apipolicies.Spec.ApiInstanceID, err)
+		return err
 	}
+	log.Printf("Deploying API policies on API instance %d\n", apiInstanceID)
 
 	// Get existing policies for this API instance
 	existingPolicies, err := client.GetApiInstancePolicies(organization.ID, environment.ID, apiInstanceID)
@@ -295,22 +644,26 @@ func deployApiPolicy(apipolicies resources.ApiPoliciesV1, client *anypointclient
 		return fmt.Errorf("failed to get API instance policies: %v", err)
 	}
 
+	// The existing policies matched by a declared one, the others are deleted in authoritative mode
+	declared := map[int]bool{}
 	for _, apipolicy := range apipolicies.Spec.Policies {
+		policyName := fmt.Sprintf("%d/%s:%s", apiInstanceID, apipolicy.GroupID, apipolicy.AssetID)
 
-		var matchingPolicy *anypointclient.ApiPolicyResponse
-		for i, policy := range *existingPolicies {
-			// Check if policy matches the Group ID, AssetID, and PointcutData in the spec
-			if policy.Template.GroupID == apipolicy.GroupID &&
-				policy.Template.AssetID == apipolicy.AssetID &&
-				reflect.DeepEqual(policy.PointcutData, apipolicy.PointcutData) {
-				matchingPolicy = &(*existingPolicies)[i]
-				break
-			}
+		matchingPolicy := findMatchingPolicy(*existingPolicies, apipolicy)
+		if matchingPolicy != nil {
+			declared[matchingPolicy.PolicyID] = true
 		}
 
 		// No policy with the same Group ID, Asset ID, and pointcut is found, create a new one
 		if matchingPolicy == nil {
 			log.Println(color.Colorize(color.Yellow, fmt.Sprintf("Policy with matching template %s:%s:%s and pointcut not found for API instance %d", apipolicy.GroupID, apipolicy.AssetID, apipolicy.AssetVersion, apiInstanceID)))
+			changes.Add(plan.Change{
+				Kind:        "ApiPolicy",
+				Name:        policyName,
+				Action:      plan.ActionCreate,
+				Fingerprint: plan.Fingerprint(nil),
+				Payload:     plan.NewPayload(apiPolicyPayload{ApiInstanceID: apiInstanceID, Policy: apipolicy}),
+			})
 			if dryRun {
 				log.Println(color.Colorize(color.Yellow, fmt.Sprintf("[DRY-RUN] Would CREATE API Policy %s:%s:%s for instance %d", apipolicy.GroupID, apipolicy.AssetID, apipolicy.AssetVersion, apiInstanceID)))
 				continue
@@ -336,13 +689,19 @@ func deployApiPolicy(apipolicies resources.ApiPoliciesV1, client *anypointclient
 			apipolicy.AssetVersion = matchingPolicy.Template.AssetVersion
 		}
 		// Check if version or configuration data has changed
-		configChanged := false
-		if matchingPolicy.Template.AssetVersion != apipolicy.AssetVersion || !reflect.DeepEqual(matchingPolicy.Configuration, apipolicy.ConfigurationData) {
-			configChanged = true
-		}
+		fieldChanges := policyChanges(apipolicy, *matchingPolicy)
 
 		// Update policy if configuration has changed
-		if configChanged || viper.GetBool("force-update") {
+		if len(fieldChanges) > 0 || viper.GetBool("force-update") {
+			changes.Add(plan.Change{
+				Kind:        "ApiPolicy",
+				Name:        policyName,
+				Action:      plan.ActionUpdate,
+				Forced:      len(fieldChanges) == 0,
+				Fields:      fieldChanges,
+				Fingerprint: plan.Fingerprint(matchingPolicy),
+				Payload:     plan.NewPayload(apiPolicyPayload{ApiInstanceID: apiInstanceID, PolicyID: matchingPolicy.PolicyID, Policy: apipolicy}),
+			})
 			if dryRun {
 				log.Println(color.Colorize(color.Yellow, fmt.Sprintf("[DRY-RUN] Would UPDATE API Policy %s:%s:%s for instance %d", apipolicy.GroupID, apipolicy.AssetID, apipolicy.AssetVersion, apiInstanceID)))
 				continue
@@ -367,19 +726,59 @@ func deployApiPolicy(apipolicies resources.ApiPoliciesV1, client *anypointclient
 				log.Println(color.Colorize(color.Green, fmt.Sprintf("API Policy %s:%s:%s for instance %d successfully updated", apipolicy.GroupID, apipolicy.AssetID, apipolicy.AssetVersion, apiInstanceID)))
 			}
 		} else {
+			changes.Add(plan.Change{
+				Kind:        "ApiPolicy",
+				Name:        policyName,
+				Action:      plan.ActionNone,
+				Fingerprint: plan.Fingerprint(matchingPolicy),
+				Payload:     plan.NewPayload(apiPolicyPayload{ApiInstanceID: apiInstanceID, PolicyID: matchingPolicy.PolicyID, Policy: apipolicy}),
+			})
 			log.Println(color.Colorize(color.Blue, fmt.Sprintf("API Policy %s:%s:%s for instance %d already configured correctly", apipolicy.GroupID, apipolicy.AssetID, apipolicy.AssetVersion, apiInstanceID)))
 		}
 	}
 
+	if apipolicies.Spec.Authoritative {
+		for _, existingPolicy := range *existingPolicies {
+			if declared[existingPolicy.PolicyID] {
+				continue
+			}
+			if err := deleteApiPolicy(client, organization, environment, apiInstanceID, existingPolicy, dryRun, changes); err != nil {
+				return err
+			}
+		}
+	}
+
+	return orderApiPolicies(client, organization, environment, apiInstanceID, apipolicies, *existingPolicies, dryRun, changes)
+}
+
+// deleteApiPolicy deletes a policy that an authoritative descriptor does not declare
+func deleteApiPolicy(client anypointclient.ApiManager, organization anypointclient.Organization, environment anypointclient.Environment, apiInstanceID int, policy anypointclient.ApiPolicyResponse, dryRun bool, changes *plan.Recorder) error {
+	template := policy.Template
+	changes.Add(plan.Change{
+		Kind:        "ApiPolicy",
+		Name:        fmt.Sprintf("%d/%s:%s", apiInstanceID, template.GroupID, template.AssetID),
+		Action:      plan.ActionDelete,
+		Fingerprint: plan.Fingerprint(policy),
+		Payload:     plan.NewPayload(apiPolicyPayload{ApiInstanceID: apiInstanceID, PolicyID: policy.PolicyID}),
+	})
+	if dryRun {
+		log.Println(color.Colorize(color.Yellow, fmt.Sprintf("[DRY-RUN] Would DELETE API Policy %s:%s:%s for instance %d", template.GroupID, template.AssetID, template.AssetVersion, apiInstanceID)))
+		return nil
+	}
+	if err := client.DeleteApiInstancePolicy(organization.ID, environment.ID, apiInstanceID, policy.PolicyID); err != nil {
+		return fmt.Errorf("failed to delete API policy %d of API instance %d: %v", policy.PolicyID, apiInstanceID, err)
+	}
+	log.Println(color.Colorize(color.Green, fmt.Sprintf("API Policy %s:%s:%s for instance %d successfully deleted", template.GroupID, template.AssetID, template.AssetVersion, apiInstanceID)))
 	return nil
 }
 
-func deployMqDestinations(mqDestinations resources.MqDestinationsV1, client *anypointclient.AnypointClient, organization anypointclient.Organization, environment anypointclient.Environment) error {
+func deployMqDestinations(mqDestinations resources.MqDestinationsV1, client anypointclient.MqAdmin, organization anypointclient.Organization, environment anypointclient.Environment, declared map[string]bool, changes *plan.Recorder) error {
 	mqRegion := viper.GetString("mq-region")
 	if mqRegion == "" {
 		return fmt.Errorf("--mq-region flag is required for MqDestinations resources")
 	}
 	dryRun := viper.GetBool("dry-run")
+	pruner := newMqPruner(client, organization.ID, environment.ID, mqRegion, declared, dryRun, changes)
 
 	log.Printf("Deploying MQ destinations to region %s\n", mqRegion)
 
@@ -407,6 +806,7 @@ func deployMqDestinations(mqDestinations resources.MqDestinationsV1, client *any
 		}
 
 		if existingQueue == nil {
+			changes.Add(plan.Change{Kind: "MqQueue", Name: queue.QueueID, Action: plan.ActionCreate, Fingerprint: plan.Fingerprint(existingQueue), Payload: plan.NewPayload(queue)})
 			if dryRun {
 				log.Println(color.Colorize(color.Yellow, fmt.Sprintf("[DRY-RUN] Would CREATE queue: [%s]", queue.QueueID)))
 			} else {
@@ -417,7 +817,16 @@ func deployMqDestinations(mqDestinations resources.MqDestinationsV1, client *any
 				}
 				log.Println(color.Colorize(color.Green, fmt.Sprintf("Queue [%s] successfully created", queue.QueueID)))
 			}
-		} else if queueNeedsUpdate(queue, *existingQueue) || viper.GetBool("force-update") {
+		} else if fieldChanges := queueChanges(queue, *existingQueue); len(fieldChanges) > 0 || viper.GetBool("force-update") {
+			changes.Add(plan.Change{
+				Kind:        "MqQueue",
+				Name:        queue.QueueID,
+				Action:      plan.ActionUpdate,
+				Forced:      len(fieldChanges) == 0,
+				Fields:      fieldChanges,
+				Fingerprint: plan.Fingerprint(existingQueue),
+				Payload:     plan.NewPayload(queue),
+			})
 			if dryRun {
 				log.Println(color.Colorize(color.Yellow, fmt.Sprintf("[DRY-RUN] Would UPDATE queue: [%s]", queue.QueueID)))
 			} else {
@@ -429,6 +838,7 @@ func deployMqDestinations(mqDestinations resources.MqDestinationsV1, client *any
 				log.Println(color.Colorize(color.Green, fmt.Sprintf("Queue [%s] successfully updated", queue.QueueID)))
 			}
 		} else {
+			changes.Add(plan.Change{Kind: "MqQueue", Name: queue.QueueID, Action: plan.ActionNone, Fingerprint: plan.Fingerprint(existingQueue)})
 			log.Println(color.Colorize(color.Blue, fmt.Sprintf("Queue [%s] already configured correctly", queue.QueueID)))
 		}
 	}
@@ -441,6 +851,7 @@ func deployMqDestinations(mqDestinations resources.MqDestinationsV1, client *any
 		}
 
 		if existingExchange == nil {
+			changes.Add(plan.Change{Kind: "MqExchange", Name: exchange.ExchangeID, Action: plan.ActionCreate, Fingerprint: plan.Fingerprint(existingExchange), Payload: plan.NewPayload(exchange.MqExchange)})
 			if dryRun {
 				log.Println(color.Colorize(color.Yellow, fmt.Sprintf("[DRY-RUN] Would CREATE exchange: [%s]", exchange.ExchangeID)))
 			} else {
@@ -451,7 +862,16 @@ func deployMqDestinations(mqDestinations resources.MqDestinationsV1, client *any
 				}
 				log.Println(color.Colorize(color.Green, fmt.Sprintf("Exchange [%s] successfully created", exchange.ExchangeID)))
 			}
-		} else if exchangeNeedsUpdate(exchange.MqExchange, *existingExchange) || viper.GetBool("force-update") {
+		} else if fieldChanges := exchangeChanges(exchange.MqExchange, *existingExchange); len(fieldChanges) > 0 || viper.GetBool("force-update") {
+			changes.Add(plan.Change{
+				Kind:        "MqExchange",
+				Name:        exchange.ExchangeID,
+				Action:      plan.ActionUpdate,
+				Forced:      len(fieldChanges) == 0,
+				Fields:      fieldChanges,
+				Fingerprint: plan.Fingerprint(existingExchange),
+				Payload:     plan.NewPayload(exchange.MqExchange),
+			})
 			if dryRun {
 				log.Println(color.Colorize(color.Yellow, fmt.Sprintf("[DRY-RUN] Would UPDATE exchange: [%s]", exchange.ExchangeID)))
 			} else {
@@ -463,36 +883,105 @@ func deployMqDestinations(mqDestinations resources.MqDestinationsV1, client *any
 				log.Println(color.Colorize(color.Green, fmt.Sprintf("Exchange [%s] successfully updated", exchange.ExchangeID)))
 			}
 		} else {
+			changes.Add(plan.Change{Kind: "MqExchange", Name: exchange.ExchangeID, Action: plan.ActionNone, Fingerprint: plan.Fingerprint(existingExchange)})
 			log.Println(color.Colorize(color.Blue, fmt.Sprintf("Exchange [%s] already configured correctly", exchange.ExchangeID)))
 		}
 
 		// Handle bindings for this exchange
-		err = syncExchangeBindings(client, organization.ID, environment.ID, mqRegion, exchange.ExchangeID, exchange.Bindings, dryRun)
+		err = syncExchangeBindings(client, organization.ID, environment.ID, mqRegion, exchange.ExchangeID, exchange.Bindings, dryRun, changes, pruner)
 		if err != nil {
 			return fmt.Errorf("failed to sync bindings for exchange %s: %v", exchange.ExchangeID, err)
 		}
 	}
 
+	if pruner != nil {
+		if err := pruner.pruneDestinations(mqDestinations); err != nil {
+			return fmt.Errorf("failed to prune MQ destinations: %v", err)
+		}
+	}
+
 	log.Println(color.Colorize(color.Green, "MQ destinations deployment completed successfully"))
 	return nil
 }
 
-func queueNeedsUpdate(desired anypointclient.MqQueue, current anypointclient.MqDestination) bool {
-	return desired.Fifo != current.Fifo ||
-		desired.IsEncrypted() != current.Encrypted ||
-		desired.MaxDeliveries != current.MaxDeliveries ||
-		desired.DeadLetterQueueID != current.DeadLetterQueueID ||
-		desired.IsFallback != current.IsFallback ||
-		desired.DefaultTtl != current.DefaultTtl ||
-		desired.DefaultLockTtl != current.DefaultLockTtl ||
-		desired.DefaultDeliveryDelay != current.DefaultDeliveryDelay
+// findPolicyByID returns the existing policy with the given ID, or nil
+func findPolicyByID(existingPolicies []anypointclient.ApiPolicyResponse, policyID int) *anypointclient.ApiPolicyResponse {
+	for i, policy := range existingPolicies {
+		if policy.PolicyID == policyID {
+			return &existingPolicies[i]
+		}
+	}
+	return nil
+}
+
+// findMatchingPolicy returns the existing policy with the same Group ID, Asset ID and PointcutData as the requested one, or nil
+func findMatchingPolicy(existingPolicies []anypointclient.ApiPolicyResponse, apipolicy anypointclient.ApiPolicyRequest) *anypointclient.ApiPolicyResponse {
+	for i, policy := range existingPolicies {
+		if policy.Template.GroupID == apipolicy.GroupID &&
+			policy.Template.AssetID == apipolicy.AssetID &&
+			reflect.DeepEqual(policy.PointcutData, apipolicy.PointcutData) {
+			return &existingPolicies[i]
+		}
+	}
+	return nil
 }
 
-func exchangeNeedsUpdate(desired anypointclient.MqExchange, current anypointclient.MqDestination) bool {
-	return desired.Fifo != current.Fifo || desired.IsEncrypted() != current.Encrypted
+// fieldChanges collects the fields where the current value differs from the desired one
+type fieldChanges []appconf.FieldChange
+
+func (changes *fieldChanges) compare(field string, current, desired any) {
+	if !reflect.DeepEqual(current, desired) {
+		*changes = append(*changes, appconf.FieldChange{Field: field, Current: current, Desired: desired})
+	}
 }
 
-func syncExchangeBindings(client *anypointclient.AnypointClient, orgID, envID, region, exchangeID string, desiredBindings []anypointclient.MqBinding, dryRun bool) error {
+// policyChanges returns the fields where the existing API policy differs from the desired one
+func policyChanges(desired anypointclient.ApiPolicyRequest, current anypointclient.ApiPolicyResponse) []appconf.FieldChange {
+	var changes fieldChanges
+	changes.compare("assetVersion", current.Template.AssetVersion, desired.AssetVersion)
+	changes.compare("disabled", current.Disabled, desired.Disabled)
+
+	keys := make([]string, 0, len(current.Configuration)+len(desired.ConfigurationData))
+	for key := range current.Configuration {
+		keys = append(keys, key)
+	}
+	for key := range desired.ConfigurationData {
+		if _, ok := current.Configuration[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		changes.compare("configurationData."+key, current.Configuration[key], desired.ConfigurationData[key])
+	}
+	return changes
+}
+
+// queueChanges returns the fields where the existing queue differs from the desired one
+func queueChanges(desired anypointclient.MqQueue, current anypointclient.MqDestination) []appconf.FieldChange {
+	var changes fieldChanges
+	changes.compare("fifo", current.Fifo, desired.Fifo)
+	changes.compare("encrypted", current.Encrypted, desired.IsEncrypted())
+	changes.compare("maxDeliveries", current.MaxDeliveries, desired.MaxDeliveries)
+	changes.compare("deadLetterQueueId", current.DeadLetterQueueID, desired.DeadLetterQueueID)
+	changes.compare("isFallback", current.IsFallback, desired.IsFallback)
+	changes.compare("defaultTtl", current.DefaultTtl, desired.DefaultTtl)
+	changes.compare("defaultLockTtl", current.DefaultLockTtl, desired.DefaultLockTtl)
+	changes.compare("defaultDeliveryDelay", current.DefaultDeliveryDelay, desired.DefaultDeliveryDelay)
+	return changes
+}
+
+// exchangeChanges returns the fields where the existing exchange differs from the desired one
+func exchangeChanges(desired anypointclient.MqExchange, current anypointclient.MqDestination) []appconf.FieldChange {
+	var changes fieldChanges
+	changes.compare("fifo", current.Fifo, desired.Fifo)
+	changes.compare("encrypted", current.Encrypted, desired.IsEncrypted())
+	return changes
+}
+
+// syncExchangeBindings creates and updates the bindings of an exchange. Bindings that are no longer declared are
+// only deleted by the pruner, which is nil unless prune mode is on.
+func syncExchangeBindings(client anypointclient.MqAdmin, orgID, envID, region, exchangeID string, desiredBindings []anypointclient.MqBinding, dryRun bool, changes *plan.Recorder, pruner *mqPruner) error {
 	existingBindings, err := client.GetMqExchangeBindings(orgID, envID, region, exchangeID)
 	if err != nil {
 		return fmt.Errorf("failed to get existing bindings: %v", err)
@@ -515,7 +1004,10 @@ func syncExchangeBindings(client *anypointclient.AnypointClient, orgID, envID, r
 	// Create or update bindings
 	for _, desiredBinding := range desiredBindings {
 		existingBinding, exists := existingBindingsMap[desiredBinding.QueueID]
+		bindingName := fmt.Sprintf("%s -> %s", exchangeID, desiredBinding.QueueID)
+		bindingPayload := plan.NewPayload(mqBindingPayload{ExchangeID: exchangeID, QueueID: desiredBinding.QueueID, RoutingRules: desiredBinding.RoutingRules})
 		if !exists {
+			changes.Add(plan.Change{Kind: "MqBinding", Name: bindingName, Action: plan.ActionCreate, Fingerprint: plan.Fingerprint(nil), Payload: bindingPayload})
 			if dryRun {
 				log.Println(color.Colorize(color.Yellow, fmt.Sprintf("[DRY-RUN] Would CREATE binding: [%s -> %s]", exchangeID, desiredBinding.QueueID)))
 				if len(desiredBinding.RoutingRules) > 0 {
@@ -540,7 +1032,12 @@ func syncExchangeBindings(client *anypointclient.AnypointClient, orgID, envID, r
 					log.Println(color.Colorize(color.Green, fmt.Sprintf("Routing rules for [%s -> %s] successfully set", exchangeID, desiredBinding.QueueID)))
 				}
 			}
-		} else if routingRulesNeedUpdate(desiredBinding.RoutingRules, existingBinding.RoutingRules) || viper.GetBool("force-update") {
+		} else if rulesChanged := routingRulesNeedUpdate(desiredBinding.RoutingRules, existingBinding.RoutingRules); rulesChanged || viper.GetBool("force-update") {
+			change := plan.Change{Kind: "MqBinding", Name: bindingName, Action: plan.ActionUpdate, Forced: !rulesChanged, Fingerprint: plan.Fingerprint(existingBinding), Payload: bindingPayload}
+			if rulesChanged {
+				change.Fields = []appconf.FieldChange{{Field: "routingRules", Current: existingBinding.RoutingRules, Desired: desiredBinding.RoutingRules}}
+			}
+			changes.Add(change)
 			if dryRun {
 				log.Println(color.Colorize(color.Yellow, fmt.Sprintf("[DRY-RUN] Would UPDATE routing rules for: [%s -> %s]", exchangeID, desiredBinding.QueueID)))
 			} else {
@@ -553,12 +1050,20 @@ func syncExchangeBindings(client *anypointclient.AnypointClient, orgID, envID, r
 				log.Println(color.Colorize(color.Green, fmt.Sprintf("Routing rules for [%s -> %s] successfully updated", exchangeID, desiredBinding.QueueID)))
 			}
 		} else {
+			changes.Add(plan.Change{Kind: "MqBinding", Name: bindingName, Action: plan.ActionNone, Fingerprint: plan.Fingerprint(existingBinding), Payload: bindingPayload})
 			log.Println(color.Colorize(color.Blue, fmt.Sprintf("Binding [%s -> %s] already configured correctly", exchangeID, desiredBinding.QueueID)))
 		}
 	}
 
-	// Note: We don't delete bindings that are no longer in the config - that's too dangerous.
-	// Bindings must be deleted manually if needed.
+	if pruner != nil {
+		for _, existingBinding := range existingBindings {
+			if _, declared := desiredBindingsMap[existingBinding.QueueID]; !declared {
+				if err := pruner.pruneBinding(exchangeID, existingBinding); err != nil {
+					return err
+				}
+			}
+		}
+	}
 
 	return nil
 }
@@ -1,10 +1,19 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"reflect"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/plan"
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/resources"
 	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient"
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient/anypointclienttest"
+	"github.com/spf13/viper"
 )
 
 func TestPolicyMatchingWithPointcuts(t *testing.T) {
@@ -134,3 +143,330 @@ func TestPolicyMatchingWithPointcuts(t *testing.T) {
 		})
 	}
 }
+
+func TestPolicyChanges(t *testing.T) {
+	current := anypointclient.ApiPolicyResponse{
+		Configuration: map[string]any{"ips": []any{"127.0.0.1"}, "ipExpression": "#[attributes.remoteAddress]"},
+	}
+	current.Template.AssetVersion = "1.1.0"
+
+	desired := anypointclient.ApiPolicyRequest{
+		AssetVersion:      "1.1.1",
+		ConfigurationData: map[string]any{"ips": []any{"127.0.0.1"}, "ipExpression": "#[attributes.headers['x-forwarded-for']]"},
+	}
+
+	changes := policyChanges(desired, current)
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 changes, got %+v", changes)
+	}
+	if changes[0].Field != "assetVersion" || changes[1].Field != "configurationData.ipExpression" {
+		t.Errorf("unexpected changes %+v", changes)
+	}
+}
+
+func TestQueueChanges(t *testing.T) {
+	current := anypointclient.MqDestination{Type: "queue", QueueID: "my-queue", Encrypted: true, MaxDeliveries: 2, DefaultTtl: 604800000}
+	desired := anypointclient.MqQueue{QueueID: "my-queue", MaxDeliveries: 2, DefaultTtl: 604800000}
+
+	if changes := queueChanges(desired, current); len(changes) != 0 {
+		t.Errorf("expected no changes, got %+v", changes)
+	}
+
+	desired.MaxDeliveries = 5
+	changes := queueChanges(desired, current)
+	if len(changes) != 1 || changes[0].Field != "maxDeliveries" {
+		t.Errorf("expected maxDeliveries change, got %+v", changes)
+	}
+}
+
+var (
+	testOrganization = anypointclient.Organization{ID: "org-id", Name: "Root"}
+	testEnvironment  = anypointclient.Environment{ID: "env-id", Name: "Sandbox", OrganizationID: "org-id"}
+)
+
+// setFlag sets a flag for the duration of the test
+func setFlag(t *testing.T, key string, value any) {
+	previous := viper.Get(key)
+	viper.Set(key, value)
+	t.Cleanup(func() { viper.Set(key, previous) })
+}
+
+// mutatingCalls returns the calls that change the state of Anypoint Platform
+func mutatingCalls(fake *anypointclienttest.Fake) []string {
+	var calls []string
+	for _, call := range fake.Calls() {
+		if !strings.HasPrefix(call, "Get") && call != "WaitForRollout" && call != "SchedulesDiffFromSourceCode" {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func testDeployment(version string) anypointclient.CloudhubDeploymentReq {
+	var deployment anypointclient.CloudhubDeploymentReq
+	deployment.Name = "orders"
+	deployment.Target.Provider = "MC"
+	deployment.Target.TargetID = "ps-id"
+	deployment.Target.Replicas = 1
+	deployment.Target.DeploymentSettings.UpdateStrategy = "rolling"
+	deployment.Target.DeploymentSettings.Runtime.Version = "4.9.0"
+	deployment.Target.DeploymentSettings.Runtime.ReleaseChannel = "LTS"
+	deployment.Target.DeploymentSettings.Runtime.Java = "17"
+	deployment.Application.Ref.GroupID = "org-id"
+	deployment.Application.Ref.ArtifactID = "orders"
+	deployment.Application.Ref.Version = version
+	deployment.Application.Ref.Packaging = "jar"
+	deployment.Application.DesiredState = "STARTED"
+	deployment.Application.VCores = 0.1
+	deployment.Application.Configuration.MuleAgentApplicationPropertiesService.Properties = map[string]string{"env": "sandbox"}
+	return deployment
+}
+
+func TestDeployApplication(t *testing.T) {
+	setFlag(t, "dry-run", false)
+	setFlag(t, "wait", true)
+	fake := anypointclienttest.NewFake(testOrganization)
+	changes := plan.New(testOrganization, testEnvironment)
+
+	if err := deployApplication(testDeployment("1.0.0"), fake, testOrganization, testEnvironment, anypointclient.PrivateSpace{}, changes.Source("orders.json")); err != nil {
+		t.Fatal(err)
+	}
+	if calls := mutatingCalls(fake); !slices.Equal(calls, []string{"CreateDeployment"}) {
+		t.Errorf("expected the deployment to be created, got %v", calls)
+	}
+
+	fake.Reset()
+	if err := deployApplication(testDeployment("1.0.0"), fake, testOrganization, testEnvironment, anypointclient.PrivateSpace{}, changes.Source("orders.json")); err != nil {
+		t.Fatal(err)
+	}
+	if calls := mutatingCalls(fake); len(calls) != 0 {
+		t.Errorf("expected an unchanged deployment to be left alone, got %v", calls)
+	}
+
+	fake.Reset()
+	if err := deployApplication(testDeployment("1.1.0"), fake, testOrganization, testEnvironment, anypointclient.PrivateSpace{}, changes.Source("orders.json")); err != nil {
+		t.Fatal(err)
+	}
+	if deployment, _ := fake.Deployment(testEnvironment, "orders"); deployment.Application.Ref.Version != "1.1.0" {
+		t.Errorf("expected version 1.1.0 to be deployed, got %s", deployment.Application.Ref.Version)
+	}
+
+	actions := make([]plan.Action, 0, len(changes.Changes))
+	for _, change := range changes.Changes {
+		actions = append(actions, change.Action)
+	}
+	if !slices.Equal(actions, []plan.Action{plan.ActionCreate, plan.ActionNone, plan.ActionUpdate}) {
+		t.Errorf("unexpected plan %v", actions)
+	}
+}
+
+func TestDeployApplicationDryRun(t *testing.T) {
+	setFlag(t, "dry-run", true)
+	fake := anypointclienttest.NewFake(testOrganization)
+	changes := plan.New(testOrganization, testEnvironment)
+
+	if err := deployApplication(testDeployment("1.0.0"), fake, testOrganization, testEnvironment, anypointclient.PrivateSpace{}, changes.Source("orders.json")); err != nil {
+		t.Fatal(err)
+	}
+	if calls := mutatingCalls(fake); len(calls) != 0 {
+		t.Errorf("expected nothing to be changed in a dry run, got %v", calls)
+	}
+	if len(changes.Changes) != 1 || changes.Changes[0].Action != plan.ActionCreate {
+		t.Errorf("expected a planned create, got %+v", changes.Changes)
+	}
+}
+
+func TestDeployApplicationRollsBack(t *testing.T) {
+	setFlag(t, "dry-run", false)
+	setFlag(t, "rollback", true)
+	fake := anypointclienttest.NewFake(testOrganization)
+	changes := plan.New(testOrganization, testEnvironment)
+	if err := deployApplication(testDeployment("1.0.0"), fake, testOrganization, testEnvironment, anypointclient.PrivateSpace{}, changes.Source("orders.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.FailOnce("WaitForRollout", errors.New("replicas crashed"))
+	err := deployApplication(testDeployment("1.1.0"), fake, testOrganization, testEnvironment, anypointclient.PrivateSpace{}, changes.Source("orders.json"))
+	if err == nil || !strings.Contains(err.Error(), "replicas crashed") || !strings.Contains(err.Error(), "rolled back orders to version 1.0.0") {
+		t.Fatalf("expected a rolled back failure, got %v", err)
+	}
+	if deployment, _ := fake.Deployment(testEnvironment, "orders"); deployment.Application.Ref.Version != "1.0.0" {
+		t.Errorf("expected version 1.0.0 to be restored, got %s", deployment.Application.Ref.Version)
+	}
+}
+
+func TestDeployApiPolicy(t *testing.T) {
+	setFlag(t, "dry-run", false)
+	fake := anypointclienttest.NewFake(testOrganization)
+	api := fake.AddApi(testEnvironment, anypointclient.ApiInstance{AssetID: "orders-api"})
+	var existing anypointclient.ApiPolicyResponse
+	existing.Template.GroupID = "mulesoft"
+	existing.Template.AssetID = "ip-allowlist"
+	existing.Template.AssetVersion = "1.0.0"
+	fake.AddPolicy(api.ID, existing)
+
+	var policies resources.ApiPoliciesV1
+	policies.Spec.ApiInstanceID = strconv.Itoa(api.ID)
+	policies.Spec.Policies = []anypointclient.ApiPolicyRequest{
+		{GroupID: "mulesoft", AssetID: "ip-allowlist", AssetVersion: "1.1.0"},
+		{GroupID: "mulesoft", AssetID: "rate-limiting", AssetVersion: "1.0.0", ConfigurationData: map[string]any{"maximumRequests": 10}},
+	}
+	if err := deployApiPolicy(context.Background(), policies, fake, testOrganization, testEnvironment, plan.New(testOrganization, testEnvironment).Source("policies.json")); err != nil {
+		t.Fatal(err)
+	}
+	if calls := mutatingCalls(fake); !slices.Equal(calls, []string{"UpdateApiInstancePolicies", "CreateApiInstancePolicies"}) {
+		t.Errorf("expected one policy to be updated and one created, got %v", calls)
+	}
+	deployed := fake.Policies(api.ID)
+	if len(deployed) != 2 || deployed[0].Template.AssetVersion != "1.1.0" || deployed[1].Template.AssetID != "rate-limiting" {
+		t.Errorf("unexpected policies %+v", deployed)
+	}
+}
+
+func TestDeployApiPolicyAuthoritative(t *testing.T) {
+	fake := anypointclienttest.NewFake(testOrganization)
+	api := fake.AddApi(testEnvironment, anypointclient.ApiInstance{AssetID: "orders-api"})
+	for _, assetID := range []string{"ip-allowlist", "rate-limiting"} {
+		var existing anypointclient.ApiPolicyResponse
+		existing.Template.GroupID = "mulesoft"
+		existing.Template.AssetID = assetID
+		existing.Template.AssetVersion = "1.0.0"
+		fake.AddPolicy(api.ID, existing)
+	}
+
+	var policies resources.ApiPoliciesV1
+	policies.Spec.ApiInstanceID = strconv.Itoa(api.ID)
+	policies.Spec.Authoritative = true
+	policies.Spec.Policies = []anypointclient.ApiPolicyRequest{{GroupID: "mulesoft", AssetID: "ip-allowlist", AssetVersion: "1.0.0"}}
+
+	// A dry run plans the deletion without making it
+	setFlag(t, "dry-run", true)
+	changes := plan.New(testOrganization, testEnvironment)
+	if err := deployApiPolicy(context.Background(), policies, fake, testOrganization, testEnvironment, changes.Source("policies.json")); err != nil {
+		t.Fatal(err)
+	}
+	if calls := mutatingCalls(fake); len(calls) != 0 {
+		t.Errorf("expected nothing to be changed in a dry run, got %v", calls)
+	}
+	deleted := strconv.Itoa(api.ID) + "/mulesoft:rate-limiting"
+	if last := changes.Changes[len(changes.Changes)-1]; last.Action != plan.ActionDelete || last.Name != deleted {
+		t.Errorf("expected the deletion of %s to be planned, got %+v", deleted, changes.Changes)
+	}
+
+	setFlag(t, "dry-run", false)
+	if err := deployApiPolicy(context.Background(), policies, fake, testOrganization, testEnvironment, nil); err != nil {
+		t.Fatal(err)
+	}
+	if calls := mutatingCalls(fake); !slices.Equal(calls, []string{"DeleteApiInstancePolicy"}) {
+		t.Errorf("expected the undeclared policy to be deleted, got %v", calls)
+	}
+	if deployed := fake.Policies(api.ID); len(deployed) != 1 || deployed[0].Template.AssetID != "ip-allowlist" {
+		t.Errorf("expected only the declared policy to remain, got %+v", deployed)
+	}
+}
+
+func TestDeployApiPolicyOrder(t *testing.T) {
+	fake := anypointclienttest.NewFake(testOrganization)
+	api := fake.AddApi(testEnvironment, anypointclient.ApiInstance{AssetID: "orders-api"})
+	for i, assetID := range []string{"ip-allowlist", "client-id-enforcement", "rate-limiting"} {
+		var existing anypointclient.ApiPolicyResponse
+		existing.Template.GroupID = "mulesoft"
+		existing.Template.AssetID = assetID
+		existing.Template.AssetVersion = "1.0.0"
+		existing.Order = i + 1
+		fake.AddPolicy(api.ID, existing)
+	}
+
+	// client-id-enforcement is not declared and keeps its place between the declared policies
+	var policies resources.ApiPoliciesV1
+	policies.Spec.ApiInstanceID = strconv.Itoa(api.ID)
+	policies.Spec.Policies = []anypointclient.ApiPolicyRequest{
+		{GroupID: "mulesoft", AssetID: "rate-limiting", AssetVersion: "1.0.0"},
+		{GroupID: "mulesoft", AssetID: "ip-allowlist", AssetVersion: "1.0.0"},
+	}
+
+	setFlag(t, "dry-run", true)
+	changes := plan.New(testOrganization, testEnvironment)
+	if err := deployApiPolicy(context.Background(), policies, fake, testOrganization, testEnvironment, changes.Source("policies.json")); err != nil {
+		t.Fatal(err)
+	}
+	if calls := mutatingCalls(fake); len(calls) != 0 {
+		t.Errorf("expected nothing to be changed in a dry run, got %v", calls)
+	}
+	last := changes.Changes[len(changes.Changes)-1]
+	if last.Kind != "ApiPolicyOrder" || last.Action != plan.ActionUpdate {
+		t.Errorf("expected the reordering to be planned, got %+v", changes.Changes)
+	}
+
+	setFlag(t, "dry-run", false)
+	if err := deployApiPolicy(context.Background(), policies, fake, testOrganization, testEnvironment, nil); err != nil {
+		t.Fatal(err)
+	}
+	if calls := mutatingCalls(fake); !slices.Equal(calls, []string{"ReorderApiInstancePolicies"}) {
+		t.Errorf("expected the policies to be reordered, got %v", calls)
+	}
+	order := map[string]int{}
+	for _, policy := range fake.Policies(api.ID) {
+		order[policy.Template.AssetID] = policy.Order
+	}
+	if order["rate-limiting"] != 1 || order["client-id-enforcement"] != 2 || order["ip-allowlist"] != 3 {
+		t.Errorf("expected the declared order with the undeclared policy in place, got %v", order)
+	}
+
+	// Deploying again changes nothing
+	fake.Reset()
+	if err := deployApiPolicy(context.Background(), policies, fake, testOrganization, testEnvironment, nil); err != nil {
+		t.Fatal(err)
+	}
+	if calls := mutatingCalls(fake); len(calls) != 0 {
+		t.Errorf("expected the policies to be in order, got %v", calls)
+	}
+}
+
+func TestDeployApiPolicyDisabled(t *testing.T) {
+	setFlag(t, "dry-run", false)
+	fake := anypointclienttest.NewFake(testOrganization)
+	api := fake.AddApi(testEnvironment, anypointclient.ApiInstance{AssetID: "orders-api"})
+	var existing anypointclient.ApiPolicyResponse
+	existing.Template.GroupID = "mulesoft"
+	existing.Template.AssetID = "ip-allowlist"
+	existing.Template.AssetVersion = "1.0.0"
+	fake.AddPolicy(api.ID, existing)
+
+	var policies resources.ApiPoliciesV1
+	policies.Spec.ApiInstanceID = strconv.Itoa(api.ID)
+	policies.Spec.Policies = []anypointclient.ApiPolicyRequest{{GroupID: "mulesoft", AssetID: "ip-allowlist", AssetVersion: "1.0.0", Disabled: true}}
+	changes := plan.New(testOrganization, testEnvironment)
+	if err := deployApiPolicy(context.Background(), policies, fake, testOrganization, testEnvironment, changes.Source("policies.json")); err != nil {
+		t.Fatal(err)
+	}
+	if change := changes.Changes[0]; change.Action != plan.ActionUpdate || change.Fields[0].Field != "disabled" {
+		t.Errorf("expected disabled to be a change, got %+v", changes.Changes)
+	}
+	if deployed := fake.Policies(api.ID); !deployed[0].Disabled {
+		t.Errorf("expected the policy to be disabled, got %+v", deployed)
+	}
+}
+
+func TestDeployMqDestinations(t *testing.T) {
+	setFlag(t, "dry-run", false)
+	setFlag(t, "mq-region", "us-east-1")
+	fake := anypointclienttest.NewFake(testOrganization)
+	fake.AddMqDestination("org-id", "env-id", "us-east-1", anypointclient.MqDestination{Type: "queue", QueueID: "orders-dlq", Encrypted: true})
+
+	var destinations resources.MqDestinationsV1
+	destinations.Spec.Queues = []anypointclient.MqQueue{{QueueID: "orders", DeadLetterQueueID: "orders-dlq", MaxDeliveries: 5}, {QueueID: "orders-dlq"}}
+	exchange := resources.MqExchangeWithBindings{MqExchange: anypointclient.MqExchange{ExchangeID: "events"}}
+	exchange.Bindings = []anypointclient.MqBinding{{QueueID: "orders"}}
+	destinations.Spec.Exchanges = []resources.MqExchangeWithBindings{exchange}
+
+	if err := deployMqDestinations(destinations, fake, testOrganization, testEnvironment, nil, plan.New(testOrganization, testEnvironment).Source("mq.json")); err != nil {
+		t.Fatal(err)
+	}
+	if calls := mutatingCalls(fake); !slices.Equal(calls, []string{"CreateMqQueue", "CreateMqExchange", "CreateMqBinding"}) {
+		t.Errorf("expected the missing queue, exchange and binding to be created, got %v", calls)
+	}
+	if bindings := fake.MqBindings("org-id", "env-id", "us-east-1", "events"); len(bindings) != 1 || bindings[0].QueueID != "orders" {
+		t.Errorf("unexpected bindings %+v", bindings)
+	}
+}
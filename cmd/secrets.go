@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/appconf"
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/secrets"
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient"
+	"github.com/spf13/viper"
+)
+
+var (
+	vaultProvider     *secrets.VaultProvider
+	vaultProviderOnce sync.Once
+)
+
+// getSecretResolver returns the resolver for vault://, file:// and sops:// secure property references.
+// Relative file:// and sops:// paths are resolved against dir, the directory of the descriptor, like ${file()}.
+// The Vault provider is created from the flags on first use and shared, so that every secret is read once.
+func getSecretResolver(dir string) *secrets.Resolver {
+	vaultProviderOnce.Do(func() {
+		vaultAddress := viper.GetString("vault-address")
+		if vaultAddress == "" {
+			vaultAddress = os.Getenv("VAULT_ADDR")
+		}
+		vaultToken := viper.GetString("vault-token")
+		if vaultToken == "" {
+			vaultToken = os.Getenv("VAULT_TOKEN")
+		}
+		vaultProvider = secrets.NewVaultProvider(vaultAddress, vaultToken, os.Getenv("VAULT_NAMESPACE"))
+	})
+	resolver := secrets.NewResolver()
+	resolver.Register("vault", vaultProvider)
+	resolver.Register("file", secrets.FileProvider{Dir: dir})
+	resolver.Register("sops", secrets.SopsProvider{Dir: dir})
+	return resolver
+}
+
+// securePropertiesKey returns the key of the HMAC recorded in the secure properties label, nil if there is none
+func securePropertiesKey() []byte {
+	key := viper.GetString("secure-properties-key")
+	if key == "" {
+		key = os.Getenv("SECURE_PROPERTIES_KEY")
+	}
+	if key == "" {
+		return nil
+	}
+	return []byte(key)
+}
+
+// securePropertiesLabel returns the label recording the hash of the resolved secure properties of the deployment,
+// reusing the salt of the given labels
+func securePropertiesLabel(resolved anypointclient.CloudhubDeploymentReq, labels []string) (string, error) {
+	salt, err := appconf.SecurePropertiesSalt(labels)
+	if err != nil {
+		return "", err
+	}
+	return appconf.SecurePropertiesLabel(resolved.Application.Configuration.MuleAgentApplicationPropertiesService.SecureProperties,
+		salt, securePropertiesKey()), nil
+}
+
+// resolveSecureProperties returns a copy of the deployment with the secret references in its secure properties
+// replaced by the secrets. References are resolved once per deployment, when its descriptor is read, so that the values
+// sent to Anypoint Platform are those recorded in the secure properties label. Secrets never end up in plans or logs.
+// Relative paths are resolved against dir, the directory of the descriptor.
+func resolveSecureProperties(ctx context.Context, deployment anypointclient.CloudhubDeploymentReq, dir string) (anypointclient.CloudhubDeploymentReq, error) {
+	propertiesService := &deployment.Application.Configuration.MuleAgentApplicationPropertiesService
+	resolved, err := getSecretResolver(dir).ResolveAll(ctx, propertiesService.SecureProperties)
+	if err != nil {
+		return deployment, fmt.Errorf("deployment %s: %w", deployment.Name, err)
+	}
+	propertiesService.SecureProperties = resolved
+	return deployment, nil
+}
+
+// withSecureProperties returns a copy of the deployment with the given secure properties
+func withSecureProperties(deployment anypointclient.CloudhubDeploymentReq, secureProperties map[string]string) anypointclient.CloudhubDeploymentReq {
+	deployment.Application.Configuration.MuleAgentApplicationPropertiesService.SecureProperties = secureProperties
+	return deployment
+}
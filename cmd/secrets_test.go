@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/appconf"
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/plan"
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient"
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient/anypointclienttest"
+)
+
+// applicationDescriptor returns an application descriptor with the given secure properties
+func applicationDescriptor(name string, secureProperties string) string {
+	return `{"kind": "Application", "version": "v1", "spec": {"name": "` + name + `",
+		"application": {"ref": {"version": "1.0.0"}, "configuration": {"mule.agent.application.properties.service": {
+			"applicationName": "` + name + `", "secureProperties": ` + secureProperties + `}}}}}`
+}
+
+func TestProcessFilesResolvesSecretsOnce(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the sops stand-in is a shell script")
+	}
+	setFlag(t, "dry-run", false)
+	setFlag(t, "strict", true)
+	bin := t.TempDir()
+	script := "#!/bin/sh\necho \"$@\" >> " + filepath.Join(bin, "sops.log") + "\necho 'db: {password: from-sops}'\n"
+	if err := os.WriteFile(filepath.Join(bin, "sops"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"orders.json": applicationDescriptor("orders", `{"db.password": "sops://`+filepath.Join(dir, "secrets.enc.yaml")+`#db.password"}`),
+	})
+	fake := anypointclienttest.NewFake(testOrganization)
+
+	faults := processFiles(context.Background(), fake, []string{dir}, testOrganization, testEnvironment, anypointclient.PrivateSpace{}, plan.New(testOrganization, testEnvironment))
+	if len(faults) > 0 {
+		t.Fatal(faults)
+	}
+	// Anypoint Platform masks the deployed values, the label records the hash of the values that were sent
+	created, _ := fake.Deployment(testEnvironment, "orders")
+	salt, err := appconf.SecurePropertiesSalt(created.Labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if label := appconf.SecurePropertiesLabel(map[string]string{"db.password": "from-sops"}, salt, nil); !slices.Contains(created.Labels, label) {
+		t.Errorf("expected the label %s of the resolved secure properties, got %v", label, created.Labels)
+	}
+	if calls, _ := os.ReadFile(filepath.Join(bin, "sops.log")); strings.Count(string(calls), "--decrypt") != 1 {
+		t.Errorf("expected the secrets to be decrypted once, got calls %q", calls)
+	}
+}
+
+func TestProcessFilesReportsMissingSecretsBeforeDeploying(t *testing.T) {
+	setFlag(t, "dry-run", false)
+	setFlag(t, "strict", true)
+	setFlag(t, "mq-region", "us-east-1")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a-orders.json": applicationDescriptor("orders", `{"db.password": "file://`+filepath.Join(dir, "missing.txt")+`"}`),
+		"b-queues.json": `{"kind": "MqDestinations", "version": "v1", "spec": {"queues": [{"queueId": "orders"}]}}`,
+	})
+	fake := anypointclienttest.NewFake(testOrganization)
+
+	faults := processFiles(context.Background(), fake, []string{dir}, testOrganization, testEnvironment, anypointclient.PrivateSpace{}, plan.New(testOrganization, testEnvironment))
+	if len(faults) != 1 || !strings.Contains(faults[0].Error(), "db.password") || !strings.Contains(faults[0].Error(), "missing.txt") {
+		t.Fatalf("expected the missing secret to be reported, got %v", faults)
+	}
+	if calls := mutatingCalls(fake); len(calls) != 0 {
+		t.Errorf("expected nothing to be deployed with --strict, got %v", calls)
+	}
+}
+
+func TestProcessFilesResolvesSecretFilesRelativeToTheDescriptor(t *testing.T) {
+	setFlag(t, "dry-run", true)
+	setFlag(t, "strict", true)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"db-password.txt": "from-file\n",
+		"orders.json":     applicationDescriptor("orders", `{"db.password": "file://db-password.txt"}`),
+	})
+	changes := plan.New(testOrganization, testEnvironment)
+
+	faults := processFiles(context.Background(), anypointclienttest.NewFake(testOrganization), []string{dir}, testOrganization, testEnvironment, anypointclient.PrivateSpace{}, changes)
+	if len(faults) > 0 {
+		t.Fatal(faults)
+	}
+	var payload applicationPayload
+	if err := json.Unmarshal(changes.Changes[0].Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	salt, err := appconf.SecurePropertiesSalt(payload.Deployment.Labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if label := appconf.SecurePropertiesLabel(map[string]string{"db.password": "from-file"}, salt, nil); !slices.Contains(payload.Deployment.Labels, label) {
+		t.Errorf("expected the secret file next to the descriptor to be read, got labels %v", payload.Deployment.Labels)
+	}
+}
@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// isYAML returns true if the descriptor file is written in YAML
+func isYAML(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// decodeDescriptors decodes every resource in a descriptor file, using the file extension to tell YAML from JSON.
+// A JSON file holds a single resource or an array of resources and a YAML file one resource per document.
+// Every resource that fails to decode is reported by its index in the file, and then no resources are returned
+// so that a file is never deployed partially.
+func decodeDescriptors(file string, data []byte) ([]any, []error) {
+	if isYAML(file) {
+		return decodeYAMLDescriptors(file, data)
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		resource, err := unmarshalResource(data)
+		if err != nil {
+			return nil, []error{fmt.Errorf("failed to decode %s: %w", file, err)}
+		}
+		return []any{resource}, nil
+	}
+
+	var documents []json.RawMessage
+	if err := json.Unmarshal(trimmed, &documents); err != nil {
+		return nil, []error{fmt.Errorf("failed to decode %s: %w", file, err)}
+	}
+	resources := make([]any, len(documents))
+	var errs []error
+	for i, document := range documents {
+		resource, err := unmarshalResource(document)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to decode %s: %w", resourceSource(file, i, len(documents)), err))
+			continue
+		}
+		resources[i] = resource
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return resources, nil
+}
+
+// decodeYAMLDescriptors decodes every non-empty document in a YAML descriptor file
+func decodeYAMLDescriptors(file string, data []byte) ([]any, []error) {
+	var documents []*yaml.Node
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var document yaml.Node
+		err := decoder.Decode(&document)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, []error{fmt.Errorf("failed to parse YAML %s: %w", file, err)}
+		}
+		// Skip empty documents, e.g. after a trailing ---
+		if len(document.Content) == 0 || document.Content[0].ShortTag() == "!!null" {
+			continue
+		}
+		documents = append(documents, &document)
+	}
+
+	resources := make([]any, len(documents))
+	var errs []error
+	for i, document := range documents {
+		resource, err := unmarshalYAMLResource(document)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to decode %s: %w", resourceSource(file, i, len(documents)), err))
+			continue
+		}
+		resources[i] = resource
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return resources, nil
+}
+
+// unmarshalYAMLResource decodes a YAML document by converting it to JSON, so that YAML and JSON descriptors
+// share the same field names and kind dispatch. Decoding errors are reported with the YAML line of the field.
+func unmarshalYAMLResource(document *yaml.Node) (any, error) {
+	value, err := yamlValue(document)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert YAML: %w", err)
+	}
+
+	resource, err := unmarshalResource(data)
+	if err != nil {
+		line := document.Line
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			line = yamlLine(document, typeErr.Field)
+		}
+		return nil, fmt.Errorf("line %d: %w", line, err)
+	}
+	return resource, nil
+}
+
+// yamlValue converts a YAML node to the value encoding/json would have decoded from the equivalent JSON
+func yamlValue(node *yaml.Node) (any, error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		return yamlValue(node.Content[0])
+	case yaml.AliasNode:
+		return yamlValue(node.Alias)
+	case yaml.MappingNode:
+		mapping := make(map[string]any, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("line %d: mapping keys must be strings", key.Line)
+			}
+			converted, err := yamlValue(value)
+			if err != nil {
+				return nil, err
+			}
+			mapping[key.Value] = converted
+		}
+		return mapping, nil
+	case yaml.SequenceNode:
+		sequence := make([]any, 0, len(node.Content))
+		for _, item := range node.Content {
+			converted, err := yamlValue(item)
+			if err != nil {
+				return nil, err
+			}
+			sequence = append(sequence, converted)
+		}
+		return sequence, nil
+	default:
+		// Timestamps and other tagged scalars are kept as written, like in JSON
+		switch node.ShortTag() {
+		case "!!str", "!!timestamp", "!!binary":
+			return node.Value, nil
+		}
+		var value any
+		if err := node.Decode(&value); err != nil {
+			return nil, fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		return value, nil
+	}
+}
+
+// yamlLine returns the line of the field with the given dotted JSON path, or of the closest parent that can be found.
+// Sequences are not part of the path, the line of the sequence is returned for fields inside them.
+func yamlLine(node *yaml.Node, path string) int {
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	line := node.Line
+	if path == "" {
+		return line
+	}
+	for _, field := range strings.Split(path, ".") {
+		if node.Kind != yaml.MappingNode {
+			return line
+		}
+		found := false
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == field {
+				line = node.Content[i].Line
+				node = node.Content[i+1]
+				found = true
+				break
+			}
+		}
+		if !found {
+			return line
+		}
+	}
+	return line
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/resources"
+)
+
+func TestDecodeYAMLDescriptor(t *testing.T) {
+	descriptor := `
+kind: Application
+version: v1
+spec:
+  name: simple-app
+  labels: [team-a]
+  target:
+    replicas: 2
+    deploymentSettings:
+      runtime:
+        version: "4.6.0"
+  application:
+    ref:
+      groupId: com.example
+      artifactId: simple-app
+      version: 1.0.0
+    vCores: 0.1
+    configuration:
+      mule.agent.application.properties.service:
+        properties:
+          http.port: "8081"
+`
+	descriptors, errs := decodeDescriptors("simple-app.yaml", []byte(descriptor))
+	if len(errs) > 0 || len(descriptors) != 1 {
+		t.Fatalf("failed to decode descriptor: %v", errs)
+	}
+	resource := descriptors[0]
+	app, ok := resource.(resources.ApplicationV1)
+	if !ok {
+		t.Fatalf("expected ApplicationV1, got %T", resource)
+	}
+	if app.Spec.Name != "simple-app" || app.Spec.Target.Replicas != 2 || app.Spec.Application.VCores != 0.1 {
+		t.Errorf("unexpected spec %+v", app.Spec)
+	}
+	if app.Spec.Application.Ref.Version != "1.0.0" {
+		t.Errorf("expected version 1.0.0, got %s", app.Spec.Application.Ref.Version)
+	}
+	if port := app.Spec.Application.Configuration.MuleAgentApplicationPropertiesService.Properties["http.port"]; port != "8081" {
+		t.Errorf("expected http.port 8081, got %s", port)
+	}
+}
+
+func TestDecodeYAMLDescriptorErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		descriptor string
+		expected   string
+	}{
+		{
+			name:       "syntax error",
+			descriptor: "kind: Application\nversion: v1\nspec:\n  name: [unclosed\n",
+			expected:   "line",
+		},
+		{
+			name:       "wrong type",
+			descriptor: "kind: Application\nversion: v1\nspec:\n  name: app\n  target:\n    replicas: two\n",
+			expected:   "line 6:",
+		},
+		{
+			name:       "unknown kind",
+			descriptor: "kind: Unknown\nversion: v1\n",
+			expected:   "unknown kind: Unknown",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			descriptors, errs := decodeDescriptors("descriptor.yml", []byte(test.descriptor))
+			if len(errs) != 1 || descriptors != nil {
+				t.Fatalf("expected a single error and no descriptors, got %v", errs)
+			}
+			if !strings.Contains(errs[0].Error(), test.expected) {
+				t.Errorf("expected error to contain %q, got %v", test.expected, errs[0])
+			}
+		})
+	}
+}
+
+func TestDecodeJSONDescriptor(t *testing.T) {
+	descriptors, errs := decodeDescriptors("policies.json", []byte(`{"kind": "ApiPolicies", "version": "v1", "spec": {"apiInstanceId": "42"}}`))
+	if len(errs) > 0 || len(descriptors) != 1 {
+		t.Fatalf("failed to decode descriptor: %v", errs)
+	}
+	if policies, ok := descriptors[0].(resources.ApiPoliciesV1); !ok || policies.Spec.ApiInstanceID != "42" {
+		t.Errorf("unexpected resource %+v", descriptors[0])
+	}
+}
+
+func TestDecodeMultiDocumentYAML(t *testing.T) {
+	descriptor := `kind: MqDestinations
+version: v1
+spec:
+  queues:
+    - queueId: orders
+---
+kind: Application
+version: v1
+spec:
+  name: simple-app
+---
+`
+	descriptors, errs := decodeDescriptors("resources.yaml", []byte(descriptor))
+	if len(errs) > 0 {
+		t.Fatalf("failed to decode descriptors: %v", errs)
+	}
+	if len(descriptors) != 2 {
+		t.Fatalf("expected 2 resources, got %d", len(descriptors))
+	}
+	if _, ok := descriptors[0].(resources.MqDestinationsV1); !ok {
+		t.Errorf("expected MqDestinationsV1 first, got %T", descriptors[0])
+	}
+	if _, ok := descriptors[1].(resources.ApplicationV1); !ok {
+		t.Errorf("expected ApplicationV1 second, got %T", descriptors[1])
+	}
+}
+
+func TestDecodeJSONArrayReportsIndex(t *testing.T) {
+	descriptor := `[
+		{"kind": "ApiPolicies", "version": "v1", "spec": {"apiInstanceId": "42"}},
+		{"kind": "Unknown", "version": "v1"}
+	]`
+	descriptors, errs := decodeDescriptors("resources.json", []byte(descriptor))
+	if descriptors != nil {
+		t.Errorf("expected no resources when one fails to decode, got %+v", descriptors)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "resources.json[1]") {
+		t.Errorf("expected error for resources.json[1], got %v", errs)
+	}
+}
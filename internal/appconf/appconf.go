@@ -1,10 +1,12 @@
 package appconf
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -34,108 +36,155 @@ func UpdateDeploymentToLatestSchema(newDeployment anypointclient.CloudhubDeploym
 	return updatedDeployment, nil
 }
 
-func PrepareDeploymentAndCheckChanges(
+// Defaults filled in by the CloudHub 2.0 API when the corresponding field is left empty in the request
+const (
+	defaultReplicas       = 1
+	defaultUpdateStrategy = "rolling"
+	defaultDesiredState   = "STARTED"
+)
+
+// FieldChange describes a single field that differs between the desired and the current deployment
+type FieldChange struct {
+	Field   string `json:"field"`
+	Current any    `json:"current"`
+	Desired any    `json:"desired"`
+}
+
+func (change FieldChange) String() string {
+	return fmt.Sprintf("%s changed from %v to %v", change.Field, change.Current, change.Desired)
+}
+
+// PrepareDeploymentAndListChanges prepares the deployment request and returns every field where
+// the running deployment differs from the desired one.
+func PrepareDeploymentAndListChanges(
 	newDeployment anypointclient.CloudhubDeploymentReq,
-	deployment anypointclient.CloudhubDeploymentResp) (anypointclient.CloudhubDeploymentReq, bool) {
+	deployment anypointclient.CloudhubDeploymentResp) (anypointclient.CloudhubDeploymentReq, []FieldChange) {
 
 	// Clone newDeployment to a new struct called updatedDeployment
 	updatedDeployment := newDeployment
 
-	changed := false
-
-	if newDeployment.Name != deployment.Name {
-		fmt.Println("Name changed from", deployment.Name, "to", newDeployment.Name, "for deployment", deployment.Name)
-		changed = true
+	var changes []FieldChange
+	compare := func(field string, current, desired any) {
+		if !reflect.DeepEqual(current, desired) {
+			changes = append(changes, FieldChange{Field: field, Current: current, Desired: desired})
+		}
 	}
 
-	if ingressUpdated(newDeployment.Target.DeploymentSettings.HTTP, deployment.Target.DeploymentSettings.HTTP) {
-		changed = true
-	}
+	desiredSettings := newDeployment.Target.DeploymentSettings
+	currentSettings := deployment.Target.DeploymentSettings
 
-	if newDeployment.Target.DeploymentSettings.Jvm != deployment.Target.DeploymentSettings.Jvm {
-		fmt.Println("Jvm settings changed from", deployment.Target.DeploymentSettings.Jvm, "to", newDeployment.Target.DeploymentSettings.Jvm, "for deployment", deployment.Name)
-		changed = true
+	compare("name", deployment.Name, newDeployment.Name)
+	currentHashLabel, currentLabels := splitSecurePropertiesLabel(deployment.Labels)
+	desiredHashLabel, desiredLabels := splitSecurePropertiesLabel(newDeployment.Labels)
+	if !sliceEquals(desiredLabels, currentLabels) {
+		changes = append(changes, FieldChange{Field: "labels", Current: currentLabels, Desired: desiredLabels})
 	}
+	compare("application.configuration.secureProperties", securePropertiesHash(currentHashLabel), securePropertiesHash(desiredHashLabel))
+	if newDeployment.Target.Provider != "" {
+		compare("target.provider", deployment.Target.Provider, newDeployment.Target.Provider)
+	}
+	compare("target.replicas", withDefault(deployment.Target.Replicas, defaultReplicas), withDefault(newDeployment.Target.Replicas, defaultReplicas))
+
+	compare("target.deploymentSettings.clustered", currentSettings.Clustered, desiredSettings.Clustered)
+	compare("target.deploymentSettings.enforceDeployingReplicasAcrossNodes", currentSettings.EnforceDeployingReplicasAcrossNodes, desiredSettings.EnforceDeployingReplicasAcrossNodes)
+	changes = append(changes, ingressChanges(desiredSettings.HTTP, currentSettings.HTTP)...)
 
 	// Otherwise use the runtime struct
-	if runtimeVersionUpdated(newDeployment.Target.DeploymentSettings.Runtime.Version, deployment.Target.DeploymentSettings.Runtime.Version) {
+	if runtimeVersionUpdated(desiredSettings.Runtime.Version, currentSettings.Runtime.Version) {
 		// Remove any tilde from the new version
-		updatedDeployment.Target.DeploymentSettings.Runtime.Version = strings.TrimPrefix(newDeployment.Target.DeploymentSettings.Runtime.Version, "~")
-		fmt.Println("Runtime.Version changed from", deployment.Target.DeploymentSettings.Runtime.Version, "to", newDeployment.Target.DeploymentSettings.Runtime.Version, "for deployment", deployment.Name)
-		changed = true
+		updatedDeployment.Target.DeploymentSettings.Runtime.Version = strings.TrimPrefix(desiredSettings.Runtime.Version, "~")
+		changes = append(changes, FieldChange{Field: "target.deploymentSettings.runtime.version", Current: currentSettings.Runtime.Version, Desired: desiredSettings.Runtime.Version})
 	} else {
 		// Use the existing runtime version to make sure that we do not change version if the new version was a tilde range
-		updatedDeployment.Target.DeploymentSettings.Runtime.Version = deployment.Target.DeploymentSettings.Runtime.Version
+		updatedDeployment.Target.DeploymentSettings.Runtime.Version = currentSettings.Runtime.Version
 	}
 	// Also check if the Java version has changed
-	if newDeployment.Target.DeploymentSettings.Runtime.Java != deployment.Target.DeploymentSettings.Runtime.Java {
-		fmt.Println("Runtime.Java changed from", deployment.Target.DeploymentSettings.Runtime.Java, "to", newDeployment.Target.DeploymentSettings.Runtime.Java, "for deployment", deployment.Name)
-		changed = true
-	}
+	compare("target.deploymentSettings.runtime.java", currentSettings.Runtime.Java, desiredSettings.Runtime.Java)
+	if desiredSettings.Runtime.ReleaseChannel != "" {
+		compare("target.deploymentSettings.runtime.releaseChannel", currentSettings.Runtime.ReleaseChannel, desiredSettings.Runtime.ReleaseChannel)
+	}
+
+	compare("target.deploymentSettings.updateStrategy", withDefault(currentSettings.UpdateStrategy, defaultUpdateStrategy), withDefault(desiredSettings.UpdateStrategy, defaultUpdateStrategy))
+	compare("target.deploymentSettings.disableAmLogForwarding", currentSettings.DisableAmLogForwarding, desiredSettings.DisableAmLogForwarding)
+	compare("target.deploymentSettings.persistentObjectStore", currentSettings.PersistentObjectStore, desiredSettings.PersistentObjectStore)
+	compare("target.deploymentSettings.generateDefaultPublicUrl", currentSettings.GenerateDefaultPublicURL, desiredSettings.GenerateDefaultPublicURL)
+
+	compare("application.desiredState", withDefault(deployment.Application.DesiredState, defaultDesiredState), withDefault(newDeployment.Application.DesiredState, defaultDesiredState))
+	compare("application.vCores", deployment.Application.VCores, newDeployment.Application.VCores)
+	compare("application.ref.groupId", deployment.Application.Ref.GroupID, newDeployment.Application.Ref.GroupID)
+	compare("application.ref.artifactId", deployment.Application.Ref.ArtifactID, newDeployment.Application.Ref.ArtifactID)
+	compare("application.ref.packaging", deployment.Application.Ref.Packaging, newDeployment.Application.Ref.Packaging)
+	compare("application.ref.version", deployment.Application.Ref.Version, newDeployment.Application.Ref.Version)
+	compare("application.integrations.services.objectStoreV2.enabled",
+		deployment.Application.Integrations.Services.ObjectStoreV2.Enabled,
+		newDeployment.Application.Integrations.Services.ObjectStoreV2.Enabled)
 
-	if newDeployment.Target.DeploymentSettings.DisableAmLogForwarding != deployment.Target.DeploymentSettings.DisableAmLogForwarding {
-		fmt.Println("DisableAmLogForwarding changed from", deployment.Target.DeploymentSettings.DisableAmLogForwarding, "to", newDeployment.Target.DeploymentSettings.DisableAmLogForwarding, "for deployment", deployment.Name)
-		changed = true
+	if schedulesHaveChanged(newDeployment.Application.Configuration.MuleAgentScheduleService.Schedulers, deployment.Application.Configuration.MuleAgentScheduleService.Schedulers) {
+		changes = append(changes, FieldChange{
+			Field:   "application.configuration.schedulers",
+			Current: deployment.Application.Configuration.MuleAgentScheduleService.Schedulers,
+			Desired: newDeployment.Application.Configuration.MuleAgentScheduleService.Schedulers,
+		})
 	}
 
-	if newDeployment.Application.VCores != deployment.Application.VCores {
-		fmt.Println("VCores changed from", deployment.Application.VCores, "to", newDeployment.Application.VCores, "for deployment", deployment.Name)
-		changed = true
+	currentScopes := deployment.Application.Configuration.MuleAgentLoggingService.ScopeLoggingConfigurations
+	desiredScopes := newDeployment.Application.Configuration.MuleAgentLoggingService.ScopeLoggingConfigurations
+	if (len(currentScopes) > 0 || len(desiredScopes) > 0) && !reflect.DeepEqual(normalizeJSON(currentScopes), normalizeJSON(desiredScopes)) {
+		changes = append(changes, FieldChange{Field: "application.configuration.scopeLoggingConfigurations", Current: currentScopes, Desired: desiredScopes})
 	}
 
-	if newDeployment.Application.Ref.GroupID != deployment.Application.Ref.GroupID {
-		fmt.Println("GroupID changed from", deployment.Application.Ref.GroupID, "to", newDeployment.Application.Ref.GroupID, "for deployment", deployment.Name)
-		changed = true
-	}
+	changes = append(changes, propertiesChanges(
+		deployment.Application.Configuration.MuleAgentApplicationPropertiesService.Properties,
+		newDeployment.Application.Configuration.MuleAgentApplicationPropertiesService.Properties)...)
+	changes = append(changes, securePropertiesChanges(
+		deployment.Application.Configuration.MuleAgentApplicationPropertiesService.SecureProperties,
+		newDeployment.Application.Configuration.MuleAgentApplicationPropertiesService.SecureProperties)...)
 
-	if newDeployment.Application.Ref.ArtifactID != deployment.Application.Ref.ArtifactID {
-		fmt.Println("ArtifactID changed from", deployment.Application.Ref.ArtifactID, "to", newDeployment.Application.Ref.ArtifactID, "for deployment", deployment.Name)
-		changed = true
-	}
+	return updatedDeployment, changes
+}
 
-	if newDeployment.Application.Ref.Packaging != deployment.Application.Ref.Packaging {
-		fmt.Println("Packaging changed from", deployment.Application.Ref.Packaging, "to", newDeployment.Application.Ref.Packaging, "for deployment", deployment.Name)
-		changed = true
+// withDefault returns defaultValue if value is the zero value, otherwise value
+func withDefault[T comparable](value, defaultValue T) T {
+	var zero T
+	if value == zero {
+		return defaultValue
 	}
+	return value
+}
 
-	if newDeployment.Application.Ref.Version != deployment.Application.Ref.Version {
-		fmt.Println("Version changed from", deployment.Application.Ref.Version, "to", newDeployment.Application.Ref.Version, "for deployment", deployment.Name)
-		changed = true
+// normalizeJSON round-trips value through JSON so that values decoded from different sources compare equal
+func normalizeJSON(value any) any {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value
 	}
-
-	if schedulesHaveChanged(newDeployment.Application.Configuration.MuleAgentScheduleService.Schedulers, deployment.Application.Configuration.MuleAgentScheduleService.Schedulers) {
-		fmt.Println("Schedulers Configuration changed for deployment", deployment.Name)
-		changed = true
+	var normalized any
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return value
 	}
-
-	changed = changed || propertiesHaveChanged(
-		deployment.Application.Configuration.MuleAgentApplicationPropertiesService.Properties,
-		newDeployment.Application.Configuration.MuleAgentApplicationPropertiesService.Properties)
-
-	return updatedDeployment, changed
+	return normalized
 }
 
-// ingressUpdated returns true if the ingress settings have changed, false otherwise
-func ingressUpdated(desiredHttpIngress, currentHttpIngress anypointclient.DeploymentHttpIngress) bool {
+// ingressChanges returns the list of ingress settings that have changed
+func ingressChanges(desiredHttpIngress, currentHttpIngress anypointclient.DeploymentHttpIngress) []FieldChange {
+	const prefix = "target.deploymentSettings.http.inbound."
+	var changes []FieldChange
+
 	// Check PathRewrite, LastMileSecurity, and ForwardSslSession
 	if desiredHttpIngress.Inbound.PathRewrite != currentHttpIngress.Inbound.PathRewrite {
-		log.Printf("PathRewrite changed from %q to %q", currentHttpIngress.Inbound.PathRewrite, desiredHttpIngress.Inbound.PathRewrite)
-		return true
+		changes = append(changes, FieldChange{Field: prefix + "pathRewrite", Current: currentHttpIngress.Inbound.PathRewrite, Desired: desiredHttpIngress.Inbound.PathRewrite})
 	}
 	if desiredHttpIngress.Inbound.LastMileSecurity != currentHttpIngress.Inbound.LastMileSecurity {
-		log.Printf("LastMileSecurity changed from %v to %v", currentHttpIngress.Inbound.LastMileSecurity, desiredHttpIngress.Inbound.LastMileSecurity)
-		return true
+		changes = append(changes, FieldChange{Field: prefix + "lastMileSecurity", Current: currentHttpIngress.Inbound.LastMileSecurity, Desired: desiredHttpIngress.Inbound.LastMileSecurity})
 	}
 	if desiredHttpIngress.Inbound.ForwardSslSession != currentHttpIngress.Inbound.ForwardSslSession {
-		log.Printf("ForwardSslSession changed from %v to %v", currentHttpIngress.Inbound.ForwardSslSession, desiredHttpIngress.Inbound.ForwardSslSession)
-		return true
+		changes = append(changes, FieldChange{Field: prefix + "forwardSslSession", Current: currentHttpIngress.Inbound.ForwardSslSession, Desired: desiredHttpIngress.Inbound.ForwardSslSession})
 	}
 
 	// Check InternalURL only if specified in desired config
 	if desiredHttpIngress.Inbound.InternalURL != "" &&
 		desiredHttpIngress.Inbound.InternalURL != currentHttpIngress.Inbound.InternalURL {
-		log.Printf("InternalURL changed from %q to %q", currentHttpIngress.Inbound.InternalURL, desiredHttpIngress.Inbound.InternalURL)
-		return true
+		changes = append(changes, FieldChange{Field: prefix + "internalUrl", Current: currentHttpIngress.Inbound.InternalURL, Desired: desiredHttpIngress.Inbound.InternalURL})
 	}
 
 	// Compare PublicURL lists, filtering out auto-generated CloudHub URLs
@@ -143,8 +192,7 @@ func ingressUpdated(desiredHttpIngress, currentHttpIngress anypointclient.Deploy
 	currentURLs := filterNonCloudhubURLs(strings.Split(currentHttpIngress.Inbound.PublicURL, ","))
 
 	if !sliceEquals(desiredURLs, currentURLs) {
-		log.Printf("PublicURL (filtered) changed from %v to %v", currentURLs, desiredURLs)
-		return true
+		changes = append(changes, FieldChange{Field: prefix + "publicUrl", Current: currentURLs, Desired: desiredURLs})
 	}
 
 	// If desired config specifies endpoints, compare only non-CloudHub endpoints
@@ -153,8 +201,8 @@ func ingressUpdated(desiredHttpIngress, currentHttpIngress anypointclient.Deploy
 		currentEndpoints := filterNonCloudhubEndpoints(currentHttpIngress.Inbound.Endpoints)
 
 		if len(desiredEndpoints) != len(currentEndpoints) {
-			log.Printf("Endpoint count (filtered) changed from %d to %d", len(currentEndpoints), len(desiredEndpoints))
-			return true
+			changes = append(changes, FieldChange{Field: prefix + "endpoints", Current: currentEndpoints, Desired: desiredEndpoints})
+			return changes
 		}
 
 		// Compare endpoints by matching URLs (not by index position)
@@ -164,21 +212,18 @@ func ingressUpdated(desiredHttpIngress, currentHttpIngress anypointclient.Deploy
 				if desired.URL == current.URL {
 					found = true
 					if !endpointEquals(desired, current) {
-						log.Printf("Endpoint %q changed: PathRewrite %q->%q, Access %q->%q",
-							desired.URL, current.PathRewrite, desired.PathRewrite, current.Access, desired.Access)
-						return true
+						changes = append(changes, FieldChange{Field: prefix + "endpoints[" + desired.URL + "]", Current: current, Desired: desired})
 					}
 					break
 				}
 			}
 			if !found {
-				log.Printf("Endpoint %q not found in current deployment", desired.URL)
-				return true
+				changes = append(changes, FieldChange{Field: prefix + "endpoints[" + desired.URL + "]", Current: nil, Desired: desired})
 			}
 		}
 	}
 
-	return false
+	return changes
 }
 
 // runtimeVersionUpdated returns true if the version should be updated, false otherwise
@@ -222,17 +267,37 @@ func runtimeVersionUpdated(desiredVersion string, currentVersion string) bool {
 	return desiredParts[0] != currentParts[0]
 }
 
-func propertiesHaveChanged(oldProperties, newProperties map[string]string) bool {
-	return propertiesMismatch(oldProperties, newProperties) || propertiesMismatch(newProperties, oldProperties)
-}
+// propertiesChanges returns one change per property whose value differs.
+// A masked (secret) value on one side is not reported when the property is absent on the other side.
+func propertiesChanges(oldProperties, newProperties map[string]string) []FieldChange {
+	keys := make([]string, 0, len(oldProperties)+len(newProperties))
+	for property := range oldProperties {
+		keys = append(keys, property)
+	}
+	for property := range newProperties {
+		if _, ok := oldProperties[property]; !ok {
+			keys = append(keys, property)
+		}
+	}
+	sort.Strings(keys)
 
-func propertiesMismatch(src, dest map[string]string) bool {
-	for property, value := range src {
-		if dest[property] != value && !isSecret(value) {
-			return true
+	var changes []FieldChange
+	for _, property := range keys {
+		oldValue, oldExists := oldProperties[property]
+		newValue, newExists := newProperties[property]
+		if oldValue == newValue {
+			continue
+		}
+		if (!newExists && IsSecret(oldValue)) || (!oldExists && IsSecret(newValue)) {
+			continue
 		}
+		changes = append(changes, FieldChange{
+			Field:   "application.configuration.properties." + property,
+			Current: oldValue,
+			Desired: newValue,
+		})
 	}
-	return false
+	return changes
 }
 
 func schedulesHaveChanged(slice1, slice2 []anypointclient.Schedule) bool {
@@ -251,7 +316,33 @@ func schedulesHaveChanged(slice1, slice2 []anypointclient.Schedule) bool {
 	return !reflect.DeepEqual(map1, map2)
 }
 
-func isSecret(value string) bool {
+// RollbackDeployment returns a copy of the failed deployment with the application ref, properties and runtime
+// of the previous deployment. Secure properties are masked by Anypoint Platform and can not be restored,
+// the ones of the failed deployment are kept.
+func RollbackDeployment(failed anypointclient.CloudhubDeploymentReq, previous anypointclient.CloudhubDeploymentResp) anypointclient.CloudhubDeploymentReq {
+	rollback := failed
+
+	rollback.Application.Ref.GroupID = previous.Application.Ref.GroupID
+	rollback.Application.Ref.ArtifactID = previous.Application.Ref.ArtifactID
+	rollback.Application.Ref.Version = previous.Application.Ref.Version
+	rollback.Application.Ref.Packaging = previous.Application.Ref.Packaging
+
+	properties := map[string]string{}
+	for property, value := range previous.Application.Configuration.MuleAgentApplicationPropertiesService.Properties {
+		if !IsSecret(value) {
+			properties[property] = value
+		}
+	}
+	rollback.Application.Configuration.MuleAgentApplicationPropertiesService.Properties = properties
+
+	rollback.Target.DeploymentSettings.Runtime.Version = previous.Target.DeploymentSettings.Runtime.Version
+	rollback.Target.DeploymentSettings.Runtime.ReleaseChannel = previous.Target.DeploymentSettings.Runtime.ReleaseChannel
+	rollback.Target.DeploymentSettings.Runtime.Java = previous.Target.DeploymentSettings.Runtime.Java
+	return rollback
+}
+
+// IsSecret returns true if the value is a secret masked by Anypoint Platform
+func IsSecret(value string) bool {
 	matching, _ := regexp.MatchString("^[*]+$", value)
 	return matching
 }
@@ -271,17 +362,25 @@ func GetAnypointClient() *anypointclient.AnypointClient {
 
 	proxyURL := viper.GetString("proxy")
 
+	var client *anypointclient.AnypointClient
 	switch viper.GetString("authType") {
 	case "bearer":
-		return anypointclient.NewAnypointClientWithToken(viper.GetString("bearer"), baseURL, proxyURL)
+		client = anypointclient.NewAnypointClientWithToken(viper.GetString("bearer"), baseURL, proxyURL)
 	case "user":
-		return anypointclient.NewAnypointClientWithCredentials(viper.GetString("user"), viper.GetString("password"), baseURL, proxyURL)
+		client = anypointclient.NewAnypointClientWithCredentials(viper.GetString("user"), viper.GetString("password"), baseURL, proxyURL)
 	case "connectedapp":
-		return anypointclient.NewAnypointClientWithConnectedApp(viper.GetString("client-id"), viper.GetString("client-secret"), baseURL, proxyURL)
+		client = anypointclient.NewAnypointClientWithConnectedApp(viper.GetString("client-id"), viper.GetString("client-secret"), baseURL, proxyURL)
 	default:
 		log.Fatalf("Unknown authentication method: %s", viper.GetString("authType"))
 	}
-	return nil
+	client.RequestTimeout = viper.GetDuration("request-timeout")
+	if viper.IsSet("max-retries") {
+		client.RetryPolicy.MaxRetries = viper.GetInt("max-retries")
+	}
+	if viper.IsSet("max-retry-backoff") {
+		client.RetryPolicy.MaxBackoff = viper.GetDuration("max-retry-backoff")
+	}
+	return client
 }
 
 // sliceEquals compares two slices of comparable elements and returns true if they are equal, false otherwise.
@@ -317,8 +416,8 @@ func endpointEquals(a, b anypointclient.IngressEndpoint) bool {
 		a.Access == b.Access
 }
 
-// isCloudHubURL returns true if the URL is an auto-generated CloudHub URL
-func isCloudHubURL(url string) bool {
+// IsCloudHubURL returns true if the URL is an auto-generated CloudHub URL
+func IsCloudHubURL(url string) bool {
 	return strings.Contains(url, ".cloudhub.io")
 }
 
@@ -326,7 +425,7 @@ func isCloudHubURL(url string) bool {
 func filterNonCloudhubURLs(urls []string) []string {
 	var filtered []string
 	for _, url := range urls {
-		if url != "" && !isCloudHubURL(url) {
+		if url != "" && !IsCloudHubURL(url) {
 			filtered = append(filtered, url)
 		}
 	}
@@ -337,7 +436,7 @@ func filterNonCloudhubURLs(urls []string) []string {
 func filterNonCloudhubEndpoints(endpoints []anypointclient.IngressEndpoint) []anypointclient.IngressEndpoint {
 	var filtered []anypointclient.IngressEndpoint
 	for _, endpoint := range endpoints {
-		if !isCloudHubURL(endpoint.URL) {
+		if !IsCloudHubURL(endpoint.URL) {
 			filtered = append(filtered, endpoint)
 		}
 	}
@@ -52,8 +52,8 @@ var _ = Describe("Appconf", func() {
 		err = json.NewDecoder(strings.NewReader(string(requesttext))).Decode(&desiredDeployment)
 		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
 
-		_, changed := appconf.PrepareDeploymentAndCheckChanges(desiredDeployment, currentDeployment)
-		Ω(changed).Should(BeTrue(), "Should detect change")
+		_, changes := appconf.PrepareDeploymentAndListChanges(desiredDeployment, currentDeployment)
+		Ω(changes).ShouldNot(BeEmpty(), "Should detect change")
 	})
 
 	It("detect change of runtime version", func() {
@@ -76,8 +76,8 @@ var _ = Describe("Appconf", func() {
 		err = json.NewDecoder(strings.NewReader(string(requesttext))).Decode(&desiredDeployment)
 		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
 
-		_, changed := appconf.PrepareDeploymentAndCheckChanges(desiredDeployment, currentDeployment)
-		Ω(changed).Should(BeTrue(), "Should detect change")
+		_, changes := appconf.PrepareDeploymentAndListChanges(desiredDeployment, currentDeployment)
+		Ω(changes).ShouldNot(BeEmpty(), "Should detect change")
 	})
 
 	It("detect change of runtime version (old syntax)", func() {
@@ -104,8 +104,8 @@ var _ = Describe("Appconf", func() {
 		desiredDeployment, err = appconf.UpdateDeploymentToLatestSchema(desiredDeployment)
 		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
 
-		_, changed := appconf.PrepareDeploymentAndCheckChanges(desiredDeployment, currentDeployment)
-		Ω(changed).Should(BeTrue(), "Should detect change")
+		_, changes := appconf.PrepareDeploymentAndListChanges(desiredDeployment, currentDeployment)
+		Ω(changes).ShouldNot(BeEmpty(), "Should detect change")
 	})
 
 	It("testing tilde range", func() {
@@ -128,8 +128,8 @@ var _ = Describe("Appconf", func() {
 		err = json.NewDecoder(strings.NewReader(string(requesttext))).Decode(&desiredDeployment)
 		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
 
-		_, changed := appconf.PrepareDeploymentAndCheckChanges(desiredDeployment, currentDeployment)
-		Ω(changed).Should(BeFalse(), "Should not detect change")
+		_, changes := appconf.PrepareDeploymentAndListChanges(desiredDeployment, currentDeployment)
+		Ω(changes).Should(BeEmpty(), "Should not detect change")
 	})
 
 	It("detect multiple changes", func() {
@@ -152,8 +152,8 @@ var _ = Describe("Appconf", func() {
 		err = json.NewDecoder(strings.NewReader(string(requesttext))).Decode(&desiredDeployment)
 		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
 
-		_, changed := appconf.PrepareDeploymentAndCheckChanges(desiredDeployment, currentDeployment)
-		Ω(changed).Should(BeTrue(), "Should detect change")
+		_, changes := appconf.PrepareDeploymentAndListChanges(desiredDeployment, currentDeployment)
+		Ω(changes).ShouldNot(BeEmpty(), "Should detect change")
 	})
 
 	It("property change", func() {
@@ -176,8 +176,8 @@ var _ = Describe("Appconf", func() {
 		err = json.NewDecoder(strings.NewReader(string(requesttext))).Decode(&desiredDeployment)
 		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
 
-		_, changed := appconf.PrepareDeploymentAndCheckChanges(desiredDeployment, currentDeployment)
-		Ω(changed).Should(BeTrue(), "Should detect change")
+		_, changes := appconf.PrepareDeploymentAndListChanges(desiredDeployment, currentDeployment)
+		Ω(changes).ShouldNot(BeEmpty(), "Should detect change")
 	})
 
 	It("publicUrl change", func() {
@@ -200,8 +200,8 @@ var _ = Describe("Appconf", func() {
 		err = json.NewDecoder(strings.NewReader(string(requesttext))).Decode(&desiredDeployment)
 		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
 
-		_, changed := appconf.PrepareDeploymentAndCheckChanges(desiredDeployment, currentDeployment)
-		Ω(changed).Should(BeTrue(), "Should detect change")
+		_, changes := appconf.PrepareDeploymentAndListChanges(desiredDeployment, currentDeployment)
+		Ω(changes).ShouldNot(BeEmpty(), "Should detect change")
 	})
 
 	It("should handle deployments with endpoints configuration", func() {
@@ -239,8 +239,8 @@ var _ = Describe("Appconf", func() {
 		err = json.NewDecoder(strings.NewReader(string(requesttext))).Decode(&desiredDeployment)
 		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
 
-		_, changed := appconf.PrepareDeploymentAndCheckChanges(desiredDeployment, currentDeployment)
-		Ω(changed).Should(BeTrue(), "Should detect endpoint URL change")
+		_, changes := appconf.PrepareDeploymentAndListChanges(desiredDeployment, currentDeployment)
+		Ω(changes).ShouldNot(BeEmpty(), "Should detect endpoint URL change")
 	})
 
 	It("should handle backwards compatibility when endpoints are not specified", func() {
@@ -261,9 +261,9 @@ var _ = Describe("Appconf", func() {
 		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
 
 		// Should work without endpoints (backwards compatibility)
-		_, changed := appconf.PrepareDeploymentAndCheckChanges(desiredDeployment, currentDeployment)
+		_, changes := appconf.PrepareDeploymentAndListChanges(desiredDeployment, currentDeployment)
 		// The test should not panic and should handle the case gracefully
-		Ω(changed).Should(BeFalse(), "Should not detect changes when they are identical without endpoints")
+		Ω(changes).Should(BeEmpty(), "Should not detect changes when they are identical without endpoints")
 	})
 
 	It("should not trigger redeploy when config has no endpoints but deployment has endpoints", func() {
@@ -284,8 +284,8 @@ var _ = Describe("Appconf", func() {
 		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
 
 		// Should NOT trigger a change just because deployment has endpoints that config doesn't specify
-		_, changed := appconf.PrepareDeploymentAndCheckChanges(desiredDeployment, currentDeployment)
-		Ω(changed).Should(BeFalse(), "Should not detect changes when config has no endpoints but matches publicUrl")
+		_, changes := appconf.PrepareDeploymentAndListChanges(desiredDeployment, currentDeployment)
+		Ω(changes).Should(BeEmpty(), "Should not detect changes when config has no endpoints but matches publicUrl")
 	})
 
 	It("should detect publicUrl changes even when endpoints are present", func() {
@@ -309,7 +309,219 @@ var _ = Describe("Appconf", func() {
 		desiredDeployment.Target.DeploymentSettings.HTTP.Inbound.PublicURL = "https://different-url.example.com"
 
 		// Should detect the publicUrl change even though endpoints match
-		_, changed := appconf.PrepareDeploymentAndCheckChanges(desiredDeployment, currentDeployment)
-		Ω(changed).Should(BeTrue(), "Should detect publicUrl change even with endpoints present")
+		_, changes := appconf.PrepareDeploymentAndListChanges(desiredDeployment, currentDeployment)
+		Ω(changes).ShouldNot(BeEmpty(), "Should detect publicUrl change even with endpoints present")
+	})
+
+	It("should detect replicas, desired state and objectStoreV2 changes", func() {
+		responsetext, err := testresources.ReadFile("resources/simple-app-current.json")
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+
+		var currentDeployment anypointclient.CloudhubDeploymentResp
+		err = json.NewDecoder(strings.NewReader(string(responsetext))).Decode(&currentDeployment)
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+
+		requesttext, err := testresources.ReadFile("resources/simple-app-v2-tilderange.json")
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+
+		var desiredDeployment anypointclient.CloudhubDeploymentReq
+		err = json.NewDecoder(strings.NewReader(string(requesttext))).Decode(&desiredDeployment)
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+
+		desiredDeployment.Target.Replicas = 2
+		desiredDeployment.Application.DesiredState = "STOPPED"
+		desiredDeployment.Application.Integrations.Services.ObjectStoreV2.Enabled = true
+		desiredDeployment.Labels = []string{"team-a"}
+
+		_, changes := appconf.PrepareDeploymentAndListChanges(desiredDeployment, currentDeployment)
+		fields := []string{}
+		for _, change := range changes {
+			fields = append(fields, change.Field)
+		}
+		Ω(fields).Should(ConsistOf(
+			"labels",
+			"target.replicas",
+			"application.desiredState",
+			"application.integrations.services.objectStoreV2.enabled",
+		))
+	})
+
+	It("should not detect changes for fields filled in by server defaults", func() {
+		responsetext, err := testresources.ReadFile("resources/simple-app-current.json")
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+
+		var currentDeployment anypointclient.CloudhubDeploymentResp
+		err = json.NewDecoder(strings.NewReader(string(responsetext))).Decode(&currentDeployment)
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+
+		requesttext, err := testresources.ReadFile("resources/simple-app-v2-tilderange.json")
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+
+		var desiredDeployment anypointclient.CloudhubDeploymentReq
+		err = json.NewDecoder(strings.NewReader(string(requesttext))).Decode(&desiredDeployment)
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+
+		desiredDeployment.Target.Replicas = 0
+		desiredDeployment.Target.DeploymentSettings.UpdateStrategy = ""
+		desiredDeployment.Application.DesiredState = ""
+		desiredDeployment.Application.Configuration.MuleAgentLoggingService.ScopeLoggingConfigurations = nil
+
+		_, changes := appconf.PrepareDeploymentAndListChanges(desiredDeployment, currentDeployment)
+		Ω(changes).Should(BeEmpty())
+	})
+
+	It("should report each changed property", func() {
+		responsetext, err := testresources.ReadFile("resources/simple-app-current.json")
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+
+		var currentDeployment anypointclient.CloudhubDeploymentResp
+		err = json.NewDecoder(strings.NewReader(string(responsetext))).Decode(&currentDeployment)
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+
+		requesttext, err := testresources.ReadFile("resources/simple-app-v4.json")
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+
+		var desiredDeployment anypointclient.CloudhubDeploymentReq
+		err = json.NewDecoder(strings.NewReader(string(requesttext))).Decode(&desiredDeployment)
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+
+		_, changes := appconf.PrepareDeploymentAndListChanges(desiredDeployment, currentDeployment)
+		Ω(changes).ShouldNot(BeEmpty())
+		for _, change := range changes {
+			Ω(change.Field).Should(HavePrefix("application.configuration.properties."))
+		}
+	})
+
+	It("should restore ref, properties and runtime of the previous deployment on rollback", func() {
+		var previous anypointclient.CloudhubDeploymentResp
+		previous.Application.Ref.GroupID = "com.example"
+		previous.Application.Ref.ArtifactID = "simple-app"
+		previous.Application.Ref.Version = "1.0.0"
+		previous.Application.Ref.Packaging = "jar"
+		previous.Application.Configuration.MuleAgentApplicationPropertiesService.Properties = map[string]string{
+			"http.port":   "8081",
+			"db.password": "****",
+		}
+		previous.Target.DeploymentSettings.Runtime.Version = "4.6.0:20e-java17"
+
+		var failed anypointclient.CloudhubDeploymentReq
+		failed.Name = "simple-app"
+		failed.Target.Replicas = 2
+		failed.Application.Ref.GroupID = "com.example"
+		failed.Application.Ref.ArtifactID = "simple-app"
+		failed.Application.Ref.Version = "1.1.0"
+		failed.Application.Ref.Packaging = "jar"
+		failed.Application.Configuration.MuleAgentApplicationPropertiesService.Properties = map[string]string{"http.port": "8082"}
+		failed.Application.Configuration.MuleAgentApplicationPropertiesService.SecureProperties = map[string]string{"db.password": "secret"}
+		failed.Target.DeploymentSettings.Runtime.Version = "4.7.0"
+
+		rollback := appconf.RollbackDeployment(failed, previous)
+		Ω(rollback.Name).Should(Equal("simple-app"))
+		Ω(rollback.Target.Replicas).Should(Equal(2))
+		Ω(rollback.Application.Ref.Version).Should(Equal("1.0.0"))
+		Ω(rollback.Target.DeploymentSettings.Runtime.Version).Should(Equal("4.6.0:20e-java17"))
+		Ω(rollback.Application.Configuration.MuleAgentApplicationPropertiesService.Properties).Should(Equal(map[string]string{"http.port": "8081"}))
+		Ω(rollback.Application.Configuration.MuleAgentApplicationPropertiesService.SecureProperties).Should(HaveKeyWithValue("db.password", "secret"))
+		Ω(failed.Application.Ref.Version).Should(Equal("1.1.0"), "failed deployment is not modified")
+	})
+
+	It("should detect changed secure properties through the hash label", func() {
+		responsetext, err := testresources.ReadFile("resources/simple-app-current.json")
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+
+		var currentDeployment anypointclient.CloudhubDeploymentResp
+		err = json.NewDecoder(strings.NewReader(string(responsetext))).Decode(&currentDeployment)
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+
+		requesttext, err := testresources.ReadFile("resources/simple-app-v1.json")
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+
+		var desiredDeployment anypointclient.CloudhubDeploymentReq
+		err = json.NewDecoder(strings.NewReader(string(requesttext))).Decode(&desiredDeployment)
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+
+		salt, err := appconf.SecurePropertiesSalt(nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		currentLabel := appconf.SecurePropertiesLabel(map[string]string{"db.password": "old"}, salt, nil)
+		currentDeployment.Labels = appconf.WithSecurePropertiesLabel(currentDeployment.Labels, currentLabel)
+		Ω(appconf.SecurePropertiesSalt(currentDeployment.Labels)).Should(Equal(salt))
+
+		desiredDeployment.Labels = appconf.WithSecurePropertiesLabel(desiredDeployment.Labels,
+			appconf.SecurePropertiesLabel(map[string]string{"db.password": "old"}, salt, nil))
+		_, changes := appconf.PrepareDeploymentAndListChanges(desiredDeployment, currentDeployment)
+		for _, change := range changes {
+			Ω(change.Field).ShouldNot(Equal("application.configuration.secureProperties"))
+			Ω(change.Field).ShouldNot(Equal("labels"))
+		}
+
+		desiredDeployment.Labels = appconf.WithSecurePropertiesLabel(desiredDeployment.Labels,
+			appconf.SecurePropertiesLabel(map[string]string{"db.password": "rotated"}, salt, nil))
+		_, changes = appconf.PrepareDeploymentAndListChanges(desiredDeployment, currentDeployment)
+		var fields []string
+		for _, change := range changes {
+			fields = append(fields, change.Field)
+		}
+		Ω(fields).Should(ContainElement("application.configuration.secureProperties"))
+		Ω(fields).ShouldNot(ContainElement("labels"))
+	})
+
+	It("should key the secure properties hash with the HMAC key", func() {
+		properties := map[string]string{"db.password": "secret"}
+		unkeyed := appconf.SecurePropertiesLabel(properties, "0a1b2c3d", nil)
+		keyed := appconf.SecurePropertiesLabel(properties, "0a1b2c3d", []byte("key"))
+		Ω(keyed).Should(HavePrefix("secure-properties-0a1b2c3d-"))
+		Ω(keyed).ShouldNot(Equal(unkeyed))
+		Ω(keyed).Should(Equal(appconf.SecurePropertiesLabel(properties, "0a1b2c3d", []byte("key"))))
+		Ω(keyed).ShouldNot(Equal(appconf.SecurePropertiesLabel(properties, "0a1b2c3d", []byte("other key"))))
+	})
+
+	It("should report added and removed secure property keys without values", func() {
+		responsetext, err := testresources.ReadFile("resources/simple-app-current.json")
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+
+		var currentDeployment anypointclient.CloudhubDeploymentResp
+		err = json.NewDecoder(strings.NewReader(string(responsetext))).Decode(&currentDeployment)
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+		currentDeployment.Application.Configuration.MuleAgentApplicationPropertiesService.SecureProperties = map[string]string{
+			"db.password": "****",
+			"api.key":     "****",
+		}
+
+		requesttext, err := testresources.ReadFile("resources/simple-app-v1.json")
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+
+		var desiredDeployment anypointclient.CloudhubDeploymentReq
+		err = json.NewDecoder(strings.NewReader(string(requesttext))).Decode(&desiredDeployment)
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+		desiredDeployment.Application.Configuration.MuleAgentApplicationPropertiesService.SecureProperties = map[string]string{
+			"db.password":   "secret",
+			"client.secret": "secret",
+		}
+
+		_, changes := appconf.PrepareDeploymentAndListChanges(desiredDeployment, currentDeployment)
+		secureChanges := map[string]appconf.FieldChange{}
+		for _, change := range changes {
+			if strings.HasPrefix(change.Field, "application.configuration.secureProperties.") {
+				secureChanges[change.Field] = change
+			}
+		}
+		Ω(secureChanges).Should(HaveLen(2))
+		Ω(secureChanges["application.configuration.secureProperties.client.secret"].Current).Should(BeNil())
+		Ω(secureChanges["application.configuration.secureProperties.client.secret"].Desired).Should(Equal("****"))
+		Ω(secureChanges["application.configuration.secureProperties.api.key"].Current).Should(Equal("****"))
+		Ω(secureChanges["application.configuration.secureProperties.api.key"].Desired).Should(BeNil())
+	})
+
+	It("should list keys set in both properties and secureProperties", func() {
+		var deployment anypointclient.CloudhubDeploymentReq
+		deployment.Application.Configuration.MuleAgentApplicationPropertiesService.Properties = map[string]string{
+			"db.password": "plain",
+			"http.port":   "8081",
+		}
+		deployment.Application.Configuration.MuleAgentApplicationPropertiesService.SecureProperties = map[string]string{
+			"db.password": "secret",
+		}
+
+		Ω(appconf.DuplicatePropertyKeys(deployment)).Should(Equal([]string{"db.password"}))
 	})
 })
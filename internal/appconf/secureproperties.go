@@ -0,0 +1,142 @@
+package appconf
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient"
+)
+
+// securePropertiesLabelPrefix marks the deployment label recording the salted hash of the secure properties.
+// Anypoint Platform masks secure property values, so the hash is the only way to tell that a secret has changed.
+const securePropertiesLabelPrefix = "secure-properties-"
+
+// SecurePropertiesSalt returns the salt of the secure properties label, or a new random salt if there is none.
+// Reusing the salt of the running deployment makes the hashes comparable.
+func SecurePropertiesSalt(labels []string) (string, error) {
+	label, _ := splitSecurePropertiesLabel(labels)
+	if salt, _, found := strings.Cut(strings.TrimPrefix(label, securePropertiesLabelPrefix), "-"); found {
+		return salt, nil
+	}
+	random := make([]byte, 4)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to create a secure properties salt: %w", err)
+	}
+	return hex.EncodeToString(random), nil
+}
+
+// SecurePropertiesLabel returns the label recording the salted hash of every secure property, or an empty string
+// if there are no secure properties. The salt is part of the label, so a low-entropy secret can be guessed offline
+// from a plain hash. Given a key, the hash is an HMAC keyed with it, which can not be checked without the key.
+func SecurePropertiesLabel(secureProperties map[string]string, salt string, hmacKey []byte) string {
+	if len(secureProperties) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(secureProperties))
+	for key := range secureProperties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var digest hash.Hash
+	if len(hmacKey) > 0 {
+		digest = hmac.New(sha256.New, hmacKey)
+	} else {
+		digest = sha256.New()
+	}
+	digest.Write([]byte(salt))
+	for _, key := range keys {
+		digest.Write([]byte(key))
+		digest.Write([]byte{0})
+		digest.Write([]byte(secureProperties[key]))
+		digest.Write([]byte{0})
+	}
+	return securePropertiesLabelPrefix + salt + "-" + hex.EncodeToString(digest.Sum(nil))[:24]
+}
+
+// WithSecurePropertiesLabel returns the labels with the secure properties label replaced by the given one
+func WithSecurePropertiesLabel(labels []string, label string) []string {
+	_, others := splitSecurePropertiesLabel(labels)
+	if label == "" {
+		return others
+	}
+	return append(others, label)
+}
+
+// splitSecurePropertiesLabel separates the secure properties label from the other labels
+func splitSecurePropertiesLabel(labels []string) (string, []string) {
+	var label string
+	others := []string{}
+	for _, l := range labels {
+		if strings.HasPrefix(l, securePropertiesLabelPrefix) {
+			label = l
+			continue
+		}
+		others = append(others, l)
+	}
+	return label, others
+}
+
+// securePropertiesHash returns the hash part of a secure properties label, nil if there is no label
+func securePropertiesHash(label string) any {
+	if label == "" {
+		return nil
+	}
+	_, hash, _ := strings.Cut(strings.TrimPrefix(label, securePropertiesLabelPrefix), "-")
+	return hash
+}
+
+// maskedValue is shown instead of the value of a secure property
+const maskedValue = "****"
+
+// MaskSecureProperties returns a copy of the secure properties with every value masked, so that the keys can be
+// recorded without the secrets
+func MaskSecureProperties(secureProperties map[string]string) map[string]string {
+	if secureProperties == nil {
+		return nil
+	}
+	masked := make(map[string]string, len(secureProperties))
+	for key := range secureProperties {
+		masked[key] = maskedValue
+	}
+	return masked
+}
+
+// securePropertiesChanges returns a change for every secure property that was added or removed.
+// Values are masked by Anypoint Platform and never compared or reported, see SecurePropertiesLabel.
+func securePropertiesChanges(currentProperties, desiredProperties map[string]string) []FieldChange {
+	var changes []FieldChange
+	for property := range desiredProperties {
+		if _, found := currentProperties[property]; !found {
+			changes = append(changes, FieldChange{Field: "application.configuration.secureProperties." + property, Current: nil, Desired: maskedValue})
+		}
+	}
+	for property := range currentProperties {
+		if _, found := desiredProperties[property]; !found {
+			changes = append(changes, FieldChange{Field: "application.configuration.secureProperties." + property, Current: maskedValue, Desired: nil})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
+
+// DuplicatePropertyKeys returns the keys that are set in both properties and secureProperties of a deployment
+func DuplicatePropertyKeys(deployment anypointclient.CloudhubDeploymentReq) []string {
+	propertiesService := deployment.Application.Configuration.MuleAgentApplicationPropertiesService
+	var duplicates []string
+	for property := range propertiesService.SecureProperties {
+		if _, found := propertiesService.Properties[property]; found {
+			duplicates = append(duplicates, property)
+		}
+	}
+	sort.Strings(duplicates)
+	return duplicates
+}
@@ -0,0 +1,187 @@
+package export
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/appconf"
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/resources"
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/templating"
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient"
+)
+
+var nonAlphanumeric = regexp.MustCompile("[^A-Za-z0-9]+")
+
+// SecurePlaceholder returns the placeholder used instead of the value of a secure property.
+// The placeholder is an environment variable reference, e.g. ${DB_PASSWORD} for db.password,
+// so that the value can be provided when the exported descriptor is deployed.
+func SecurePlaceholder(property string) string {
+	name := strings.Trim(nonAlphanumeric.ReplaceAllString(property, "_"), "_")
+	return "${" + strings.ToUpper(name) + "}"
+}
+
+// Application converts a running deployment into an Application descriptor.
+// Fields only set by the server, such as ids, status and generated CloudHub URLs, are left out
+// and masked secure properties are replaced with placeholders.
+func Application(deployment anypointclient.CloudhubDeploymentResp) resources.ApplicationV1 {
+	var app resources.ApplicationV1
+	app.Kind = "Application"
+	app.Version = "v1"
+
+	spec := &app.Spec
+	spec.Name = deployment.Name
+	// The secure properties label is recorded when the descriptor is deployed
+	spec.Labels = appconf.WithSecurePropertiesLabel(deployment.Labels, "")
+	spec.Target.Provider = deployment.Target.Provider
+	spec.Target.TargetID = deployment.Target.TargetID
+	spec.Target.Replicas = deployment.Target.Replicas
+
+	current := deployment.Target.DeploymentSettings
+	settings := &spec.Target.DeploymentSettings
+	settings.Clustered = current.Clustered
+	settings.EnforceDeployingReplicasAcrossNodes = current.EnforceDeployingReplicasAcrossNodes
+	settings.HTTP = exportIngress(current.HTTP)
+	// The build and channel parts of the version are chosen by the server
+	settings.Runtime.Version = strings.SplitN(current.Runtime.Version, ":", 2)[0]
+	settings.Runtime.ReleaseChannel = current.Runtime.ReleaseChannel
+	settings.Runtime.Java = current.Runtime.Java
+	settings.UpdateStrategy = current.UpdateStrategy
+	settings.DisableAmLogForwarding = current.DisableAmLogForwarding
+	settings.PersistentObjectStore = current.PersistentObjectStore
+	settings.GenerateDefaultPublicURL = current.GenerateDefaultPublicURL
+
+	spec.Application.Ref.GroupID = deployment.Application.Ref.GroupID
+	spec.Application.Ref.ArtifactID = deployment.Application.Ref.ArtifactID
+	spec.Application.Ref.Version = deployment.Application.Ref.Version
+	spec.Application.Ref.Packaging = deployment.Application.Ref.Packaging
+	spec.Application.Assets = []any{}
+	spec.Application.DesiredState = deployment.Application.DesiredState
+	spec.Application.VCores = deployment.Application.VCores
+	spec.Application.Integrations.Services.ObjectStoreV2.Enabled = deployment.Application.Integrations.Services.ObjectStoreV2.Enabled
+
+	configuration := deployment.Application.Configuration
+	propertiesService := &spec.Application.Configuration.MuleAgentApplicationPropertiesService
+	propertiesService.ApplicationName = configuration.MuleAgentApplicationPropertiesService.ApplicationName
+	propertiesService.Properties = map[string]string{}
+	propertiesService.SecureProperties = map[string]string{}
+	for property, value := range configuration.MuleAgentApplicationPropertiesService.Properties {
+		if appconf.IsSecret(value) {
+			propertiesService.SecureProperties[property] = SecurePlaceholder(property)
+			continue
+		}
+		// Mule property placeholders such as ${http.port} must not be expanded when the descriptor is deployed
+		propertiesService.Properties[property] = templating.Escape(value)
+	}
+	for property := range configuration.MuleAgentApplicationPropertiesService.SecureProperties {
+		propertiesService.SecureProperties[property] = SecurePlaceholder(property)
+	}
+
+	spec.Application.Configuration.MuleAgentLoggingService.ScopeLoggingConfigurations = configuration.MuleAgentLoggingService.ScopeLoggingConfigurations
+	if spec.Application.Configuration.MuleAgentLoggingService.ScopeLoggingConfigurations == nil {
+		spec.Application.Configuration.MuleAgentLoggingService.ScopeLoggingConfigurations = []any{}
+	}
+	spec.Application.Configuration.MuleAgentScheduleService.Schedulers = configuration.MuleAgentScheduleService.Schedulers
+	if spec.Application.Configuration.MuleAgentScheduleService.Schedulers == nil {
+		spec.Application.Configuration.MuleAgentScheduleService.Schedulers = []anypointclient.Schedule{}
+	}
+	// Scheduler names are generated from the flow name when deploying
+	for i := range spec.Application.Configuration.MuleAgentScheduleService.Schedulers {
+		spec.Application.Configuration.MuleAgentScheduleService.Schedulers[i].Name = ""
+	}
+
+	return app
+}
+
+// exportIngress removes the URLs and endpoints generated by CloudHub from the ingress settings
+func exportIngress(current anypointclient.DeploymentHttpIngress) anypointclient.DeploymentHttpIngress {
+	var ingress anypointclient.DeploymentHttpIngress
+	ingress.Inbound.PathRewrite = current.Inbound.PathRewrite
+	ingress.Inbound.LastMileSecurity = current.Inbound.LastMileSecurity
+	ingress.Inbound.ForwardSslSession = current.Inbound.ForwardSslSession
+
+	var publicURLs []string
+	for _, url := range strings.Split(current.Inbound.PublicURL, ",") {
+		if url != "" && !appconf.IsCloudHubURL(url) {
+			publicURLs = append(publicURLs, url)
+		}
+	}
+	ingress.Inbound.PublicURL = strings.Join(publicURLs, ",")
+
+	for _, endpoint := range current.Inbound.Endpoints {
+		if !appconf.IsCloudHubURL(endpoint.URL) {
+			ingress.Inbound.Endpoints = append(ingress.Inbound.Endpoints, endpoint)
+		}
+	}
+	return ingress
+}
+
+// ApiPolicies converts the policies applied to an API instance into an ApiPolicies descriptor, in policy order
+func ApiPolicies(apiInstanceID int, policies []anypointclient.ApiPolicyResponse) resources.ApiPoliciesV1 {
+	var apiPolicies resources.ApiPoliciesV1
+	apiPolicies.Kind = "ApiPolicies"
+	apiPolicies.Version = "v1"
+	apiPolicies.Spec.ApiInstanceID = strconv.Itoa(apiInstanceID)
+
+	sorted := make([]anypointclient.ApiPolicyResponse, len(policies))
+	copy(sorted, policies)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Order < sorted[j].Order
+	})
+
+	apiPolicies.Spec.Policies = []anypointclient.ApiPolicyRequest{}
+	for _, policy := range sorted {
+		apiPolicies.Spec.Policies = append(apiPolicies.Spec.Policies, anypointclient.ApiPolicyRequest{
+			ConfigurationData: policy.Configuration,
+			Disabled:          policy.Disabled,
+			PointcutData:      policy.PointcutData,
+			GroupID:           policy.Template.GroupID,
+			AssetID:           policy.Template.AssetID,
+			AssetVersion:      policy.Template.AssetVersion,
+		})
+	}
+	return apiPolicies
+}
+
+// MqDestinations converts the queues and exchanges of a region, and the bindings of each exchange keyed
+// by exchange id, into an MqDestinations descriptor
+func MqDestinations(destinations []anypointclient.MqDestination, bindings map[string][]anypointclient.MqBinding) resources.MqDestinationsV1 {
+	var mq resources.MqDestinationsV1
+	mq.Kind = "MqDestinations"
+	mq.Version = "v1"
+
+	for _, destination := range destinations {
+		encrypted := destination.Encrypted
+		switch destination.Type {
+		case "queue":
+			mq.Spec.Queues = append(mq.Spec.Queues, anypointclient.MqQueue{
+				QueueID:              destination.QueueID,
+				Type:                 "queue",
+				Fifo:                 destination.Fifo,
+				Encrypted:            &encrypted,
+				MaxDeliveries:        destination.MaxDeliveries,
+				DeadLetterQueueID:    destination.DeadLetterQueueID,
+				IsFallback:           destination.IsFallback,
+				DefaultTtl:           destination.DefaultTtl,
+				DefaultLockTtl:       destination.DefaultLockTtl,
+				DefaultDeliveryDelay: destination.DefaultDeliveryDelay,
+			})
+		case "exchange":
+			exchange := resources.MqExchangeWithBindings{
+				MqExchange: anypointclient.MqExchange{
+					ExchangeID: destination.ExchangeID,
+					Fifo:       destination.Fifo,
+					Encrypted:  &encrypted,
+				},
+			}
+			for _, binding := range bindings[destination.ExchangeID] {
+				// The exchange is implied by the enclosing exchange
+				binding.ExchangeID = ""
+				exchange.Bindings = append(exchange.Bindings, binding)
+			}
+			mq.Spec.Exchanges = append(mq.Spec.Exchanges, exchange)
+		}
+	}
+	return mq
+}
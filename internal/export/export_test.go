@@ -0,0 +1,134 @@
+package export
+
+import (
+	"testing"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient"
+)
+
+func TestSecurePlaceholder(t *testing.T) {
+	for property, expected := range map[string]string{
+		"db.password":      "${DB_PASSWORD}",
+		"api-key":          "${API_KEY}",
+		"secure::client.s": "${SECURE_CLIENT_S}",
+	} {
+		if placeholder := SecurePlaceholder(property); placeholder != expected {
+			t.Errorf("expected %s for %s, got %s", expected, property, placeholder)
+		}
+	}
+}
+
+func TestApplicationStripsServerFields(t *testing.T) {
+	var deployment anypointclient.CloudhubDeploymentResp
+	deployment.ID = "deployment-id"
+	deployment.Name = "my-app"
+	deployment.Status = "APPLIED"
+	deployment.Target.Replicas = 2
+	deployment.Target.DeploymentSettings.Runtime.Version = "4.6.0:20e-java17"
+	inbound := &deployment.Target.DeploymentSettings.HTTP.Inbound
+	inbound.PublicURL = "my-app-abc123.x1y2z3.deu-c1.cloudhub.io,api.example.com"
+	inbound.InternalURL = "http://my-app.app:8081"
+	inbound.Endpoints = []anypointclient.IngressEndpoint{
+		{URL: "my-app-abc123.x1y2z3.deu-c1.cloudhub.io"},
+		{URL: "api.example.com", Access: "public"},
+	}
+	deployment.Application.Configuration.MuleAgentApplicationPropertiesService.Properties = map[string]string{
+		"http.port":   "8081",
+		"db.password": "****",
+		"api.url":     "http://localhost:${http.port}",
+	}
+	deployment.Application.Configuration.MuleAgentApplicationPropertiesService.SecureProperties = map[string]string{
+		"api.key": "****",
+	}
+	deployment.Application.Configuration.MuleAgentScheduleService.Schedulers = []anypointclient.Schedule{
+		{Name: "generated-name", FlowName: "poll"},
+	}
+
+	app := Application(deployment)
+
+	if app.Kind != "Application" || app.Version != "v1" {
+		t.Errorf("unexpected resource %s/%s", app.Kind, app.Version)
+	}
+	settings := app.Spec.Target.DeploymentSettings
+	if settings.Runtime.Version != "4.6.0" {
+		t.Errorf("expected runtime version without build suffix, got %s", settings.Runtime.Version)
+	}
+	if settings.HTTP.Inbound.PublicURL != "api.example.com" {
+		t.Errorf("expected CloudHub URL to be removed, got %s", settings.HTTP.Inbound.PublicURL)
+	}
+	if len(settings.HTTP.Inbound.Endpoints) != 1 || settings.HTTP.Inbound.Endpoints[0].URL != "api.example.com" {
+		t.Errorf("expected only custom endpoints, got %+v", settings.HTTP.Inbound.Endpoints)
+	}
+	if settings.HTTP.Inbound.InternalURL != "" {
+		t.Errorf("expected internal URL to be removed, got %s", settings.HTTP.Inbound.InternalURL)
+	}
+
+	properties := app.Spec.Application.Configuration.MuleAgentApplicationPropertiesService
+	if properties.Properties["http.port"] != "8081" {
+		t.Errorf("expected plain property to be kept, got %+v", properties.Properties)
+	}
+	if properties.Properties["api.url"] != "http://localhost:$${http.port}" {
+		t.Errorf("expected Mule placeholder to be escaped, got %s", properties.Properties["api.url"])
+	}
+	if _, found := properties.Properties["db.password"]; found {
+		t.Errorf("expected masked property to be removed from properties")
+	}
+	if properties.SecureProperties["db.password"] != "${DB_PASSWORD}" {
+		t.Errorf("expected placeholder for secure property, got %+v", properties.SecureProperties)
+	}
+	if properties.SecureProperties["api.key"] != "${API_KEY}" {
+		t.Errorf("expected placeholder for masked secure property, got %+v", properties.SecureProperties)
+	}
+
+	schedulers := app.Spec.Application.Configuration.MuleAgentScheduleService.Schedulers
+	if len(schedulers) != 1 || schedulers[0].Name != "" || schedulers[0].FlowName != "poll" {
+		t.Errorf("expected scheduler without generated name, got %+v", schedulers)
+	}
+}
+
+func TestApiPoliciesInPolicyOrder(t *testing.T) {
+	var second, first anypointclient.ApiPolicyResponse
+	second.Order = 2
+	second.Template.AssetID = "rate-limiting"
+	second.Disabled = true
+	first.Order = 1
+	first.Template.AssetID = "client-id-enforcement"
+	first.Configuration = map[string]any{"credentialsOriginHasHttpBasicAuthenticationHeader": "customExpression"}
+
+	policies := ApiPolicies(42, []anypointclient.ApiPolicyResponse{second, first})
+
+	if policies.Spec.ApiInstanceID != "42" {
+		t.Errorf("expected api instance id 42, got %s", policies.Spec.ApiInstanceID)
+	}
+	if len(policies.Spec.Policies) != 2 {
+		t.Fatalf("expected 2 policies, got %d", len(policies.Spec.Policies))
+	}
+	if policies.Spec.Policies[0].AssetID != "client-id-enforcement" || policies.Spec.Policies[0].ConfigurationData == nil {
+		t.Errorf("expected client-id-enforcement with configuration first, got %+v", policies.Spec.Policies[0])
+	}
+	if !policies.Spec.Policies[1].Disabled {
+		t.Errorf("expected disabled flag to be exported")
+	}
+}
+
+func TestMqDestinations(t *testing.T) {
+	destinations := []anypointclient.MqDestination{
+		{Type: "queue", QueueID: "orders", MaxDeliveries: 5, Encrypted: true},
+		{Type: "exchange", ExchangeID: "events"},
+	}
+	bindings := map[string][]anypointclient.MqBinding{
+		"events": {{QueueID: "orders", ExchangeID: "events"}},
+	}
+
+	mq := MqDestinations(destinations, bindings)
+
+	if len(mq.Spec.Queues) != 1 || mq.Spec.Queues[0].QueueID != "orders" || !mq.Spec.Queues[0].IsEncrypted() {
+		t.Errorf("unexpected queues %+v", mq.Spec.Queues)
+	}
+	if len(mq.Spec.Exchanges) != 1 || len(mq.Spec.Exchanges[0].Bindings) != 1 {
+		t.Fatalf("unexpected exchanges %+v", mq.Spec.Exchanges)
+	}
+	if binding := mq.Spec.Exchanges[0].Bindings[0]; binding.QueueID != "orders" || binding.ExchangeID != "" {
+		t.Errorf("unexpected binding %+v", binding)
+	}
+}
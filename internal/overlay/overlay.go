@@ -0,0 +1,28 @@
+package overlay
+
+// MergePatch applies a JSON merge patch (RFC 7386) to a document decoded into generic values, i.e. maps,
+// slices and scalars as produced by encoding/json. Objects are merged recursively, a null value removes the
+// field and every other value, including arrays, replaces the value in the document.
+// The document is not modified, a new value is returned.
+func MergePatch(document any, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	documentObject, ok := document.(map[string]any)
+	merged := make(map[string]any, len(documentObject)+len(patchObject))
+	if ok {
+		for key, value := range documentObject {
+			merged[key] = value
+		}
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(merged, key)
+			continue
+		}
+		merged[key] = MergePatch(merged[key], value)
+	}
+	return merged
+}
@@ -0,0 +1,66 @@
+package overlay
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func decode(t *testing.T, document string) any {
+	t.Helper()
+	var value any
+	if err := json.Unmarshal([]byte(document), &value); err != nil {
+		t.Fatalf("failed to decode %s: %v", document, err)
+	}
+	return value
+}
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		patch    string
+		expected string
+	}{
+		{
+			name:     "replace nested scalar",
+			document: `{"spec": {"name": "app", "target": {"replicas": 1}}}`,
+			patch:    `{"spec": {"target": {"replicas": 3}}}`,
+			expected: `{"spec": {"name": "app", "target": {"replicas": 3}}}`,
+		},
+		{
+			name:     "add and remove properties",
+			document: `{"properties": {"a": "1", "b": "2"}}`,
+			patch:    `{"properties": {"b": null, "c": "3"}}`,
+			expected: `{"properties": {"a": "1", "c": "3"}}`,
+		},
+		{
+			name:     "replace array",
+			document: `{"labels": ["a", "b"]}`,
+			patch:    `{"labels": ["c"]}`,
+			expected: `{"labels": ["c"]}`,
+		},
+		{
+			name:     "object replaces scalar",
+			document: `{"value": "x"}`,
+			patch:    `{"value": {"nested": true}}`,
+			expected: `{"value": {"nested": true}}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged := MergePatch(decode(t, test.document), decode(t, test.patch))
+			if expected := decode(t, test.expected); !reflect.DeepEqual(merged, expected) {
+				t.Errorf("expected %v, got %v", expected, merged)
+			}
+		})
+	}
+}
+
+func TestMergePatchDoesNotModifyDocument(t *testing.T) {
+	document := decode(t, `{"spec": {"replicas": 1}}`)
+	MergePatch(document, decode(t, `{"spec": {"replicas": 2}}`))
+	if !reflect.DeepEqual(document, decode(t, `{"spec": {"replicas": 1}}`)) {
+		t.Errorf("document was modified: %v", document)
+	}
+}
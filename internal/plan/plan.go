@@ -0,0 +1,222 @@
+package plan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/appconf"
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient"
+	"github.com/TwiN/go-color"
+)
+
+// Action is the operation a plan will perform on a resource
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+	ActionNone   Action = "none"
+)
+
+// Change describes the operation planned for a single resource and the fields that triggered it.
+//
+// Fingerprint identifies the live state of the resource when the plan was made and Payload holds
+// everything needed to execute the change, so that a saved plan can be applied exactly as reviewed.
+type Change struct {
+	Kind        string                `json:"kind"`
+	Name        string                `json:"name"`
+	Source      string                `json:"source,omitempty"`
+	Action      Action                `json:"action"`
+	Forced      bool                  `json:"forced,omitempty"`
+	Fields      []appconf.FieldChange `json:"fields,omitempty"`
+	Fingerprint string                `json:"fingerprint,omitempty"`
+	Payload     json.RawMessage       `json:"payload,omitempty"`
+}
+
+// Plan collects the changes needed to bring an environment in line with a set of descriptors.
+// It is safe for concurrent use.
+type Plan struct {
+	Organization   string   `json:"organization"`
+	OrganizationID string   `json:"organizationId"`
+	Environment    string   `json:"environment"`
+	EnvironmentID  string   `json:"environmentId"`
+	PrivateSpaceID string   `json:"privateSpaceId,omitempty"`
+	MqRegion       string   `json:"mqRegion,omitempty"`
+	Changes        []Change `json:"changes"`
+
+	mu sync.Mutex
+}
+
+// Recorder adds changes found in a single descriptor file to a plan
+type Recorder struct {
+	plan   *Plan
+	source string
+}
+
+// New creates an empty plan for the given organization and environment
+func New(organization anypointclient.Organization, environment anypointclient.Environment) *Plan {
+	return &Plan{
+		Organization:   organization.Name,
+		OrganizationID: organization.ID,
+		Environment:    environment.Name,
+		EnvironmentID:  environment.ID,
+		Changes:        []Change{},
+	}
+}
+
+// Load reads a plan previously written with Save
+func Load(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan file %s: %w", path, err)
+	}
+	var p Plan
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to decode plan file %s: %w", path, err)
+	}
+	return &p, nil
+}
+
+// Save writes the plan as JSON to the given file, readable only by the owner
+func (p *Plan) Save(path string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create plan file %s: %w", path, err)
+	}
+	defer file.Close()
+	return p.WriteJSON(file)
+}
+
+// Fingerprint returns a stable hash of the given live state. A nil state, i.e. a resource
+// that does not exist, has a fingerprint of its own.
+func Fingerprint(state any) string {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// NewPayload encodes the request needed to execute a change
+func NewPayload(request any) json.RawMessage {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// Source returns a Recorder that stamps every change with the given source file
+func (p *Plan) Source(source string) *Recorder {
+	return &Recorder{plan: p, source: source}
+}
+
+// Add appends a change to the plan
+func (r *Recorder) Add(change Change) {
+	if r == nil || r.plan == nil {
+		return
+	}
+	change.Source = r.source
+	r.plan.mu.Lock()
+	defer r.plan.mu.Unlock()
+	r.plan.Changes = append(r.plan.Changes, change)
+}
+
+// HasChanges returns true if any change in the plan would modify Anypoint Platform
+func (p *Plan) HasChanges() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, change := range p.Changes {
+		if change.Action != ActionNone {
+			return true
+		}
+	}
+	return false
+}
+
+// sort orders changes by source file and the index of the resource in it, e.g. file[2] before file[10],
+// while keeping the order within each resource
+func (p *Plan) sort() {
+	sort.SliceStable(p.Changes, func(i, j int) bool {
+		fileI, indexI := SplitSource(p.Changes[i].Source)
+		fileJ, indexJ := SplitSource(p.Changes[j].Source)
+		if fileI != fileJ {
+			return fileI < fileJ
+		}
+		return indexI < indexJ
+	})
+}
+
+// SplitSource returns the file of a source and the index of the resource in it, 0 if the source has no index
+func SplitSource(source string) (string, int) {
+	if open := strings.LastIndex(source, "["); open >= 0 && strings.HasSuffix(source, "]") {
+		if index, err := strconv.Atoi(source[open+1 : len(source)-1]); err == nil {
+			return source[:open], index
+		}
+	}
+	return source, 0
+}
+
+// WriteJSON writes the plan as indented JSON
+func (p *Plan) WriteJSON(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sort()
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(p)
+}
+
+// WriteText writes the plan as a colorized, per-field diff
+func (p *Plan) WriteText(w io.Writer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sort()
+
+	fmt.Fprintf(w, "Plan for environment %s in organization %s\n\n", p.Environment, p.Organization)
+
+	counts := map[Action]int{}
+	for _, change := range p.Changes {
+		counts[change.Action]++
+		switch change.Action {
+		case ActionCreate:
+			fmt.Fprintln(w, color.Colorize(color.Green, fmt.Sprintf("+ %s [%s] (%s)", change.Kind, change.Name, change.Source)))
+		case ActionUpdate:
+			header := fmt.Sprintf("~ %s [%s] (%s)", change.Kind, change.Name, change.Source)
+			if change.Forced && len(change.Fields) == 0 {
+				header += " forced update"
+			}
+			fmt.Fprintln(w, color.Colorize(color.Yellow, header))
+			for _, field := range change.Fields {
+				fmt.Fprintln(w, color.Colorize(color.Red, fmt.Sprintf("    - %s: %s", field.Field, formatValue(field.Current))))
+				fmt.Fprintln(w, color.Colorize(color.Green, fmt.Sprintf("    + %s: %s", field.Field, formatValue(field.Desired))))
+			}
+		case ActionDelete:
+			fmt.Fprintln(w, color.Colorize(color.Red, fmt.Sprintf("- %s [%s] (%s)", change.Kind, change.Name, change.Source)))
+		default:
+			fmt.Fprintln(w, color.Colorize(color.Blue, fmt.Sprintf("= %s [%s] (%s)", change.Kind, change.Name, change.Source)))
+		}
+	}
+
+	fmt.Fprintf(w, "\nPlan: %d to create, %d to update, %d to delete, %d unchanged.\n",
+		counts[ActionCreate], counts[ActionUpdate], counts[ActionDelete], counts[ActionNone])
+}
+
+// formatValue renders a field value as compact JSON so that strings, numbers and lists are easy to tell apart
+func formatValue(value any) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
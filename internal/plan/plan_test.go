@@ -0,0 +1,153 @@
+package plan
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/internal/appconf"
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient"
+)
+
+var (
+	testOrganization = anypointclient.Organization{ID: "org-id", Name: "Example Inc"}
+	testEnvironment  = anypointclient.Environment{ID: "env-id", Name: "Sandbox", OrganizationID: "org-id"}
+)
+
+func TestPlanRecordsChangesPerSource(t *testing.T) {
+	p := New(testOrganization, testEnvironment)
+	p.Source("b.json").Add(Change{Kind: "MqQueue", Name: "queue", Action: ActionNone})
+	p.Source("a.json").Add(Change{Kind: "Application", Name: "app", Action: ActionUpdate, Fields: []appconf.FieldChange{
+		{Field: "target.replicas", Current: 1, Desired: 2},
+	}})
+
+	if !p.HasChanges() {
+		t.Errorf("expected plan to have changes")
+	}
+
+	var buffer bytes.Buffer
+	if err := p.WriteJSON(&buffer); err != nil {
+		t.Fatalf("failed to write plan: %v", err)
+	}
+
+	var decoded struct {
+		Environment string   `json:"environment"`
+		Changes     []Change `json:"changes"`
+	}
+	if err := json.Unmarshal(buffer.Bytes(), &decoded); err != nil {
+		t.Fatalf("failed to decode plan: %v", err)
+	}
+	if decoded.Environment != "Sandbox" {
+		t.Errorf("expected environment Sandbox, got %s", decoded.Environment)
+	}
+	if len(decoded.Changes) != 2 || decoded.Changes[0].Source != "a.json" {
+		t.Fatalf("expected changes sorted by source, got %+v", decoded.Changes)
+	}
+	if decoded.Changes[0].Fields[0].Field != "target.replicas" {
+		t.Errorf("expected field target.replicas, got %s", decoded.Changes[0].Fields[0].Field)
+	}
+}
+
+func TestPlanWriteText(t *testing.T) {
+	p := New(testOrganization, testEnvironment)
+	p.Source("app.json").Add(Change{Kind: "Application", Name: "app", Action: ActionUpdate, Fields: []appconf.FieldChange{
+		{Field: "application.ref.version", Current: "1.0.0", Desired: "1.0.1"},
+	}})
+	p.Source("mq.json").Add(Change{Kind: "MqQueue", Name: "queue", Action: ActionCreate})
+	p.Source("mq.json").Add(Change{Kind: "MqExchange", Name: "exchange", Action: ActionDelete})
+
+	var buffer bytes.Buffer
+	p.WriteText(&buffer)
+	output := buffer.String()
+
+	for _, expected := range []string{
+		`- application.ref.version: "1.0.0"`,
+		`+ application.ref.version: "1.0.1"`,
+		"+ MqQueue [queue] (mq.json)",
+		"- MqExchange [exchange] (mq.json)",
+		"Plan: 1 to create, 1 to update, 1 to delete, 0 unchanged.",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected output to contain %q, got:\n%s", expected, output)
+		}
+	}
+}
+
+func TestPlanSortsResourcesByIndex(t *testing.T) {
+	p := New(testOrganization, testEnvironment)
+	for _, source := range []string{"b.yaml", "a.yaml[10]", "a.yaml[2]", "a.yaml[1]"} {
+		p.Source(source).Add(Change{Kind: "MqQueue", Name: source, Action: ActionCreate})
+	}
+	var buffer bytes.Buffer
+	if err := p.WriteJSON(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	var sources []string
+	for _, change := range p.Changes {
+		sources = append(sources, change.Source)
+	}
+	if expected := []string{"a.yaml[1]", "a.yaml[2]", "a.yaml[10]", "b.yaml"}; !slices.Equal(sources, expected) {
+		t.Errorf("expected changes in the order %v, got %v", expected, sources)
+	}
+}
+
+func TestPlanWithoutChanges(t *testing.T) {
+	p := New(testOrganization, testEnvironment)
+	p.Source("app.json").Add(Change{Kind: "Application", Name: "app", Action: ActionNone})
+	if p.HasChanges() {
+		t.Errorf("expected plan without changes")
+	}
+}
+
+func TestPlanSaveAndLoad(t *testing.T) {
+	p := New(testOrganization, testEnvironment)
+	p.MqRegion = "eu-central-1"
+	p.Source("mq.json").Add(Change{
+		Kind:        "MqQueue",
+		Name:        "queue",
+		Action:      ActionCreate,
+		Fingerprint: Fingerprint(nil),
+		Payload:     NewPayload(anypointclient.MqQueue{QueueID: "queue"}),
+	})
+
+	path := t.TempDir() + "/plan.json"
+	if err := p.Save(path); err != nil {
+		t.Fatalf("failed to save plan: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("expected the plan file to be readable only by the owner, got %v: %v", info.Mode(), err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("failed to load plan: %v", err)
+	}
+	if loaded.EnvironmentID != "env-id" || loaded.MqRegion != "eu-central-1" {
+		t.Errorf("unexpected plan header %+v", loaded)
+	}
+
+	var queue anypointclient.MqQueue
+	if err := json.Unmarshal(loaded.Changes[0].Payload, &queue); err != nil || queue.QueueID != "queue" {
+		t.Errorf("failed to decode payload %s: %v", loaded.Changes[0].Payload, err)
+	}
+	if loaded.Changes[0].Fingerprint != Fingerprint(nil) {
+		t.Errorf("fingerprint not preserved")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	var missing *anypointclient.MqDestination
+	if Fingerprint(missing) != Fingerprint(nil) {
+		t.Errorf("expected missing resource to have the same fingerprint as nil")
+	}
+	a := anypointclient.MqDestination{QueueID: "queue", MaxDeliveries: 1}
+	b := anypointclient.MqDestination{QueueID: "queue", MaxDeliveries: 2}
+	if Fingerprint(a) == Fingerprint(b) {
+		t.Errorf("expected different fingerprints for different states")
+	}
+	if Fingerprint(a) != Fingerprint(a) {
+		t.Errorf("expected stable fingerprint")
+	}
+}
@@ -15,8 +15,30 @@ type ApplicationV1 struct {
 type ApiPoliciesV1 struct {
 	BaseResource
 	Spec struct {
-		ApiInstanceID string                            `json:"apiInstanceId"`
-		Policies      []anypointclient.ApiPolicyRequest `json:"policy"`
+		ApiInstanceID string `json:"apiInstanceId,omitempty"`
+		// ApiInstance selects the API instance instead of ApiInstanceID, so that the descriptor works in every environment
+		ApiInstance *anypointclient.ApiInstanceSelector `json:"apiInstance,omitempty"`
+		Policies    []anypointclient.ApiPolicyRequest   `json:"policy"`
+		// Authoritative makes the descriptor the complete set of policies of the API instance, so that
+		// policies it does not declare are deleted
+		Authoritative bool `json:"authoritative,omitempty"`
+	} `json:"spec"`
+}
+
+// ApiInstanceV1 is an API Manager instance of an API asset from Exchange
+type ApiInstanceV1 struct {
+	BaseResource
+	Spec struct {
+		// Name is how the other descriptors of a run refer to the instance, with ${apiInstance(name)}
+		Name    string `json:"name"`
+		GroupID string `json:"groupId"`
+		AssetID string `json:"assetId"`
+		Version string `json:"version"`
+		// Technology is mule4 or flexGateway
+		Technology     string `json:"technology"`
+		InstanceLabel  string `json:"instanceLabel,omitempty"`
+		EndpointURI    string `json:"endpointUri,omitempty"`
+		DeploymentType string `json:"deploymentType,omitempty"`
 	} `json:"spec"`
 }
 
@@ -31,5 +53,8 @@ type MqDestinationsV1 struct {
 	Spec struct {
 		Queues    []anypointclient.MqQueue `json:"queues,omitempty"`
 		Exchanges []MqExchangeWithBindings `json:"exchanges,omitempty"`
+		// Owns holds globs of the queue and exchange IDs managed by the descriptor, deleted by --prune-mq
+		// when they are no longer declared
+		Owns []string `json:"owns,omitempty"`
 	} `json:"spec"`
 }
@@ -0,0 +1,56 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// FileProvider resolves file://path references to the content of the file, without trailing newlines.
+// With file://path#key the file is read as a JSON or YAML document and the value of the dot separated key is used.
+type FileProvider struct {
+	// Dir is the directory relative paths are resolved against
+	Dir string
+}
+
+func (p FileProvider) Resolve(ctx context.Context, reference Reference) (string, error) {
+	path := reference.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(p.Dir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file %s", path)
+	}
+	if reference.Key == "" {
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	return lookupKey(data, reference.Key)
+}
+
+// lookupKey returns the value of a dot separated key in a JSON or YAML document
+func lookupKey(data []byte, key string) (string, error) {
+	var document any
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return "", fmt.Errorf("failed to parse secret document")
+	}
+	value := document
+	for _, part := range strings.Split(key, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return "", fmt.Errorf("key %s not found", key)
+		}
+		if value, ok = object[part]; !ok {
+			return "", fmt.Errorf("key %s not found", key)
+		}
+	}
+	switch value.(type) {
+	case map[string]any, []any, nil:
+		return "", fmt.Errorf("key %s is not a single value", key)
+	}
+	return fmt.Sprint(value), nil
+}
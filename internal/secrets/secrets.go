@@ -0,0 +1,95 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Reference points to a secret held by a provider, e.g. vault://secret/data/app#password
+type Reference struct {
+	Scheme string
+	Path   string
+	Key    string
+}
+
+func (r Reference) String() string {
+	if r.Key == "" {
+		return r.Scheme + "://" + r.Path
+	}
+	return r.Scheme + "://" + r.Path + "#" + r.Key
+}
+
+// Provider resolves the references of one scheme. Implementations must be safe for concurrent use,
+// must stop once ctx is done and must never include the value of a secret in an error.
+type Provider interface {
+	Resolve(ctx context.Context, reference Reference) (string, error)
+}
+
+// Resolver resolves references using the provider registered for their scheme
+type Resolver struct {
+	providers map[string]Provider
+	mu        sync.RWMutex
+}
+
+// NewResolver creates a resolver without any providers
+func NewResolver() *Resolver {
+	return &Resolver{providers: map[string]Provider{}}
+}
+
+// Register makes the provider resolve references with the given scheme
+func (r *Resolver) Register(scheme string, provider Provider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.providers[scheme] = provider
+}
+
+// Parse returns the reference in a value if the value is a reference to a registered provider.
+// Values that are not references, e.g. plain text secrets, are left alone.
+func (r *Resolver) Parse(value string) (Reference, bool) {
+	scheme, rest, found := strings.Cut(value, "://")
+	if !found {
+		return Reference{}, false
+	}
+	r.mu.RLock()
+	_, registered := r.providers[scheme]
+	r.mu.RUnlock()
+	if !registered {
+		return Reference{}, false
+	}
+	path, key, _ := strings.Cut(rest, "#")
+	return Reference{Scheme: scheme, Path: path, Key: key}, true
+}
+
+// ResolveAll returns a copy of the values with every reference replaced by the secret it points to.
+// Every reference that can not be resolved is listed in the error, by name and reference only.
+func (r *Resolver) ResolveAll(ctx context.Context, values map[string]string) (map[string]string, error) {
+	if values == nil {
+		return nil, nil
+	}
+	resolved := make(map[string]string, len(values))
+	var failures []string
+	for name, value := range values {
+		reference, ok := r.Parse(value)
+		if !ok {
+			resolved[name] = value
+			continue
+		}
+		r.mu.RLock()
+		provider := r.providers[reference.Scheme]
+		r.mu.RUnlock()
+		secret, err := provider.Resolve(ctx, reference)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s (%s): %v", name, reference, err))
+			continue
+		}
+		resolved[name] = secret
+	}
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return nil, fmt.Errorf("failed to resolve secrets:\n  %s", strings.Join(failures, "\n  "))
+	}
+	return resolved, nil
+}
@@ -0,0 +1,188 @@
+package secrets
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// vaultStandIn serves a KV version 2 secret and a KV version 1 secret, and counts the reads
+func vaultStandIn(t *testing.T, reads *int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "test-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		*reads++
+		switch r.URL.Path {
+		case "/v1/secret/data/my-app":
+			w.Write([]byte(`{"data": {"data": {"db.password": "s3cr3t", "api.key": "abc"}, "metadata": {"version": 3}}}`))
+		case "/v1/kv/my-app":
+			w.Write([]byte(`{"data": {"db.password": "v1-secret"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestVaultProvider(t *testing.T) {
+	reads := 0
+	server := vaultStandIn(t, &reads)
+	resolver := NewResolver()
+	resolver.Register("vault", NewVaultProvider(server.URL, "test-token", ""))
+
+	resolved, err := resolver.ResolveAll(context.Background(), map[string]string{
+		"db.password": "vault://secret/data/my-app#db.password",
+		"api.key":     "vault://secret/data/my-app#api.key",
+		"legacy":      "vault://kv/my-app#db.password",
+		"plain":       "not-a-reference",
+	})
+	if err != nil {
+		t.Fatalf("failed to resolve secrets: %v", err)
+	}
+	expected := map[string]string{"db.password": "s3cr3t", "api.key": "abc", "legacy": "v1-secret", "plain": "not-a-reference"}
+	for name, value := range expected {
+		if resolved[name] != value {
+			t.Errorf("expected %s for %s, got %s", value, name, resolved[name])
+		}
+	}
+	if reads != 2 {
+		t.Errorf("expected each secret path to be read once, got %d reads", reads)
+	}
+}
+
+func TestVaultProviderErrorsDoNotLeakSecrets(t *testing.T) {
+	reads := 0
+	server := vaultStandIn(t, &reads)
+	resolver := NewResolver()
+	resolver.Register("vault", NewVaultProvider(server.URL, "test-token", ""))
+
+	_, err := resolver.ResolveAll(context.Background(), map[string]string{
+		"missing.key":  "vault://secret/data/my-app#missing",
+		"missing.path": "vault://secret/data/other#password",
+	})
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	for _, expected := range []string{"missing.key", "missing.path", "key missing not found", "returned 404"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to contain %q, got %v", expected, err)
+		}
+	}
+	if strings.Contains(err.Error(), "s3cr3t") {
+		t.Errorf("error leaks secret: %v", err)
+	}
+}
+
+func TestVaultProviderIsCancelled(t *testing.T) {
+	hung := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-hung:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(hung) })
+	provider := NewVaultProvider(server.URL, "test-token", "")
+	if provider.HTTPClient.Timeout <= 0 {
+		t.Errorf("expected requests to Vault to time out")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	if _, err := provider.Resolve(ctx, Reference{Scheme: "vault", Path: "secret/data/my-app", Key: "db.password"}); err == nil {
+		t.Errorf("expected a request to a hung Vault server to fail")
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("expected the request to stop with the context, took %s", elapsed)
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "password.txt"), []byte("p@ss\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "secrets.yaml"), []byte("db:\n  password: from-yaml\n"), 0o644)
+
+	resolver := NewResolver()
+	resolver.Register("file", FileProvider{Dir: dir})
+	resolved, err := resolver.ResolveAll(context.Background(), map[string]string{
+		"whole": "file://password.txt",
+		"key":   "file://secrets.yaml#db.password",
+	})
+	if err != nil {
+		t.Fatalf("failed to resolve secrets: %v", err)
+	}
+	if resolved["whole"] != "p@ss" || resolved["key"] != "from-yaml" {
+		t.Errorf("unexpected secrets %v", resolved)
+	}
+}
+
+// sopsStandIn writes a fake sops command to dir that prints the given document for --decrypt of an existing
+// file, and logs every call to sops.log
+func sopsStandIn(t *testing.T, dir, document string) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("the sops stand-in is a shell script")
+	}
+	script := "#!/bin/sh\n" +
+		"echo \"$@\" >> " + filepath.Join(dir, "sops.log") + "\n" +
+		"[ \"$1\" = --decrypt ] && [ -f \"$2\" ] || { echo \"failed to read $2\" >&2; exit 1; }\n" +
+		"cat <<'EOF'\n" + document + "\nEOF\n"
+	command := filepath.Join(dir, "sops")
+	if err := os.WriteFile(command, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return command
+}
+
+func TestSopsProvider(t *testing.T) {
+	dir := t.TempDir()
+	command := sopsStandIn(t, dir, "db:\n  password: from-sops\n")
+	os.WriteFile(filepath.Join(dir, "secrets.enc.yaml"), []byte("encrypted"), 0o644)
+
+	resolver := NewResolver()
+	resolver.Register("sops", SopsProvider{Command: command, Dir: dir})
+	resolved, err := resolver.ResolveAll(context.Background(), map[string]string{
+		"db.password": "sops://secrets.enc.yaml#db.password",
+		"plain":       "not-a-reference",
+	})
+	if err != nil {
+		t.Fatalf("failed to resolve secrets: %v", err)
+	}
+	if resolved["db.password"] != "from-sops" || resolved["plain"] != "not-a-reference" {
+		t.Errorf("unexpected secrets %v", resolved)
+	}
+	calls, _ := os.ReadFile(filepath.Join(dir, "sops.log"))
+	if expected := "--decrypt " + filepath.Join(dir, "secrets.enc.yaml") + "\n"; string(calls) != expected {
+		t.Errorf("expected the file to be decrypted with --decrypt, got calls %q", calls)
+	}
+
+	_, err = resolver.ResolveAll(context.Background(), map[string]string{"missing": "sops://missing.enc.yaml#db.password"})
+	if err == nil || !strings.Contains(err.Error(), "failed to read") {
+		t.Errorf("expected the sops error to be reported, got %v", err)
+	}
+	if _, err := resolver.ResolveAll(context.Background(), map[string]string{"no.key": "sops://secrets.enc.yaml"}); err == nil {
+		t.Errorf("expected a reference without key to fail")
+	}
+}
+
+func TestParseIgnoresUnknownSchemes(t *testing.T) {
+	resolver := NewResolver()
+	resolver.Register("vault", NewVaultProvider("", "", ""))
+	if _, ok := resolver.Parse("https://example.com/path#fragment"); ok {
+		t.Errorf("expected URL with unregistered scheme not to be a reference")
+	}
+	reference, ok := resolver.Parse("vault://secret/data/app#key")
+	if !ok || reference.Path != "secret/data/app" || reference.Key != "key" {
+		t.Errorf("unexpected reference %+v", reference)
+	}
+}
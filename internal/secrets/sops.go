@@ -0,0 +1,41 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+)
+
+// SopsProvider resolves sops://path#key references by decrypting the file with the sops command line tool
+// and reading the dot separated key from the decrypted JSON or YAML document
+type SopsProvider struct {
+	// Command is the sops executable, sops from PATH if empty
+	Command string
+	// Dir is the directory relative paths are resolved against
+	Dir string
+}
+
+func (p SopsProvider) Resolve(ctx context.Context, reference Reference) (string, error) {
+	if reference.Key == "" {
+		return "", fmt.Errorf("missing key, use sops://path#key")
+	}
+	command := p.Command
+	if command == "" {
+		command = "sops"
+	}
+	path := reference.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(p.Dir, path)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, command, "--decrypt", path)
+	cmd.Stderr = &stderr
+	decrypted, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt %s: %v %s", path, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return lookupKey(decrypted, reference.Key)
+}
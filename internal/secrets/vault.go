@@ -0,0 +1,107 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// vaultTimeout is the max time of a request to Vault, so that a server that does not answer can not hang a run
+const vaultTimeout = 30 * time.Second
+
+// VaultProvider resolves vault://path#key references by reading the secret at path from a HashiCorp Vault
+// compatible HTTP API. Both KV version 1 and version 2 responses are supported, for version 2 the path
+// includes the data segment, e.g. vault://secret/data/my-app#db.password.
+type VaultProvider struct {
+	Address    string
+	Token      string
+	Namespace  string
+	HTTPClient *http.Client
+
+	// Secrets are cached by path, so that a secret with several keys is read once
+	cache map[string]map[string]any
+	mu    sync.Mutex
+}
+
+// NewVaultProvider creates a provider reading secrets from the Vault server at address
+func NewVaultProvider(address string, token string, namespace string) *VaultProvider {
+	return &VaultProvider{
+		Address:    strings.TrimRight(address, "/"),
+		Token:      token,
+		Namespace:  namespace,
+		HTTPClient: &http.Client{Timeout: vaultTimeout},
+		cache:      map[string]map[string]any{},
+	}
+}
+
+func (p *VaultProvider) Resolve(ctx context.Context, reference Reference) (string, error) {
+	if reference.Key == "" {
+		return "", fmt.Errorf("missing key, use vault://path#key")
+	}
+	data, err := p.read(ctx, reference.Path)
+	if err != nil {
+		return "", err
+	}
+	value, found := data[reference.Key]
+	if !found {
+		return "", fmt.Errorf("key %s not found", reference.Key)
+	}
+	if _, ok := value.(string); !ok {
+		return "", fmt.Errorf("key %s is not a string", reference.Key)
+	}
+	return value.(string), nil
+}
+
+// read returns the data of the secret at path
+func (p *VaultProvider) read(ctx context.Context, path string) (map[string]any, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if data, found := p.cache[path]; found {
+		return data, nil
+	}
+	if p.Address == "" {
+		return nil, fmt.Errorf("no Vault address configured")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/v1/%s", p.Address, strings.TrimLeft(path, "/")), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", p.Token)
+	if p.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.Namespace)
+	}
+
+	res, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call Vault: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Vault returned %d for %s", res.StatusCode, path)
+	}
+
+	var response struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode Vault response")
+	}
+	data := response.Data
+	// KV version 2 nests the secret in data.data next to data.metadata
+	if nested, ok := data["data"].(map[string]any); ok {
+		if _, versioned := data["metadata"]; versioned {
+			data = nested
+		}
+	}
+
+	if p.cache == nil {
+		p.cache = map[string]map[string]any{}
+	}
+	p.cache[path] = data
+	return data, nil
+}
@@ -0,0 +1,195 @@
+package templating
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	functionCall = regexp.MustCompile(`^([A-Za-z0-9]+)\((.*)\)$`)
+)
+
+// Expander replaces references in descriptors with their values. The supported references are
+//
+//	${VAR}                   the value of the variable, an error if it is not defined
+//	${VAR:-default}          the value of the variable, or default if it is not defined or empty
+//	${file(path)}            the content of the file, relative to Dir
+//	${base64(VAR)}           the base64 encoded value of a reference, e.g. ${base64(file(keystore.jks))}
+//	${name(argument)}        the value returned by the function of Functions with that name
+//	$${...}                  the literal text ${...}
+//
+// A $ that is not followed by { is kept as is, so values such as passwords and DataWeave expressions
+// do not need to be escaped. Braces inside a reference must be balanced, e.g. ${JSON:-{}}.
+// The values of variables, defaults and files are quoted with Quote.
+type Expander struct {
+	// Lookup returns the value of a variable and whether it is defined
+	Lookup func(name string) (string, bool)
+	// Dir is the directory files are read relative to
+	Dir string
+	// Functions are the functions available besides file and base64, called with the trimmed argument
+	Functions map[string]func(argument string) (string, error)
+	// Quote, if set, quotes the values of variables and the content of files for the format of the template,
+	// e.g. with QuoteString. Values encoded with base64 are not quoted.
+	Quote func(content string) string
+}
+
+// Reference is a reference that could not be resolved
+type Reference struct {
+	Line       int
+	Expression string
+	Reason     string
+}
+
+// UnresolvedError lists every reference in a template that could not be resolved
+type UnresolvedError struct {
+	References []Reference
+}
+
+func (e *UnresolvedError) Error() string {
+	var builder strings.Builder
+	builder.WriteString("unresolved references:")
+	for _, reference := range e.References {
+		fmt.Fprintf(&builder, "\n  line %d: ${%s}: %s", reference.Line, reference.Expression, reference.Reason)
+	}
+	return builder.String()
+}
+
+// New creates an Expander resolving variables from the environment and files relative to dir
+func New(dir string) *Expander {
+	return &Expander{Lookup: os.LookupEnv, Dir: dir}
+}
+
+// Escape returns the value with every ${ escaped, so that expanding it gives back the value
+func Escape(value string) string {
+	return strings.ReplaceAll(value, "${", "$${")
+}
+
+// QuoteString escapes the value for a double-quoted string in JSON or YAML, so that quotes, backslashes
+// and line breaks in it do not end the string
+func QuoteString(value string) string {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(value)
+	quoted := strings.TrimSuffix(buffer.String(), "\n")
+	return quoted[1 : len(quoted)-1]
+}
+
+// Expand replaces every reference in the input. If any reference can not be resolved an *UnresolvedError
+// listing all of them is returned.
+func (e *Expander) Expand(input string) (string, error) {
+	var output strings.Builder
+	var unresolved []Reference
+	line := 1
+
+	for i := 0; i < len(input); i++ {
+		switch {
+		case input[i] == '\n':
+			line++
+		case strings.HasPrefix(input[i:], "$${"):
+			output.WriteString("${")
+			i += 2
+			continue
+		case strings.HasPrefix(input[i:], "${"):
+			end := closingBrace(input[i:])
+			if end < 0 {
+				unresolved = append(unresolved, Reference{Line: line, Expression: input[i+2:], Reason: "missing closing }"})
+				i = len(input)
+				continue
+			}
+			expression := input[i+2 : i+end]
+			value, err := e.evaluate(expression, true)
+			if err != nil {
+				unresolved = append(unresolved, Reference{Line: line, Expression: expression, Reason: err.Error()})
+			}
+			output.WriteString(value)
+			i += end
+			continue
+		}
+		output.WriteByte(input[i])
+	}
+
+	if len(unresolved) > 0 {
+		return "", &UnresolvedError{References: unresolved}
+	}
+	return output.String(), nil
+}
+
+// closingBrace returns the index of the } closing the reference at the start of input, skipping balanced
+// braces inside it, or -1 if it is not closed
+func closingBrace(input string) int {
+	depth := 0
+	for i := 2; i < len(input); i++ {
+		switch input[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// evaluate returns the value of the expression inside ${}. The values of variables and files are quoted
+// unless they are encoded by the enclosing expression.
+func (e *Expander) evaluate(expression string, quote bool) (string, error) {
+	expression = strings.TrimSpace(expression)
+
+	if call := functionCall.FindStringSubmatch(expression); call != nil {
+		argument := strings.TrimSpace(call[2])
+		switch call[1] {
+		case "file":
+			path := argument
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(e.Dir, path)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return "", fmt.Errorf("failed to read file %s", path)
+			}
+			return e.quote(string(data), quote), nil
+		case "base64":
+			value, err := e.evaluate(argument, false)
+			if err != nil {
+				return "", err
+			}
+			return base64.StdEncoding.EncodeToString([]byte(value)), nil
+		default:
+			if function, ok := e.Functions[call[1]]; ok {
+				return function(argument)
+			}
+			return "", fmt.Errorf("unknown function %s", call[1])
+		}
+	}
+
+	name, defaultValue, hasDefault := strings.Cut(expression, ":-")
+	if !variableName.MatchString(name) {
+		return "", fmt.Errorf("invalid variable name %q, use $${ for a literal ${", name)
+	}
+	value, found := e.Lookup(name)
+	if hasDefault && value == "" {
+		return e.quote(defaultValue, quote), nil
+	}
+	if !found {
+		return "", fmt.Errorf("variable %s is not defined", name)
+	}
+	return e.quote(value, quote), nil
+}
+
+// quote returns the value quoted with Quote, or as is if it is not to be quoted or there is no Quote
+func (e *Expander) quote(value string, quote bool) string {
+	if quote && e.Quote != nil {
+		return e.Quote(value)
+	}
+	return value
+}
@@ -0,0 +1,129 @@
+package templating
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testExpander(t *testing.T) *Expander {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ca.pem"), []byte("certificate"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	variables := map[string]string{"NAME": "app", "EMPTY": "", "PASSWORD": "pa$$word"}
+	return &Expander{
+		Lookup: func(name string) (string, bool) {
+			value, found := variables[name]
+			return value, found
+		},
+		Dir: dir,
+		Functions: map[string]func(string) (string, error){
+			"toUpper": func(argument string) (string, error) { return strings.ToUpper(argument), nil },
+		},
+	}
+}
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{input: `{"name": "${NAME}"}`, expected: `{"name": "app"}`},
+		{input: `${ NAME }-api`, expected: `app-api`},
+		{input: `${MISSING:-default}`, expected: `default`},
+		{input: `${EMPTY:-default}`, expected: `default`},
+		{input: `${NAME:-default}`, expected: `app`},
+		{input: `price: $100, regex: ^a$`, expected: `price: $100, regex: ^a$`},
+		{input: `${PASSWORD}`, expected: `pa$$word`},
+		{input: `$${http.port}`, expected: `${http.port}`},
+		{input: `${file(ca.pem)}`, expected: `certificate`},
+		{input: `${base64(NAME)}`, expected: `YXBw`},
+		{input: `${base64(file(ca.pem))}`, expected: `Y2VydGlmaWNhdGU=`},
+		{input: `${toUpper( orders )}`, expected: `ORDERS`},
+		{input: `${MISSING:-{}}`, expected: `{}`},
+		{input: `${MISSING:-{"a": {}}}!`, expected: `{"a": {}}!`},
+	}
+	expander := testExpander(t)
+	for _, test := range tests {
+		output, err := expander.Expand(test.input)
+		if err != nil {
+			t.Errorf("failed to expand %s: %v", test.input, err)
+			continue
+		}
+		if output != test.expected {
+			t.Errorf("expected %s to expand to %s, got %s", test.input, test.expected, output)
+		}
+	}
+}
+
+func TestExpandQuotesValues(t *testing.T) {
+	expander := testExpander(t)
+	content := "-----BEGIN CERTIFICATE-----\nMII\"C\\\n-----END CERTIFICATE-----\n"
+	if err := os.WriteFile(filepath.Join(expander.Dir, "ca.pem"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	password := "pa\"ss\\word\n"
+	expander.Lookup = func(name string) (string, bool) {
+		if name == "PASSWORD" {
+			return password, true
+		}
+		return "", false
+	}
+	expander.Quote = QuoteString
+
+	output, err := expander.Expand(`{"ca": "${file(ca.pem)}", "encoded": "${base64(file(ca.pem))}", ` +
+		`"password": "${PASSWORD}", "default": "${MISSING:-a"b\c}", "encodedPassword": "${base64(PASSWORD)}"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]string
+	if err := json.Unmarshal([]byte(output), &decoded); err != nil {
+		t.Fatalf("expected valid JSON, got %s: %v", output, err)
+	}
+	if decoded["ca"] != content {
+		t.Errorf("expected the content of the file, got %q", decoded["ca"])
+	}
+	if decoded["encoded"] != base64.StdEncoding.EncodeToString([]byte(content)) {
+		t.Errorf("expected the encoded content not to be quoted, got %q", decoded["encoded"])
+	}
+	if decoded["password"] != password {
+		t.Errorf("expected the value of the variable, got %q", decoded["password"])
+	}
+	if decoded["default"] != `a"b\c` {
+		t.Errorf("expected the default value, got %q", decoded["default"])
+	}
+	if decoded["encodedPassword"] != base64.StdEncoding.EncodeToString([]byte(password)) {
+		t.Errorf("expected the encoded value not to be quoted, got %q", decoded["encodedPassword"])
+	}
+}
+
+func TestExpandListsEveryUnresolvedReference(t *testing.T) {
+	input := "{\n  \"a\": \"${MISSING}\",\n  \"b\": \"${http.port}\",\n  \"c\": \"${file(missing.pem)}\"\n}"
+	_, err := testExpander(t).Expand(input)
+
+	var unresolved *UnresolvedError
+	if !errors.As(err, &unresolved) {
+		t.Fatalf("expected UnresolvedError, got %v", err)
+	}
+	if len(unresolved.References) != 3 {
+		t.Fatalf("expected 3 unresolved references, got %+v", unresolved.References)
+	}
+	for i, line := range []int{2, 3, 4} {
+		if unresolved.References[i].Line != line {
+			t.Errorf("expected reference %d on line %d, got %d", i, line, unresolved.References[i].Line)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	value := "${app.name} costs $5"
+	output, err := testExpander(t).Expand(Escape(value))
+	if err != nil || output != value {
+		t.Errorf("expected escaped value to expand to %s, got %s (%v)", value, output, err)
+	}
+}
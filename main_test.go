@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient"
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointfake"
+)
+
+// runAsCLI is set in the environment of the test binary when it is run as the command line tool
+const runAsCLI = "ANYPOINTCHDEPLOYER_RUN_AS_CLI"
+
+// TestMain runs the command line tool instead of the tests when the test binary is started by chdeploy, so that
+// the end-to-end tests run the real tool in a separate process against a fake Anypoint Platform
+func TestMain(m *testing.M) {
+	if os.Getenv(runAsCLI) == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+var (
+	e2eOrganization = anypointclient.Organization{ID: "org-id", Name: "Root"}
+	e2eEnvironment  = anypointclient.Environment{ID: "env-id", Name: "Sandbox", OrganizationID: "org-id"}
+)
+
+func newE2EServer(t *testing.T) *anypointfake.Server {
+	server := anypointfake.NewServer(e2eOrganization)
+	t.Cleanup(server.Close)
+	server.AddEnvironment(e2eEnvironment)
+	server.AddPrivateSpace(anypointclient.PrivateSpace{ID: "ps-id", Name: "Private", OrganizationID: "org-id"})
+	return server
+}
+
+// chdeploy runs the tool against the server and returns its output and exit code
+func chdeploy(t *testing.T, server *anypointfake.Server, args ...string) (string, int) {
+	args = append([]string{
+		"--base-url", server.URL, "--authtype", "user", "-u", "user", "-p", "password",
+		"-o", "Root", "-e", "Sandbox", "-v", "Private", "--max-retry-backoff", "10ms",
+	}, args...)
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), runAsCLI+"=1")
+	output, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return string(output), exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(output), 0
+}
+
+func writeApplication(t *testing.T, version string) string {
+	file := filepath.Join(t.TempDir(), "orders.yaml")
+	descriptor := `kind: Application
+version: v1
+spec:
+  name: orders
+  target:
+    provider: MC
+    replicas: 1
+    deploymentSettings:
+      updateStrategy: rolling
+      runtime:
+        version: 4.9.0
+        releaseChannel: LTS
+        java: "17"
+  application:
+    ref:
+      groupId: org-id
+      artifactId: orders
+      version: ` + version + `
+      packaging: jar
+    desiredState: STARTED
+    vCores: 0.1
+    configuration:
+      mule.agent.application.properties.service:
+        properties:
+          env: sandbox
+`
+	if err := os.WriteFile(file, []byte(descriptor), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestEndToEndDeployAndRollBack(t *testing.T) {
+	server := newE2EServer(t)
+
+	output, code := chdeploy(t, server, "--wait", writeApplication(t, "1.0.0"))
+	if code != 0 {
+		t.Fatalf("expected the deployment to succeed, exit code %d:\n%s", code, output)
+	}
+	deployment, ok := server.Deployment(e2eEnvironment, "orders")
+	if !ok || deployment.Application.Ref.Version != "1.0.0" || deployment.Target.TargetID != "ps-id" {
+		t.Fatalf("expected version 1.0.0 in the private space, got %+v", deployment)
+	}
+
+	server.FailRollout("1.1.0", "CrashLoopBackOff")
+	output, code = chdeploy(t, server, "--rollback", writeApplication(t, "1.1.0"))
+	if code != 10 {
+		t.Fatalf("expected the failed rollout to exit 10, exit code %d:\n%s", code, output)
+	}
+	if !strings.Contains(output, "CrashLoopBackOff") || !strings.Contains(output, "rolled back orders to version 1.0.0") {
+		t.Errorf("expected the replica reason and the rollback to be reported:\n%s", output)
+	}
+	if deployment, _ := server.Deployment(e2eEnvironment, "orders"); deployment.Application.Ref.Version != "1.0.0" {
+		t.Errorf("expected version 1.0.0 to be restored, got %s", deployment.Application.Ref.Version)
+	}
+}
+
+func TestEndToEndRetries(t *testing.T) {
+	server := newE2EServer(t)
+	server.Inject(anypointfake.Failure{Path: "/deployments$", Method: "GET", Status: http.StatusBadGateway, Times: 2})
+	server.Inject(anypointfake.Failure{Path: "/deployments$", Method: "POST", Status: http.StatusServiceUnavailable, Header: http.Header{"Retry-After": {"0"}}, Times: 1})
+
+	output, code := chdeploy(t, server, writeApplication(t, "1.0.0"))
+	if code != 0 {
+		t.Fatalf("expected the failures to be retried, exit code %d:\n%s", code, output)
+	}
+	if _, ok := server.Deployment(e2eEnvironment, "orders"); !ok {
+		t.Errorf("expected the deployment to be created:\n%s", output)
+	}
+	if strings.Count(output, "retry 1 of 3") != 2 || !strings.Contains(output, "retry 2 of 3") {
+		t.Errorf("expected the retries to be logged:\n%s", output)
+	}
+}
+
+func TestEndToEndDryRun(t *testing.T) {
+	server := newE2EServer(t)
+
+	output, code := chdeploy(t, server, "--dry-run", writeApplication(t, "1.0.0"))
+	if code != 0 {
+		t.Fatalf("expected the dry run to succeed, exit code %d:\n%s", code, output)
+	}
+	for _, request := range server.Requests() {
+		if request.Method != http.MethodGet && !strings.HasPrefix(request.Path, "/accounts/") {
+			t.Errorf("expected nothing to be changed in a dry run, got %s %s", request.Method, request.Path)
+		}
+	}
+}
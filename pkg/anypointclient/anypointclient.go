@@ -1,11 +1,13 @@
 package anypointclient
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -25,14 +27,18 @@ const (
 AnypointClient represents the
 */
 type AnypointClient struct {
-	HTTPClient   *http.Client
-	username     string
-	password     string
-	clientId     string
-	clientSecret string
-	bearer       string
-	authType     AuthenticationType
-	baseURL      string
+	HTTPClient  *http.Client
+	RetryPolicy RetryPolicy
+	// RequestTimeout limits the time of each request to Anypoint Platform, including its retries. 0 means no limit.
+	RequestTimeout time.Duration
+	ctx            context.Context
+	username       string
+	password       string
+	clientId       string
+	clientSecret   string
+	session        *session
+	authType       AuthenticationType
+	baseURL        string
 }
 
 // createHTTPClient creates an HTTP client, optionally configured with a proxy
@@ -63,7 +69,8 @@ func NewAnypointClientWithToken(bearer string, baseURL string, proxyURL string)
 	var c AnypointClient
 
 	c.HTTPClient = createHTTPClient(proxyURL)
-	c.bearer = bearer
+	c.RetryPolicy = DefaultRetryPolicy()
+	c.session = &session{bearer: bearer}
 	c.baseURL = baseURL
 	c.authType = BearerAuthenticationType
 	return &c
@@ -76,10 +83,11 @@ func NewAnypointClientWithCredentials(username string, password string, baseURL
 	var c AnypointClient
 
 	c.HTTPClient = createHTTPClient(proxyURL)
+	c.RetryPolicy = DefaultRetryPolicy()
 	c.baseURL = baseURL
 	c.username = username
 	c.password = password
-	c.bearer = ""
+	c.session = &session{}
 	c.authType = UserAuthenticationType
 
 	return &c
@@ -92,37 +100,45 @@ func NewAnypointClientWithConnectedApp(clientId string, clientSecret string, bas
 	var c AnypointClient
 
 	c.HTTPClient = createHTTPClient(proxyURL)
+	c.RetryPolicy = DefaultRetryPolicy()
 	c.baseURL = baseURL
 	c.clientId = clientId
 	c.clientSecret = clientSecret
-	c.bearer = ""
+	c.session = &session{}
 	c.authType = ConnectedAppAuthenticationType
 
 	return &c
 }
 
-func (client *AnypointClient) newRequest(method string, path string, body io.Reader) (*http.Request, error) {
-	url := fmt.Sprintf("%s/%s", client.baseURL, path)
-	req, err := http.NewRequest(method, url, body)
-	if err != nil {
-		return nil, err
-	}
-	if client.bearer != "" {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", client.bearer))
+/*
+WithContext returns a shallow copy of the client using the given context for the methods not taking a context,
+e.g. to cancel every call made by the copy when the context is cancelled.
+*/
+func (client *AnypointClient) WithContext(ctx context.Context) *AnypointClient {
+	c := *client
+	c.ctx = ctx
+	return &c
+}
+
+// context returns the context used by the methods not taking a context
+func (client *AnypointClient) context() context.Context {
+	if client.ctx == nil {
+		return context.Background()
 	}
-	return req, nil
+	return client.ctx
 }
 
-func (client *AnypointClient) newGetRequest(path string) (*http.Response, error) {
+func (client *AnypointClient) newRequest(ctx context.Context, method string, path string, body io.Reader) (*http.Request, error) {
 	url := fmt.Sprintf("%s/%s", client.baseURL, path)
-	req, err := http.NewRequest("GET", url, nil)
+	return http.NewRequestWithContext(ctx, method, url, body)
+}
+
+func (client *AnypointClient) newGetRequest(ctx context.Context, path string) (*http.Response, error) {
+	req, err := client.newRequest(ctx, "GET", path, nil)
 	if err != nil {
 		return nil, err
 	}
-	if client.bearer != "" {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", client.bearer))
-	}
-	return client.HTTPClient.Do(req)
+	return client.do(req)
 }
 
 func ResolveBaseURLFromRegion(region string) (string, error) {
@@ -35,6 +35,8 @@ var (
 var _ = BeforeSuite(func() {
 	baseUrl, _ := ResolveBaseURLFromRegion("US")
 	client = *NewAnypointClientWithCredentials("user", "password", baseUrl, "")
+	// start logged in, so that only the login tests need a login response
+	client.session.bearer = "test-token"
 	// block all HTTP requests
 	httpmock.ActivateNonDefault(client.HTTPClient)
 })
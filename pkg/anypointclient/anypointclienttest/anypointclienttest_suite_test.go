@@ -0,0 +1,13 @@
+package anypointclienttest_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestAnypointClientTest(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Anypoint Client Fake Test Suite")
+}
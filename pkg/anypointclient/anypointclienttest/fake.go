@@ -0,0 +1,932 @@
+// Package anypointclienttest provides an in-memory implementation of the Anypoint Platform APIs for use in tests
+package anypointclienttest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient"
+	"github.com/pkg/errors"
+)
+
+// Fake implements anypointclient.AnypointAPI on the in-memory state of one organization. Deployments roll out at
+// once and secure property values are masked. Failures are injected with Fail and FailOnce, calls are recorded.
+type Fake struct {
+	mu            sync.Mutex
+	organization  anypointclient.Organization
+	environments  []anypointclient.Environment
+	privateSpaces []anypointclient.PrivateSpace
+	deployments   map[string]map[string]anypointclient.CloudhubDeploymentResp
+	schedulers    map[string][]anypointclient.Schedule
+	apis          map[string][]anypointclient.ApiInstance
+	policies      map[int][]anypointclient.ApiPolicyResponse
+	destinations  map[string]map[string]anypointclient.MqDestination
+	bindings      map[string][]anypointclient.MqBinding
+	queueStats    map[string]anypointclient.MqQueueStats
+	assets        map[string][]anypointclient.ExchangeAsset
+	failures      map[string][]failure
+	calls         []string
+	lastID        int
+}
+
+var _ anypointclient.AnypointAPI = (*Fake)(nil)
+
+// failure is an error injected into a method, returned by the next call only if once is set
+type failure struct {
+	err  error
+	once bool
+}
+
+// NewFake creates a fake of the organization, including its sub organizations
+func NewFake(organization anypointclient.Organization) *Fake {
+	return &Fake{
+		organization: organization,
+		deployments:  map[string]map[string]anypointclient.CloudhubDeploymentResp{},
+		schedulers:   map[string][]anypointclient.Schedule{},
+		apis:         map[string][]anypointclient.ApiInstance{},
+		policies:     map[int][]anypointclient.ApiPolicyResponse{},
+		destinations: map[string]map[string]anypointclient.MqDestination{},
+		bindings:     map[string][]anypointclient.MqBinding{},
+		queueStats:   map[string]anypointclient.MqQueueStats{},
+		assets:       map[string][]anypointclient.ExchangeAsset{},
+		failures:     map[string][]failure{},
+	}
+}
+
+// Fail makes every following call of the method return err, until Reset is called
+func (fake *Fake) Fail(method string, err error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.failures[method] = append(fake.failures[method], failure{err: err})
+}
+
+// FailOnce makes the next call of the method return err. Failures injected for the same method are
+// returned in the order they were injected.
+func (fake *Fake) FailOnce(method string, err error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.failures[method] = append(fake.failures[method], failure{err: err, once: true})
+}
+
+// Reset removes every injected failure and forgets the recorded calls. The state is kept.
+func (fake *Fake) Reset() {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.failures = map[string][]failure{}
+	fake.calls = nil
+}
+
+// Calls returns the names of the methods called, in the order they were called
+func (fake *Fake) Calls() []string {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	return slices.Clone(fake.calls)
+}
+
+// call records a call of the method and returns the failure injected into it, if any. The lock must be held.
+func (fake *Fake) call(method string) error {
+	fake.calls = append(fake.calls, method)
+	failures := fake.failures[method]
+	if len(failures) == 0 {
+		return nil
+	}
+	if failures[0].once {
+		fake.failures[method] = failures[1:]
+	}
+	return failures[0].err
+}
+
+// newID returns a new ID, unique within the fake. The lock must be held.
+func (fake *Fake) newID() int {
+	fake.lastID++
+	return fake.lastID
+}
+
+// AddEnvironment adds an environment that ResolveEnvironment can find
+func (fake *Fake) AddEnvironment(environment anypointclient.Environment) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.environments = append(fake.environments, environment)
+}
+
+// AddPrivateSpace adds a private space that ResolvePrivateSpace can find
+func (fake *Fake) AddPrivateSpace(privateSpace anypointclient.PrivateSpace) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.privateSpaces = append(fake.privateSpaces, privateSpace)
+}
+
+// Login always succeeds, unless a failure is injected
+func (fake *Fake) Login() error {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	return fake.call("Login")
+}
+
+// ResolveOrganization finds the organization or sub organization by its path, e.g. Root/Sub
+func (fake *Fake) ResolveOrganization(organizationPath string) (anypointclient.Organization, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("ResolveOrganization"); err != nil {
+		return anypointclient.Organization{}, err
+	}
+	parts := strings.Split(organizationPath, "/")
+	if parts[0] != fake.organization.Name {
+		return anypointclient.Organization{}, errors.Errorf("failed to find organization %s", organizationPath)
+	}
+	organization := fake.organization
+	for _, part := range parts[1:] {
+		i := slices.IndexFunc(organization.SubOrganizations, func(sub anypointclient.Organization) bool { return sub.Name == part })
+		if i < 0 {
+			return anypointclient.Organization{}, errors.Errorf("failed to find organization %s", organizationPath)
+		}
+		organization = organization.SubOrganizations[i]
+	}
+	return organization, nil
+}
+
+// ResolveEnvironment finds an environment added with AddEnvironment by name
+func (fake *Fake) ResolveEnvironment(organization anypointclient.Organization, environmentName string) (anypointclient.Environment, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("ResolveEnvironment"); err != nil {
+		return anypointclient.Environment{}, err
+	}
+	for _, environment := range fake.environments {
+		if environment.OrganizationID == organization.ID && environment.Name == environmentName {
+			return environment, nil
+		}
+	}
+	return anypointclient.Environment{}, errors.Errorf("failed to find environment named %s in organization %s", environmentName, organization.Name)
+}
+
+// EnvironmentsPaginator returns a paginator over the environments of the organization added with AddEnvironment
+func (fake *Fake) EnvironmentsPaginator(organization anypointclient.Organization) *anypointclient.Paginator[anypointclient.Environment] {
+	return anypointclient.NewPaginator(anypointclient.DefaultPageSize, func(_ context.Context, offset int, limit int) (anypointclient.Page[anypointclient.Environment], error) {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		if err := fake.call("GetEnvironments"); err != nil {
+			return anypointclient.Page[anypointclient.Environment]{}, err
+		}
+		var environments []anypointclient.Environment
+		for _, environment := range fake.environments {
+			if environment.OrganizationID == organization.ID {
+				environments = append(environments, environment)
+			}
+		}
+		return anypointclient.Page[anypointclient.Environment]{Items: page(environments, offset, limit), Total: len(environments)}, nil
+	})
+}
+
+// ResolvePrivateSpace finds a private space added with AddPrivateSpace by name
+func (fake *Fake) ResolvePrivateSpace(organization anypointclient.Organization, privateSpaceName string) (anypointclient.PrivateSpace, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("ResolvePrivateSpace"); err != nil {
+		return anypointclient.PrivateSpace{}, err
+	}
+	for _, privateSpace := range fake.privateSpaces {
+		if privateSpace.OrganizationID == organization.ID && privateSpace.Name == privateSpaceName {
+			return privateSpace, nil
+		}
+	}
+	return anypointclient.PrivateSpace{}, errors.Errorf("failed to find private space named %s in organization %s", privateSpaceName, organization.Name)
+}
+
+// AddDeployment adds a deployment to the environment. A deployment without ID is given one.
+func (fake *Fake) AddDeployment(environment anypointclient.Environment, deployment anypointclient.CloudhubDeploymentResp) anypointclient.CloudhubDeploymentResp {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if deployment.ID == "" {
+		deployment.ID = fmt.Sprintf("deployment-%d", fake.newID())
+	}
+	if fake.deployments[environment.ID] == nil {
+		fake.deployments[environment.ID] = map[string]anypointclient.CloudhubDeploymentResp{}
+	}
+	fake.deployments[environment.ID][deployment.Name] = deployment
+	return deployment
+}
+
+// Deployment returns the deployment in the environment by name, and whether it exists
+func (fake *Fake) Deployment(environment anypointclient.Environment, deploymentName string) (anypointclient.CloudhubDeploymentResp, bool) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	deployment, ok := fake.deployments[environment.ID][deploymentName]
+	return deployment, ok
+}
+
+// SetSourceSchedulers sets the schedulers found in the source code of the deployment, which are compared with
+// the configured ones by SchedulesDiffFromSourceCode
+func (fake *Fake) SetSourceSchedulers(deploymentID string, schedulers []anypointclient.Schedule) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.schedulers[deploymentID] = schedulers
+}
+
+// GetDeployments returns every deployment in the environment, ordered by name
+func (fake *Fake) GetDeployments(environment anypointclient.Environment) ([]anypointclient.Deployment, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("GetDeployments"); err != nil {
+		return nil, err
+	}
+	var deployments []anypointclient.Deployment
+	for _, name := range slices.Sorted(maps.Keys(fake.deployments[environment.ID])) {
+		current := fake.deployments[environment.ID][name]
+		deployment := anypointclient.Deployment{
+			ID:                    current.ID,
+			Name:                  current.Name,
+			CreationDate:          current.CreationDate,
+			LastModifiedDate:      current.LastModifiedDate,
+			Status:                current.Status,
+			CurrentRuntimeVersion: current.Target.DeploymentSettings.RuntimeVersion,
+		}
+		deployment.Target.Provider = current.Target.Provider
+		deployment.Target.TargetID = current.Target.TargetID
+		deployment.Application.Status = current.Application.Status
+		deployments = append(deployments, deployment)
+	}
+	return deployments, nil
+}
+
+// GetDeployment returns the deployment by name, or an empty deployment if there is none
+func (fake *Fake) GetDeployment(environment anypointclient.Environment, deploymentName string) (anypointclient.CloudhubDeploymentResp, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("GetDeployment"); err != nil {
+		return anypointclient.CloudhubDeploymentResp{}, err
+	}
+	return fake.deployments[environment.ID][deploymentName], nil
+}
+
+// CreateDeployment creates the deployment, which is rolled out at once
+func (fake *Fake) CreateDeployment(environment anypointclient.Environment, privateSpace anypointclient.PrivateSpace, deployment anypointclient.CloudhubDeploymentReq) (anypointclient.CloudhubDeploymentResp, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("CreateDeployment"); err != nil {
+		return anypointclient.CloudhubDeploymentResp{}, err
+	}
+	if _, ok := fake.deployments[environment.ID][deployment.Name]; ok {
+		return anypointclient.CloudhubDeploymentResp{}, errors.Errorf("deployment %s already exists", deployment.Name)
+	}
+	created, err := fake.rollOut(deployment, anypointclient.CloudhubDeploymentResp{ID: fmt.Sprintf("deployment-%d", fake.newID())})
+	if err != nil {
+		return anypointclient.CloudhubDeploymentResp{}, err
+	}
+	if fake.deployments[environment.ID] == nil {
+		fake.deployments[environment.ID] = map[string]anypointclient.CloudhubDeploymentResp{}
+	}
+	fake.deployments[environment.ID][deployment.Name] = created
+	return created, nil
+}
+
+// UpdateDeployment replaces the deployment with the given ID, which is rolled out at once
+func (fake *Fake) UpdateDeployment(environment anypointclient.Environment, privateSpace anypointclient.PrivateSpace, deployment anypointclient.CloudhubDeploymentReq, deploymentID string) error {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("UpdateDeployment"); err != nil {
+		return err
+	}
+	for name, current := range fake.deployments[environment.ID] {
+		if current.ID != deploymentID {
+			continue
+		}
+		updated, err := fake.rollOut(deployment, current)
+		if err != nil {
+			return err
+		}
+		delete(fake.deployments[environment.ID], name)
+		fake.deployments[environment.ID][updated.Name] = updated
+		return nil
+	}
+	return errors.Errorf("deployment %s not found", deploymentID)
+}
+
+// rollOut returns the state of the previous deployment after the requested one has been rolled out
+func (fake *Fake) rollOut(deployment anypointclient.CloudhubDeploymentReq, previous anypointclient.CloudhubDeploymentResp) (anypointclient.CloudhubDeploymentResp, error) {
+	data, err := json.Marshal(deployment)
+	if err != nil {
+		return anypointclient.CloudhubDeploymentResp{}, errors.Wrap(err, "failed to marshal deployment")
+	}
+	var rolledOut anypointclient.CloudhubDeploymentResp
+	if err := json.Unmarshal(data, &rolledOut); err != nil {
+		return anypointclient.CloudhubDeploymentResp{}, errors.Wrap(err, "failed to unmarshal deployment")
+	}
+
+	properties := &rolledOut.Application.Configuration.MuleAgentApplicationPropertiesService
+	for key := range properties.SecureProperties {
+		properties.SecureProperties[key] = "****"
+	}
+	now := time.Now().UnixMilli()
+	rolledOut.ID = previous.ID
+	rolledOut.CreationDate = previous.CreationDate
+	if rolledOut.CreationDate == 0 {
+		rolledOut.CreationDate = now
+	}
+	rolledOut.LastModifiedDate = now
+	rolledOut.Status = "APPLIED"
+	rolledOut.DesiredVersion = fmt.Sprintf("version-%d", fake.newID())
+	rolledOut.LastSuccessfulVersion = rolledOut.DesiredVersion
+	if deployment.Application.DesiredState == "STOPPED" {
+		rolledOut.Application.Status = "NOT_RUNNING"
+		return rolledOut, nil
+	}
+	rolledOut.Application.Status = "RUNNING"
+	for i := range max(deployment.Target.Replicas, 1) {
+		rolledOut.Replicas = append(rolledOut.Replicas, anypointclient.DeploymentReplica{
+			ID:                       fmt.Sprintf("%s-replica-%d", rolledOut.ID, i),
+			State:                    "STARTED",
+			CurrentDeploymentVersion: rolledOut.DesiredVersion,
+		})
+	}
+	return rolledOut, nil
+}
+
+// DeleteDeployment deletes the deployment with the given ID
+func (fake *Fake) DeleteDeployment(environment anypointclient.Environment, privateSpace anypointclient.PrivateSpace, deploymentID string) error {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("DeleteDeployment"); err != nil {
+		return err
+	}
+	for name, current := range fake.deployments[environment.ID] {
+		if current.ID == deploymentID {
+			delete(fake.deployments[environment.ID], name)
+			return nil
+		}
+	}
+	return errors.Errorf("deployment %s not found", deploymentID)
+}
+
+// SchedulesDiffFromSourceCode compares the schedulers of the deployment with the ones set with
+// SetSourceSchedulers. If none are set the source code has the schedulers of the deployment.
+func (fake *Fake) SchedulesDiffFromSourceCode(environment anypointclient.Environment, newDeployment anypointclient.CloudhubDeploymentReq, deploymentID string) error {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("SchedulesDiffFromSourceCode"); err != nil {
+		return err
+	}
+	source, ok := fake.schedulers[deploymentID]
+	if !ok {
+		return nil
+	}
+	configured := newDeployment.Application.Configuration.MuleAgentScheduleService.Schedulers
+	sameFlow := func(a, b anypointclient.Schedule) bool { return a.FlowName == b.FlowName && a.Type == b.Type }
+	if len(configured) != len(source) {
+		return errors.Errorf("configuration has %d schedulers, but source code defines %d schedulers", len(configured), len(source))
+	}
+	for _, scheduler := range configured {
+		if !slices.ContainsFunc(source, func(s anypointclient.Schedule) bool { return sameFlow(s, scheduler) }) {
+			return errors.Errorf("scheduler of flow %s is not defined in the source code", scheduler.FlowName)
+		}
+	}
+	return nil
+}
+
+// WaitForRollout returns the deployment, which has always finished its rollout unless a failure is injected.
+// An injected failure is returned together with the deployment, like a failed rollout.
+func (fake *Fake) WaitForRollout(environment anypointclient.Environment, deploymentName string, timeout time.Duration, interval time.Duration) (anypointclient.CloudhubDeploymentResp, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	deployment, ok := fake.deployments[environment.ID][deploymentName]
+	if err := fake.call("WaitForRollout"); err != nil {
+		return deployment, err
+	}
+	if !ok {
+		return deployment, errors.Errorf("deployment %s not found", deploymentName)
+	}
+	return deployment, nil
+}
+
+// AddApi adds an API instance to the environment. An instance without ID is given one.
+func (fake *Fake) AddApi(environment anypointclient.Environment, instance anypointclient.ApiInstance) anypointclient.ApiInstance {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if instance.ID == 0 {
+		instance.ID = fake.newID()
+	}
+	fake.apis[environment.ID] = append(fake.apis[environment.ID], instance)
+	return instance
+}
+
+// AddPolicy adds a policy to an API instance. A policy without ID is given one.
+func (fake *Fake) AddPolicy(apiInstanceID int, policy anypointclient.ApiPolicyResponse) anypointclient.ApiPolicyResponse {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if policy.PolicyID == 0 {
+		policy.PolicyID = fake.newID()
+	}
+	policy.APIID = apiInstanceID
+	fake.policies[apiInstanceID] = append(fake.policies[apiInstanceID], policy)
+	return policy
+}
+
+// Policies returns the policies of an API instance
+func (fake *Fake) Policies(apiInstanceID int) []anypointclient.ApiPolicyResponse {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	return slices.Clone(fake.policies[apiInstanceID])
+}
+
+// GetApis returns a page of the API instances in the environment
+func (fake *Fake) GetApis(orgId string, envId string, offset int, limit int) (*anypointclient.ApiListResponse, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("GetApis"); err != nil {
+		return nil, err
+	}
+	instances := fake.apis[envId]
+	return &anypointclient.ApiListResponse{Total: len(instances), Instances: page(instances, offset, limit)}, nil
+}
+
+// ApisPaginator returns a paginator over the API instances of the environment, fetching the pages with GetApis.
+// No page is fetched once the context is done.
+func (fake *Fake) ApisPaginator(orgId string, envId string) *anypointclient.Paginator[anypointclient.ApiInstance] {
+	return anypointclient.NewPaginator(anypointclient.DefaultPageSize, func(ctx context.Context, offset int, limit int) (anypointclient.Page[anypointclient.ApiInstance], error) {
+		if err := ctx.Err(); err != nil {
+			return anypointclient.Page[anypointclient.ApiInstance]{}, err
+		}
+		response, err := fake.GetApis(orgId, envId, offset, limit)
+		if err != nil {
+			return anypointclient.Page[anypointclient.ApiInstance]{}, err
+		}
+		return anypointclient.Page[anypointclient.ApiInstance]{Items: response.Instances, Total: response.Total}, nil
+	})
+}
+
+// GetApiInstance returns the API instance of the environment
+func (fake *Fake) GetApiInstance(orgId string, envId string, apiInstanceID int) (*anypointclient.ApiInstance, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("GetApiInstance"); err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(fake.apis[envId], func(instance anypointclient.ApiInstance) bool { return instance.ID == apiInstanceID })
+	if i < 0 {
+		return nil, errors.Errorf("API instance %d not found", apiInstanceID)
+	}
+	instance := fake.apis[envId][i]
+	return &instance, nil
+}
+
+// CreateApiInstance adds a new API instance to the environment
+func (fake *Fake) CreateApiInstance(orgId string, envId string, request anypointclient.ApiInstanceRequest) (*anypointclient.ApiInstance, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("CreateApiInstance"); err != nil {
+		return nil, err
+	}
+	instance := applyApiInstance(anypointclient.ApiInstance{ID: fake.newID(), OrganizationID: orgId, EnvironmentID: envId}, request)
+	fake.apis[envId] = append(fake.apis[envId], instance)
+	return &instance, nil
+}
+
+// UpdateApiInstance changes the asset version, endpoint and technology of an API instance
+func (fake *Fake) UpdateApiInstance(orgId string, envId string, apiInstanceID int, request anypointclient.ApiInstanceRequest) (*anypointclient.ApiInstance, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("UpdateApiInstance"); err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(fake.apis[envId], func(instance anypointclient.ApiInstance) bool { return instance.ID == apiInstanceID })
+	if i < 0 {
+		return nil, errors.Errorf("API instance %d not found", apiInstanceID)
+	}
+	// The asset of an instance can not be changed, only its version
+	request.Spec.GroupID, request.Spec.AssetID = fake.apis[envId][i].GroupID, fake.apis[envId][i].AssetID
+	fake.apis[envId][i] = applyApiInstance(fake.apis[envId][i], request)
+	instance := fake.apis[envId][i]
+	return &instance, nil
+}
+
+// applyApiInstance returns the API instance with the asset, endpoint and technology of the request
+func applyApiInstance(instance anypointclient.ApiInstance, request anypointclient.ApiInstanceRequest) anypointclient.ApiInstance {
+	instance.GroupID = request.Spec.GroupID
+	instance.AssetID = request.Spec.AssetID
+	instance.AssetVersion = request.Spec.Version
+	instance.ProductVersion = "v" + strings.SplitN(request.Spec.Version, ".", 2)[0]
+	instance.Technology = request.Technology
+	instance.InstanceLabel = request.InstanceLabel
+	instance.EndpointURI = request.Endpoint.URI
+	endpoint := request.Endpoint
+	instance.Endpoint = &endpoint
+	instance.AutodiscoveryInstanceName = fmt.Sprintf("%s:%d", instance.ProductVersion, instance.ID)
+	return instance
+}
+
+// GetApiInstancePolicies returns the policies of an API instance
+func (fake *Fake) GetApiInstancePolicies(orgId string, envId string, apiInstanceID int) (*[]anypointclient.ApiPolicyResponse, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("GetApiInstancePolicies"); err != nil {
+		return nil, err
+	}
+	policies := slices.Clone(fake.policies[apiInstanceID])
+	return &policies, nil
+}
+
+// CreateApiInstancePolicies applies a new policy to an API instance
+func (fake *Fake) CreateApiInstancePolicies(orgId string, envId string, apiInstanceID int, apipolicy anypointclient.ApiPolicyRequest) error {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("CreateApiInstancePolicies"); err != nil {
+		return err
+	}
+	policy := applyPolicy(anypointclient.ApiPolicyResponse{PolicyID: fake.newID(), APIID: apiInstanceID, OrganizationID: orgId}, apipolicy)
+	if policy.Order == 0 {
+		policy.Order = len(fake.policies[apiInstanceID]) + 1
+	}
+	fake.policies[apiInstanceID] = append(fake.policies[apiInstanceID], policy)
+	return nil
+}
+
+// UpdateApiInstancePolicies replaces the configuration of a policy of an API instance
+func (fake *Fake) UpdateApiInstancePolicies(orgId string, envId string, apiInstanceID int, policyID int, apipolicy anypointclient.ApiPolicyRequest) error {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("UpdateApiInstancePolicies"); err != nil {
+		return err
+	}
+	policies := fake.policies[apiInstanceID]
+	i := slices.IndexFunc(policies, func(policy anypointclient.ApiPolicyResponse) bool { return policy.PolicyID == policyID })
+	if i < 0 {
+		return errors.Errorf("policy %d not found on API instance %d", policyID, apiInstanceID)
+	}
+	order := policies[i].Order
+	policies[i] = applyPolicy(policies[i], apipolicy)
+	if policies[i].Order == 0 {
+		policies[i].Order = order
+	}
+	return nil
+}
+
+// DeleteApiInstancePolicy removes the policy from the API instance. A policy that does not exist is already removed.
+func (fake *Fake) DeleteApiInstancePolicy(orgId string, envId string, apiInstanceID int, policyID int) error {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("DeleteApiInstancePolicy"); err != nil {
+		return err
+	}
+	fake.policies[apiInstanceID] = slices.DeleteFunc(fake.policies[apiInstanceID], func(policy anypointclient.ApiPolicyResponse) bool { return policy.PolicyID == policyID })
+	return nil
+}
+
+// ReorderApiInstancePolicies sets the order of the policies of the API instance. Every policy must exist.
+func (fake *Fake) ReorderApiInstancePolicies(orgId string, envId string, apiInstanceID int, order []anypointclient.ApiPolicyOrder) error {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("ReorderApiInstancePolicies"); err != nil {
+		return err
+	}
+	policies := fake.policies[apiInstanceID]
+	for _, policyOrder := range order {
+		i := slices.IndexFunc(policies, func(policy anypointclient.ApiPolicyResponse) bool { return policy.PolicyID == policyOrder.ID })
+		if i < 0 {
+			return errors.Errorf("policy %d not found on API instance %d", policyOrder.ID, apiInstanceID)
+		}
+		policies[i].Order = policyOrder.Order
+	}
+	return nil
+}
+
+// applyPolicy returns the policy with the template and configuration of the request
+func applyPolicy(policy anypointclient.ApiPolicyResponse, apipolicy anypointclient.ApiPolicyRequest) anypointclient.ApiPolicyResponse {
+	policy.Template.GroupID = apipolicy.GroupID
+	policy.Template.AssetID = apipolicy.AssetID
+	policy.Template.AssetVersion = apipolicy.AssetVersion
+	policy.Configuration = apipolicy.ConfigurationData
+	policy.Order = apipolicy.Order
+	policy.Disabled = apipolicy.Disabled
+	policy.PointcutData = apipolicy.PointcutData
+	policy.Standalone = apipolicy.Standalone
+	return policy
+}
+
+// mqKey is the key of the MQ destinations and bindings of a region of an environment
+func mqKey(orgID, envID, region string) string {
+	return orgID + "/" + envID + "/" + region
+}
+
+// AddMqDestination adds a queue or exchange to the region of the environment
+func (fake *Fake) AddMqDestination(orgID, envID, region string, destination anypointclient.MqDestination) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.putDestination(mqKey(orgID, envID, region), destination)
+}
+
+// MqDestination returns the queue or exchange with the given ID, and whether it exists
+func (fake *Fake) MqDestination(orgID, envID, region, destinationID string) (anypointclient.MqDestination, bool) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	destination, ok := fake.destinations[mqKey(orgID, envID, region)][destinationID]
+	return destination, ok
+}
+
+// MqBindings returns the bindings of an exchange
+func (fake *Fake) MqBindings(orgID, envID, region, exchangeID string) []anypointclient.MqBinding {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	return slices.Clone(fake.bindings[mqKey(orgID, envID, region)+"/"+exchangeID])
+}
+
+// putDestination adds or replaces a queue or exchange. The lock must be held.
+func (fake *Fake) putDestination(key string, destination anypointclient.MqDestination) {
+	if fake.destinations[key] == nil {
+		fake.destinations[key] = map[string]anypointclient.MqDestination{}
+	}
+	id := destination.QueueID
+	if destination.Type == "exchange" {
+		id = destination.ExchangeID
+	}
+	fake.destinations[key][id] = destination
+}
+
+// GetMqDestinations returns every queue and exchange in the region, ordered by ID
+func (fake *Fake) GetMqDestinations(orgID, envID, region string) ([]anypointclient.MqDestination, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("GetMqDestinations"); err != nil {
+		return nil, err
+	}
+	destinations := fake.destinations[mqKey(orgID, envID, region)]
+	var all []anypointclient.MqDestination
+	for _, id := range slices.Sorted(maps.Keys(destinations)) {
+		all = append(all, destinations[id])
+	}
+	return all, nil
+}
+
+// GetMqQueue returns the queue, or nil if there is none
+func (fake *Fake) GetMqQueue(orgID, envID, region, queueID string) (*anypointclient.MqDestination, error) {
+	return fake.getDestination("GetMqQueue", "queue", orgID, envID, region, queueID)
+}
+
+// GetMqExchange returns the exchange, or nil if there is none
+func (fake *Fake) GetMqExchange(orgID, envID, region, exchangeID string) (*anypointclient.MqDestination, error) {
+	return fake.getDestination("GetMqExchange", "exchange", orgID, envID, region, exchangeID)
+}
+
+func (fake *Fake) getDestination(method, destinationType, orgID, envID, region, destinationID string) (*anypointclient.MqDestination, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call(method); err != nil {
+		return nil, err
+	}
+	destination, ok := fake.destinations[mqKey(orgID, envID, region)][destinationID]
+	if !ok || destination.Type != destinationType {
+		return nil, nil
+	}
+	return &destination, nil
+}
+
+// CreateMqQueue creates the queue
+func (fake *Fake) CreateMqQueue(orgID, envID, region string, queue anypointclient.MqQueue) error {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("CreateMqQueue"); err != nil {
+		return err
+	}
+	key := mqKey(orgID, envID, region)
+	if _, ok := fake.destinations[key][queue.QueueID]; ok {
+		return errors.Errorf("queue %s already exists", queue.QueueID)
+	}
+	fake.putDestination(key, queueDestination(queue))
+	return nil
+}
+
+// UpdateMqQueue replaces the settings of the queue
+func (fake *Fake) UpdateMqQueue(orgID, envID, region string, queue anypointclient.MqQueue) error {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("UpdateMqQueue"); err != nil {
+		return err
+	}
+	key := mqKey(orgID, envID, region)
+	if existing, ok := fake.destinations[key][queue.QueueID]; !ok || existing.Type != "queue" {
+		return errors.Errorf("queue %s not found", queue.QueueID)
+	}
+	fake.putDestination(key, queueDestination(queue))
+	return nil
+}
+
+// queueDestination returns the queue as listed by Anypoint MQ, which encrypts queues unless told otherwise
+func queueDestination(queue anypointclient.MqQueue) anypointclient.MqDestination {
+	return anypointclient.MqDestination{
+		Type:                 "queue",
+		QueueID:              queue.QueueID,
+		Fifo:                 queue.Fifo,
+		Encrypted:            queue.Encrypted == nil || *queue.Encrypted,
+		MaxDeliveries:        queue.MaxDeliveries,
+		DeadLetterQueueID:    queue.DeadLetterQueueID,
+		IsFallback:           queue.IsFallback,
+		DefaultTtl:           queue.DefaultTtl,
+		DefaultLockTtl:       queue.DefaultLockTtl,
+		DefaultDeliveryDelay: queue.DefaultDeliveryDelay,
+	}
+}
+
+// CreateMqExchange creates the exchange, or replaces its settings if it exists
+func (fake *Fake) CreateMqExchange(orgID, envID, region string, exchange anypointclient.MqExchange) error {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("CreateMqExchange"); err != nil {
+		return err
+	}
+	fake.putDestination(mqKey(orgID, envID, region), anypointclient.MqDestination{
+		Type:       "exchange",
+		ExchangeID: exchange.ExchangeID,
+		Fifo:       exchange.Fifo,
+		Encrypted:  exchange.Encrypted == nil || *exchange.Encrypted,
+	})
+	return nil
+}
+
+// GetMqExchangeBindings returns the bindings of the exchange
+func (fake *Fake) GetMqExchangeBindings(orgID, envID, region, exchangeID string) ([]anypointclient.MqBinding, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("GetMqExchangeBindings"); err != nil {
+		return nil, err
+	}
+	return slices.Clone(fake.bindings[mqKey(orgID, envID, region)+"/"+exchangeID]), nil
+}
+
+// CreateMqBinding binds the queue to the exchange. Both must exist.
+func (fake *Fake) CreateMqBinding(orgID, envID, region, exchangeID, queueID string) error {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("CreateMqBinding"); err != nil {
+		return err
+	}
+	key := mqKey(orgID, envID, region)
+	if destination, ok := fake.destinations[key][exchangeID]; !ok || destination.Type != "exchange" {
+		return errors.Errorf("exchange %s not found", exchangeID)
+	}
+	if destination, ok := fake.destinations[key][queueID]; !ok || destination.Type != "queue" {
+		return errors.Errorf("queue %s not found", queueID)
+	}
+	bindings := fake.bindings[key+"/"+exchangeID]
+	if slices.ContainsFunc(bindings, func(binding anypointclient.MqBinding) bool { return binding.QueueID == queueID }) {
+		return errors.Errorf("queue %s is already bound to exchange %s", queueID, exchangeID)
+	}
+	fake.bindings[key+"/"+exchangeID] = append(bindings, anypointclient.MqBinding{QueueID: queueID, ExchangeID: exchangeID})
+	return nil
+}
+
+// UpdateMqBindingRoutingRules replaces the routing rules of the binding
+func (fake *Fake) UpdateMqBindingRoutingRules(orgID, envID, region, exchangeID, queueID string, routingRules []anypointclient.MqRoutingRule) error {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("UpdateMqBindingRoutingRules"); err != nil {
+		return err
+	}
+	bindings := fake.bindings[mqKey(orgID, envID, region)+"/"+exchangeID]
+	i := slices.IndexFunc(bindings, func(binding anypointclient.MqBinding) bool { return binding.QueueID == queueID })
+	if i < 0 {
+		return errors.Errorf("queue %s is not bound to exchange %s", queueID, exchangeID)
+	}
+	bindings[i].RoutingRules = slices.Clone(routingRules)
+	return nil
+}
+
+// DeleteMqBinding unbinds the queue from the exchange
+func (fake *Fake) DeleteMqBinding(orgID, envID, region, exchangeID, queueID string) error {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("DeleteMqBinding"); err != nil {
+		return err
+	}
+	key := mqKey(orgID, envID, region) + "/" + exchangeID
+	bindings := fake.bindings[key]
+	i := slices.IndexFunc(bindings, func(binding anypointclient.MqBinding) bool { return binding.QueueID == queueID })
+	if i < 0 {
+		return errors.Errorf("queue %s is not bound to exchange %s", queueID, exchangeID)
+	}
+	fake.bindings[key] = slices.Delete(bindings, i, i+1)
+	return nil
+}
+
+// DeleteMqQueue deletes the queue and its bindings. A queue that does not exist is already deleted.
+func (fake *Fake) DeleteMqQueue(orgID, envID, region, queueID string) error {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("DeleteMqQueue"); err != nil {
+		return err
+	}
+	key := mqKey(orgID, envID, region)
+	if destination, ok := fake.destinations[key][queueID]; !ok || destination.Type != "queue" {
+		return nil
+	}
+	delete(fake.destinations[key], queueID)
+	delete(fake.queueStats, key+"/"+queueID)
+	for id, destination := range fake.destinations[key] {
+		if destination.Type == "exchange" {
+			fake.bindings[key+"/"+id] = slices.DeleteFunc(fake.bindings[key+"/"+id], func(binding anypointclient.MqBinding) bool { return binding.QueueID == queueID })
+		}
+	}
+	return nil
+}
+
+// DeleteMqExchange deletes the exchange and its bindings. An exchange that does not exist is already deleted.
+func (fake *Fake) DeleteMqExchange(orgID, envID, region, exchangeID string) error {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("DeleteMqExchange"); err != nil {
+		return err
+	}
+	key := mqKey(orgID, envID, region)
+	if destination, ok := fake.destinations[key][exchangeID]; !ok || destination.Type != "exchange" {
+		return nil
+	}
+	delete(fake.destinations[key], exchangeID)
+	delete(fake.bindings, key+"/"+exchangeID)
+	return nil
+}
+
+// SetMqQueueMessages sets the number of messages waiting and in flight in the queue, as returned by GetMqQueueStats
+func (fake *Fake) SetMqQueueMessages(orgID, envID, region, queueID string, messages, inflightMessages int64) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.queueStats[mqKey(orgID, envID, region)+"/"+queueID] = anypointclient.MqQueueStats{
+		Destination:      queueID,
+		Messages:         messages,
+		InflightMessages: inflightMessages,
+	}
+}
+
+// GetMqQueueStats returns the messages set with SetMqQueueMessages. Queues are empty unless set otherwise.
+func (fake *Fake) GetMqQueueStats(orgID, envID, region, queueID string) (anypointclient.MqQueueStats, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("GetMqQueueStats"); err != nil {
+		return anypointclient.MqQueueStats{}, err
+	}
+	key := mqKey(orgID, envID, region)
+	if destination, ok := fake.destinations[key][queueID]; !ok || destination.Type != "queue" {
+		return anypointclient.MqQueueStats{}, errors.Errorf("queue %s not found", queueID)
+	}
+	if stats, ok := fake.queueStats[key+"/"+queueID]; ok {
+		return stats, nil
+	}
+	return anypointclient.MqQueueStats{Destination: queueID}, nil
+}
+
+// AddExchangeAsset adds an asset to the Exchange of the organization
+func (fake *Fake) AddExchangeAsset(orgId string, asset anypointclient.ExchangeAsset) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.assets[orgId] = append(fake.assets[orgId], asset)
+}
+
+// GetExchangeAssets returns a page of the assets of the organization
+func (fake *Fake) GetExchangeAssets(orgId string, offset int, limit int) (*[]anypointclient.ExchangeAsset, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("GetExchangeAssets"); err != nil {
+		return nil, err
+	}
+	assets := page(fake.assets[orgId], offset, limit)
+	return &assets, nil
+}
+
+// GetExchangeAssetsDetails returns the asset of the organization with the given asset ID
+func (fake *Fake) GetExchangeAssetsDetails(orgId string, assetId string) (*anypointclient.ExchangeAsset, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("GetExchangeAssetsDetails"); err != nil {
+		return nil, err
+	}
+	for _, asset := range fake.assets[orgId] {
+		if asset.AssetID == assetId {
+			return &asset, nil
+		}
+	}
+	return nil, errors.Errorf("asset %s not found in organization %s", assetId, orgId)
+}
+
+// UpdateExchangeApiManagedInstanceUrl checks that the asset exists. The instance URLs are not kept.
+func (fake *Fake) UpdateExchangeApiManagedInstanceUrl(orgId string, assetId string, versionGroup string, instanceId string, newURL string) error {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.call("UpdateExchangeApiManagedInstanceUrl"); err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(fake.assets[orgId], func(asset anypointclient.ExchangeAsset) bool { return asset.AssetID == assetId }) {
+		return errors.Errorf("asset %s not found in organization %s", assetId, orgId)
+	}
+	return nil
+}
+
+// page returns a copy of the items from offset, at most limit of them
+func page[T any](items []T, offset int, limit int) []T {
+	if offset >= len(items) {
+		return []T{}
+	}
+	return slices.Clone(items[offset:min(offset+limit, len(items))])
+}
@@ -0,0 +1,196 @@
+package anypointclienttest_test
+
+import (
+	"errors"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient"
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient/anypointclienttest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Fake", func() {
+	var fake *anypointclienttest.Fake
+	organization := anypointclient.Organization{ID: "org-id", Name: "Root", SubOrganizations: []anypointclient.Organization{{ID: "sub-id", Name: "Sub"}}}
+	environment := anypointclient.Environment{ID: "env-id", Name: "Sandbox", OrganizationID: "org-id"}
+
+	BeforeEach(func() {
+		fake = anypointclienttest.NewFake(organization)
+		fake.AddEnvironment(environment)
+	})
+
+	It("resolves organizations and environments", func() {
+		sub, err := fake.ResolveOrganization("Root/Sub")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(sub.ID).Should(Equal("sub-id"))
+		_, err = fake.ResolveOrganization("Other")
+		Ω(err).Should(HaveOccurred())
+
+		env, err := fake.ResolveEnvironment(organization, "Sandbox")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(env).Should(Equal(environment))
+		_, err = fake.ResolveEnvironment(organization, "Production")
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("creates and updates deployments that roll out at once", func() {
+		var req anypointclient.CloudhubDeploymentReq
+		req.Name = "app"
+		req.Target.Replicas = 2
+		req.Application.Ref.Version = "1.0.0"
+		req.Application.Configuration.MuleAgentApplicationPropertiesService.SecureProperties = map[string]string{"password": "secret"}
+
+		created, err := fake.CreateDeployment(environment, anypointclient.PrivateSpace{}, req)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(created.ID).ShouldNot(BeEmpty())
+		Ω(created.Rollout()).Should(Equal(anypointclient.RolloutSucceeded))
+		Ω(created.Replicas).Should(HaveLen(2))
+		Ω(created.Application.Configuration.MuleAgentApplicationPropertiesService.SecureProperties).Should(Equal(map[string]string{"password": "****"}))
+
+		_, err = fake.CreateDeployment(environment, anypointclient.PrivateSpace{}, req)
+		Ω(err).Should(HaveOccurred())
+
+		req.Application.Ref.Version = "1.1.0"
+		Ω(fake.UpdateDeployment(environment, anypointclient.PrivateSpace{}, req, created.ID)).Should(Succeed())
+		deployment, err := fake.GetDeployment(environment, "app")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(deployment.ID).Should(Equal(created.ID))
+		Ω(deployment.Application.Ref.Version).Should(Equal("1.1.0"))
+		Ω(deployment.Rollout()).Should(Equal(anypointclient.RolloutSucceeded))
+
+		deployments, err := fake.GetDeployments(environment)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(deployments).Should(HaveLen(1))
+
+		Ω(fake.DeleteDeployment(environment, anypointclient.PrivateSpace{}, created.ID)).Should(Succeed())
+		deployment, err = fake.GetDeployment(environment, "app")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(deployment.Name).Should(BeEmpty())
+	})
+
+	It("compares schedulers with the ones of the source code", func() {
+		var req anypointclient.CloudhubDeploymentReq
+		req.Application.Configuration.MuleAgentScheduleService.Schedulers = []anypointclient.Schedule{{FlowName: "poll", Type: "FixedFrequency"}}
+		Ω(fake.SchedulesDiffFromSourceCode(environment, req, "deployment-id")).Should(Succeed())
+
+		fake.SetSourceSchedulers("deployment-id", []anypointclient.Schedule{{FlowName: "other", Type: "FixedFrequency"}})
+		Ω(fake.SchedulesDiffFromSourceCode(environment, req, "deployment-id")).ShouldNot(Succeed())
+	})
+
+	It("injects failures", func() {
+		boom := errors.New("boom")
+		fake.FailOnce("Login", boom)
+		Ω(fake.Login()).Should(MatchError(boom))
+		Ω(fake.Login()).Should(Succeed())
+
+		fake.Fail("GetMqQueue", boom)
+		_, err := fake.GetMqQueue("org-id", "env-id", "us-east-1", "queue")
+		Ω(err).Should(MatchError(boom))
+		_, err = fake.GetMqQueue("org-id", "env-id", "us-east-1", "queue")
+		Ω(err).Should(MatchError(boom))
+		Ω(fake.Calls()).Should(Equal([]string{"Login", "Login", "GetMqQueue", "GetMqQueue"}))
+
+		fake.Reset()
+		_, err = fake.GetMqQueue("org-id", "env-id", "us-east-1", "queue")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(fake.Calls()).Should(Equal([]string{"GetMqQueue"}))
+	})
+
+	It("returns the deployment with an injected rollout failure", func() {
+		added := fake.AddDeployment(environment, anypointclient.CloudhubDeploymentResp{Name: "app"})
+		fake.FailOnce("WaitForRollout", errors.New("rollout failed"))
+		deployment, err := fake.WaitForRollout(environment, "app", 0, 0)
+		Ω(err).Should(HaveOccurred())
+		Ω(deployment.ID).Should(Equal(added.ID))
+	})
+
+	It("keeps API instances", func() {
+		request := anypointclient.ApiInstanceRequest{
+			Spec:       anypointclient.ApiInstanceAsset{GroupID: "org-id", AssetID: "orders-api", Version: "1.0.0"},
+			Endpoint:   anypointclient.ApiInstanceEndpoint{DeploymentType: "CH2", URI: "https://orders.example.com"},
+			Technology: "mule4",
+		}
+		created, err := fake.CreateApiInstance("org-id", "env-id", request)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(created.ProductVersion).Should(Equal("v1"))
+
+		request.Spec.Version = "1.1.0"
+		_, err = fake.UpdateApiInstance("org-id", "env-id", created.ID, request)
+		Ω(err).ShouldNot(HaveOccurred())
+		instance, err := fake.GetApiInstance("org-id", "env-id", created.ID)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(instance.AssetVersion).Should(Equal("1.1.0"))
+		Ω(instance.Endpoint.URI).Should(Equal("https://orders.example.com"))
+
+		_, err = fake.GetApiInstance("org-id", "env-id", 999)
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("keeps API policies", func() {
+		api := fake.AddApi(environment, anypointclient.ApiInstance{AssetID: "orders-api"})
+		apis, err := fake.GetApis("org-id", "env-id", 0, 10)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(apis.Total).Should(Equal(1))
+		Ω(apis.Instances[0].ID).Should(Equal(api.ID))
+
+		Ω(fake.CreateApiInstancePolicies("org-id", "env-id", api.ID, anypointclient.ApiPolicyRequest{GroupID: "g", AssetID: "rate-limiting", AssetVersion: "1.0.0"})).Should(Succeed())
+		policies, err := fake.GetApiInstancePolicies("org-id", "env-id", api.ID)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(*policies).Should(HaveLen(1))
+		policy := (*policies)[0]
+		Ω(policy.Template.AssetID).Should(Equal("rate-limiting"))
+		Ω(policy.Order).Should(Equal(1))
+
+		Ω(fake.UpdateApiInstancePolicies("org-id", "env-id", api.ID, policy.PolicyID, anypointclient.ApiPolicyRequest{GroupID: "g", AssetID: "rate-limiting", AssetVersion: "1.1.0"})).Should(Succeed())
+		Ω(fake.Policies(api.ID)[0].Template.AssetVersion).Should(Equal("1.1.0"))
+		Ω(fake.UpdateApiInstancePolicies("org-id", "env-id", api.ID, 999, anypointclient.ApiPolicyRequest{})).ShouldNot(Succeed())
+		Ω(fake.ReorderApiInstancePolicies("org-id", "env-id", api.ID, []anypointclient.ApiPolicyOrder{{ID: policy.PolicyID, Order: 2}})).Should(Succeed())
+		Ω(fake.Policies(api.ID)[0].Order).Should(Equal(2))
+		Ω(fake.ReorderApiInstancePolicies("org-id", "env-id", api.ID, []anypointclient.ApiPolicyOrder{{ID: 999, Order: 1}})).ShouldNot(Succeed())
+		Ω(fake.DeleteApiInstancePolicy("org-id", "env-id", api.ID, policy.PolicyID)).Should(Succeed())
+		Ω(fake.Policies(api.ID)).Should(BeEmpty())
+	})
+
+	It("keeps MQ destinations and bindings", func() {
+		Ω(fake.CreateMqQueue("org-id", "env-id", "us-east-1", anypointclient.MqQueue{QueueID: "orders"})).Should(Succeed())
+		queue, err := fake.GetMqQueue("org-id", "env-id", "us-east-1", "orders")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(queue.Encrypted).Should(BeTrue())
+		exchange, err := fake.GetMqExchange("org-id", "env-id", "us-east-1", "orders")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(exchange).Should(BeNil())
+
+		Ω(fake.CreateMqBinding("org-id", "env-id", "us-east-1", "events", "orders")).ShouldNot(Succeed())
+		Ω(fake.CreateMqExchange("org-id", "env-id", "us-east-1", anypointclient.MqExchange{ExchangeID: "events"})).Should(Succeed())
+		Ω(fake.CreateMqBinding("org-id", "env-id", "us-east-1", "events", "orders")).Should(Succeed())
+		rules := []anypointclient.MqRoutingRule{{PropertyName: "type", PropertyType: "STRING", MatcherType: "EQ", Value: "order"}}
+		Ω(fake.UpdateMqBindingRoutingRules("org-id", "env-id", "us-east-1", "events", "orders", rules)).Should(Succeed())
+		bindings, err := fake.GetMqExchangeBindings("org-id", "env-id", "us-east-1", "events")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(bindings).Should(Equal([]anypointclient.MqBinding{{QueueID: "orders", ExchangeID: "events", RoutingRules: rules}}))
+
+		destinations, err := fake.GetMqDestinations("org-id", "env-id", "us-east-1")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(destinations).Should(HaveLen(2))
+
+		Ω(fake.DeleteMqBinding("org-id", "env-id", "us-east-1", "events", "orders")).Should(Succeed())
+		Ω(fake.MqBindings("org-id", "env-id", "us-east-1", "events")).Should(BeEmpty())
+	})
+
+	It("deletes MQ destinations together with their bindings", func() {
+		fake.AddMqDestination("org-id", "env-id", "us-east-1", anypointclient.MqDestination{Type: "queue", QueueID: "orders"})
+		fake.AddMqDestination("org-id", "env-id", "us-east-1", anypointclient.MqDestination{Type: "exchange", ExchangeID: "events"})
+		Ω(fake.CreateMqBinding("org-id", "env-id", "us-east-1", "events", "orders")).Should(Succeed())
+		fake.SetMqQueueMessages("org-id", "env-id", "us-east-1", "orders", 2, 0)
+		stats, err := fake.GetMqQueueStats("org-id", "env-id", "us-east-1", "orders")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(stats.Messages).Should(Equal(int64(2)))
+
+		Ω(fake.DeleteMqQueue("org-id", "env-id", "us-east-1", "orders")).Should(Succeed())
+		Ω(fake.MqBindings("org-id", "env-id", "us-east-1", "events")).Should(BeEmpty())
+		Ω(fake.DeleteMqExchange("org-id", "env-id", "us-east-1", "events")).Should(Succeed())
+		destinations, err := fake.GetMqDestinations("org-id", "env-id", "us-east-1")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(destinations).Should(BeEmpty())
+	})
+})
@@ -0,0 +1,74 @@
+package anypointclient
+
+import "time"
+
+// CloudHubDeployments is the CloudHub 2.0 deployment API of Anypoint Platform. Like the other interfaces in this
+// file it groups the methods of AnypointClient by area, so that callers can be tested against anypointclienttest.
+type CloudHubDeployments interface {
+	GetDeployments(environment Environment) ([]Deployment, error)
+	GetDeployment(environment Environment, deploymentName string) (CloudhubDeploymentResp, error)
+	CreateDeployment(environment Environment, privateSpace PrivateSpace, deployment CloudhubDeploymentReq) (CloudhubDeploymentResp, error)
+	UpdateDeployment(environment Environment, privateSpace PrivateSpace, deployment CloudhubDeploymentReq, deploymentID string) error
+	DeleteDeployment(environment Environment, privateSpace PrivateSpace, deploymentID string) error
+	SchedulesDiffFromSourceCode(environment Environment, newDeployment CloudhubDeploymentReq, deploymentID string) error
+	WaitForRollout(environment Environment, deploymentName string, timeout time.Duration, interval time.Duration) (CloudhubDeploymentResp, error)
+}
+
+// ApiManager is the API Manager API of Anypoint Platform, managing API instances and their policies
+type ApiManager interface {
+	GetApis(orgId string, envId string, offset int, limit int) (*ApiListResponse, error)
+	ApisPaginator(orgId string, envId string) *Paginator[ApiInstance]
+	GetApiInstance(orgId string, envId string, apiInstanceID int) (*ApiInstance, error)
+	CreateApiInstance(orgId string, envId string, instance ApiInstanceRequest) (*ApiInstance, error)
+	UpdateApiInstance(orgId string, envId string, apiInstanceID int, instance ApiInstanceRequest) (*ApiInstance, error)
+	GetApiInstancePolicies(orgId string, envId string, apiInstanceID int) (*[]ApiPolicyResponse, error)
+	CreateApiInstancePolicies(orgId string, envId string, apiInstanceID int, apipolicy ApiPolicyRequest) error
+	UpdateApiInstancePolicies(orgId string, envId string, apiInstanceID int, policyID int, apipolicy ApiPolicyRequest) error
+	DeleteApiInstancePolicy(orgId string, envId string, apiInstanceID int, policyID int) error
+	ReorderApiInstancePolicies(orgId string, envId string, apiInstanceID int, order []ApiPolicyOrder) error
+}
+
+// MqAdmin is the Anypoint MQ admin API of Anypoint Platform, managing queues, exchanges and bindings
+type MqAdmin interface {
+	GetMqDestinations(orgID, envID, region string) ([]MqDestination, error)
+	GetMqQueue(orgID, envID, region, queueID string) (*MqDestination, error)
+	CreateMqQueue(orgID, envID, region string, queue MqQueue) error
+	UpdateMqQueue(orgID, envID, region string, queue MqQueue) error
+	GetMqExchange(orgID, envID, region, exchangeID string) (*MqDestination, error)
+	CreateMqExchange(orgID, envID, region string, exchange MqExchange) error
+	GetMqExchangeBindings(orgID, envID, region, exchangeID string) ([]MqBinding, error)
+	CreateMqBinding(orgID, envID, region, exchangeID, queueID string) error
+	UpdateMqBindingRoutingRules(orgID, envID, region, exchangeID, queueID string, routingRules []MqRoutingRule) error
+	DeleteMqBinding(orgID, envID, region, exchangeID, queueID string) error
+	DeleteMqQueue(orgID, envID, region, queueID string) error
+	DeleteMqExchange(orgID, envID, region, exchangeID string) error
+	GetMqQueueStats(orgID, envID, region, queueID string) (MqQueueStats, error)
+}
+
+// Exchange is the Exchange API of Anypoint Platform, holding the assets that API instances are created from
+type Exchange interface {
+	GetExchangeAssets(orgId string, offset int, limit int) (*[]ExchangeAsset, error)
+	GetExchangeAssetsDetails(orgId string, assetId string) (*ExchangeAsset, error)
+	UpdateExchangeApiManagedInstanceUrl(orgId string, assetId string, versionGroup string, instanceId string, newURL string) error
+}
+
+// AccessManagement is the Access Management API of Anypoint Platform, used to log in and resolve
+// organizations, environments and private spaces by name
+type AccessManagement interface {
+	Login() error
+	ResolveOrganization(organizationPath string) (Organization, error)
+	ResolveEnvironment(organization Organization, environmentName string) (Environment, error)
+	EnvironmentsPaginator(organization Organization) *Paginator[Environment]
+	ResolvePrivateSpace(organization Organization, privateSpaceName string) (PrivateSpace, error)
+}
+
+// AnypointAPI is every area of Anypoint Platform used by this module
+type AnypointAPI interface {
+	AccessManagement
+	CloudHubDeployments
+	ApiManager
+	MqAdmin
+	Exchange
+}
+
+var _ AnypointAPI = (*AnypointClient)(nil)
@@ -2,6 +2,7 @@ package anypointclient
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -12,46 +13,123 @@ import (
 )
 
 type ApiListResponse struct {
-	Total     int `json:"total"`
-	Instances []struct {
-		Audit struct {
-			Created struct {
-				Date time.Time `json:"date"`
-			} `json:"created"`
-			Updated struct {
-				Date time.Time `json:"date"`
-			} `json:"updated"`
-		} `json:"audit"`
-		MasterOrganizationID string    `json:"masterOrganizationId"`
-		OrganizationID       string    `json:"organizationId"`
-		ID                   int       `json:"id"`
-		InstanceLabel        string    `json:"instanceLabel"`
-		GroupID              string    `json:"groupId"`
-		AssetID              string    `json:"assetId"`
-		AssetVersion         string    `json:"assetVersion"`
-		ProductVersion       string    `json:"productVersion"`
-		Description          any       `json:"description"`
-		Tags                 []any     `json:"tags"`
-		Order                int       `json:"order"`
-		ProviderID           any       `json:"providerId"`
-		Deprecated           bool      `json:"deprecated"`
-		LastActiveDate       time.Time `json:"lastActiveDate"`
-		EndpointURI          string    `json:"endpointUri"`
-		EnvironmentID        string    `json:"environmentId"`
-		IsPublic             bool      `json:"isPublic"`
-		Stage                string    `json:"stage"`
-		Technology           string    `json:"technology"`
-		LastActiveDelta      int       `json:"lastActiveDelta,omitempty"`
-		Pinned               bool      `json:"pinned"`
-		ActiveContractsCount int       `json:"activeContractsCount"`
-		Asset                struct {
-			Name              string `json:"name"`
-			ExchangeAssetName string `json:"exchangeAssetName"`
-			GroupID           string `json:"groupId"`
-			AssetID           string `json:"assetId"`
-		} `json:"asset"`
-		AutodiscoveryInstanceName string `json:"autodiscoveryInstanceName"`
-	} `json:"instances"`
+	Total     int           `json:"total"`
+	Instances []ApiInstance `json:"instances"`
+}
+
+// ApiInstance is an API instance managed in API Manager
+type ApiInstance struct {
+	Audit struct {
+		Created struct {
+			Date time.Time `json:"date"`
+		} `json:"created"`
+		Updated struct {
+			Date time.Time `json:"date"`
+		} `json:"updated"`
+	} `json:"audit"`
+	MasterOrganizationID string    `json:"masterOrganizationId"`
+	OrganizationID       string    `json:"organizationId"`
+	ID                   int       `json:"id"`
+	InstanceLabel        string    `json:"instanceLabel"`
+	GroupID              string    `json:"groupId"`
+	AssetID              string    `json:"assetId"`
+	AssetVersion         string    `json:"assetVersion"`
+	ProductVersion       string    `json:"productVersion"`
+	Description          any       `json:"description"`
+	Tags                 []any     `json:"tags"`
+	Order                int       `json:"order"`
+	ProviderID           any       `json:"providerId"`
+	Deprecated           bool      `json:"deprecated"`
+	LastActiveDate       time.Time `json:"lastActiveDate"`
+	EndpointURI          string    `json:"endpointUri"`
+	EnvironmentID        string    `json:"environmentId"`
+	IsPublic             bool      `json:"isPublic"`
+	Stage                string    `json:"stage"`
+	Technology           string    `json:"technology"`
+	LastActiveDelta      int       `json:"lastActiveDelta,omitempty"`
+	Pinned               bool      `json:"pinned"`
+	ActiveContractsCount int       `json:"activeContractsCount"`
+	Asset                struct {
+		Name              string `json:"name"`
+		ExchangeAssetName string `json:"exchangeAssetName"`
+		GroupID           string `json:"groupId"`
+		AssetID           string `json:"assetId"`
+	} `json:"asset"`
+	AutodiscoveryInstanceName string `json:"autodiscoveryInstanceName"`
+	// Endpoint is only returned when a single API instance is fetched
+	Endpoint *ApiInstanceEndpoint `json:"endpoint,omitempty"`
+}
+
+// ApiInstanceRequest creates or updates an API instance managing an API asset from Exchange
+type ApiInstanceRequest struct {
+	Spec          ApiInstanceAsset    `json:"spec"`
+	Endpoint      ApiInstanceEndpoint `json:"endpoint"`
+	Technology    string              `json:"technology,omitempty"`
+	InstanceLabel string              `json:"instanceLabel,omitempty"`
+}
+
+// ApiInstanceAsset is the Exchange asset managed by an API instance
+type ApiInstanceAsset struct {
+	GroupID string `json:"groupId"`
+	AssetID string `json:"assetId"`
+	Version string `json:"version"`
+}
+
+// ApiInstanceEndpoint is where the implementation of an API instance runs
+type ApiInstanceEndpoint struct {
+	DeploymentType string `json:"deploymentType,omitempty"`
+	URI            string `json:"uri,omitempty"`
+}
+
+/*
+ApiInstanceSelector selects an API instance without its numeric ID, which differs in every environment. It selects
+either by the autodiscovery name, or by the Exchange asset narrowed down by the product version and instance label.
+*/
+type ApiInstanceSelector struct {
+	GroupID           string `json:"groupId,omitempty"`
+	AssetID           string `json:"assetId,omitempty"`
+	ProductVersion    string `json:"productVersion,omitempty"`
+	InstanceLabel     string `json:"instanceLabel,omitempty"`
+	AutodiscoveryName string `json:"autodiscoveryName,omitempty"`
+}
+
+// Validate returns an error unless the selector has either an autodiscovery name or an asset
+func (selector ApiInstanceSelector) Validate() error {
+	byAsset := selector.GroupID != "" || selector.AssetID != "" || selector.ProductVersion != "" || selector.InstanceLabel != ""
+	switch {
+	case selector.AutodiscoveryName != "" && byAsset:
+		return errors.New("an API instance selector has either autodiscoveryName or the asset, not both")
+	case selector.AutodiscoveryName != "":
+		return nil
+	case selector.GroupID == "" || selector.AssetID == "":
+		return errors.New("an API instance selector needs autodiscoveryName, or groupId and assetId")
+	}
+	return nil
+}
+
+// Matches returns true if the API instance is selected
+func (selector ApiInstanceSelector) Matches(instance ApiInstance) bool {
+	if selector.AutodiscoveryName != "" {
+		return instance.AutodiscoveryInstanceName == selector.AutodiscoveryName
+	}
+	return instance.GroupID == selector.GroupID &&
+		instance.AssetID == selector.AssetID &&
+		(selector.ProductVersion == "" || instance.ProductVersion == selector.ProductVersion) &&
+		(selector.InstanceLabel == "" || instance.InstanceLabel == selector.InstanceLabel)
+}
+
+func (selector ApiInstanceSelector) String() string {
+	if selector.AutodiscoveryName != "" {
+		return "autodiscoveryName=" + selector.AutodiscoveryName
+	}
+	description := fmt.Sprintf("asset=%s:%s", selector.GroupID, selector.AssetID)
+	if selector.ProductVersion != "" {
+		description += " productVersion=" + selector.ProductVersion
+	}
+	if selector.InstanceLabel != "" {
+		description += " instanceLabel=" + selector.InstanceLabel
+	}
+	return description
 }
 
 type ApiPolicyResponse struct {
@@ -86,7 +164,7 @@ type ApiPolicyResponse struct {
 type ApiPolicyRequest struct {
 	ConfigurationData map[string]any `json:"configurationData,omitempty"`
 	Order             int            `json:"order,omitempty"`
-	Disabled          bool           `json:"disabled,omitempty"`
+	Disabled          bool           `json:"disabled"` // Sent when false, so that a disabled policy is enabled again
 	PointcutData      any            `json:"pointcutData,omitempty"`
 	GroupID           string         `json:"groupId,omitempty"`
 	AssetID           string         `json:"assetId,omitempty"`
@@ -104,6 +182,22 @@ type ImplementationAsset struct {
 }
 
 func (client *AnypointClient) GetApis(orgId string, envId string, offset int, limit int) (*ApiListResponse, error) {
+	return client.GetApisContext(client.context(), orgId, envId, offset, limit)
+}
+
+// ApisPaginator returns a paginator over the API instances of an environment, sorted by name
+func (client *AnypointClient) ApisPaginator(orgId string, envId string) *Paginator[ApiInstance] {
+	return NewPaginator(DefaultPageSize, func(ctx context.Context, offset int, limit int) (Page[ApiInstance], error) {
+		response, err := client.GetApisContext(ctx, orgId, envId, offset, limit)
+		if err != nil {
+			return Page[ApiInstance]{}, err
+		}
+		return Page[ApiInstance]{Items: response.Instances, Total: response.Total}, nil
+	})
+}
+
+// GetApisContext is like GetApis but uses the given context
+func (client *AnypointClient) GetApisContext(ctx context.Context, orgId string, envId string, offset int, limit int) (*ApiListResponse, error) {
 
 	getAPIUrl := fmt.Sprintf(
 		"apimanager/xapi/v1/organizations/%s/environments/%s/apis?ascending=true&limit=%d&offset=%d&sort=name",
@@ -112,25 +206,15 @@ func (client *AnypointClient) GetApis(orgId string, envId string, offset int, li
 		limit,
 		offset,
 	)
-	req, _ := client.newRequest("GET", getAPIUrl, nil)
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", client.bearer))
-	res, err := client.HTTPClient.Do(req)
+	req, _ := client.newRequest(ctx, "GET", getAPIUrl, nil)
+	res, err := client.do(req)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to call Anypoint Platform")
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		var response map[string]any
-		bodyBytes, err := io.ReadAll(res.Body)
-		err = json.Unmarshal(bodyBytes, &response)
-		if err != nil {
-			return nil,
-				errors.Wrapf(err, "Call to Anypoint Platform returned %d. Failed to decode error response payload", res.StatusCode)
-
-		}
-		return nil, errors.Errorf("Call to Anypoint Platform returned %d : %s",
-			res.StatusCode, response["message"])
+		return nil, newAPIError(res)
 	}
 
 	var response ApiListResponse
@@ -146,37 +230,47 @@ func (client *AnypointClient) GetApis(orgId string, envId string, offset int, li
 }
 
 func (client *AnypointClient) GetApiInstancePolicies(orgId string, envId string, apiInstanceID int) (*[]ApiPolicyResponse, error) {
+	return client.GetApiInstancePoliciesContext(client.context(), orgId, envId, apiInstanceID)
+}
+
+// GetApiInstancePoliciesContext is like GetApiInstancePolicies but uses the given context
+func (client *AnypointClient) GetApiInstancePoliciesContext(ctx context.Context, orgId string, envId string, apiInstanceID int) (*[]ApiPolicyResponse, error) {
 	getAPIInstancePolicyURL := fmt.Sprintf(
 		"apimanager/api/v1/organizations/%s/environments/%s/apis/%d/policies?fullInfo=true",
 		orgId,
 		envId,
 		apiInstanceID,
 	)
-	req, _ := client.newRequest("GET", getAPIInstancePolicyURL, nil)
-	res, err := client.HTTPClient.Do(req)
+	req, _ := client.newRequest(ctx, "GET", getAPIInstancePolicyURL, nil)
+	res, err := client.do(req)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to call Anypoint Platform")
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode != http.StatusOK {
+		return nil, newAPIError(res)
+	}
 	var response struct {
 		Policies []ApiPolicyResponse
-	} = struct{ Policies []ApiPolicyResponse }{}
-	if res.StatusCode == http.StatusOK {
-		bodyBytes, err := io.ReadAll(res.Body)
-		if err != nil {
-			return nil, errors.Wrapf(err, "failed to read response from Anypoint Platform")
-		}
-		err = json.Unmarshal(bodyBytes, &response)
-		if err != nil {
-			fmt.Printf("Failed to unmarshal response from Anypoint Platform: %v %s\n", err, string(bodyBytes))
-			return nil, errors.Wrapf(err, "failed to unmarshal response from Anypoint Platform")
-		}
+	}
+	bodyBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read response from Anypoint Platform")
+	}
+	err = json.Unmarshal(bodyBytes, &response)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal response from Anypoint Platform")
 	}
 	return &response.Policies, nil
 }
 
 func (client *AnypointClient) UpdateApiInstancePolicies(orgId string, envId string, apiInstanceID int, policyID int, apipolicy ApiPolicyRequest) error {
+	return client.UpdateApiInstancePoliciesContext(client.context(), orgId, envId, apiInstanceID, policyID, apipolicy)
+}
+
+// UpdateApiInstancePoliciesContext is like UpdateApiInstancePolicies but uses the given context
+func (client *AnypointClient) UpdateApiInstancePoliciesContext(ctx context.Context, orgId string, envId string, apiInstanceID int, policyID int, apipolicy ApiPolicyRequest) error {
 	updateAPIInstancePolicyURL := fmt.Sprintf(
 		"apimanager/api/v1/organizations/%s/environments/%s/apis/%d/policies/%d",
 		orgId,
@@ -188,26 +282,26 @@ func (client *AnypointClient) UpdateApiInstancePolicies(orgId string, envId stri
 	if err != nil {
 		return errors.Wrapf(err, "failed to marshal API Policy to JSON")
 	}
-	req, _ := client.newRequest("PATCH", updateAPIInstancePolicyURL, bytes.NewBuffer([]byte(updatePolicyPayload)))
+	req, _ := client.newRequest(ctx, "PATCH", updateAPIInstancePolicyURL, bytes.NewBuffer([]byte(updatePolicyPayload)))
 	req.Header.Set("Content-Type", "application/json;charset=utf-8")
-	res, err := client.HTTPClient.Do(req)
+	res, err := client.do(req)
 	if err != nil {
 		return errors.Wrapf(err, "failed to call Anypoint Platform")
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		bodyBytes, err := io.ReadAll(res.Body)
-		if err != nil {
-			return errors.Wrapf(err, "failed to read response from Anypoint Platform")
-		}
-		return errors.Errorf("Failed to update API Instance Policies status code: %d\nResponse Message:%s",
-			res.StatusCode, string(bodyBytes))
+		return newAPIError(res)
 	}
 
 	return nil
 }
 
 func (client *AnypointClient) CreateApiInstancePolicies(orgId string, envId string, apiInstanceID int, apipolicy ApiPolicyRequest) error {
+	return client.CreateApiInstancePoliciesContext(client.context(), orgId, envId, apiInstanceID, apipolicy)
+}
+
+// CreateApiInstancePoliciesContext is like CreateApiInstancePolicies but uses the given context
+func (client *AnypointClient) CreateApiInstancePoliciesContext(ctx context.Context, orgId string, envId string, apiInstanceID int, apipolicy ApiPolicyRequest) error {
 	createAPIInstancePolicyURL := fmt.Sprintf(
 		"apimanager/api/v1/organizations/%s/environments/%s/apis/%d/policies",
 		orgId,
@@ -218,21 +312,163 @@ func (client *AnypointClient) CreateApiInstancePolicies(orgId string, envId stri
 	if err != nil {
 		return errors.Wrapf(err, "failed to marshal API Policy to JSON")
 	}
-	req, _ := client.newRequest("POST", createAPIInstancePolicyURL, bytes.NewBuffer([]byte(updatePolicyPayload)))
+	req, _ := client.newRequest(ctx, "POST", createAPIInstancePolicyURL, bytes.NewBuffer([]byte(updatePolicyPayload)))
 	req.Header.Set("Content-Type", "application/json;charset=utf-8")
-	res, err := client.HTTPClient.Do(req)
+	res, err := client.do(req)
 	if err != nil {
 		return errors.Wrapf(err, "failed to call Anypoint Platform")
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusCreated {
-		bodyBytes, err := io.ReadAll(res.Body)
-		if err != nil {
-			return errors.Wrapf(err, "failed to read response from Anypoint Platform")
-		}
-		return errors.Errorf("Failed to update API Instance Policies status code: %d\nResponse Message:%s",
-			res.StatusCode, string(bodyBytes))
+		return newAPIError(res)
+	}
+
+	return nil
+}
+
+// DeleteApiInstancePolicy removes a policy from an API instance. A policy that does not exist is already removed.
+func (client *AnypointClient) DeleteApiInstancePolicy(orgId string, envId string, apiInstanceID int, policyID int) error {
+	return client.DeleteApiInstancePolicyContext(client.context(), orgId, envId, apiInstanceID, policyID)
+}
+
+// DeleteApiInstancePolicyContext is like DeleteApiInstancePolicy but uses the given context
+func (client *AnypointClient) DeleteApiInstancePolicyContext(ctx context.Context, orgId string, envId string, apiInstanceID int, policyID int) error {
+	deleteAPIInstancePolicyURL := fmt.Sprintf(
+		"apimanager/api/v1/organizations/%s/environments/%s/apis/%d/policies/%d",
+		orgId,
+		envId,
+		apiInstanceID,
+		policyID,
+	)
+	req, _ := client.newRequest(ctx, "DELETE", deleteAPIInstancePolicyURL, nil)
+	res, err := client.do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to call Anypoint Platform")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNotFound {
+		return newAPIError(res)
 	}
 
 	return nil
 }
+
+// ApiPolicyOrder is the position of a policy in the chain of policies of an API instance, starting at 1
+type ApiPolicyOrder struct {
+	ID    int `json:"id"`
+	Order int `json:"order"`
+}
+
+// ReorderApiInstancePolicies sets the order in which the policies of an API instance are applied
+func (client *AnypointClient) ReorderApiInstancePolicies(orgId string, envId string, apiInstanceID int, order []ApiPolicyOrder) error {
+	return client.ReorderApiInstancePoliciesContext(client.context(), orgId, envId, apiInstanceID, order)
+}
+
+// ReorderApiInstancePoliciesContext is like ReorderApiInstancePolicies but uses the given context
+func (client *AnypointClient) ReorderApiInstancePoliciesContext(ctx context.Context, orgId string, envId string, apiInstanceID int, order []ApiPolicyOrder) error {
+	reorderAPIInstancePoliciesURL := fmt.Sprintf(
+		"apimanager/api/v1/organizations/%s/environments/%s/apis/%d/policies",
+		orgId,
+		envId,
+		apiInstanceID,
+	)
+	reorderPayload, err := json.Marshal(order)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal API Policy order to JSON")
+	}
+	req, _ := client.newRequest(ctx, "PATCH", reorderAPIInstancePoliciesURL, bytes.NewBuffer(reorderPayload))
+	req.Header.Set("Content-Type", "application/json;charset=utf-8")
+	res, err := client.do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to call Anypoint Platform")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
+		return newAPIError(res)
+	}
+
+	return nil
+}
+
+// GetApiInstance returns the API instance with its endpoint
+func (client *AnypointClient) GetApiInstance(orgId string, envId string, apiInstanceID int) (*ApiInstance, error) {
+	return client.GetApiInstanceContext(client.context(), orgId, envId, apiInstanceID)
+}
+
+// GetApiInstanceContext is like GetApiInstance but uses the given context
+func (client *AnypointClient) GetApiInstanceContext(ctx context.Context, orgId string, envId string, apiInstanceID int) (*ApiInstance, error) {
+	getAPIInstanceURL := fmt.Sprintf(
+		"apimanager/api/v1/organizations/%s/environments/%s/apis/%d",
+		orgId,
+		envId,
+		apiInstanceID,
+	)
+	req, _ := client.newRequest(ctx, "GET", getAPIInstanceURL, nil)
+	return client.apiInstanceResponse(req, http.StatusOK)
+}
+
+// CreateApiInstance creates an API instance and returns it
+func (client *AnypointClient) CreateApiInstance(orgId string, envId string, instance ApiInstanceRequest) (*ApiInstance, error) {
+	return client.CreateApiInstanceContext(client.context(), orgId, envId, instance)
+}
+
+// CreateApiInstanceContext is like CreateApiInstance but uses the given context
+func (client *AnypointClient) CreateApiInstanceContext(ctx context.Context, orgId string, envId string, instance ApiInstanceRequest) (*ApiInstance, error) {
+	createAPIInstanceURL := fmt.Sprintf(
+		"apimanager/api/v1/organizations/%s/environments/%s/apis",
+		orgId,
+		envId,
+	)
+	instancePayload, err := json.Marshal(instance)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to marshal API instance to JSON")
+	}
+	req, _ := client.newRequest(ctx, "POST", createAPIInstanceURL, bytes.NewBuffer(instancePayload))
+	req.Header.Set("Content-Type", "application/json;charset=utf-8")
+	return client.apiInstanceResponse(req, http.StatusCreated)
+}
+
+// UpdateApiInstance changes the asset version, endpoint and technology of an API instance and returns it
+func (client *AnypointClient) UpdateApiInstance(orgId string, envId string, apiInstanceID int, instance ApiInstanceRequest) (*ApiInstance, error) {
+	return client.UpdateApiInstanceContext(client.context(), orgId, envId, apiInstanceID, instance)
+}
+
+// UpdateApiInstanceContext is like UpdateApiInstance but uses the given context
+func (client *AnypointClient) UpdateApiInstanceContext(ctx context.Context, orgId string, envId string, apiInstanceID int, instance ApiInstanceRequest) (*ApiInstance, error) {
+	updateAPIInstanceURL := fmt.Sprintf(
+		"apimanager/api/v1/organizations/%s/environments/%s/apis/%d",
+		orgId,
+		envId,
+		apiInstanceID,
+	)
+	instancePayload, err := json.Marshal(map[string]any{
+		"assetVersion":  instance.Spec.Version,
+		"endpoint":      instance.Endpoint,
+		"technology":    instance.Technology,
+		"instanceLabel": instance.InstanceLabel,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to marshal API instance to JSON")
+	}
+	req, _ := client.newRequest(ctx, "PATCH", updateAPIInstanceURL, bytes.NewBuffer(instancePayload))
+	req.Header.Set("Content-Type", "application/json;charset=utf-8")
+	return client.apiInstanceResponse(req, http.StatusOK)
+}
+
+// apiInstanceResponse sends the request and decodes the API instance in the response
+func (client *AnypointClient) apiInstanceResponse(req *http.Request, expectedStatus int) (*ApiInstance, error) {
+	res, err := client.do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to call Anypoint Platform")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != expectedStatus {
+		return nil, newAPIError(res)
+	}
+
+	var instance ApiInstance
+	if err := json.NewDecoder(res.Body).Decode(&instance); err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal response from Anypoint Platform")
+	}
+	return &instance, nil
+}
@@ -2,6 +2,7 @@ package anypointclient
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -13,10 +14,11 @@ import (
 )
 
 type CloudhubDeploymentResp struct {
-	ID               string `json:"id,omitempty"`
-	Name             string `json:"name,omitempty"`
-	CreationDate     int64  `json:"creationDate,omitempty"`
-	LastModifiedDate int64  `json:"lastModifiedDate,omitempty"`
+	ID               string   `json:"id,omitempty"`
+	Name             string   `json:"name,omitempty"`
+	Labels           []string `json:"labels,omitempty"`
+	CreationDate     int64    `json:"creationDate,omitempty"`
+	LastModifiedDate int64    `json:"lastModifiedDate,omitempty"`
 	Target           struct {
 		Provider           string `json:"provider,omitempty"`
 		TargetID           string `json:"targetId,omitempty"`
@@ -70,8 +72,9 @@ type CloudhubDeploymentResp struct {
 		} `json:"ref"`
 		Configuration struct {
 			MuleAgentApplicationPropertiesService struct {
-				ApplicationName string            `json:"applicationName,omitempty"`
-				Properties      map[string]string `json:"properties,omitempty"`
+				ApplicationName  string            `json:"applicationName,omitempty"`
+				Properties       map[string]string `json:"properties,omitempty"`
+				SecureProperties map[string]string `json:"secureProperties,omitempty"` // Values are masked by Anypoint Platform
 			} `json:"mule.agent.application.properties.service"`
 			MuleAgentLoggingService struct {
 				ArtifactName               string `json:"artifactName,omitempty"`
@@ -81,17 +84,26 @@ type CloudhubDeploymentResp struct {
 				Schedulers []Schedule `json:"schedulers,omitempty"`
 			} `json:"mule.agent.scheduling.service"`
 		} `json:"configuration"`
+		Integrations struct {
+			Services struct {
+				ObjectStoreV2 struct {
+					Enabled bool `json:"enabled"`
+				} `json:"objectStoreV2"`
+			} `json:"services"`
+		} `json:"integrations"`
 		VCores float32 `json:"vCores,omitempty"`
 	} `json:"application"`
-	DesiredVersion string `json:"desiredVersion,omitempty"`
-	Replicas       []struct {
-		ID                       string `json:"id,omitempty"`
-		State                    string `json:"state,omitempty"`
-		DeploymentLocation       string `json:"deploymentLocation,omitempty"`
-		CurrentDeploymentVersion string `json:"currentDeploymentVersion,omitempty"`
-		Reason                   string `json:"reason,omitempty"`
-	} `json:"replicas,omitempty"`
-	LastSuccessfulVersion string `json:"lastSuccessfulVersion,omitempty"`
+	DesiredVersion        string              `json:"desiredVersion,omitempty"`
+	Replicas              []DeploymentReplica `json:"replicas,omitempty"`
+	LastSuccessfulVersion string              `json:"lastSuccessfulVersion,omitempty"`
+}
+
+type DeploymentReplica struct {
+	ID                       string `json:"id,omitempty"`
+	State                    string `json:"state,omitempty"`
+	DeploymentLocation       string `json:"deploymentLocation,omitempty"`
+	CurrentDeploymentVersion string `json:"currentDeploymentVersion,omitempty"`
+	Reason                   string `json:"reason,omitempty"`
 }
 
 type CloudhubDeploymentReq struct {
@@ -207,36 +219,53 @@ type Deployment struct {
 	LastSuccessfulRuntimeVersion string `json:"lastSuccessfulRuntimeVersion,omitempty"`
 }
 
+// GetDeployments returns every deployment in an environment
 func (client *AnypointClient) GetDeployments(environment Environment) ([]Deployment, error) {
-	reqPath := fmt.Sprintf("/amc/application-manager/api/v2/organizations/%s/environments/%s/deployments", environment.OrganizationID, environment.ID)
-	req, _ := client.newRequest("GET", reqPath, nil)
+	return client.GetDeploymentsContext(client.context(), environment)
+}
+
+// GetDeploymentsContext is like GetDeployments but uses the given context
+func (client *AnypointClient) GetDeploymentsContext(ctx context.Context, environment Environment) ([]Deployment, error) {
+	return client.DeploymentsPaginator(environment).Collect(ctx)
+}
+
+// DeploymentsPaginator returns a paginator over the deployments in an environment
+func (client *AnypointClient) DeploymentsPaginator(environment Environment) *Paginator[Deployment] {
+	return NewPaginator(DefaultPageSize, func(ctx context.Context, offset int, limit int) (Page[Deployment], error) {
+		return client.getDeploymentsPage(ctx, environment, offset, limit)
+	})
+}
+
+func (client *AnypointClient) getDeploymentsPage(ctx context.Context, environment Environment, offset int, limit int) (Page[Deployment], error) {
+	reqPath := fmt.Sprintf("/amc/application-manager/api/v2/organizations/%s/environments/%s/deployments?offset=%d&limit=%d", environment.OrganizationID, environment.ID, offset, limit)
+	req, _ := client.newRequest(ctx, "GET", reqPath, nil)
 
-	res, err := client.HTTPClient.Do(req)
+	res, err := client.do(req)
 	if err != nil {
-		return nil, wrapError(err, "failed to call Anypoint Platform")
+		return Page[Deployment]{}, wrapError(err, "failed to call Anypoint Platform")
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		return nil, errors.Errorf("call to Anypoint Platform returned %d", res.StatusCode)
+		return Page[Deployment]{}, newAPIError(res)
 	}
 
 	var deploymentsResp CloudhubDeploymentsResp
 	err = decodeResponseBody(res.Body, &deploymentsResp)
 	if err != nil {
-		return nil, errors.Wrap(err, "Failed to process response")
+		return Page[Deployment]{}, errors.Wrap(err, "Failed to process response")
 	}
 
-	return deploymentsResp.Deloyments, nil
+	return Page[Deployment]{Items: deploymentsResp.Deloyments, Total: deploymentsResp.Total}, nil
 }
 
-func (client *AnypointClient) getDeloymentId(environment Environment, deploymentName string) (string, error) {
-	deployments, err := client.GetDeployments(environment)
-	if err != nil {
-		return "", err
-	}
-
-	for _, deployment := range deployments {
+// getDeloymentId returns the ID of the named deployment, or an empty string if there is none.
+// Pages are fetched until the deployment is found.
+func (client *AnypointClient) getDeloymentId(ctx context.Context, environment Environment, deploymentName string) (string, error) {
+	for deployment, err := range client.DeploymentsPaginator(environment).All(ctx) {
+		if err != nil {
+			return "", err
+		}
 		if deployment.Name == deploymentName {
 			return deployment.ID, nil
 		}
@@ -245,8 +274,13 @@ func (client *AnypointClient) getDeloymentId(environment Environment, deployment
 }
 
 func (client *AnypointClient) GetDeployment(environment Environment, deploymentName string) (CloudhubDeploymentResp, error) {
+	return client.GetDeploymentContext(client.context(), environment, deploymentName)
+}
+
+// GetDeploymentContext is like GetDeployment but uses the given context
+func (client *AnypointClient) GetDeploymentContext(ctx context.Context, environment Environment, deploymentName string) (CloudhubDeploymentResp, error) {
 
-	deploymentId, err := client.getDeloymentId(environment, deploymentName)
+	deploymentId, err := client.getDeloymentId(ctx, environment, deploymentName)
 	if err != nil {
 		return CloudhubDeploymentResp{}, err
 	}
@@ -255,19 +289,22 @@ func (client *AnypointClient) GetDeployment(environment Environment, deploymentN
 	}
 
 	reqPath := fmt.Sprintf("/amc/application-manager/api/v2/organizations/%s/environments/%s/deployments/%s", environment.OrganizationID, environment.ID, deploymentId)
-	req, err := client.newRequest("GET", reqPath, nil)
+	req, err := client.newRequest(ctx, "GET", reqPath, nil)
 	if err != nil {
 		return CloudhubDeploymentResp{}, errors.Wrap(err, "failed to create new request")
 	}
 
-	res, err := client.HTTPClient.Do(req)
+	res, err := client.do(req)
 	if err != nil {
 		return CloudhubDeploymentResp{}, errors.Wrapf(err, "failed to call Anypoint Platform")
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode == http.StatusNotFound {
+		return CloudhubDeploymentResp{}, nil
+	}
 	if res.StatusCode != http.StatusOK {
-		return handleErrorResponse(res)
+		return CloudhubDeploymentResp{}, newAPIError(res)
 	}
 
 	var response CloudhubDeploymentResp
@@ -281,20 +318,6 @@ func (client *AnypointClient) GetDeployment(environment Environment, deploymentN
 
 /*--------------------*/
 
-func handleErrorResponse(res *http.Response) (CloudhubDeploymentResp, error) {
-	var response map[string]any
-	err := decodeResponseBody(res.Body, &response)
-	if err != nil {
-		return CloudhubDeploymentResp{}, errors.Wrapf(err, "call to Anypoint Platform returned %d. Failed to decode error response payload", res.StatusCode)
-	}
-
-	if res.StatusCode == http.StatusNotFound {
-		return CloudhubDeploymentResp{}, nil
-	}
-
-	return CloudhubDeploymentResp{}, errors.Errorf("Call to Anypoint Platform returned %d : %s", res.StatusCode, response["message"])
-}
-
 func decodeResponseBody(body io.Reader, target any) error {
 	bodyBytes, err := io.ReadAll(body)
 	if err != nil {
@@ -311,31 +334,31 @@ func decodeResponseBody(body io.Reader, target any) error {
 /*---------------------------------------------*/
 
 func (client *AnypointClient) DeleteDeployment(environment Environment, privateSpace PrivateSpace, deploymentID string) error {
+	return client.DeleteDeploymentContext(client.context(), environment, privateSpace, deploymentID)
+}
+
+// DeleteDeploymentContext is like DeleteDeployment but uses the given context
+func (client *AnypointClient) DeleteDeploymentContext(ctx context.Context, environment Environment, privateSpace PrivateSpace, deploymentID string) error {
 	reqPath := fmt.Sprintf("/amc/application-manager/api/v2/organizations/%s/environments/%s/deployments/%s", environment.OrganizationID, environment.ID, deploymentID)
-	req, _ := client.newRequest("DELETE", reqPath, nil)
+	req, _ := client.newRequest(ctx, "DELETE", reqPath, nil)
 
-	res, err := client.HTTPClient.Do(req)
+	res, err := client.do(req)
 	if err != nil {
 		return errors.Wrapf(err, "Failed to call Anypoint Platform")
 	}
+	defer res.Body.Close()
 	if res.StatusCode != http.StatusNoContent {
-		var response map[string]any
-		bodyBytes, err := io.ReadAll(res.Body)
-		if err != nil {
-			return errors.Wrapf(err, "Call to Anypoint Platform returned %d. Failed to decode error response payload", res.StatusCode)
-		}
-		err = json.Unmarshal(bodyBytes, &response)
-		if err != nil {
-			return errors.Wrapf(err, "Call to Anypoint Platform returned %d. Failed to decode error response payload", res.StatusCode)
-
-		}
-		return errors.Errorf("Call to Anypoint Platform returned %d : %s",
-			res.StatusCode, response["message"])
+		return newAPIError(res)
 	}
 	return nil
 }
 
 func (client *AnypointClient) CreateDeployment(environment Environment, privateSpace PrivateSpace, deployment CloudhubDeploymentReq) (CloudhubDeploymentResp, error) {
+	return client.CreateDeploymentContext(client.context(), environment, privateSpace, deployment)
+}
+
+// CreateDeploymentContext is like CreateDeployment but uses the given context
+func (client *AnypointClient) CreateDeploymentContext(ctx context.Context, environment Environment, privateSpace PrivateSpace, deployment CloudhubDeploymentReq) (CloudhubDeploymentResp, error) {
 	if privateSpace.ID != "" {
 		deployment.Target.TargetID = privateSpace.ID
 	}
@@ -348,33 +371,22 @@ func (client *AnypointClient) CreateDeployment(environment Environment, privateS
 	err := json.NewEncoder(buffer).Encode(deployment)
 
 	if err != nil {
-		errors.Wrapf(err, "failed to encode request")
+		return CloudhubDeploymentResp{}, errors.Wrapf(err, "failed to encode deployment %s", deployment.Name)
 	}
 	reqPath := fmt.Sprintf("/amc/application-manager/api/v2/organizations/%s/environments/%s/deployments", environment.OrganizationID, environment.ID)
 
-	req, _ := client.newRequest("POST", reqPath, buffer)
+	req, _ := client.newRequest(ctx, "POST", reqPath, buffer)
 
 	req.Header.Add("Content-Type", "application/json")
 
-	res, err := client.HTTPClient.Do(req)
+	res, err := client.do(req)
 	if err != nil {
 		return CloudhubDeploymentResp{}, errors.Wrapf(err, "Failed to call Anypoint Platform")
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusAccepted {
-		var response map[string]any
-		bodyBytes, err := io.ReadAll(res.Body)
-		if err != nil {
-			return CloudhubDeploymentResp{}, errors.Wrapf(err, "Call to Anypoint Platform returned %d. Failed to decode error response payload", res.StatusCode)
-		}
-		err = json.Unmarshal(bodyBytes, &response)
-		if err != nil {
-			return CloudhubDeploymentResp{}, errors.Wrapf(err, "Call to Anypoint Platform returned %d. Failed to decode error response payload", res.StatusCode)
-
-		}
-		return CloudhubDeploymentResp{}, errors.Errorf("Call to Anypoint Platform returned %d : %s",
-			res.StatusCode, response["message"])
+		return CloudhubDeploymentResp{}, newAPIError(res)
 	}
 
 	var response CloudhubDeploymentResp
@@ -394,6 +406,16 @@ func (client *AnypointClient) UpdateDeployment(
 	privateSpace PrivateSpace,
 	deployment CloudhubDeploymentReq,
 	deploymentID string) error {
+	return client.UpdateDeploymentContext(client.context(), environment, privateSpace, deployment, deploymentID)
+}
+
+// UpdateDeploymentContext is like UpdateDeployment but uses the given context
+func (client *AnypointClient) UpdateDeploymentContext(
+	ctx context.Context,
+	environment Environment,
+	privateSpace PrivateSpace,
+	deployment CloudhubDeploymentReq,
+	deploymentID string) error {
 
 	if privateSpace.ID != "" {
 		deployment.Target.TargetID = privateSpace.ID
@@ -402,34 +424,23 @@ func (client *AnypointClient) UpdateDeployment(
 	err := json.NewEncoder(buffer).Encode(deployment)
 
 	if err != nil {
-		errors.Wrapf(err, "failed to encode request")
+		return errors.Wrapf(err, "failed to encode deployment %s", deployment.Name)
 	}
 
 	reqPath := fmt.Sprintf("/amc/application-manager/api/v2/organizations/%s/environments/%s/deployments/%s", environment.OrganizationID, environment.ID, deploymentID)
 
-	req, _ := client.newRequest("PATCH", reqPath, buffer)
+	req, _ := client.newRequest(ctx, "PATCH", reqPath, buffer)
 
 	req.Header.Add("Content-Type", "application/json")
 
-	res, err := client.HTTPClient.Do(req)
+	res, err := client.do(req)
 	if err != nil {
 		return errors.Wrapf(err, "Failed to call Anypoint Platform")
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		var response map[string]any
-		bodyBytes, err := io.ReadAll(res.Body)
-		if err != nil {
-			return errors.Wrapf(err, "Call to Anypoint Platform returned %d. Failed to decode error response payload", res.StatusCode)
-		}
-		err = json.Unmarshal(bodyBytes, &response)
-		if err != nil {
-			return errors.Wrapf(err, "Call to Anypoint Platform returned %d. Failed to decode error response payload", res.StatusCode)
-
-		}
-		return errors.Errorf("Call to Anypoint Platform returned %d : %s",
-			res.StatusCode, response["message"])
+		return newAPIError(res)
 	}
 
 	var response CloudhubDeploymentResp
@@ -448,18 +459,27 @@ func (client *AnypointClient) SchedulesDiffFromSourceCode(
 	environment Environment,
 	newDeployment CloudhubDeploymentReq,
 	deploymentID string) error {
+	return client.SchedulesDiffFromSourceCodeContext(client.context(), environment, newDeployment, deploymentID)
+}
+
+// SchedulesDiffFromSourceCodeContext is like SchedulesDiffFromSourceCode but uses the given context
+func (client *AnypointClient) SchedulesDiffFromSourceCodeContext(
+	ctx context.Context,
+	environment Environment,
+	newDeployment CloudhubDeploymentReq,
+	deploymentID string) error {
 
 	reqPath := fmt.Sprintf("/amc/application-manager/api/v2/organizations/%s/environments/%s/deployments/%s/schedulers", environment.OrganizationID, environment.ID, deploymentID)
-	req, _ := client.newRequest("GET", reqPath, nil)
+	req, _ := client.newRequest(ctx, "GET", reqPath, nil)
 
-	res, err := client.HTTPClient.Do(req)
+	res, err := client.do(req)
 	if err != nil {
 		return errors.Wrapf(err, "failed to call Anypoint Platform")
 	}
 
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		return errors.Errorf("call to Anypoint Platform returned %d", res.StatusCode)
+		return newAPIError(res)
 	}
 
 	var response ScheduleResp
@@ -499,7 +519,14 @@ func compareSchedules(slice1, slice2 []Schedule) error {
 	return nil
 }
 
+// UpdateScheduleNames sets the name of every scheduler to the one Anypoint Platform derives from its flow name.
+// It does not call Anypoint Platform, use SetScheduleNames where there is no client.
 func (client *AnypointClient) UpdateScheduleNames(schedulers []Schedule) {
+	SetScheduleNames(schedulers)
+}
+
+// SetScheduleNames sets the name of every scheduler to polling://<flow name>/, the name Anypoint Platform gives it
+func SetScheduleNames(schedulers []Schedule) {
 	for i := range schedulers {
 		scheduler := &schedulers[i]
 		scheduler.Name = "polling://" + scheduler.FlowName + "/"
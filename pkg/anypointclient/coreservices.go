@@ -1,6 +1,7 @@
 package anypointclient
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 
@@ -28,20 +29,34 @@ LoginResponse represents the JSON data beeing returned by the Login request.
 type LoginResponse struct {
 	AccessToken string `json:"access_token"`
 	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in,omitempty"`
 	RedirectURL string `url:"redirectUrl"`
 }
 
+/*
+Login acquires a bearer token using the credentials of the client. The token is refreshed automatically
+before it expires and when Anypoint Platform rejects it. Login fails if no token is returned.
+*/
 func (client *AnypointClient) Login() error {
-	var err error
+	return client.LoginContext(client.context())
+}
+
+// LoginContext is like Login but uses the given context
+func (client *AnypointClient) LoginContext(ctx context.Context) error {
+	bearer, _ := client.session.token()
 	// We are already "logged in"
 	if client.authType == BearerAuthenticationType {
+		if bearer == "" {
+			return errors.New("no bearer token given")
+		}
 		return nil
 	}
-	client.bearer, err = client.getAuthorizationBearerToken(client.authType)
+	_, err := client.refresh(ctx, bearer)
 	return err
 }
 
-func (client *AnypointClient) getAuthorizationBearerToken(authType AuthenticationType) (string, error) {
+func (client *AnypointClient) getAuthorizationBearerToken(ctx context.Context, authType AuthenticationType) (LoginResponse, error) {
+	var loginRespone LoginResponse
 	var loginURL string
 	data := url.Values{}
 	switch authType {
@@ -57,31 +72,36 @@ func (client *AnypointClient) getAuthorizationBearerToken(authType Authenticatio
 		data.Set("client_secret", client.clientSecret)
 		data.Set("grant_type", "client_credentials")
 	default:
-		return "", errors.Errorf("can not get bearer token using authentication type %s", authType)
+		return loginRespone, errors.Errorf("can not get bearer token using authentication type %s", authType)
 	}
 
-	req, _ := client.newRequest("POST", loginURL, strings.NewReader(data.Encode()))
+	req, _ := client.newRequest(ctx, "POST", loginURL, strings.NewReader(data.Encode()))
 
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	// Requesting a token has no side effects
+	markIdempotent(req)
 
-	res, err := client.HTTPClient.Do(req)
+	// The login request is sent without a bearer token
+	res, err := client.withRequestTimeout(req, client.doWithRetries)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to call Anypoint Platform")
+		return loginRespone, errors.Wrap(err, "failed to call Anypoint Platform")
 	}
 	defer res.Body.Close()
 
-	var loginRespone LoginResponse
-
-	if res.StatusCode == http.StatusOK {
-		bodyBytes, err := io.ReadAll(res.Body)
-		if err != nil {
-			return "", errors.Wrap(err, "failed to read response from Anypoint Platform")
-		}
-		err = json.Unmarshal(bodyBytes, &loginRespone)
-		if err != nil {
-			return "", errors.Wrap(err, "failed to unmarshal response from Anypoint Platform")
-		}
+	if res.StatusCode != http.StatusOK {
+		return loginRespone, errors.Wrap(newAPIError(res), "login failed")
+	}
+	bodyBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		return loginRespone, errors.Wrap(err, "failed to read response from Anypoint Platform")
+	}
+	err = json.Unmarshal(bodyBytes, &loginRespone)
+	if err != nil {
+		return loginRespone, errors.Wrap(err, "failed to unmarshal response from Anypoint Platform")
+	}
+	if loginRespone.AccessToken == "" {
+		return loginRespone, errors.New("login failed, no access token in response from Anypoint Platform")
 	}
 
-	return loginRespone.AccessToken, nil
+	return loginRespone, nil
 }
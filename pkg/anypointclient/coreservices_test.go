@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/jarcoal/httpmock"
 	. "github.com/onsi/ginkgo/v2"
@@ -25,6 +26,86 @@ var _ = Describe("Login", func() {
 		err = client.Login()
 		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
 		Ω(client.username).Should(Equal("user"), "username")
-		Ω(client.bearer).Should(Equal("12345678-1234-1234-1234-123456789101"), "bearer")
+		Ω(client.session.bearer).Should(Equal("12345678-1234-1234-1234-123456789101"), "bearer")
+	})
+
+	It("should fail with status and message when the login is rejected", func() {
+		httpmock.RegisterResponder("POST", "/accounts/login",
+			httpmock.NewStringResponder(401, `{"message": "Invalid username or password"}`))
+
+		err := client.Login()
+		Ω(err).Should(HaveOccurred())
+		Ω(err.Error()).Should(ContainSubstring("401"))
+		Ω(err.Error()).Should(ContainSubstring("Invalid username or password"))
+	})
+
+	It("should fail when no token is returned", func() {
+		httpmock.RegisterResponder("POST", "/accounts/login", httpmock.NewStringResponder(200, `{"token_type": "bearer"}`))
+
+		err := client.Login()
+		Ω(err).Should(HaveOccurred())
+	})
+})
+
+var _ = Describe("Token refresh", func() {
+	var logins int
+
+	BeforeEach(func() {
+		logins = 0
+		httpmock.RegisterResponder("POST", "/accounts/login", func(req *http.Request) (*http.Response, error) {
+			logins++
+			return httpmock.NewStringResponse(200, fmt.Sprintf(`{"access_token": "token-%d", "expires_in": 3600}`, logins)), nil
+		})
+	})
+
+	AfterEach(func() {
+		client.session.expiresAt = time.Time{}
+	})
+
+	It("should track when the token expires", func() {
+		err := client.Login()
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+		Ω(client.session.expiresAt).Should(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+	})
+
+	It("should log in again before the token expires", func() {
+		client.session.expiresAt = time.Now().Add(10 * time.Second)
+		var authorization string
+		httpmock.RegisterResponder("GET", rolloutDeploymentsPath, func(req *http.Request) (*http.Response, error) {
+			authorization = req.Header.Get("Authorization")
+			return httpmock.NewStringResponse(200, `{"total": 0, "items": []}`), nil
+		})
+
+		_, err := client.GetDeployments(rolloutEnvironment)
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+		Ω(logins).Should(Equal(1))
+		Ω(authorization).Should(Equal("Bearer token-1"))
+	})
+
+	It("should log in again and resend the request on 401", func() {
+		var authorizations []string
+		httpmock.RegisterResponder("GET", rolloutDeploymentsPath, func(req *http.Request) (*http.Response, error) {
+			authorizations = append(authorizations, req.Header.Get("Authorization"))
+			if len(authorizations) == 1 {
+				return httpmock.NewStringResponse(401, `{"message": "Token expired"}`), nil
+			}
+			return httpmock.NewStringResponse(200, `{"total": 0, "items": []}`), nil
+		})
+
+		_, err := client.GetDeployments(rolloutEnvironment)
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+		Ω(logins).Should(Equal(1))
+		Ω(authorizations).Should(HaveLen(2))
+		Ω(authorizations[1]).Should(Equal("Bearer token-1"))
+	})
+
+	It("should not send requests with an empty token when the login fails", func() {
+		client.session.expiresAt = time.Now()
+		httpmock.RegisterResponder("POST", "/accounts/login", httpmock.NewStringResponder(403, `{"message": "Forbidden"}`))
+
+		_, err := client.GetDeployments(rolloutEnvironment)
+		Ω(err).Should(HaveOccurred())
+		Ω(err.Error()).Should(ContainSubstring("403"))
+		Ω(httpmock.GetTotalCallCount()).Should(Equal(1))
 	})
 })
@@ -1,6 +1,7 @@
 package anypointclient
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -25,30 +26,49 @@ type EnvironmentResponse struct {
 ResolveEnvironment will resolve, in the given organization, an Environment by name.
 */
 func (client *AnypointClient) ResolveEnvironment(organization Organization, environmentName string) (Environment, error) {
-	envResp := new(EnvironmentResponse)
-
-	req, _ := client.newRequest("GET", fmt.Sprintf("accounts/api/organizations/%s/environments", organization.ID), nil)
-	res, err := client.HTTPClient.Do(req)
-	if err != nil {
-		return Environment{}, err
-	}
-	defer res.Body.Close()
+	return client.ResolveEnvironmentContext(client.context(), organization, environmentName)
+}
 
-	if res.StatusCode == http.StatusOK {
-		bodyBytes, err := io.ReadAll(res.Body)
+// ResolveEnvironmentContext is like ResolveEnvironment but uses the given context
+func (client *AnypointClient) ResolveEnvironmentContext(ctx context.Context, organization Organization, environmentName string) (Environment, error) {
+	for e, err := range client.EnvironmentsPaginator(organization).All(ctx) {
 		if err != nil {
 			return Environment{}, err
 		}
-		err = json.Unmarshal(bodyBytes, &envResp)
-		if err != nil {
-			return Environment{}, err
-		}
-	}
-
-	for _, e := range envResp.Data {
 		if e.Name == environmentName {
 			return e, nil
 		}
 	}
 	return Environment{}, fmt.Errorf("failed to find environment named %s in organization %s", environmentName, organization.Name)
 }
+
+// EnvironmentsPaginator returns a paginator over the environments of an organization
+func (client *AnypointClient) EnvironmentsPaginator(organization Organization) *Paginator[Environment] {
+	return NewPaginator(DefaultPageSize, func(ctx context.Context, offset int, limit int) (Page[Environment], error) {
+		return client.getEnvironmentsPage(ctx, organization, offset, limit)
+	})
+}
+
+func (client *AnypointClient) getEnvironmentsPage(ctx context.Context, organization Organization, offset int, limit int) (Page[Environment], error) {
+	envResp := new(EnvironmentResponse)
+
+	req, _ := client.newRequest(ctx, "GET", fmt.Sprintf("accounts/api/organizations/%s/environments?offset=%d&limit=%d", organization.ID, offset, limit), nil)
+	res, err := client.do(req)
+	if err != nil {
+		return Page[Environment]{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return Page[Environment]{}, fmt.Errorf("failed to list environments in organization %s: %w", organization.Name, newAPIError(res))
+	}
+	bodyBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		return Page[Environment]{}, err
+	}
+	err = json.Unmarshal(bodyBytes, &envResp)
+	if err != nil {
+		return Page[Environment]{}, err
+	}
+	return Page[Environment]{Items: envResp.Data, Total: envResp.Total}, nil
+}
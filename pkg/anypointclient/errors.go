@@ -0,0 +1,146 @@
+package anypointclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Errors matched by an APIError with errors.Is, e.g. errors.Is(err, anypointclient.ErrNotFound)
+var (
+	ErrNotFound     = &statusClass{"not found"}
+	ErrConflict     = &statusClass{"conflict"}
+	ErrUnauthorized = &statusClass{"unauthorized"}
+	ErrThrottled    = &statusClass{"throttled"}
+)
+
+// statusClass is a class of error responses, see APIError.Is
+type statusClass struct {
+	name string
+}
+
+func (class *statusClass) Error() string {
+	return class.name
+}
+
+// maxErrorBody is the max number of bytes of an error response read for its message
+const maxErrorBody = 64 << 10
+
+// redacted replaces the values of headers that carry credentials
+const redacted = "REDACTED"
+
+// APIError is an error response from Anypoint Platform, found with errors.As. Use errors.Is with ErrNotFound,
+// ErrConflict, ErrUnauthorized or ErrThrottled for the common failures. Headers carrying credentials are redacted.
+type APIError struct {
+	StatusCode int
+	Method     string
+	Path       string
+	Code       string // Error code given by Anypoint Platform, if any
+	Message    string
+	RequestID  string // Correlation id of the request, to quote in support cases
+	Header     http.Header
+}
+
+func (e *APIError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s: Anypoint Platform returned %d %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		fmt.Fprintf(&b, ": %s", e.Message)
+	}
+	if e.Code != "" {
+		fmt.Fprintf(&b, " (code %s)", e.Code)
+	}
+	if e.RequestID != "" {
+		fmt.Fprintf(&b, " (request id %s)", e.RequestID)
+	}
+	return b.String()
+}
+
+// Is reports whether the error response is of the class of target. Both 401 Unauthorized and 403 Forbidden are
+// ErrUnauthorized.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrThrottled:
+		return e.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// requestIDHeaders are the headers in which Anypoint Platform returns the id of a request, in order of preference
+var requestIDHeaders = []string{"X-Request-Id", "X-Correlation-Id", "X-Anypnt-Trx-Id"}
+
+// sensitiveHeaders are redacted in the headers kept by an APIError
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// newAPIError returns the error of an error response. The message and code are taken from the first of the usual
+// fields found in the body, which differ by API, and a body that is not JSON is the message.
+func newAPIError(res *http.Response) *APIError {
+	apiErr := &APIError{StatusCode: res.StatusCode, Header: redactHeader(res.Header)}
+	if req := res.Request; req != nil {
+		apiErr.Method = req.Method
+		apiErr.Path = req.URL.Path
+	}
+	for _, name := range requestIDHeaders {
+		if id := res.Header.Get(name); id != "" {
+			apiErr.RequestID = id
+			break
+		}
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBody))
+	var response struct {
+		Message          string `json:"message"`
+		ErrorDescription string `json:"error_description"`
+		Error            any    `json:"error"`
+		Details          string `json:"details"`
+		Code             any    `json:"code"`
+		ErrorCode        string `json:"errorCode"`
+		Name             string `json:"name"`
+	}
+	if json.Unmarshal(body, &response) != nil {
+		apiErr.Message = strings.TrimSpace(string(body))
+		return apiErr
+	}
+	errorText, _ := response.Error.(string)
+	apiErr.Message = firstNonEmpty(response.ErrorDescription, response.Message, response.Details, errorText)
+	if response.Code != nil {
+		apiErr.Code = fmt.Sprint(response.Code)
+	}
+	apiErr.Code = firstNonEmpty(apiErr.Code, response.ErrorCode, response.Name)
+	if apiErr.Code == "" && response.ErrorDescription != "" {
+		// OAuth errors put the code in error and the message in error_description
+		apiErr.Code = errorText
+	}
+	return apiErr
+}
+
+// redactHeader returns a copy of the header with the values of the headers carrying credentials redacted
+func redactHeader(header http.Header) http.Header {
+	if header == nil {
+		return nil
+	}
+	header = header.Clone()
+	for _, name := range sensitiveHeaders {
+		if _, ok := header[name]; ok {
+			header[name] = []string{redacted}
+		}
+	}
+	return header
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,67 @@
+package anypointclient
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/jarcoal/httpmock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("APIError", func() {
+	It("should carry the details of the error response", func() {
+		httpmock.RegisterResponder("POST", rolloutDeploymentsPath, func(req *http.Request) (*http.Response, error) {
+			res := httpmock.NewStringResponse(409, `{"message": "Deployment my-app already exists", "code": "DUPLICATE_DEPLOYMENT"}`)
+			res.Header.Set("X-Request-Id", "request-id")
+			return res, nil
+		})
+
+		_, err := client.CreateDeployment(rolloutEnvironment, PrivateSpace{}, CloudhubDeploymentReq{Name: "my-app"})
+		var apiErr *APIError
+		Ω(errors.As(err, &apiErr)).Should(BeTrue(), "Error is %v", err)
+		Ω(apiErr.StatusCode).Should(Equal(http.StatusConflict))
+		Ω(apiErr.Method).Should(Equal("POST"))
+		Ω(apiErr.Path).Should(HaveSuffix("/environments/env-id/deployments"))
+		Ω(apiErr.Code).Should(Equal("DUPLICATE_DEPLOYMENT"))
+		Ω(apiErr.Message).Should(Equal("Deployment my-app already exists"))
+		Ω(apiErr.RequestID).Should(Equal("request-id"))
+		Ω(err.Error()).Should(ContainSubstring("returned 409 Conflict: Deployment my-app already exists"))
+		Ω(errors.Is(err, ErrConflict)).Should(BeTrue())
+		Ω(errors.Is(err, ErrNotFound)).Should(BeFalse())
+	})
+
+	It("should be matched by the class of the status code", func() {
+		Ω(errors.Is(&APIError{StatusCode: http.StatusNotFound}, ErrNotFound)).Should(BeTrue())
+		Ω(errors.Is(&APIError{StatusCode: http.StatusUnauthorized}, ErrUnauthorized)).Should(BeTrue())
+		Ω(errors.Is(&APIError{StatusCode: http.StatusForbidden}, ErrUnauthorized)).Should(BeTrue())
+		Ω(errors.Is(&APIError{StatusCode: http.StatusTooManyRequests}, ErrThrottled)).Should(BeTrue())
+		Ω(errors.Is(&APIError{StatusCode: http.StatusBadRequest}, ErrNotFound)).Should(BeFalse())
+	})
+
+	It("should use a body that is not JSON as the message", func() {
+		httpmock.RegisterResponder("DELETE", rolloutDeploymentPath, httpmock.NewStringResponder(400, "Bad deployment\n"))
+
+		err := client.DeleteDeployment(rolloutEnvironment, PrivateSpace{}, "deployment-id")
+		var apiErr *APIError
+		Ω(errors.As(err, &apiErr)).Should(BeTrue(), "Error is %v", err)
+		Ω(apiErr.Message).Should(Equal("Bad deployment"))
+	})
+
+	It("should not print the credentials of the request", func() {
+		httpmock.RegisterResponder("PATCH", `=~/exchange/api/v2/assets/org-id/my-api/versionGroups/v1/instances/managed/1$`,
+			func(req *http.Request) (*http.Response, error) {
+				res := httpmock.NewStringResponse(403, `{"message": "Forbidden"}`)
+				res.Header.Set("Set-Cookie", "session=secret")
+				return res, nil
+			})
+
+		err := client.UpdateExchangeApiManagedInstanceUrl("org-id", "my-api", "v1", "1", "https://api.example.com")
+		var apiErr *APIError
+		Ω(errors.As(err, &apiErr)).Should(BeTrue(), "Error is %v", err)
+		Ω(errors.Is(err, ErrUnauthorized)).Should(BeTrue())
+		Ω(apiErr.Header.Get("Set-Cookie")).Should(Equal(redacted))
+		Ω(err.Error()).ShouldNot(ContainSubstring("test-token"))
+		Ω(err.Error()).ShouldNot(ContainSubstring("secret"))
+	})
+})
@@ -2,6 +2,7 @@ package anypointclient
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -112,7 +113,23 @@ type ExchangeAsset struct {
 GetExchangeAssets retrieves assets from exchange
 */
 func (client *AnypointClient) GetExchangeAssets(orgId string, offset int, limit int) (*[]ExchangeAsset, error) {
-	req, _ := client.newRequest("GET", "exchange/api/v2/assets", nil)
+	return client.GetExchangeAssetsContext(client.context(), orgId, offset, limit)
+}
+
+// ExchangeAssetsPaginator returns a paginator over the API assets in Exchange of an organization
+func (client *AnypointClient) ExchangeAssetsPaginator(orgId string) *Paginator[ExchangeAsset] {
+	return NewPaginator(DefaultPageSize, func(ctx context.Context, offset int, limit int) (Page[ExchangeAsset], error) {
+		assets, err := client.GetExchangeAssetsContext(ctx, orgId, offset, limit)
+		if err != nil {
+			return Page[ExchangeAsset]{}, err
+		}
+		return Page[ExchangeAsset]{Items: *assets}, nil
+	})
+}
+
+// GetExchangeAssetsContext is like GetExchangeAssets but uses the given context
+func (client *AnypointClient) GetExchangeAssetsContext(ctx context.Context, orgId string, offset int, limit int) (*[]ExchangeAsset, error) {
+	req, _ := client.newRequest(ctx, "GET", "exchange/api/v2/assets", nil)
 	// curl 'https://anypoint.mulesoft.com/exchange/api/v2/assets?search=&&domain=&&masterOrganizationId=xxx&offset=20&limit=20&sharedWithMe=&includeSnapshots=true'  -H 'authorization: bearer xxxxx'
 	q := req.URL.Query()
 	q.Add("search", "")
@@ -140,49 +157,56 @@ func (client *AnypointClient) GetExchangeAssets(orgId string, offset int, limit
 	q.Add("includeSnapshots", "true")
 	req.URL.RawQuery = q.Encode()
 
-	res, err := client.HTTPClient.Do(req)
+	res, err := client.do(req)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to call Anypoint Platform")
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode != http.StatusOK {
+		return nil, newAPIError(res)
+	}
 	var response []ExchangeAsset
-	if res.StatusCode == http.StatusOK {
-		bodyBytes, err := io.ReadAll(res.Body)
-		if err != nil {
-			return nil, errors.Wrapf(err, "failed to read response from Anypoint Platform")
-		}
-		err = json.Unmarshal(bodyBytes, &response)
-		if err != nil {
-			return nil, errors.Wrapf(err, "failed to unmarshal response from Anypoint Platform")
-		}
+	bodyBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read response from Anypoint Platform")
+	}
+	err = json.Unmarshal(bodyBytes, &response)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal response from Anypoint Platform")
 	}
 	return &response, nil
 }
 
 // curl 'https://anypoint.mulesoft.com/exchange/api/v2/assets/xxxx/api' -H 'User-Agent: Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:89.0) Gecko/20100101 Firefox/89.0' -H 'Accept: application/json' -H 'Accept-Language: en,en-US;q=0.7,sv;q=0.3' --compressed
 func (client *AnypointClient) GetExchangeAssetsDetails(orgId string, assetId string) (*ExchangeAsset, error) {
-	req, _ := client.newRequest("GET",
+	return client.GetExchangeAssetsDetailsContext(client.context(), orgId, assetId)
+}
+
+// GetExchangeAssetsDetailsContext is like GetExchangeAssetsDetails but uses the given context
+func (client *AnypointClient) GetExchangeAssetsDetailsContext(ctx context.Context, orgId string, assetId string) (*ExchangeAsset, error) {
+	req, _ := client.newRequest(ctx, "GET",
 		fmt.Sprintf("exchange/api/v2/assets/%s/%s", orgId, assetId),
 		nil)
 	// curl 'https://eu1.anypoint.mulesoft.com/exchange/api/v2/assets/%s/%s'
 
-	res, err := client.HTTPClient.Do(req)
+	res, err := client.do(req)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to call Anypoint Platform")
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode != http.StatusOK {
+		return nil, newAPIError(res)
+	}
 	var response ExchangeAsset
-	if res.StatusCode == http.StatusOK {
-		bodyBytes, err := io.ReadAll(res.Body)
-		if err != nil {
-			return nil, errors.Wrapf(err, "failed to read response from Anypoint Platform")
-		}
-		err = json.Unmarshal(bodyBytes, &response)
-		if err != nil {
-			return nil, errors.Wrapf(err, "failed to unmarshal response from Anypoint Platform")
-		}
+	bodyBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read response from Anypoint Platform")
+	}
+	err = json.Unmarshal(bodyBytes, &response)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal response from Anypoint Platform")
 	}
 	return &response, nil
 }
@@ -192,6 +216,11 @@ func (client *AnypointClient) GetExchangeAssetsDetails(orgId string, assetId str
 // -X PATCH  -H 'authorization: bearer xxxxxxxx' -H 'content-type: application/json'
 // --data-raw '{"name":"v1:25252525","endpointUri":"https://api.example.com/api/v2/","isPublic":false}'
 func (client *AnypointClient) UpdateExchangeApiManagedInstanceUrl(orgId string, assetId string, versionGroup string, instanceId string, newURL string) error {
+	return client.UpdateExchangeApiManagedInstanceUrlContext(client.context(), orgId, assetId, versionGroup, instanceId, newURL)
+}
+
+// UpdateExchangeApiManagedInstanceUrlContext is like UpdateExchangeApiManagedInstanceUrl but uses the given context
+func (client *AnypointClient) UpdateExchangeApiManagedInstanceUrlContext(ctx context.Context, orgId string, assetId string, versionGroup string, instanceId string, newURL string) error {
 	updateInstancePayload :=
 		fmt.Sprintf(
 			`{"name":"%s:%s","endpointUri":"%s"}`,
@@ -200,18 +229,18 @@ func (client *AnypointClient) UpdateExchangeApiManagedInstanceUrl(orgId string,
 			newURL,
 		)
 
-	req, _ := client.newRequest("PATCH",
+	req, _ := client.newRequest(ctx, "PATCH",
 		fmt.Sprintf("exchange/api/v2/assets/%s/%s/versionGroups/%s/instances/managed/%s", orgId, assetId, versionGroup, instanceId),
 		bytes.NewBuffer([]byte(updateInstancePayload)))
 	req.Header.Set("Content-Type", "application/json")
-	res, err := client.HTTPClient.Do(req)
+	res, err := client.do(req)
 	if err != nil {
 		return errors.Wrapf(err, "failed to call Anypoint Platform")
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusNoContent {
-		return errors.Errorf("Failed to update Exchange instance: %+v\n\t%+v", req, res)
+		return errors.Wrapf(newAPIError(res), "failed to update Exchange instance %s of asset %s", instanceId, assetId)
 	}
 	return nil
 }
@@ -2,10 +2,11 @@ package anypointclient
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"net/url"
 
 	"github.com/pkg/errors"
 )
@@ -137,43 +138,63 @@ type MqDestination struct {
 
 // GetMqDestinations retrieves all MQ destinations (queues and exchanges) for a region
 func (client *AnypointClient) GetMqDestinations(orgID, envID, region string) ([]MqDestination, error) {
-	reqPath := fmt.Sprintf("mq/admin/api/v1/organizations/%s/environments/%s/regions/%s/destinations", orgID, envID, region)
-	req, err := client.newRequest("GET", reqPath, nil)
+	return client.GetMqDestinationsContext(client.context(), orgID, envID, region)
+}
+
+// GetMqDestinationsContext is like GetMqDestinations but uses the given context
+func (client *AnypointClient) GetMqDestinationsContext(ctx context.Context, orgID, envID, region string) ([]MqDestination, error) {
+	return client.MqDestinationsPaginator(orgID, envID, region).Collect(ctx)
+}
+
+// MqDestinationsPaginator returns a paginator over the MQ destinations (queues and exchanges) of a region
+func (client *AnypointClient) MqDestinationsPaginator(orgID, envID, region string) *Paginator[MqDestination] {
+	return NewPaginator(DefaultPageSize, func(ctx context.Context, offset int, limit int) (Page[MqDestination], error) {
+		return client.getMqDestinationsPage(ctx, orgID, envID, region, offset, limit)
+	})
+}
+
+func (client *AnypointClient) getMqDestinationsPage(ctx context.Context, orgID, envID, region string, offset int, limit int) (Page[MqDestination], error) {
+	reqPath := fmt.Sprintf("mq/admin/api/v1/organizations/%s/environments/%s/regions/%s/destinations?offset=%d&limit=%d", orgID, envID, region, offset, limit)
+	req, err := client.newRequest(ctx, "GET", reqPath, nil)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create request")
+		return Page[MqDestination]{}, errors.Wrap(err, "failed to create request")
 	}
 	req.Header.Set("Accept", "application/json")
 
-	res, err := client.HTTPClient.Do(req)
+	res, err := client.do(req)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to call Anypoint Platform")
+		return Page[MqDestination]{}, errors.Wrap(err, "failed to call Anypoint Platform")
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(res.Body)
-		return nil, errors.Errorf("call to Anypoint Platform returned %d: %s", res.StatusCode, string(bodyBytes))
+		return Page[MqDestination]{}, newAPIError(res)
 	}
 
 	var destinations []MqDestination
 	err = decodeResponseBody(res.Body, &destinations)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to decode response")
+		return Page[MqDestination]{}, errors.Wrap(err, "failed to decode response")
 	}
 
-	return destinations, nil
+	return Page[MqDestination]{Items: destinations}, nil
 }
 
 // GetMqQueue retrieves a specific queue by ID
 func (client *AnypointClient) GetMqQueue(orgID, envID, region, queueID string) (*MqDestination, error) {
+	return client.GetMqQueueContext(client.context(), orgID, envID, region, queueID)
+}
+
+// GetMqQueueContext is like GetMqQueue but uses the given context
+func (client *AnypointClient) GetMqQueueContext(ctx context.Context, orgID, envID, region, queueID string) (*MqDestination, error) {
 	reqPath := fmt.Sprintf("mq/admin/api/v1/organizations/%s/environments/%s/regions/%s/destinations/queues/%s", orgID, envID, region, queueID)
-	req, err := client.newRequest("GET", reqPath, nil)
+	req, err := client.newRequest(ctx, "GET", reqPath, nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create request")
 	}
 	req.Header.Set("Accept", "application/json")
 
-	res, err := client.HTTPClient.Do(req)
+	res, err := client.do(req)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to call Anypoint Platform")
 	}
@@ -184,8 +205,7 @@ func (client *AnypointClient) GetMqQueue(orgID, envID, region, queueID string) (
 	}
 
 	if res.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(res.Body)
-		return nil, errors.Errorf("call to Anypoint Platform returned %d: %s", res.StatusCode, string(bodyBytes))
+		return nil, newAPIError(res)
 	}
 
 	var destination MqDestination
@@ -199,6 +219,11 @@ func (client *AnypointClient) GetMqQueue(orgID, envID, region, queueID string) (
 
 // CreateMqQueue creates a new MQ queue
 func (client *AnypointClient) CreateMqQueue(orgID, envID, region string, queue MqQueue) error {
+	return client.CreateMqQueueContext(client.context(), orgID, envID, region, queue)
+}
+
+// CreateMqQueueContext is like CreateMqQueue but uses the given context
+func (client *AnypointClient) CreateMqQueueContext(ctx context.Context, orgID, envID, region string, queue MqQueue) error {
 	reqPath := fmt.Sprintf("mq/admin/api/v1/organizations/%s/environments/%s/regions/%s/destinations/queues/%s", orgID, envID, region, queue.QueueID)
 
 	// Build request body without queueId (it's in the URL)
@@ -219,22 +244,21 @@ func (client *AnypointClient) CreateMqQueue(orgID, envID, region string, queue M
 		return errors.Wrap(err, "failed to encode request")
 	}
 
-	req, err := client.newRequest("PUT", reqPath, buffer)
+	req, err := client.newRequest(ctx, "PUT", reqPath, buffer)
 	if err != nil {
 		return errors.Wrap(err, "failed to create request")
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 
-	res, err := client.HTTPClient.Do(req)
+	res, err := client.do(req)
 	if err != nil {
 		return errors.Wrap(err, "failed to call Anypoint Platform")
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
-		bodyBytes, _ := io.ReadAll(res.Body)
-		return errors.Errorf("call to Anypoint Platform returned %d: %s", res.StatusCode, string(bodyBytes))
+		return newAPIError(res)
 	}
 
 	return nil
@@ -242,6 +266,11 @@ func (client *AnypointClient) CreateMqQueue(orgID, envID, region string, queue M
 
 // UpdateMqQueue updates an existing MQ queue using PATCH
 func (client *AnypointClient) UpdateMqQueue(orgID, envID, region string, queue MqQueue) error {
+	return client.UpdateMqQueueContext(client.context(), orgID, envID, region, queue)
+}
+
+// UpdateMqQueueContext is like UpdateMqQueue but uses the given context
+func (client *AnypointClient) UpdateMqQueueContext(ctx context.Context, orgID, envID, region string, queue MqQueue) error {
 	reqPath := fmt.Sprintf("mq/admin/api/v1/organizations/%s/environments/%s/regions/%s/destinations/queues/%s", orgID, envID, region, queue.QueueID)
 
 	// Build request body for update (PATCH)
@@ -263,22 +292,21 @@ func (client *AnypointClient) UpdateMqQueue(orgID, envID, region string, queue M
 		return errors.Wrap(err, "failed to encode request")
 	}
 
-	req, err := client.newRequest("PATCH", reqPath, buffer)
+	req, err := client.newRequest(ctx, "PATCH", reqPath, buffer)
 	if err != nil {
 		return errors.Wrap(err, "failed to create request")
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 
-	res, err := client.HTTPClient.Do(req)
+	res, err := client.do(req)
 	if err != nil {
 		return errors.Wrap(err, "failed to call Anypoint Platform")
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(res.Body)
-		return errors.Errorf("call to Anypoint Platform returned %d: %s", res.StatusCode, string(bodyBytes))
+		return newAPIError(res)
 	}
 
 	return nil
@@ -286,14 +314,19 @@ func (client *AnypointClient) UpdateMqQueue(orgID, envID, region string, queue M
 
 // GetMqExchange retrieves a specific exchange by ID
 func (client *AnypointClient) GetMqExchange(orgID, envID, region, exchangeID string) (*MqDestination, error) {
+	return client.GetMqExchangeContext(client.context(), orgID, envID, region, exchangeID)
+}
+
+// GetMqExchangeContext is like GetMqExchange but uses the given context
+func (client *AnypointClient) GetMqExchangeContext(ctx context.Context, orgID, envID, region, exchangeID string) (*MqDestination, error) {
 	reqPath := fmt.Sprintf("mq/admin/api/v1/organizations/%s/environments/%s/regions/%s/destinations/exchanges/%s", orgID, envID, region, exchangeID)
-	req, err := client.newRequest("GET", reqPath, nil)
+	req, err := client.newRequest(ctx, "GET", reqPath, nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create request")
 	}
 	req.Header.Set("Accept", "application/json")
 
-	res, err := client.HTTPClient.Do(req)
+	res, err := client.do(req)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to call Anypoint Platform")
 	}
@@ -304,8 +337,7 @@ func (client *AnypointClient) GetMqExchange(orgID, envID, region, exchangeID str
 	}
 
 	if res.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(res.Body)
-		return nil, errors.Errorf("call to Anypoint Platform returned %d: %s", res.StatusCode, string(bodyBytes))
+		return nil, newAPIError(res)
 	}
 
 	var destination MqDestination
@@ -319,6 +351,11 @@ func (client *AnypointClient) GetMqExchange(orgID, envID, region, exchangeID str
 
 // CreateMqExchange creates a new MQ exchange
 func (client *AnypointClient) CreateMqExchange(orgID, envID, region string, exchange MqExchange) error {
+	return client.CreateMqExchangeContext(client.context(), orgID, envID, region, exchange)
+}
+
+// CreateMqExchangeContext is like CreateMqExchange but uses the given context
+func (client *AnypointClient) CreateMqExchangeContext(ctx context.Context, orgID, envID, region string, exchange MqExchange) error {
 	reqPath := fmt.Sprintf("mq/admin/api/v1/organizations/%s/environments/%s/regions/%s/destinations/exchanges/%s", orgID, envID, region, exchange.ExchangeID)
 
 	// Build request body without exchangeId (it's in the URL)
@@ -333,22 +370,21 @@ func (client *AnypointClient) CreateMqExchange(orgID, envID, region string, exch
 		return errors.Wrap(err, "failed to encode request")
 	}
 
-	req, err := client.newRequest("PUT", reqPath, buffer)
+	req, err := client.newRequest(ctx, "PUT", reqPath, buffer)
 	if err != nil {
 		return errors.Wrap(err, "failed to create request")
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 
-	res, err := client.HTTPClient.Do(req)
+	res, err := client.do(req)
 	if err != nil {
 		return errors.Wrap(err, "failed to call Anypoint Platform")
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
-		bodyBytes, _ := io.ReadAll(res.Body)
-		return errors.Errorf("call to Anypoint Platform returned %d: %s", res.StatusCode, string(bodyBytes))
+		return newAPIError(res)
 	}
 
 	return nil
@@ -356,14 +392,19 @@ func (client *AnypointClient) CreateMqExchange(orgID, envID, region string, exch
 
 // GetMqExchangeBindings retrieves all bindings for an exchange (including routing rules)
 func (client *AnypointClient) GetMqExchangeBindings(orgID, envID, region, exchangeID string) ([]MqBinding, error) {
+	return client.GetMqExchangeBindingsContext(client.context(), orgID, envID, region, exchangeID)
+}
+
+// GetMqExchangeBindingsContext is like GetMqExchangeBindings but uses the given context
+func (client *AnypointClient) GetMqExchangeBindingsContext(ctx context.Context, orgID, envID, region, exchangeID string) ([]MqBinding, error) {
 	reqPath := fmt.Sprintf("mq/admin/api/v1/organizations/%s/environments/%s/regions/%s/bindings/exchanges/%s?inclusion=ALL", orgID, envID, region, exchangeID)
-	req, err := client.newRequest("GET", reqPath, nil)
+	req, err := client.newRequest(ctx, "GET", reqPath, nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create request")
 	}
 	req.Header.Set("Accept", "application/json")
 
-	res, err := client.HTTPClient.Do(req)
+	res, err := client.do(req)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to call Anypoint Platform")
 	}
@@ -374,8 +415,7 @@ func (client *AnypointClient) GetMqExchangeBindings(orgID, envID, region, exchan
 	}
 
 	if res.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(res.Body)
-		return nil, errors.Errorf("call to Anypoint Platform returned %d: %s", res.StatusCode, string(bodyBytes))
+		return nil, newAPIError(res)
 	}
 
 	var bindings []MqBinding
@@ -389,23 +429,27 @@ func (client *AnypointClient) GetMqExchangeBindings(orgID, envID, region, exchan
 
 // CreateMqBinding creates a binding between an exchange and a queue (without routing rules)
 func (client *AnypointClient) CreateMqBinding(orgID, envID, region, exchangeID, queueID string) error {
+	return client.CreateMqBindingContext(client.context(), orgID, envID, region, exchangeID, queueID)
+}
+
+// CreateMqBindingContext is like CreateMqBinding but uses the given context
+func (client *AnypointClient) CreateMqBindingContext(ctx context.Context, orgID, envID, region, exchangeID, queueID string) error {
 	reqPath := fmt.Sprintf("mq/admin/api/v1/organizations/%s/environments/%s/regions/%s/bindings/exchanges/%s/queues/%s", orgID, envID, region, exchangeID, queueID)
 
-	req, err := client.newRequest("PUT", reqPath, nil)
+	req, err := client.newRequest(ctx, "PUT", reqPath, nil)
 	if err != nil {
 		return errors.Wrap(err, "failed to create request")
 	}
 	req.Header.Set("Accept", "application/json")
 
-	res, err := client.HTTPClient.Do(req)
+	res, err := client.do(req)
 	if err != nil {
 		return errors.Wrap(err, "failed to call Anypoint Platform")
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusNoContent {
-		bodyBytes, _ := io.ReadAll(res.Body)
-		return errors.Errorf("call to Anypoint Platform returned %d: %s", res.StatusCode, string(bodyBytes))
+		return newAPIError(res)
 	}
 
 	return nil
@@ -418,6 +462,11 @@ type MqRoutingRulesRequest struct {
 
 // UpdateMqBindingRoutingRules updates the routing rules for a binding
 func (client *AnypointClient) UpdateMqBindingRoutingRules(orgID, envID, region, exchangeID, queueID string, routingRules []MqRoutingRule) error {
+	return client.UpdateMqBindingRoutingRulesContext(client.context(), orgID, envID, region, exchangeID, queueID, routingRules)
+}
+
+// UpdateMqBindingRoutingRulesContext is like UpdateMqBindingRoutingRules but uses the given context
+func (client *AnypointClient) UpdateMqBindingRoutingRulesContext(ctx context.Context, orgID, envID, region, exchangeID, queueID string, routingRules []MqRoutingRule) error {
 	reqPath := fmt.Sprintf("mq/admin/api/v1/organizations/%s/environments/%s/regions/%s/bindings/exchanges/%s/queues/%s/rules/routing", orgID, envID, region, exchangeID, queueID)
 
 	requestBody := MqRoutingRulesRequest{RoutingRules: routingRules}
@@ -427,22 +476,21 @@ func (client *AnypointClient) UpdateMqBindingRoutingRules(orgID, envID, region,
 		return errors.Wrap(err, "failed to encode request")
 	}
 
-	req, err := client.newRequest("PUT", reqPath, buffer)
+	req, err := client.newRequest(ctx, "PUT", reqPath, buffer)
 	if err != nil {
 		return errors.Wrap(err, "failed to create request")
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 
-	res, err := client.HTTPClient.Do(req)
+	res, err := client.do(req)
 	if err != nil {
 		return errors.Wrap(err, "failed to call Anypoint Platform")
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusNoContent {
-		bodyBytes, _ := io.ReadAll(res.Body)
-		return errors.Errorf("call to Anypoint Platform returned %d: %s", res.StatusCode, string(bodyBytes))
+		return newAPIError(res)
 	}
 
 	return nil
@@ -450,23 +498,118 @@ func (client *AnypointClient) UpdateMqBindingRoutingRules(orgID, envID, region,
 
 // DeleteMqBinding deletes a binding between an exchange and a queue
 func (client *AnypointClient) DeleteMqBinding(orgID, envID, region, exchangeID, queueID string) error {
+	return client.DeleteMqBindingContext(client.context(), orgID, envID, region, exchangeID, queueID)
+}
+
+// DeleteMqBindingContext is like DeleteMqBinding but uses the given context
+func (client *AnypointClient) DeleteMqBindingContext(ctx context.Context, orgID, envID, region, exchangeID, queueID string) error {
 	reqPath := fmt.Sprintf("mq/admin/api/v1/organizations/%s/environments/%s/regions/%s/bindings/exchanges/%s/queues/%s", orgID, envID, region, exchangeID, queueID)
 
-	req, err := client.newRequest("DELETE", reqPath, nil)
+	req, err := client.newRequest(ctx, "DELETE", reqPath, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create request")
+	}
+
+	res, err := client.do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to call Anypoint Platform")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusNotFound {
+		return newAPIError(res)
+	}
+
+	return nil
+}
+
+// DeleteMqQueue deletes a queue. Messages in the queue are lost, see GetMqQueueStats.
+func (client *AnypointClient) DeleteMqQueue(orgID, envID, region, queueID string) error {
+	return client.DeleteMqQueueContext(client.context(), orgID, envID, region, queueID)
+}
+
+// DeleteMqQueueContext is like DeleteMqQueue but uses the given context
+func (client *AnypointClient) DeleteMqQueueContext(ctx context.Context, orgID, envID, region, queueID string) error {
+	reqPath := fmt.Sprintf("mq/admin/api/v1/organizations/%s/environments/%s/regions/%s/destinations/queues/%s", orgID, envID, region, queueID)
+	return client.deleteMqDestination(ctx, reqPath)
+}
+
+// DeleteMqExchange deletes an exchange together with its bindings
+func (client *AnypointClient) DeleteMqExchange(orgID, envID, region, exchangeID string) error {
+	return client.DeleteMqExchangeContext(client.context(), orgID, envID, region, exchangeID)
+}
+
+// DeleteMqExchangeContext is like DeleteMqExchange but uses the given context
+func (client *AnypointClient) DeleteMqExchangeContext(ctx context.Context, orgID, envID, region, exchangeID string) error {
+	reqPath := fmt.Sprintf("mq/admin/api/v1/organizations/%s/environments/%s/regions/%s/destinations/exchanges/%s", orgID, envID, region, exchangeID)
+	return client.deleteMqDestination(ctx, reqPath)
+}
+
+// deleteMqDestination deletes a queue or exchange. A destination that does not exist is already deleted.
+func (client *AnypointClient) deleteMqDestination(ctx context.Context, reqPath string) error {
+	req, err := client.newRequest(ctx, "DELETE", reqPath, nil)
 	if err != nil {
 		return errors.Wrap(err, "failed to create request")
 	}
 
-	res, err := client.HTTPClient.Do(req)
+	res, err := client.do(req)
 	if err != nil {
 		return errors.Wrap(err, "failed to call Anypoint Platform")
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusNotFound {
-		bodyBytes, _ := io.ReadAll(res.Body)
-		return errors.Errorf("call to Anypoint Platform returned %d: %s", res.StatusCode, string(bodyBytes))
+		return newAPIError(res)
 	}
 
 	return nil
 }
+
+// MqQueueStats is the number of messages currently in a queue, from the Anypoint MQ stats API
+type MqQueueStats struct {
+	Destination      string `json:"destination"`
+	Messages         int64  `json:"messages"`
+	InflightMessages int64  `json:"inflightMessages"`
+}
+
+// IsEmpty returns true if the queue has no messages, neither waiting nor in flight
+func (stats MqQueueStats) IsEmpty() bool {
+	return stats.Messages == 0 && stats.InflightMessages == 0
+}
+
+// GetMqQueueStats retrieves the number of messages currently in a queue
+func (client *AnypointClient) GetMqQueueStats(orgID, envID, region, queueID string) (MqQueueStats, error) {
+	return client.GetMqQueueStatsContext(client.context(), orgID, envID, region, queueID)
+}
+
+// GetMqQueueStatsContext is like GetMqQueueStats but uses the given context
+func (client *AnypointClient) GetMqQueueStatsContext(ctx context.Context, orgID, envID, region, queueID string) (MqQueueStats, error) {
+	reqPath := fmt.Sprintf("mq/stats/api/v1/organizations/%s/environments/%s/regions/%s/queues?destinationIds=%s", orgID, envID, region, url.QueryEscape(queueID))
+	req, err := client.newRequest(ctx, "GET", reqPath, nil)
+	if err != nil {
+		return MqQueueStats{}, errors.Wrap(err, "failed to create request")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := client.do(req)
+	if err != nil {
+		return MqQueueStats{}, errors.Wrap(err, "failed to call Anypoint Platform")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return MqQueueStats{}, newAPIError(res)
+	}
+
+	var stats []MqQueueStats
+	err = decodeResponseBody(res.Body, &stats)
+	if err != nil {
+		return MqQueueStats{}, errors.Wrap(err, "failed to decode response")
+	}
+	for _, queueStats := range stats {
+		if queueStats.Destination == queueID {
+			return queueStats, nil
+		}
+	}
+	return MqQueueStats{}, errors.Errorf("no statistics returned for queue %s", queueID)
+}
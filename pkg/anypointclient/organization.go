@@ -1,6 +1,7 @@
 package anypointclient
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -22,7 +23,12 @@ var organizationCache struct {
 }
 
 func (client *AnypointClient) ResolveOrganization(organizationPath string) (Organization, error) {
-	org, err := client.getOrganizationTree()
+	return client.ResolveOrganizationContext(client.context(), organizationPath)
+}
+
+// ResolveOrganizationContext is like ResolveOrganization but uses the given context
+func (client *AnypointClient) ResolveOrganizationContext(ctx context.Context, organizationPath string) (Organization, error) {
+	org, err := client.getOrganizationTree(ctx)
 	if err != nil {
 		return Organization{}, errors.Wrapf(err, "failed to find organtization %s", organizationPath)
 	}
@@ -46,22 +52,23 @@ func (organization *Organization) findChild(name string) *Organization {
 	return nil
 }
 
-func (client *AnypointClient) getOrganizationTree() (Organization, error) {
+func (client *AnypointClient) getOrganizationTree(ctx context.Context) (Organization, error) {
 	if !organizationCache.loaded {
-		req, _ := client.newRequest("GET", "accounts/api/me", nil)
-		res, err := client.HTTPClient.Do(req)
+		req, _ := client.newRequest(ctx, "GET", "accounts/api/me", nil)
+		res, err := client.do(req)
 		if err != nil {
 			return Organization{}, err
 		}
 		defer res.Body.Close()
 
-		if res.StatusCode == http.StatusOK {
-			err := organizationCache.value.buildOrganizationTree(res.Body)
-			if err != nil {
-				return Organization{}, err
-			}
-			organizationCache.loaded = true
+		if res.StatusCode != http.StatusOK {
+			return Organization{}, newAPIError(res)
+		}
+		err = organizationCache.value.buildOrganizationTree(res.Body)
+		if err != nil {
+			return Organization{}, err
 		}
+		organizationCache.loaded = true
 	}
 	return organizationCache.value, nil
 }
@@ -35,7 +35,7 @@ var _ = Describe("Organisation", func() {
 		err = client.Login()
 		Ω(err == nil).Should(BeTrue(), "Error is %v", err)
 		Ω(client.username).Should(Equal("user"), "username")
-		Ω(client.bearer).Should(Equal("12345678-1234-1234-1234-123456789101"), "bearer")
+		Ω(client.session.bearer).Should(Equal("12345678-1234-1234-1234-123456789101"), "bearer")
 
 		org, err := client.ResolveOrganization("Example Inc/Example Inc Lab/bob-lab")
 		Ω(err == nil).Should(BeTrue(), "Error is %v", err)
@@ -0,0 +1,103 @@
+package anypointclient
+
+import (
+	"context"
+	"iter"
+	"reflect"
+)
+
+// DefaultPageSize is the number of items fetched per page by the paginators of the client
+const DefaultPageSize = 100
+
+// Page is one page of items of a list endpoint. Total and Last are zero when the endpoint does not report them.
+type Page[T any] struct {
+	Items []T
+	Total int
+	Last  bool
+}
+
+// PageFetcher fetches the page of at most limit items starting at offset
+type PageFetcher[T any] func(ctx context.Context, offset int, limit int) (Page[T], error)
+
+// Paginator fetches the items of a list endpoint page by page, with NextPage or All. It stops at a page marked last,
+// an empty page, the total, or without a total at a page that is not full. A page repeating the first item of the
+// previous one means that the endpoint ignores the offset, it is dropped and fetching stops.
+type Paginator[T any] struct {
+	fetch    PageFetcher[T]
+	pageSize int
+	offset   int
+	done     bool
+	first    []T
+}
+
+// NewPaginator creates a paginator fetching pages of pageSize items, DefaultPageSize if pageSize is not positive
+func NewPaginator[T any](pageSize int, fetch PageFetcher[T]) *Paginator[T] {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	return &Paginator[T]{fetch: fetch, pageSize: pageSize}
+}
+
+// HasMorePages returns true until the last page has been fetched
+func (p *Paginator[T]) HasMorePages() bool {
+	return !p.done
+}
+
+// NextPage fetches the next page of items. It returns no items once the last page has been fetched.
+func (p *Paginator[T]) NextPage(ctx context.Context) ([]T, error) {
+	if p.done {
+		return nil, nil
+	}
+	page, err := p.fetch(ctx, p.offset, p.pageSize)
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Items) > 0 && len(p.first) > 0 && reflect.DeepEqual(page.Items[0], p.first[0]) {
+		p.done = true
+		return nil, nil
+	}
+	p.first = page.Items[:min(len(page.Items), 1)]
+	p.offset += len(page.Items)
+	switch {
+	case page.Last || len(page.Items) == 0:
+		p.done = true
+	case page.Total > 0:
+		// The server may cap a page below the requested size, only the total tells whether more items remain
+		p.done = p.offset >= page.Total
+	default:
+		p.done = len(page.Items) != p.pageSize
+	}
+	return page.Items, nil
+}
+
+// All returns an iterator over the remaining items, fetching the pages as they are needed.
+// Iteration stops after the first error, which is yielded with the zero item.
+func (p *Paginator[T]) All(ctx context.Context) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for p.HasMorePages() {
+			items, err := p.NextPage(ctx)
+			if err != nil {
+				var zero T
+				yield(zero, err)
+				return
+			}
+			for _, item := range items {
+				if !yield(item, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Collect fetches every remaining page and returns all items
+func (p *Paginator[T]) Collect(ctx context.Context) ([]T, error) {
+	var all []T
+	for item, err := range p.All(ctx) {
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, item)
+	}
+	return all, nil
+}
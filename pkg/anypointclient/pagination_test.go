@@ -0,0 +1,215 @@
+package anypointclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/jarcoal/httpmock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// registerPagedResponder answers list requests with a window of items, read from the query parameters
+// with the given names, and records the windows asked for
+func registerPagedResponder(path string, offsetParam string, limitParam string, pageNumbers bool, count int, body func(items []map[string]any, offset int, limit int) any) *[]int {
+	var offsets []int
+	httpmock.RegisterResponder("GET", path, func(req *http.Request) (*http.Response, error) {
+		limit, _ := strconv.Atoi(req.URL.Query().Get(limitParam))
+		offset, _ := strconv.Atoi(req.URL.Query().Get(offsetParam))
+		if pageNumbers {
+			offset *= limit
+		}
+		offsets = append(offsets, offset)
+		var items []map[string]any
+		for i := offset; i < min(offset+limit, count); i++ {
+			items = append(items, map[string]any{"id": fmt.Sprintf("id-%d", i), "name": fmt.Sprintf("name-%d", i)})
+		}
+		return httpmock.NewJsonResponse(200, body(items, offset, limit))
+	})
+	return &offsets
+}
+
+var _ = Describe("Pagination", func() {
+	It("should fetch every page of deployments", func() {
+		offsets := registerPagedResponder(rolloutDeploymentsPath, "offset", "limit", false, 250,
+			func(items []map[string]any, offset int, limit int) any {
+				return map[string]any{"total": 250, "items": items}
+			})
+
+		deployments, err := client.GetDeployments(rolloutEnvironment)
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+		Ω(deployments).Should(HaveLen(250))
+		Ω(deployments[249].Name).Should(Equal("name-249"))
+		Ω(*offsets).Should(Equal([]int{0, 100, 200}))
+	})
+
+	It("should stop fetching deployments once the deployment is found", func() {
+		offsets := registerPagedResponder(rolloutDeploymentsPath, "offset", "limit", false, 250,
+			func(items []map[string]any, offset int, limit int) any {
+				return map[string]any{"total": 250, "items": items}
+			})
+
+		id, err := client.getDeloymentId(context.Background(), rolloutEnvironment, "name-120")
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+		Ω(id).Should(Equal("id-120"))
+		Ω(*offsets).Should(Equal([]int{0, 100}))
+	})
+
+	It("should resolve an environment on a later page", func() {
+		registerPagedResponder(`=~/accounts/api/organizations/org-id/environments$`, "offset", "limit", false, 150,
+			func(items []map[string]any, offset int, limit int) any {
+				return map[string]any{"total": 150, "data": items}
+			})
+
+		environment, err := client.ResolveEnvironment(Organization{ID: "org-id"}, "name-140")
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+		Ω(environment.ID).Should(Equal("id-140"))
+
+		_, err = client.ResolveEnvironment(Organization{ID: "org-id"}, "missing")
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("should resolve a private space using page numbers", func() {
+		offsets := registerPagedResponder(`=~/runtimefabric/api/organizations/org-id/privatespaces$`, "page", "size", true, 120,
+			func(items []map[string]any, offset int, limit int) any {
+				return map[string]any{"content": items, "totalElements": 120, "last": offset+limit >= 120}
+			})
+
+		privateSpace, err := client.ResolvePrivateSpace(Organization{ID: "org-id"}, "name-110")
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+		Ω(privateSpace.ID).Should(Equal("id-110"))
+		Ω(*offsets).Should(Equal([]int{0, 100}))
+	})
+
+	It("should fetch every page of MQ destinations", func() {
+		registerPagedResponder(`=~/mq/admin/api/v1/organizations/org-id/environments/env-id/regions/eu-west-1/destinations$`, "offset", "limit", false, 130,
+			func(items []map[string]any, offset int, limit int) any {
+				destinations := []map[string]any{}
+				for _, item := range items {
+					destinations = append(destinations, map[string]any{"type": "queue", "queueId": item["name"]})
+				}
+				return destinations
+			})
+
+		destinations, err := client.GetMqDestinations("org-id", "env-id", "eu-west-1")
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+		Ω(destinations).Should(HaveLen(130))
+	})
+
+	It("should iterate API instances and Exchange assets page by page", func() {
+		apiOffsets := registerPagedResponder(`=~/apimanager/xapi/v1/organizations/org-id/environments/env-id/apis$`, "offset", "limit", false, 101,
+			func(items []map[string]any, offset int, limit int) any {
+				instances := []map[string]any{}
+				for i := range items {
+					instances = append(instances, map[string]any{"id": offset + i})
+				}
+				return map[string]any{"total": 101, "instances": instances}
+			})
+		assetOffsets := registerPagedResponder(`=~/exchange/api/v2/assets$`, "offset", "limit", false, 100,
+			func(items []map[string]any, offset int, limit int) any {
+				return items
+			})
+
+		paginator := client.ApisPaginator("org-id", "env-id")
+		page, err := paginator.NextPage(context.Background())
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+		Ω(page).Should(HaveLen(100))
+		Ω(paginator.HasMorePages()).Should(BeTrue())
+		page, err = paginator.NextPage(context.Background())
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+		Ω(page).Should(HaveLen(1))
+		Ω(page[0].ID).Should(Equal(100))
+		Ω(paginator.HasMorePages()).Should(BeFalse())
+		Ω(*apiOffsets).Should(Equal([]int{0, 100}))
+
+		assets, err := client.ExchangeAssetsPaginator("org-id").Collect(context.Background())
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+		Ω(assets).Should(HaveLen(100))
+		// A full last page is followed by an empty one
+		Ω(*assetOffsets).Should(Equal([]int{0, 100}))
+	})
+
+	It("should stop when the endpoint ignores the paging", func() {
+		calls := 0
+		paginator := NewPaginator(2, func(ctx context.Context, offset int, limit int) (Page[int], error) {
+			calls++
+			return Page[int]{Items: []int{1, 2, 3}}, nil
+		})
+
+		items, err := paginator.Collect(context.Background())
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+		Ω(items).Should(Equal([]int{1, 2, 3}))
+		Ω(calls).Should(Equal(1))
+	})
+
+	It("should stop when the endpoint ignores the offset of full pages", func() {
+		var offsets []int
+		paginator := NewPaginator(2, func(ctx context.Context, offset int, limit int) (Page[int], error) {
+			offsets = append(offsets, offset)
+			return Page[int]{Items: []int{1, 2}}, nil
+		})
+
+		items, err := paginator.Collect(context.Background())
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+		Ω(items).Should(Equal([]int{1, 2}))
+		Ω(offsets).Should(Equal([]int{0, 2}))
+	})
+
+	It("should keep fetching short pages until the total is reached", func() {
+		var offsets []int
+		paginator := NewPaginator(3, func(ctx context.Context, offset int, limit int) (Page[int], error) {
+			offsets = append(offsets, offset)
+			// The server caps every page at 2 items, below the requested limit
+			items := []int{}
+			for i := offset; i < min(offset+2, 5); i++ {
+				items = append(items, i)
+			}
+			return Page[int]{Items: items, Total: 5}, nil
+		})
+
+		items, err := paginator.Collect(context.Background())
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+		Ω(items).Should(Equal([]int{0, 1, 2, 3, 4}))
+		Ω(offsets).Should(Equal([]int{0, 2, 4}))
+	})
+
+	It("should fetch a full page of MQ destinations from an endpoint that ignores the offset", func() {
+		registerPagedResponder(`=~/mq/admin/api/v1/organizations/org-id/environments/env-id/regions/eu-west-1/destinations$`, "ignored", "limit", false, 100,
+			func(items []map[string]any, offset int, limit int) any {
+				destinations := []map[string]any{}
+				for _, item := range items {
+					destinations = append(destinations, map[string]any{"type": "queue", "queueId": item["name"]})
+				}
+				return destinations
+			})
+
+		destinations, err := client.GetMqDestinations("org-id", "env-id", "eu-west-1")
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+		Ω(destinations).Should(HaveLen(100))
+	})
+
+	It("should yield the error of a failed page and stop", func() {
+		failure := errors.New("failure")
+		paginator := NewPaginator(1, func(ctx context.Context, offset int, limit int) (Page[string], error) {
+			if offset > 0 {
+				return Page[string]{}, failure
+			}
+			return Page[string]{Items: []string{"1"}}, nil
+		})
+
+		var items []string
+		var errs []error
+		for item, err := range paginator.All(context.Background()) {
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			items = append(items, item)
+		}
+		Ω(items).Should(Equal([]string{"1"}))
+		Ω(errs).Should(Equal([]error{failure}))
+	})
+})
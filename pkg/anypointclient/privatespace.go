@@ -1,6 +1,7 @@
 package anypointclient
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -49,33 +50,57 @@ type PrivateSpacesResponse struct {
 }
 
 /*
-ResolveEnvironment will resolve, in the given organization, an Environment by name.
+ResolvePrivateSpace will resolve, in the given organization, a PrivateSpace by name.
 */
 func (client *AnypointClient) ResolvePrivateSpace(organization Organization, privateSpaceName string) (PrivateSpace, error) {
-	privateSpaceResponse := new(PrivateSpacesResponse)
-
-	reqPath := fmt.Sprintf("runtimefabric/api/organizations/%s/privatespaces", organization.ID)
-	res, err := client.newGetRequest(reqPath)
-	if err != nil {
-		return PrivateSpace{}, err
-	}
-	defer res.Body.Close()
+	return client.ResolvePrivateSpaceContext(client.context(), organization, privateSpaceName)
+}
 
-	if res.StatusCode == http.StatusOK {
-		bodyBytes, err := io.ReadAll(res.Body)
+// ResolvePrivateSpaceContext is like ResolvePrivateSpace but uses the given context
+func (client *AnypointClient) ResolvePrivateSpaceContext(ctx context.Context, organization Organization, privateSpaceName string) (PrivateSpace, error) {
+	for privateSpace, err := range client.PrivateSpacesPaginator(organization).All(ctx) {
 		if err != nil {
 			return PrivateSpace{}, err
 		}
-		err = json.Unmarshal(bodyBytes, &privateSpaceResponse)
-		if err != nil {
-			return PrivateSpace{}, err
-		}
-	}
-
-	for _, privateSpace := range privateSpaceResponse.PrivateSpaces {
 		if privateSpace.Name == privateSpaceName {
 			return privateSpace, nil
 		}
 	}
 	return PrivateSpace{}, fmt.Errorf("failed to find environment named %s in organization %s", privateSpaceName, organization.Name)
 }
+
+// PrivateSpacesPaginator returns a paginator over the private spaces of an organization
+func (client *AnypointClient) PrivateSpacesPaginator(organization Organization) *Paginator[PrivateSpace] {
+	return NewPaginator(DefaultPageSize, func(ctx context.Context, offset int, limit int) (Page[PrivateSpace], error) {
+		return client.getPrivateSpacesPage(ctx, organization, offset, limit)
+	})
+}
+
+// getPrivateSpacesPage fetches a page of private spaces. Runtime Fabric pages by page number rather than offset.
+func (client *AnypointClient) getPrivateSpacesPage(ctx context.Context, organization Organization, offset int, limit int) (Page[PrivateSpace], error) {
+	privateSpaceResponse := new(PrivateSpacesResponse)
+
+	reqPath := fmt.Sprintf("runtimefabric/api/organizations/%s/privatespaces?page=%d&size=%d", organization.ID, offset/limit, limit)
+	res, err := client.newGetRequest(ctx, reqPath)
+	if err != nil {
+		return Page[PrivateSpace]{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return Page[PrivateSpace]{}, fmt.Errorf("failed to list private spaces in organization %s: %w", organization.Name, newAPIError(res))
+	}
+	bodyBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		return Page[PrivateSpace]{}, err
+	}
+	err = json.Unmarshal(bodyBytes, &privateSpaceResponse)
+	if err != nil {
+		return Page[PrivateSpace]{}, err
+	}
+	return Page[PrivateSpace]{
+		Items: privateSpaceResponse.PrivateSpaces,
+		Total: privateSpaceResponse.TotalElements,
+		Last:  privateSpaceResponse.Last,
+	}, nil
+}
@@ -0,0 +1,196 @@
+package anypointclient
+
+import (
+	"context"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy controls how requests are retried after a network error, a 429 or a 5xx response. POST and DELETE
+// requests are only retried when marked idempotent, or after a 429 or a 503 with Retry-After.
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt, 0 disables retries
+	MaxRetries int
+	// InitialBackoff is the wait before the first retry, doubled for every following retry
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between two attempts, including waits asked for with Retry-After
+	MaxBackoff time.Duration
+	// Logf is called for every retry, defaults to log.Printf
+	Logf func(format string, v ...any)
+}
+
+// DefaultRetryPolicy returns the retry policy used by new clients
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries:     3,
+		InitialBackoff: time.Second,
+		MaxBackoff:     30 * time.Second,
+		Logf:           log.Printf,
+	}
+}
+
+// backoff returns the wait before the given retry, starting at 1, as the exponential backoff with full jitter
+func (policy RetryPolicy) backoff(retry int) time.Duration {
+	backoff := policy.InitialBackoff
+	for i := 1; i < retry && backoff < policy.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+		backoff = policy.MaxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(backoff))) + 1
+}
+
+// markIdempotent marks a request as safe to retry regardless of its method. This follows the convention of
+// net/http, where a nil Idempotency-Key header marks the request without the header being sent.
+func markIdempotent(req *http.Request) {
+	req.Header["Idempotency-Key"] = nil
+}
+
+// isIdempotent returns true if the request can be sent again after a failure without side effects
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPatch:
+		return true
+	}
+	_, marked := req.Header["Idempotency-Key"]
+	return marked
+}
+
+// isRetryableStatus returns true for responses indicating a transient failure
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests ||
+		(statusCode >= http.StatusInternalServerError && statusCode != http.StatusNotImplemented)
+}
+
+// notProcessed returns true if the response tells that the request was rejected before it was processed
+func notProcessed(res *http.Response) bool {
+	return res.StatusCode == http.StatusTooManyRequests ||
+		(res.StatusCode == http.StatusServiceUnavailable && res.Header.Get("Retry-After") != "")
+}
+
+// retryAfter returns the wait asked for by the Retry-After header of a response, in seconds or as an HTTP date
+func retryAfter(res *http.Response, now time.Time) (time.Duration, bool) {
+	value := res.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := date.Sub(now); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// do sends a request to Anypoint Platform with the bearer token of the client, retrying it according to the
+// retry policy of the client. The request timeout of the client applies to all attempts, until the body of
+// the response is closed.
+func (client *AnypointClient) do(req *http.Request) (*http.Response, error) {
+	return client.withRequestTimeout(req, client.doAuthenticated)
+}
+
+// withRequestTimeout sends a request with the request timeout of the client
+func (client *AnypointClient) withRequestTimeout(req *http.Request, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	if client.RequestTimeout <= 0 {
+		return sendRecordingRequest(req, send)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), client.RequestTimeout)
+	res, err := sendRecordingRequest(req.WithContext(ctx), send)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	res.Body = &cancelOnClose{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+// sendRecordingRequest sends a request and sets it as the request of the response if the transport did not,
+// so that an APIError can tell which request failed
+func sendRecordingRequest(req *http.Request, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	res, err := send(req)
+	if err == nil && res.Request == nil {
+		res.Request = req
+	}
+	return res, err
+}
+
+// cancelOnClose releases the context of a request when the body of the response is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (body *cancelOnClose) Close() error {
+	defer body.cancel()
+	return body.ReadCloser.Close()
+}
+
+func (client *AnypointClient) doWithRetries(req *http.Request) (*http.Response, error) {
+	policy := client.RetryPolicy
+	for retry := 1; ; retry++ {
+		res, err := client.HTTPClient.Do(req)
+
+		var reason string
+		var wait time.Duration
+		switch {
+		case err != nil:
+			if req.Context().Err() != nil || !isIdempotent(req) {
+				return nil, err
+			}
+			reason = err.Error()
+		case isRetryableStatus(res.StatusCode):
+			if !isIdempotent(req) && !notProcessed(res) {
+				return res, nil
+			}
+			reason = res.Status
+			wait, _ = retryAfter(res, time.Now())
+		default:
+			return res, nil
+		}
+
+		if retry > policy.MaxRetries || (req.Body != nil && req.GetBody == nil) {
+			return res, err
+		}
+		if backoff := policy.backoff(retry); wait < backoff {
+			wait = backoff
+		}
+		if policy.MaxBackoff > 0 && wait > policy.MaxBackoff {
+			wait = policy.MaxBackoff
+		}
+		if res != nil {
+			// Drain the body so that the connection can be reused
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to rewind request body for retry")
+			}
+			req.Body = body
+		}
+
+		if policy.Logf != nil {
+			policy.Logf("%s %s failed with %s, retry %d of %d in %s", req.Method, req.URL.Path, reason, retry, policy.MaxRetries, wait.Round(time.Millisecond))
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+	}
+}
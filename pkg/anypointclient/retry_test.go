@@ -0,0 +1,171 @@
+package anypointclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Retry", func() {
+	var retries []string
+
+	BeforeEach(func() {
+		retries = nil
+		client.RetryPolicy = RetryPolicy{
+			MaxRetries:     2,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     10 * time.Millisecond,
+			Logf: func(format string, v ...any) {
+				retries = append(retries, fmt.Sprintf(format, v...))
+			},
+		}
+	})
+
+	AfterEach(func() {
+		client.RetryPolicy = DefaultRetryPolicy()
+	})
+
+	// registerStatusResponses answers with the given status codes in order, the last one is repeated
+	registerStatusResponses := func(method string, path string, statusCodes ...int) {
+		calls := 0
+		httpmock.RegisterResponder(method, path, func(req *http.Request) (*http.Response, error) {
+			statusCode := statusCodes[min(calls, len(statusCodes)-1)]
+			calls++
+			return httpmock.NewStringResponse(statusCode, `{"total": 0, "items": []}`), nil
+		})
+	}
+
+	It("should retry GET requests failing with 5xx", func() {
+		registerStatusResponses("GET", rolloutDeploymentsPath, 502, 503, 200)
+
+		_, err := client.GetDeployments(rolloutEnvironment)
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+		Ω(httpmock.GetTotalCallCount()).Should(Equal(3))
+		Ω(retries).Should(HaveLen(2))
+		Ω(retries[0]).Should(ContainSubstring("502"))
+	})
+
+	It("should give up after the max number of retries", func() {
+		registerStatusResponses("GET", rolloutDeploymentsPath, 500)
+
+		_, err := client.GetDeployments(rolloutEnvironment)
+		Ω(err).Should(HaveOccurred())
+		Ω(httpmock.GetTotalCallCount()).Should(Equal(3))
+	})
+
+	It("should resend the body of PATCH requests", func() {
+		var bodies []string
+		httpmock.RegisterResponder("PATCH", `=~/apimanager/api/v1/organizations/org-id/environments/env-id/apis/1/policies/2$`,
+			func(req *http.Request) (*http.Response, error) {
+				body := make([]byte, req.ContentLength)
+				req.Body.Read(body)
+				bodies = append(bodies, string(body))
+				if len(bodies) == 1 {
+					return httpmock.NewStringResponse(504, ""), nil
+				}
+				return httpmock.NewStringResponse(200, "{}"), nil
+			})
+
+		err := client.UpdateApiInstancePolicies("org-id", "env-id", 1, 2, ApiPolicyRequest{})
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+		Ω(bodies).Should(HaveLen(2))
+		Ω(bodies[1]).Should(Equal(bodies[0]))
+	})
+
+	It("should not retry POST requests that may have been processed", func() {
+		registerStatusResponses("POST", `=~/apimanager/api/v1/organizations/org-id/environments/env-id/apis/1/policies$`, 502, 201)
+
+		err := client.CreateApiInstancePolicies("org-id", "env-id", 1, ApiPolicyRequest{})
+		Ω(err).Should(HaveOccurred())
+		Ω(httpmock.GetTotalCallCount()).Should(Equal(1))
+		Ω(retries).Should(BeEmpty())
+	})
+
+	It("should retry POST requests that were throttled", func() {
+		registerStatusResponses("POST", `=~/apimanager/api/v1/organizations/org-id/environments/env-id/apis/1/policies$`, 429, 201)
+
+		err := client.CreateApiInstancePolicies("org-id", "env-id", 1, ApiPolicyRequest{})
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+		Ω(httpmock.GetTotalCallCount()).Should(Equal(2))
+	})
+
+	It("should honour Retry-After up to the max backoff", func() {
+		res := &http.Response{Header: http.Header{}}
+		res.Header.Set("Retry-After", "2")
+		wait, found := retryAfter(res, time.Now())
+		Ω(found).Should(BeTrue())
+		Ω(wait).Should(Equal(2 * time.Second))
+
+		now := time.Now()
+		res.Header.Set("Retry-After", now.Add(time.Minute).UTC().Format(http.TimeFormat))
+		wait, found = retryAfter(res, now)
+		Ω(found).Should(BeTrue())
+		Ω(wait).Should(BeNumerically("~", time.Minute, time.Second))
+	})
+
+	It("should back off exponentially with jitter", func() {
+		policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+		for i := 0; i < 20; i++ {
+			Ω(policy.backoff(1)).Should(BeNumerically("<=", 100*time.Millisecond))
+			Ω(policy.backoff(3)).Should(BeNumerically("<=", 400*time.Millisecond))
+			Ω(policy.backoff(10)).Should(BeNumerically("<=", time.Second))
+			Ω(policy.backoff(10)).Should(BeNumerically(">", 0))
+		}
+	})
+})
+
+var _ = Describe("Context", func() {
+	AfterEach(func() {
+		client.RequestTimeout = 0
+	})
+
+	It("should stop retrying when the context is cancelled", func() {
+		client.RetryPolicy.InitialBackoff = time.Hour
+		client.RetryPolicy.MaxBackoff = time.Hour
+		defer func() {
+			client.RetryPolicy = DefaultRetryPolicy()
+		}()
+		httpmock.RegisterResponder("GET", rolloutDeploymentsPath, httpmock.NewStringResponder(503, ""))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := client.GetDeploymentsContext(ctx, rolloutEnvironment)
+		Ω(errors.Is(err, context.DeadlineExceeded)).Should(BeTrue(), "Error is %+v", err)
+		Ω(httpmock.GetTotalCallCount()).Should(Equal(1))
+	})
+
+	It("should use the context of the client for methods without context", func() {
+		// Like a real transport, fail requests whose context is done
+		httpmock.RegisterResponder("GET", rolloutDeploymentsPath, func(req *http.Request) (*http.Response, error) {
+			if err := req.Context().Err(); err != nil {
+				return nil, err
+			}
+			return httpmock.NewStringResponse(200, `{"total": 0, "items": []}`), nil
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := client.WithContext(ctx).GetDeployments(rolloutEnvironment)
+		Ω(errors.Is(err, context.Canceled)).Should(BeTrue(), "Error is %+v", err)
+
+		_, err = client.GetDeployments(rolloutEnvironment)
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+	})
+
+	It("should time out requests taking longer than the request timeout", func() {
+		client.RequestTimeout = 10 * time.Millisecond
+		httpmock.RegisterResponder("GET", rolloutDeploymentsPath, func(req *http.Request) (*http.Response, error) {
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		})
+
+		_, err := client.GetDeployments(rolloutEnvironment)
+		Ω(errors.Is(err, context.DeadlineExceeded)).Should(BeTrue(), "Error is %+v", err)
+	})
+})
@@ -0,0 +1,101 @@
+package anypointclient
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RolloutStatus is the progress of a deployment towards its desired version
+type RolloutStatus string
+
+const (
+	RolloutInProgress RolloutStatus = "IN_PROGRESS"
+	RolloutSucceeded  RolloutStatus = "SUCCEEDED"
+	RolloutFailed     RolloutStatus = "FAILED"
+)
+
+// Rollout returns the progress of the deployment towards its desired version.
+// A rollout has succeeded when the deployment is applied, the application has reached its desired state
+// and every replica is running the desired version.
+func (deployment CloudhubDeploymentResp) Rollout() RolloutStatus {
+	if deployment.Status == "FAILED" || deployment.Application.Status == "FAILED" || deployment.Application.Status == "DEPLOYMENT_FAILED" {
+		return RolloutFailed
+	}
+	for _, replica := range deployment.Replicas {
+		if replica.State == "FAILED" {
+			return RolloutFailed
+		}
+	}
+
+	if deployment.Status != "APPLIED" {
+		return RolloutInProgress
+	}
+	if deployment.Application.DesiredState == "STOPPED" {
+		if deployment.Application.Status == "NOT_RUNNING" {
+			return RolloutSucceeded
+		}
+		return RolloutInProgress
+	}
+	if deployment.Application.Status != "RUNNING" || len(deployment.Replicas) == 0 {
+		return RolloutInProgress
+	}
+	for _, replica := range deployment.Replicas {
+		if replica.State != "STARTED" {
+			return RolloutInProgress
+		}
+		if deployment.DesiredVersion != "" && replica.CurrentDeploymentVersion != deployment.DesiredVersion {
+			return RolloutInProgress
+		}
+	}
+	return RolloutSucceeded
+}
+
+// ReplicaReasons returns the reason reported by every replica that has one
+func (deployment CloudhubDeploymentResp) ReplicaReasons() []string {
+	var reasons []string
+	for _, replica := range deployment.Replicas {
+		if replica.Reason != "" {
+			reasons = append(reasons, replica.Reason)
+		}
+	}
+	return reasons
+}
+
+// WaitForRollout polls the deployment every interval until its rollout has succeeded or failed.
+// The last state fetched is returned also when the rollout fails or does not finish within the timeout,
+// so that the replica reasons can be reported.
+func (client *AnypointClient) WaitForRollout(environment Environment, deploymentName string, timeout time.Duration, interval time.Duration) (CloudhubDeploymentResp, error) {
+	return client.WaitForRolloutContext(client.context(), environment, deploymentName, timeout, interval)
+}
+
+// WaitForRolloutContext is like WaitForRollout but uses the given context
+func (client *AnypointClient) WaitForRolloutContext(ctx context.Context, environment Environment, deploymentName string, timeout time.Duration, interval time.Duration) (CloudhubDeploymentResp, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		deployment, err := client.GetDeploymentContext(ctx, environment, deploymentName)
+		if err != nil {
+			return deployment, errors.Wrapf(err, "failed to get deployment %s", deploymentName)
+		}
+		if deployment.Name == "" {
+			return deployment, errors.Errorf("deployment %s not found", deploymentName)
+		}
+
+		switch deployment.Rollout() {
+		case RolloutSucceeded:
+			return deployment, nil
+		case RolloutFailed:
+			return deployment, errors.Errorf("rollout of deployment %s failed with status %s/%s", deploymentName, deployment.Status, deployment.Application.Status)
+		}
+
+		if time.Now().Add(interval).After(deadline) {
+			return deployment, errors.Errorf("rollout of deployment %s did not finish within %s, status is %s/%s", deploymentName, timeout, deployment.Status, deployment.Application.Status)
+		}
+		select {
+		case <-ctx.Done():
+			return deployment, errors.Wrapf(ctx.Err(), "stopped waiting for rollout of deployment %s", deploymentName)
+		case <-time.After(interval):
+		}
+	}
+}
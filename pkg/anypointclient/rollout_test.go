@@ -0,0 +1,74 @@
+package anypointclient
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	rolloutDeploymentsPath = `=~/amc/application-manager/api/v2/organizations/org-id/environments/env-id/deployments$`
+	rolloutDeploymentPath  = `=~/amc/application-manager/api/v2/organizations/org-id/environments/env-id/deployments/deployment-id$`
+)
+
+var rolloutEnvironment = Environment{ID: "env-id", Name: "Sandbox", OrganizationID: "org-id"}
+
+func registerDeploymentResponses(responses ...string) {
+	httpmock.RegisterResponder("GET", rolloutDeploymentsPath,
+		httpmock.NewStringResponder(200, `{"total": 1, "items": [{"id": "deployment-id", "name": "my-app"}]}`))
+	// The last response is repeated once all responses have been returned
+	calls := 0
+	httpmock.RegisterResponder("GET", rolloutDeploymentPath, func(req *http.Request) (*http.Response, error) {
+		body := responses[min(calls, len(responses)-1)]
+		calls++
+		return httpmock.NewStringResponse(200, body), nil
+	})
+}
+
+var _ = Describe("Rollout", func() {
+	It("should succeed when every replica runs the desired version", func() {
+		registerDeploymentResponses(
+			`{"name": "my-app", "status": "APPLYING", "desiredVersion": "v2", "application": {"status": "RUNNING", "desiredState": "STARTED"},
+			  "replicas": [{"state": "STARTED", "currentDeploymentVersion": "v1"}]}`,
+			`{"name": "my-app", "status": "APPLIED", "desiredVersion": "v2", "application": {"status": "RUNNING", "desiredState": "STARTED"},
+			  "replicas": [{"state": "STARTED", "currentDeploymentVersion": "v2"}]}`,
+		)
+
+		deployment, err := client.WaitForRollout(rolloutEnvironment, "my-app", time.Second, time.Millisecond)
+		Ω(err == nil).Should(BeTrue(), "Error is %+v", err)
+		Ω(deployment.Rollout()).Should(Equal(RolloutSucceeded))
+	})
+
+	It("should fail with the replica reasons when a replica fails", func() {
+		registerDeploymentResponses(
+			`{"name": "my-app", "status": "APPLIED", "desiredVersion": "v2", "application": {"status": "DEPLOYMENT_FAILED", "desiredState": "STARTED"},
+			  "replicas": [{"state": "FAILED", "currentDeploymentVersion": "v2", "reason": "Application failed to start"}]}`,
+		)
+
+		deployment, err := client.WaitForRollout(rolloutEnvironment, "my-app", time.Second, time.Millisecond)
+		Ω(err).Should(HaveOccurred())
+		Ω(deployment.ReplicaReasons()).Should(ConsistOf("Application failed to start"))
+	})
+
+	It("should time out when the rollout does not finish", func() {
+		registerDeploymentResponses(
+			`{"name": "my-app", "status": "APPLYING", "desiredVersion": "v2", "application": {"status": "RUNNING", "desiredState": "STARTED"},
+			  "replicas": [{"state": "PENDING", "currentDeploymentVersion": "v1"}]}`,
+		)
+
+		_, err := client.WaitForRollout(rolloutEnvironment, "my-app", 20*time.Millisecond, 5*time.Millisecond)
+		Ω(err).Should(HaveOccurred())
+		Ω(err.Error()).Should(ContainSubstring("did not finish within"))
+	})
+
+	It("should treat a stopped application as rolled out", func() {
+		var deployment CloudhubDeploymentResp
+		deployment.Status = "APPLIED"
+		deployment.Application.DesiredState = "STOPPED"
+		deployment.Application.Status = "NOT_RUNNING"
+		Ω(deployment.Rollout()).Should(Equal(RolloutSucceeded))
+	})
+})
@@ -0,0 +1,98 @@
+package anypointclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// tokenRefreshMargin is how long before it expires a token is replaced by a new one
+const tokenRefreshMargin = time.Minute
+
+// session holds the bearer token of a client, shared by the copies made with WithContext
+type session struct {
+	mu        sync.Mutex
+	bearer    string
+	expiresAt time.Time
+}
+
+// token returns the current bearer token and when it expires, the zero time if the expiry is unknown
+func (s *session) token() (string, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bearer, s.expiresAt
+}
+
+// expiring returns true if the token is missing or expires within the refresh margin
+func (s *session) expiring(now time.Time) bool {
+	bearer, expiresAt := s.token()
+	return bearer == "" || (!expiresAt.IsZero() && expiresAt.Sub(now) < tokenRefreshMargin)
+}
+
+// canRefresh returns true if the client can acquire a new token with its credentials
+func (client *AnypointClient) canRefresh() bool {
+	return client.authType != BearerAuthenticationType
+}
+
+// refresh logs in again, unless another request already replaced the stale token while waiting for the lock
+func (client *AnypointClient) refresh(ctx context.Context, stale string) (string, error) {
+	client.session.mu.Lock()
+	defer client.session.mu.Unlock()
+	if client.session.bearer != stale && client.session.bearer != "" {
+		return client.session.bearer, nil
+	}
+	login, err := client.getAuthorizationBearerToken(ctx, client.authType)
+	if err != nil {
+		return "", err
+	}
+	client.session.bearer = login.AccessToken
+	client.session.expiresAt = time.Time{}
+	if login.ExpiresIn > 0 {
+		client.session.expiresAt = time.Now().Add(time.Duration(login.ExpiresIn) * time.Second)
+	}
+	return login.AccessToken, nil
+}
+
+// doAuthenticated sends a request with the bearer token of the client. The token is refreshed before it expires,
+// and once when Anypoint Platform answers 401 Unauthorized, after which the request is sent again.
+func (client *AnypointClient) doAuthenticated(req *http.Request) (*http.Response, error) {
+	bearer, _ := client.session.token()
+	if client.canRefresh() && client.session.expiring(time.Now()) {
+		var err error
+		if bearer, err = client.refresh(req.Context(), bearer); err != nil {
+			return nil, err
+		}
+	}
+	if bearer == "" {
+		return nil, errors.New("not logged in to Anypoint Platform, no bearer token")
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", bearer))
+
+	res, err := client.doWithRetries(req)
+	if err != nil || res.StatusCode != http.StatusUnauthorized || !client.canRefresh() {
+		return res, err
+	}
+	if req.Body != nil && req.GetBody == nil {
+		return res, nil
+	}
+
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	if bearer, err = client.refresh(req.Context(), bearer); err != nil {
+		return nil, errors.Wrap(err, "failed to log in again after 401 Unauthorized")
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to rewind request body")
+		}
+		req.Body = body
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", bearer))
+	return client.doWithRetries(req)
+}
@@ -0,0 +1,126 @@
+package anypointfake
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient"
+)
+
+// tokenLifetime is the lifetime in seconds of the bearer tokens issued, reported but not enforced
+const tokenLifetime = 3600
+
+// AddEnvironment adds an environment to the organization it belongs to
+func (server *Server) AddEnvironment(environment anypointclient.Environment) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.environments = append(server.environments, environment)
+}
+
+// AddPrivateSpace adds a private space to the organization it belongs to
+func (server *Server) AddPrivateSpace(privateSpace anypointclient.PrivateSpace) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.privateSpaces = append(server.privateSpaces, privateSpace)
+}
+
+func (server *Server) registerAccounts() {
+	server.mux.HandleFunc("POST /accounts/login", func(w http.ResponseWriter, r *http.Request) {
+		server.login(w, r.PostFormValue("username") != "" && r.PostFormValue("password") != "")
+	})
+	server.mux.HandleFunc("POST /accounts/api/v2/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		server.login(w, r.PostFormValue("client_id") != "" && r.PostFormValue("client_secret") != "")
+	})
+	server.handle("GET /accounts/api/me", server.me)
+	server.handle("GET /accounts/api/organizations/{orgID}/environments", server.listEnvironments)
+	server.handle("GET /runtimefabric/api/organizations/{orgID}/privatespaces", server.listPrivateSpaces)
+}
+
+// login issues a new bearer token for any credentials given
+func (server *Server) login(w http.ResponseWriter, authenticated bool) {
+	if !authenticated {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client", "error_description": "Invalid credentials"})
+		return
+	}
+	server.mu.Lock()
+	token := fmt.Sprintf("token-%d", server.newID())
+	server.tokens[token] = true
+	server.mu.Unlock()
+	writeJSON(w, http.StatusOK, anypointclient.LoginResponse{AccessToken: token, TokenType: "bearer", ExpiresIn: tokenLifetime})
+}
+
+// me returns the organization tree, flattened into the organizations the user is a member of
+func (server *Server) me(w http.ResponseWriter, r *http.Request) {
+	type memberOf struct {
+		ID                 string   `json:"id"`
+		Name               string   `json:"name"`
+		SubOrganizationIDs []string `json:"subOrganizationIds"`
+	}
+	var members []memberOf
+	var flatten func(organization anypointclient.Organization)
+	flatten = func(organization anypointclient.Organization) {
+		member := memberOf{ID: organization.ID, Name: organization.Name, SubOrganizationIDs: []string{}}
+		for _, sub := range organization.SubOrganizations {
+			member.SubOrganizationIDs = append(member.SubOrganizationIDs, sub.ID)
+		}
+		members = append(members, member)
+		for _, sub := range organization.SubOrganizations {
+			flatten(sub)
+		}
+	}
+	flatten(server.organization)
+
+	var me struct {
+		User struct {
+			Organization struct {
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			} `json:"organization"`
+			MemberOfOrganizations []memberOf `json:"memberOfOrganizations"`
+		} `json:"user"`
+	}
+	me.User.Organization.ID = server.organization.ID
+	me.User.Organization.Name = server.organization.Name
+	me.User.MemberOfOrganizations = members
+	writeJSON(w, http.StatusOK, me)
+}
+
+func (server *Server) listEnvironments(w http.ResponseWriter, r *http.Request) {
+	var environments []anypointclient.Environment
+	for _, environment := range server.environments {
+		if environment.OrganizationID == r.PathValue("orgID") {
+			environments = append(environments, environment)
+		}
+	}
+	writeJSON(w, http.StatusOK, anypointclient.EnvironmentResponse{
+		Data:  page(environments, queryInt(r, "offset"), queryInt(r, "limit")),
+		Total: len(environments),
+	})
+}
+
+// listPrivateSpaces returns a page of private spaces. Runtime Fabric pages by page number rather than offset.
+func (server *Server) listPrivateSpaces(w http.ResponseWriter, r *http.Request) {
+	var privateSpaces []anypointclient.PrivateSpace
+	for _, privateSpace := range server.privateSpaces {
+		if privateSpace.OrganizationID == r.PathValue("orgID") {
+			privateSpaces = append(privateSpaces, privateSpace)
+		}
+	}
+	size := queryInt(r, "size")
+	if size <= 0 {
+		size = len(privateSpaces)
+	}
+	offset := queryInt(r, "page") * size
+	writeJSON(w, http.StatusOK, anypointclient.PrivateSpacesResponse{
+		PrivateSpaces: page(privateSpaces, offset, size),
+		TotalElements: len(privateSpaces),
+		Last:          offset+size >= len(privateSpaces),
+	})
+}
+
+// queryInt returns the query parameter as a number, 0 if it is missing or not a number
+func queryInt(r *http.Request, name string) int {
+	value, _ := strconv.Atoi(r.URL.Query().Get(name))
+	return value
+}
@@ -0,0 +1,13 @@
+package anypointfake_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestAnypointFake(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Anypoint Fake Server Test Suite")
+}
@@ -0,0 +1,247 @@
+package anypointfake
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient"
+)
+
+// AddApi adds an API instance to the environment. An instance without ID is given one.
+func (server *Server) AddApi(environment anypointclient.Environment, instance anypointclient.ApiInstance) anypointclient.ApiInstance {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if instance.ID == 0 {
+		instance.ID = server.newID()
+	}
+	server.apis[environment.ID] = append(server.apis[environment.ID], instance)
+	return instance
+}
+
+// AddPolicy adds a policy to an API instance. A policy without ID is given one.
+func (server *Server) AddPolicy(apiInstanceID int, policy anypointclient.ApiPolicyResponse) anypointclient.ApiPolicyResponse {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if policy.PolicyID == 0 {
+		policy.PolicyID = server.newID()
+	}
+	policy.APIID = apiInstanceID
+	server.policies[apiInstanceID] = append(server.policies[apiInstanceID], policy)
+	return policy
+}
+
+// Policies returns the policies of an API instance
+func (server *Server) Policies(apiInstanceID int) []anypointclient.ApiPolicyResponse {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return slices.Clone(server.policies[apiInstanceID])
+}
+
+func (server *Server) registerApiManager() {
+	server.handle("GET /apimanager/xapi/v1/organizations/{orgID}/environments/{envID}/apis", server.listApis)
+	apis := "/apimanager/api/v1/organizations/{orgID}/environments/{envID}/apis"
+	server.handle("POST "+apis, server.createApi)
+	server.handle("GET "+apis+"/{apiID}", server.getApi)
+	server.handle("PATCH "+apis+"/{apiID}", server.updateApi)
+	policies := "/apimanager/api/v1/organizations/{orgID}/environments/{envID}/apis/{apiID}/policies"
+	server.handle("GET "+policies, server.listPolicies)
+	server.handle("POST "+policies, server.createPolicy)
+	server.handle("PATCH "+policies, server.reorderPolicies)
+	server.handle("PATCH "+policies+"/{policyID}", server.updatePolicy)
+	server.handle("DELETE "+policies+"/{policyID}", server.deletePolicy)
+}
+
+func (server *Server) listApis(w http.ResponseWriter, r *http.Request) {
+	instances := server.apis[r.PathValue("envID")]
+	writeJSON(w, http.StatusOK, anypointclient.ApiListResponse{
+		Total:     len(instances),
+		Instances: page(instances, queryInt(r, "offset"), queryInt(r, "limit")),
+	})
+}
+
+func (server *Server) createApi(w http.ResponseWriter, r *http.Request) {
+	var request anypointclient.ApiInstanceRequest
+	if !readJSON(w, r, &request) {
+		return
+	}
+	envID := r.PathValue("envID")
+	instance := applyApiInstance(anypointclient.ApiInstance{ID: server.newID(), OrganizationID: r.PathValue("orgID"), EnvironmentID: envID}, request)
+	server.apis[envID] = append(server.apis[envID], instance)
+	writeJSON(w, http.StatusCreated, instance)
+}
+
+func (server *Server) getApi(w http.ResponseWriter, r *http.Request) {
+	if i, ok := server.apiIndex(w, r); ok {
+		writeJSON(w, http.StatusOK, server.apis[r.PathValue("envID")][i])
+	}
+}
+
+// updateApi changes the asset version, endpoint, technology and label of the API instance
+func (server *Server) updateApi(w http.ResponseWriter, r *http.Request) {
+	i, ok := server.apiIndex(w, r)
+	if !ok {
+		return
+	}
+	var update struct {
+		AssetVersion  string                             `json:"assetVersion"`
+		Endpoint      anypointclient.ApiInstanceEndpoint `json:"endpoint"`
+		Technology    string                             `json:"technology"`
+		InstanceLabel string                             `json:"instanceLabel"`
+	}
+	if !readJSON(w, r, &update) {
+		return
+	}
+	instances := server.apis[r.PathValue("envID")]
+	instances[i] = applyApiInstance(instances[i], anypointclient.ApiInstanceRequest{
+		Spec:          anypointclient.ApiInstanceAsset{GroupID: instances[i].GroupID, AssetID: instances[i].AssetID, Version: update.AssetVersion},
+		Endpoint:      update.Endpoint,
+		Technology:    update.Technology,
+		InstanceLabel: update.InstanceLabel,
+	})
+	writeJSON(w, http.StatusOK, instances[i])
+}
+
+// apiIndex returns the index of the API instance in the path, answering 404 Not Found if there is none
+func (server *Server) apiIndex(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("apiID"))
+	i := slices.IndexFunc(server.apis[r.PathValue("envID")], func(instance anypointclient.ApiInstance) bool { return instance.ID == id })
+	if err != nil || i < 0 {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("API %s not found", r.PathValue("apiID")))
+		return 0, false
+	}
+	return i, true
+}
+
+// applyApiInstance returns the API instance with the asset, endpoint and technology of the request
+func applyApiInstance(instance anypointclient.ApiInstance, request anypointclient.ApiInstanceRequest) anypointclient.ApiInstance {
+	instance.GroupID = request.Spec.GroupID
+	instance.AssetID = request.Spec.AssetID
+	instance.AssetVersion = request.Spec.Version
+	instance.ProductVersion = "v" + strings.SplitN(request.Spec.Version, ".", 2)[0]
+	instance.Technology = request.Technology
+	instance.InstanceLabel = request.InstanceLabel
+	instance.EndpointURI = request.Endpoint.URI
+	endpoint := request.Endpoint
+	instance.Endpoint = &endpoint
+	instance.AutodiscoveryInstanceName = fmt.Sprintf("%s:%d", instance.ProductVersion, instance.ID)
+	return instance
+}
+
+// apiInstance returns the API instance in the path, answering 404 Not Found if there is none
+func (server *Server) apiInstance(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("apiID"))
+	if err == nil && slices.ContainsFunc(server.apis[r.PathValue("envID")], func(instance anypointclient.ApiInstance) bool { return instance.ID == id }) {
+		return id, true
+	}
+	if _, ok := server.policies[id]; err == nil && ok {
+		return id, true
+	}
+	writeError(w, http.StatusNotFound, fmt.Sprintf("API %s not found", r.PathValue("apiID")))
+	return 0, false
+}
+
+func (server *Server) listPolicies(w http.ResponseWriter, r *http.Request) {
+	if id, ok := server.apiInstance(w, r); ok {
+		policies := server.policies[id]
+		if policies == nil {
+			policies = []anypointclient.ApiPolicyResponse{}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"policies": policies})
+	}
+}
+
+func (server *Server) createPolicy(w http.ResponseWriter, r *http.Request) {
+	id, ok := server.apiInstance(w, r)
+	if !ok {
+		return
+	}
+	var request anypointclient.ApiPolicyRequest
+	if !readJSON(w, r, &request) {
+		return
+	}
+	policy := applyPolicy(anypointclient.ApiPolicyResponse{PolicyID: server.newID(), APIID: id, OrganizationID: r.PathValue("orgID")}, request)
+	if policy.Order == 0 {
+		policy.Order = len(server.policies[id]) + 1
+	}
+	server.policies[id] = append(server.policies[id], policy)
+	writeJSON(w, http.StatusCreated, policy)
+}
+
+func (server *Server) updatePolicy(w http.ResponseWriter, r *http.Request) {
+	id, ok := server.apiInstance(w, r)
+	if !ok {
+		return
+	}
+	policyID, _ := strconv.Atoi(r.PathValue("policyID"))
+	policies := server.policies[id]
+	i := slices.IndexFunc(policies, func(policy anypointclient.ApiPolicyResponse) bool { return policy.PolicyID == policyID })
+	if i < 0 {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Policy %s not found", r.PathValue("policyID")))
+		return
+	}
+	var request anypointclient.ApiPolicyRequest
+	if !readJSON(w, r, &request) {
+		return
+	}
+	order := policies[i].Order
+	policies[i] = applyPolicy(policies[i], request)
+	if policies[i].Order == 0 {
+		policies[i].Order = order
+	}
+	writeJSON(w, http.StatusOK, policies[i])
+}
+
+func (server *Server) deletePolicy(w http.ResponseWriter, r *http.Request) {
+	id, ok := server.apiInstance(w, r)
+	if !ok {
+		return
+	}
+	policyID, _ := strconv.Atoi(r.PathValue("policyID"))
+	i := slices.IndexFunc(server.policies[id], func(policy anypointclient.ApiPolicyResponse) bool { return policy.PolicyID == policyID })
+	if i < 0 {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Policy %s not found", r.PathValue("policyID")))
+		return
+	}
+	server.policies[id] = slices.Delete(server.policies[id], i, i+1)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reorderPolicies sets the order of the policies. Every policy must exist.
+func (server *Server) reorderPolicies(w http.ResponseWriter, r *http.Request) {
+	id, ok := server.apiInstance(w, r)
+	if !ok {
+		return
+	}
+	var order []anypointclient.ApiPolicyOrder
+	if !readJSON(w, r, &order) {
+		return
+	}
+	policies := server.policies[id]
+	for _, policyOrder := range order {
+		if !slices.ContainsFunc(policies, func(policy anypointclient.ApiPolicyResponse) bool { return policy.PolicyID == policyOrder.ID }) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("Policy %d not found", policyOrder.ID))
+			return
+		}
+	}
+	for _, policyOrder := range order {
+		i := slices.IndexFunc(policies, func(policy anypointclient.ApiPolicyResponse) bool { return policy.PolicyID == policyOrder.ID })
+		policies[i].Order = policyOrder.Order
+	}
+	writeJSON(w, http.StatusOK, policies)
+}
+
+// applyPolicy returns the policy with the template and configuration of the request
+func applyPolicy(policy anypointclient.ApiPolicyResponse, request anypointclient.ApiPolicyRequest) anypointclient.ApiPolicyResponse {
+	policy.Template.GroupID = request.GroupID
+	policy.Template.AssetID = request.AssetID
+	policy.Template.AssetVersion = request.AssetVersion
+	policy.Configuration = request.ConfigurationData
+	policy.Order = request.Order
+	policy.Disabled = request.Disabled
+	policy.PointcutData = request.PointcutData
+	policy.Standalone = request.Standalone
+	return policy
+}
@@ -0,0 +1,225 @@
+package anypointfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient"
+)
+
+// maskedValue replaces the values of secure properties in the responses, like Anypoint Platform does
+const maskedValue = "****"
+
+// deployment is a deployment in an environment, with the schedulers found in the source code of the application
+type deployment struct {
+	environmentID string
+	state         anypointclient.CloudhubDeploymentResp
+	schedulers    []anypointclient.Schedule
+	sourceSet     bool
+}
+
+// AddDeployment adds a deployment to the environment. A deployment without ID is given one.
+func (server *Server) AddDeployment(environment anypointclient.Environment, state anypointclient.CloudhubDeploymentResp) anypointclient.CloudhubDeploymentResp {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if state.ID == "" {
+		state.ID = fmt.Sprintf("deployment-%d", server.newID())
+	}
+	server.deployments = append(server.deployments, &deployment{environmentID: environment.ID, state: state})
+	return state
+}
+
+// Deployment returns the deployment in the environment by name, and whether it exists
+func (server *Server) Deployment(environment anypointclient.Environment, deploymentName string) (anypointclient.CloudhubDeploymentResp, bool) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for _, deployment := range server.deployments {
+		if deployment.environmentID == environment.ID && deployment.state.Name == deploymentName {
+			return deployment.state, true
+		}
+	}
+	return anypointclient.CloudhubDeploymentResp{}, false
+}
+
+// SetSourceSchedulers sets the schedulers found in the source code of the deployment. Unless they are set,
+// the source code has the schedulers the deployment was configured with.
+func (server *Server) SetSourceSchedulers(environment anypointclient.Environment, deploymentName string, schedulers []anypointclient.Schedule) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for _, deployment := range server.deployments {
+		if deployment.environmentID == environment.ID && deployment.state.Name == deploymentName {
+			deployment.schedulers = schedulers
+			deployment.sourceSet = true
+		}
+	}
+}
+
+// FailRollout makes the rollouts of every deployment of the application version fail, the replicas reporting reason
+func (server *Server) FailRollout(version string, reason string) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.failedRollouts[version] = reason
+}
+
+func (server *Server) registerCloudHub() {
+	deployments := "/amc/application-manager/api/v2/organizations/{orgID}/environments/{envID}/deployments"
+	server.handle("GET "+deployments, server.listDeployments)
+	server.handle("POST "+deployments, server.createDeployment)
+	server.handle("GET "+deployments+"/{deploymentID}", server.getDeployment)
+	server.handle("PATCH "+deployments+"/{deploymentID}", server.updateDeployment)
+	server.handle("DELETE "+deployments+"/{deploymentID}", server.deleteDeployment)
+	server.handle("GET "+deployments+"/{deploymentID}/schedulers", server.listSchedulers)
+}
+
+// findDeployment returns the deployment in the path, answering 404 Not Found if there is none
+func (server *Server) findDeployment(w http.ResponseWriter, r *http.Request) (int, *deployment) {
+	for i, deployment := range server.deployments {
+		if deployment.environmentID == r.PathValue("envID") && deployment.state.ID == r.PathValue("deploymentID") {
+			return i, deployment
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Sprintf("Deployment %s not found", r.PathValue("deploymentID")))
+	return -1, nil
+}
+
+func (server *Server) listDeployments(w http.ResponseWriter, r *http.Request) {
+	var items []anypointclient.Deployment
+	for _, deployment := range server.deployments {
+		if deployment.environmentID != r.PathValue("envID") {
+			continue
+		}
+		item := anypointclient.Deployment{
+			ID:                    deployment.state.ID,
+			Name:                  deployment.state.Name,
+			CreationDate:          deployment.state.CreationDate,
+			LastModifiedDate:      deployment.state.LastModifiedDate,
+			Status:                deployment.state.Status,
+			CurrentRuntimeVersion: deployment.state.Target.DeploymentSettings.Runtime.Version,
+		}
+		item.Target.Provider = deployment.state.Target.Provider
+		item.Target.TargetID = deployment.state.Target.TargetID
+		item.Application.Status = deployment.state.Application.Status
+		items = append(items, item)
+	}
+	writeJSON(w, http.StatusOK, anypointclient.CloudhubDeploymentsResp{
+		Total:      len(items),
+		Deloyments: page(items, queryInt(r, "offset"), queryInt(r, "limit")),
+	})
+}
+
+func (server *Server) getDeployment(w http.ResponseWriter, r *http.Request) {
+	if _, deployment := server.findDeployment(w, r); deployment != nil {
+		writeJSON(w, http.StatusOK, deployment.state)
+	}
+}
+
+func (server *Server) createDeployment(w http.ResponseWriter, r *http.Request) {
+	var request anypointclient.CloudhubDeploymentReq
+	if !readJSON(w, r, &request) {
+		return
+	}
+	for _, deployment := range server.deployments {
+		if deployment.environmentID == r.PathValue("envID") && deployment.state.Name == request.Name {
+			writeError(w, http.StatusConflict, fmt.Sprintf("Deployment %s already exists", request.Name))
+			return
+		}
+	}
+	created := &deployment{environmentID: r.PathValue("envID")}
+	created.state.ID = fmt.Sprintf("deployment-%d", server.newID())
+	if !server.rollOut(w, created, request) {
+		return
+	}
+	server.deployments = append(server.deployments, created)
+	writeJSON(w, http.StatusAccepted, created.state)
+}
+
+func (server *Server) updateDeployment(w http.ResponseWriter, r *http.Request) {
+	_, deployment := server.findDeployment(w, r)
+	if deployment == nil {
+		return
+	}
+	var request anypointclient.CloudhubDeploymentReq
+	if !readJSON(w, r, &request) {
+		return
+	}
+	if server.rollOut(w, deployment, request) {
+		writeJSON(w, http.StatusOK, deployment.state)
+	}
+}
+
+func (server *Server) deleteDeployment(w http.ResponseWriter, r *http.Request) {
+	if i, _ := server.findDeployment(w, r); i >= 0 {
+		server.deployments = append(server.deployments[:i], server.deployments[i+1:]...)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (server *Server) listSchedulers(w http.ResponseWriter, r *http.Request) {
+	if _, deployment := server.findDeployment(w, r); deployment != nil {
+		schedulers := deployment.schedulers
+		if !deployment.sourceSet {
+			schedulers = deployment.state.Application.Configuration.MuleAgentScheduleService.Schedulers
+		}
+		writeJSON(w, http.StatusOK, anypointclient.ScheduleResp{Total: len(schedulers), Items: schedulers})
+	}
+}
+
+// rollOut replaces the state of the deployment with the requested one, as it is once rolled out. The rollout
+// fails if the application version is made to fail with FailRollout.
+func (server *Server) rollOut(w http.ResponseWriter, deployment *deployment, request anypointclient.CloudhubDeploymentReq) bool {
+	data, err := json.Marshal(request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	var state anypointclient.CloudhubDeploymentResp
+	if err := json.Unmarshal(data, &state); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+
+	properties := &state.Application.Configuration.MuleAgentApplicationPropertiesService
+	for key := range properties.SecureProperties {
+		properties.SecureProperties[key] = maskedValue
+	}
+	now := time.Now().UnixMilli()
+	state.ID = deployment.state.ID
+	state.CreationDate = deployment.state.CreationDate
+	if state.CreationDate == 0 {
+		state.CreationDate = now
+	}
+	state.LastModifiedDate = now
+	state.Status = "APPLIED"
+	state.DesiredVersion = fmt.Sprintf("version-%d", server.newID())
+	state.LastSuccessfulVersion = deployment.state.LastSuccessfulVersion
+
+	reason, failed := server.failedRollouts[request.Application.Ref.Version]
+	switch {
+	case failed:
+		state.Application.Status = "DEPLOYMENT_FAILED"
+	case request.Application.DesiredState == "STOPPED":
+		state.Application.Status = "NOT_RUNNING"
+		state.LastSuccessfulVersion = state.DesiredVersion
+	default:
+		state.Application.Status = "RUNNING"
+		state.LastSuccessfulVersion = state.DesiredVersion
+	}
+	if request.Application.DesiredState != "STOPPED" {
+		for i := range max(request.Target.Replicas, 1) {
+			replica := anypointclient.DeploymentReplica{
+				ID:                       fmt.Sprintf("%s-replica-%d", state.ID, i),
+				State:                    "STARTED",
+				CurrentDeploymentVersion: state.DesiredVersion,
+			}
+			if failed {
+				replica.State = "FAILED"
+				replica.Reason = reason
+			}
+			state.Replicas = append(state.Replicas, replica)
+		}
+	}
+	deployment.state = state
+	return true
+}
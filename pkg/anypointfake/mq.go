@@ -0,0 +1,265 @@
+package anypointfake
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient"
+)
+
+// mqKey is the key of the MQ destinations and bindings of a region of an environment
+func mqKey(orgID, envID, region string) string {
+	return orgID + "/" + envID + "/" + region
+}
+
+// AddMqDestination adds a queue or exchange to the region of the environment
+func (server *Server) AddMqDestination(orgID, envID, region string, destination anypointclient.MqDestination) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.putDestination(mqKey(orgID, envID, region), destination)
+}
+
+// MqDestination returns the queue or exchange with the given ID, and whether it exists
+func (server *Server) MqDestination(orgID, envID, region, destinationID string) (anypointclient.MqDestination, bool) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	destination, ok := server.destinations[mqKey(orgID, envID, region)][destinationID]
+	return destination, ok
+}
+
+// MqBindings returns the bindings of an exchange
+func (server *Server) MqBindings(orgID, envID, region, exchangeID string) []anypointclient.MqBinding {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return slices.Clone(server.bindings[mqKey(orgID, envID, region)+"/"+exchangeID])
+}
+
+// SetMqQueueMessages sets the number of messages waiting and in flight in a queue, as returned by the stats API
+func (server *Server) SetMqQueueMessages(orgID, envID, region, queueID string, messages, inflightMessages int64) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.queueStats[mqKey(orgID, envID, region)+"/"+queueID] = anypointclient.MqQueueStats{
+		Destination:      queueID,
+		Messages:         messages,
+		InflightMessages: inflightMessages,
+	}
+}
+
+// putDestination adds or replaces a queue or exchange. The lock must be held.
+func (server *Server) putDestination(key string, destination anypointclient.MqDestination) {
+	if server.destinations[key] == nil {
+		server.destinations[key] = map[string]anypointclient.MqDestination{}
+	}
+	id := destination.QueueID
+	if destination.Type == "exchange" {
+		id = destination.ExchangeID
+	}
+	server.destinations[key][id] = destination
+}
+
+func (server *Server) registerMq() {
+	region := "/mq/admin/api/v1/organizations/{orgID}/environments/{envID}/regions/{region}"
+	server.handle("GET "+region+"/destinations", server.listDestinations)
+	server.handle("GET "+region+"/destinations/queues/{queueID}", server.getDestination("queue", "queueID"))
+	server.handle("PUT "+region+"/destinations/queues/{queueID}", server.putQueue)
+	server.handle("PATCH "+region+"/destinations/queues/{queueID}", server.patchQueue)
+	server.handle("DELETE "+region+"/destinations/queues/{queueID}", server.deleteDestination("queue", "queueID"))
+	server.handle("GET "+region+"/destinations/exchanges/{exchangeID}", server.getDestination("exchange", "exchangeID"))
+	server.handle("PUT "+region+"/destinations/exchanges/{exchangeID}", server.putExchange)
+	server.handle("DELETE "+region+"/destinations/exchanges/{exchangeID}", server.deleteDestination("exchange", "exchangeID"))
+	server.handle("GET "+region+"/bindings/exchanges/{exchangeID}", server.listBindings)
+	server.handle("PUT "+region+"/bindings/exchanges/{exchangeID}/queues/{queueID}", server.putBinding)
+	server.handle("DELETE "+region+"/bindings/exchanges/{exchangeID}/queues/{queueID}", server.deleteBinding)
+	server.handle("PUT "+region+"/bindings/exchanges/{exchangeID}/queues/{queueID}/rules/routing", server.putRoutingRules)
+	server.handle("GET /mq/stats/api/v1/organizations/{orgID}/environments/{envID}/regions/{region}/queues", server.queueStatistics)
+}
+
+// regionKey returns the key of the region in the path
+func regionKey(r *http.Request) string {
+	return mqKey(r.PathValue("orgID"), r.PathValue("envID"), r.PathValue("region"))
+}
+
+func (server *Server) listDestinations(w http.ResponseWriter, r *http.Request) {
+	destinations := server.destinations[regionKey(r)]
+	all := []anypointclient.MqDestination{}
+	for _, id := range slices.Sorted(maps.Keys(destinations)) {
+		all = append(all, destinations[id])
+	}
+	writeJSON(w, http.StatusOK, page(all, queryInt(r, "offset"), queryInt(r, "limit")))
+}
+
+// getDestination returns a handler of the queues or exchanges, identified by the path value idName
+func (server *Server) getDestination(destinationType string, idName string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		destination, ok := server.destinations[regionKey(r)][r.PathValue(idName)]
+		if !ok || destination.Type != destinationType {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("Destination %s not found", r.PathValue(idName)))
+			return
+		}
+		writeJSON(w, http.StatusOK, destination)
+	}
+}
+
+// deleteDestination returns a handler deleting the queues or exchanges, identified by the path value idName,
+// together with their bindings
+func (server *Server) deleteDestination(destinationType string, idName string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue(idName)
+		if destination, ok := server.destinations[regionKey(r)][id]; !ok || destination.Type != destinationType {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("Destination %s not found", id))
+			return
+		}
+		delete(server.destinations[regionKey(r)], id)
+		if destinationType == "exchange" {
+			delete(server.bindings, regionKey(r)+"/"+id)
+		} else {
+			delete(server.queueStats, regionKey(r)+"/"+id)
+			for key, bindings := range server.bindings {
+				if strings.HasPrefix(key, regionKey(r)+"/") {
+					server.bindings[key] = slices.DeleteFunc(bindings, func(binding anypointclient.MqBinding) bool { return binding.QueueID == id })
+				}
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// queueStatistics returns the messages in the queues listed in destinationIds. Queues are empty unless set
+// otherwise with SetMqQueueMessages.
+func (server *Server) queueStatistics(w http.ResponseWriter, r *http.Request) {
+	stats := []anypointclient.MqQueueStats{}
+	for _, id := range strings.Split(r.URL.Query().Get("destinationIds"), ",") {
+		if destination, ok := server.destinations[regionKey(r)][id]; !ok || destination.Type != "queue" {
+			continue
+		}
+		queueStats, ok := server.queueStats[regionKey(r)+"/"+id]
+		if !ok {
+			queueStats = anypointclient.MqQueueStats{Destination: id}
+		}
+		stats = append(stats, queueStats)
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// putQueue creates a queue. Anypoint MQ encrypts queues unless told otherwise.
+func (server *Server) putQueue(w http.ResponseWriter, r *http.Request) {
+	var queue anypointclient.MqQueue
+	if !readJSON(w, r, &queue) {
+		return
+	}
+	queue.QueueID = r.PathValue("queueID")
+	status := http.StatusCreated
+	if _, ok := server.destinations[regionKey(r)][queue.QueueID]; ok {
+		status = http.StatusOK
+	}
+	destination := queueDestination(queue)
+	server.putDestination(regionKey(r), destination)
+	writeJSON(w, status, destination)
+}
+
+func (server *Server) patchQueue(w http.ResponseWriter, r *http.Request) {
+	if existing, ok := server.destinations[regionKey(r)][r.PathValue("queueID")]; !ok || existing.Type != "queue" {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Queue %s not found", r.PathValue("queueID")))
+		return
+	}
+	var queue anypointclient.MqQueue
+	if !readJSON(w, r, &queue) {
+		return
+	}
+	queue.QueueID = r.PathValue("queueID")
+	destination := queueDestination(queue)
+	server.putDestination(regionKey(r), destination)
+	writeJSON(w, http.StatusOK, destination)
+}
+
+// queueDestination returns the queue as listed by Anypoint MQ
+func queueDestination(queue anypointclient.MqQueue) anypointclient.MqDestination {
+	return anypointclient.MqDestination{
+		Type:                 "queue",
+		QueueID:              queue.QueueID,
+		Fifo:                 queue.Fifo,
+		Encrypted:            queue.IsEncrypted(),
+		MaxDeliveries:        queue.MaxDeliveries,
+		DeadLetterQueueID:    queue.DeadLetterQueueID,
+		IsFallback:           queue.IsFallback,
+		DefaultTtl:           queue.DefaultTtl,
+		DefaultLockTtl:       queue.DefaultLockTtl,
+		DefaultDeliveryDelay: queue.DefaultDeliveryDelay,
+	}
+}
+
+// putExchange creates an exchange, or replaces its settings if it exists
+func (server *Server) putExchange(w http.ResponseWriter, r *http.Request) {
+	var exchange anypointclient.MqExchange
+	if !readJSON(w, r, &exchange) {
+		return
+	}
+	exchange.ExchangeID = r.PathValue("exchangeID")
+	status := http.StatusCreated
+	if _, ok := server.destinations[regionKey(r)][exchange.ExchangeID]; ok {
+		status = http.StatusOK
+	}
+	destination := anypointclient.MqDestination{Type: "exchange", ExchangeID: exchange.ExchangeID, Fifo: exchange.Fifo, Encrypted: exchange.IsEncrypted()}
+	server.putDestination(regionKey(r), destination)
+	writeJSON(w, status, destination)
+}
+
+func (server *Server) listBindings(w http.ResponseWriter, r *http.Request) {
+	if destination, ok := server.destinations[regionKey(r)][r.PathValue("exchangeID")]; !ok || destination.Type != "exchange" {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Exchange %s not found", r.PathValue("exchangeID")))
+		return
+	}
+	bindings := server.bindings[regionKey(r)+"/"+r.PathValue("exchangeID")]
+	if bindings == nil {
+		bindings = []anypointclient.MqBinding{}
+	}
+	writeJSON(w, http.StatusOK, bindings)
+}
+
+// putBinding binds a queue to an exchange. Both must exist.
+func (server *Server) putBinding(w http.ResponseWriter, r *http.Request) {
+	exchangeID, queueID := r.PathValue("exchangeID"), r.PathValue("queueID")
+	destinations := server.destinations[regionKey(r)]
+	if destination, ok := destinations[exchangeID]; !ok || destination.Type != "exchange" {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Exchange %s not found", exchangeID))
+		return
+	}
+	if destination, ok := destinations[queueID]; !ok || destination.Type != "queue" {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Queue %s not found", queueID))
+		return
+	}
+	key := regionKey(r) + "/" + exchangeID
+	if !slices.ContainsFunc(server.bindings[key], func(binding anypointclient.MqBinding) bool { return binding.QueueID == queueID }) {
+		server.bindings[key] = append(server.bindings[key], anypointclient.MqBinding{QueueID: queueID, ExchangeID: exchangeID})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (server *Server) deleteBinding(w http.ResponseWriter, r *http.Request) {
+	key := regionKey(r) + "/" + r.PathValue("exchangeID")
+	i := slices.IndexFunc(server.bindings[key], func(binding anypointclient.MqBinding) bool { return binding.QueueID == r.PathValue("queueID") })
+	if i < 0 {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Binding of queue %s not found", r.PathValue("queueID")))
+		return
+	}
+	server.bindings[key] = slices.Delete(server.bindings[key], i, i+1)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (server *Server) putRoutingRules(w http.ResponseWriter, r *http.Request) {
+	bindings := server.bindings[regionKey(r)+"/"+r.PathValue("exchangeID")]
+	i := slices.IndexFunc(bindings, func(binding anypointclient.MqBinding) bool { return binding.QueueID == r.PathValue("queueID") })
+	if i < 0 {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Binding of queue %s not found", r.PathValue("queueID")))
+		return
+	}
+	var request anypointclient.MqRoutingRulesRequest
+	if !readJSON(w, r, &request) {
+		return
+	}
+	bindings[i].RoutingRules = request.RoutingRules
+	writeJSON(w, http.StatusOK, bindings[i])
+}
@@ -0,0 +1,233 @@
+// Package anypointfake runs a fake Anypoint Platform in an httptest.Server with the state of one organization in
+// memory, implementing the endpoints used by this tool. Failures are injected with Inject and FailRollout.
+package anypointfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient"
+)
+
+// Server is a fake Anypoint Platform. The zero value is not usable, create one with NewServer.
+type Server struct {
+	*httptest.Server
+
+	mu             sync.Mutex
+	mux            *http.ServeMux
+	organization   anypointclient.Organization
+	environments   []anypointclient.Environment
+	privateSpaces  []anypointclient.PrivateSpace
+	tokens         map[string]bool
+	deployments    []*deployment
+	failedRollouts map[string]string
+	apis           map[string][]anypointclient.ApiInstance
+	policies       map[int][]anypointclient.ApiPolicyResponse
+	destinations   map[string]map[string]anypointclient.MqDestination
+	bindings       map[string][]anypointclient.MqBinding
+	queueStats     map[string]anypointclient.MqQueueStats
+	failures       []*Failure
+	requests       []Request
+	lastID         int
+}
+
+// Request is a request received by the server
+type Request struct {
+	Method string
+	Path   string
+}
+
+// Failure is a response sent instead of the real one to the requests matching Method and Path. With Drop set the
+// connection is closed without a response, like a network error.
+type Failure struct {
+	Method string // HTTP method of the failed requests, every method if empty
+	Path   string // regular expression matched against the path of the failed requests, every path if empty
+	Status int
+	Header http.Header // e.g. Retry-After
+	Body   string
+	Drop   bool
+	Times  int // number of requests to fail, every one if 0
+
+	path *regexp.Regexp
+}
+
+// NewServer starts a fake of the organization, including its sub organizations. Close it when done.
+func NewServer(organization anypointclient.Organization) *Server {
+	server := &Server{
+		mux:            http.NewServeMux(),
+		organization:   organization,
+		tokens:         map[string]bool{},
+		failedRollouts: map[string]string{},
+		apis:           map[string][]anypointclient.ApiInstance{},
+		policies:       map[int][]anypointclient.ApiPolicyResponse{},
+		destinations:   map[string]map[string]anypointclient.MqDestination{},
+		bindings:       map[string][]anypointclient.MqBinding{},
+		queueStats:     map[string]anypointclient.MqQueueStats{},
+	}
+	server.registerAccounts()
+	server.registerCloudHub()
+	server.registerApiManager()
+	server.registerMq()
+	server.Server = httptest.NewServer(http.HandlerFunc(server.serveHTTP))
+	return server
+}
+
+// Inject makes the server fail the requests matching the failure. Failures are tried in the order they were
+// injected. It panics if Path is not a valid regular expression.
+func (server *Server) Inject(failure Failure) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	failure.path = regexp.MustCompile(failure.Path)
+	if failure.Status == 0 {
+		failure.Status = http.StatusInternalServerError
+	}
+	server.failures = append(server.failures, &failure)
+}
+
+// Reset removes every injected failure and failing rollout and forgets the received requests. The state is kept.
+func (server *Server) Reset() {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.failures = nil
+	server.failedRollouts = map[string]string{}
+	server.requests = nil
+}
+
+// Requests returns the requests received, in the order they were received
+func (server *Server) Requests() []Request {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return slices.Clone(server.requests)
+}
+
+// ExpireTokens invalidates every bearer token issued, so that the next requests are answered 401 Unauthorized
+func (server *Server) ExpireTokens() {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.tokens = map[string]bool{}
+}
+
+// AddToken makes the server accept a bearer token that it did not issue, e.g. one given with --bearer
+func (server *Server) AddToken(token string) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.tokens[token] = true
+}
+
+// serveHTTP records the request, fails it if a failure is injected, and otherwise serves it. The client sends
+// some paths with a double slash, which are cleaned before they are routed.
+func (server *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	r.URL.Path = path.Clean(r.URL.Path)
+	r.URL.RawPath = ""
+
+	server.mu.Lock()
+	server.requests = append(server.requests, Request{Method: r.Method, Path: r.URL.Path})
+	failure := server.failure(r)
+	server.mu.Unlock()
+
+	if failure != nil {
+		writeFailure(w, failure)
+		return
+	}
+	server.mux.ServeHTTP(w, r)
+}
+
+// failure returns the first injected failure matching the request, and forgets it if it has failed enough
+// requests. The lock must be held.
+func (server *Server) failure(r *http.Request) *Failure {
+	for i, failure := range server.failures {
+		if (failure.Method != "" && failure.Method != r.Method) || !failure.path.MatchString(r.URL.Path) {
+			continue
+		}
+		if failure.Times > 0 {
+			failure.Times--
+			if failure.Times == 0 {
+				server.failures = slices.Delete(server.failures, i, i+1)
+			}
+		}
+		return failure
+	}
+	return nil
+}
+
+func writeFailure(w http.ResponseWriter, failure *Failure) {
+	if failure.Drop {
+		if hijacker, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hijacker.Hijack(); err == nil {
+				conn.Close()
+				return
+			}
+		}
+	}
+	for name, values := range failure.Header {
+		w.Header()[name] = values
+	}
+	if failure.Body != "" {
+		w.WriteHeader(failure.Status)
+		fmt.Fprint(w, failure.Body)
+		return
+	}
+	writeError(w, failure.Status, fmt.Sprintf("injected failure: %s", http.StatusText(failure.Status)))
+}
+
+// handle registers a handler that is only called with a bearer token issued by the server
+func (server *Server) handle(pattern string, handler http.HandlerFunc) {
+	server.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		server.mu.Lock()
+		authorized := ok && server.tokens[token]
+		server.mu.Unlock()
+		if !authorized {
+			writeError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		handler(w, r)
+	})
+}
+
+// newID returns a new ID, unique within the server. The lock must be held.
+func (server *Server) newID() int {
+	server.lastID++
+	return server.lastID
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeError writes an error response in the format used by Anypoint Platform
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
+
+// readJSON decodes the request body, answering 400 Bad Request if it is not valid
+func readJSON(w http.ResponseWriter, r *http.Request, target any) bool {
+	if err := json.NewDecoder(r.Body).Decode(target); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return false
+	}
+	return true
+}
+
+// page returns a copy of the items from offset, at most limit of them. A limit of 0 means every item.
+func page[T any](items []T, offset int, limit int) []T {
+	if offset >= len(items) {
+		return []T{}
+	}
+	end := len(items)
+	if limit > 0 {
+		end = min(offset+limit, end)
+	}
+	return slices.Clone(items[offset:end])
+}
@@ -0,0 +1,220 @@
+package anypointfake_test
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointclient"
+	"github.com/Redpill-Linpro/anypointchdeployer/pkg/anypointfake"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	organization := anypointclient.Organization{ID: "org-id", Name: "Root", SubOrganizations: []anypointclient.Organization{{ID: "sub-id", Name: "Sub"}}}
+	environment := anypointclient.Environment{ID: "env-id", Name: "Sandbox", OrganizationID: "org-id"}
+	var server *anypointfake.Server
+	var client *anypointclient.AnypointClient
+
+	BeforeEach(func() {
+		server = anypointfake.NewServer(organization)
+		server.AddEnvironment(environment)
+		server.AddPrivateSpace(anypointclient.PrivateSpace{ID: "ps-id", Name: "Private", OrganizationID: "org-id"})
+		client = anypointclient.NewAnypointClientWithCredentials("user", "password", server.URL, "")
+		client.RetryPolicy.InitialBackoff = time.Millisecond
+		client.RetryPolicy.MaxBackoff = time.Millisecond
+		client.RetryPolicy.Logf = func(string, ...any) {}
+		Ω(client.Login()).Should(Succeed())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	newDeployment := func(version string) anypointclient.CloudhubDeploymentReq {
+		var deployment anypointclient.CloudhubDeploymentReq
+		deployment.Name = "orders"
+		deployment.Target.Replicas = 2
+		deployment.Application.Ref.Version = version
+		deployment.Application.DesiredState = "STARTED"
+		deployment.Application.Configuration.MuleAgentApplicationPropertiesService.SecureProperties = map[string]string{"password": "secret"}
+		deployment.Application.Configuration.MuleAgentScheduleService.Schedulers = []anypointclient.Schedule{{FlowName: "poll", Type: "FixedFrequency"}}
+		return deployment
+	}
+
+	It("resolves the organization, environments and private spaces", func() {
+		sub, err := client.ResolveOrganization("Root/Sub")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(sub.ID).Should(Equal("sub-id"))
+
+		env, err := client.ResolveEnvironment(organization, "Sandbox")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(env).Should(Equal(environment))
+
+		privateSpace, err := client.ResolvePrivateSpace(organization, "Private")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(privateSpace.ID).Should(Equal("ps-id"))
+	})
+
+	It("rejects logins without credentials and requests without a token", func() {
+		Ω(anypointclient.NewAnypointClientWithCredentials("user", "", server.URL, "").Login()).ShouldNot(Succeed())
+
+		unknown := anypointclient.NewAnypointClientWithToken("unknown", server.URL, "")
+		_, err := unknown.GetDeployments(environment)
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("creates, updates and deletes deployments", func() {
+		created, err := client.CreateDeployment(environment, anypointclient.PrivateSpace{}, newDeployment("1.0.0"))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(created.ID).ShouldNot(BeEmpty())
+
+		deployment, err := client.WaitForRollout(environment, "orders", time.Second, time.Millisecond)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(deployment.Replicas).Should(HaveLen(2))
+		Ω(deployment.Application.Configuration.MuleAgentApplicationPropertiesService.SecureProperties).Should(Equal(map[string]string{"password": "****"}))
+		Ω(client.SchedulesDiffFromSourceCode(environment, newDeployment("1.0.0"), created.ID)).Should(Succeed())
+
+		Ω(client.UpdateDeployment(environment, anypointclient.PrivateSpace{}, newDeployment("1.1.0"), created.ID)).Should(Succeed())
+		deployment, err = client.GetDeployment(environment, "orders")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(deployment.Application.Ref.Version).Should(Equal("1.1.0"))
+
+		Ω(client.DeleteDeployment(environment, anypointclient.PrivateSpace{}, created.ID)).Should(Succeed())
+		_, found := server.Deployment(environment, "orders")
+		Ω(found).Should(BeFalse())
+	})
+
+	It("reports schedulers missing from the source code", func() {
+		created, err := client.CreateDeployment(environment, anypointclient.PrivateSpace{}, newDeployment("1.0.0"))
+		Ω(err).ShouldNot(HaveOccurred())
+		server.SetSourceSchedulers(environment, "orders", nil)
+		Ω(client.SchedulesDiffFromSourceCode(environment, newDeployment("1.0.0"), created.ID)).ShouldNot(Succeed())
+	})
+
+	It("fails rollouts of a version", func() {
+		server.FailRollout("1.1.0", "CrashLoopBackOff")
+		created, err := client.CreateDeployment(environment, anypointclient.PrivateSpace{}, newDeployment("1.0.0"))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(client.UpdateDeployment(environment, anypointclient.PrivateSpace{}, newDeployment("1.1.0"), created.ID)).Should(Succeed())
+
+		deployment, err := client.WaitForRollout(environment, "orders", time.Second, time.Millisecond)
+		Ω(err).Should(HaveOccurred())
+		Ω(deployment.ReplicaReasons()).Should(ContainElement("CrashLoopBackOff"))
+	})
+
+	It("injects failures that the client retries", func() {
+		server.Inject(anypointfake.Failure{Method: "POST", Path: "/deployments$", Status: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"0"}}, Times: 1})
+		_, err := client.CreateDeployment(environment, anypointclient.PrivateSpace{}, newDeployment("1.0.0"))
+		Ω(err).ShouldNot(HaveOccurred())
+
+		var posts int
+		for _, request := range server.Requests() {
+			if request.Method == "POST" && request.Path == "/amc/application-manager/api/v2/organizations/org-id/environments/env-id/deployments" {
+				posts++
+			}
+		}
+		Ω(posts).Should(Equal(2))
+
+		server.Inject(anypointfake.Failure{Path: "/destinations$", Drop: true})
+		_, err = client.GetMqDestinations("org-id", "env-id", "us-east-1")
+		Ω(err).Should(HaveOccurred())
+		server.Reset()
+		_, err = client.GetMqDestinations("org-id", "env-id", "us-east-1")
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	It("answers 401 to expired tokens, after which the client logs in again", func() {
+		server.ExpireTokens()
+		_, err := client.GetDeployments(environment)
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	It("keeps API instances", func() {
+		request := anypointclient.ApiInstanceRequest{
+			Spec:       anypointclient.ApiInstanceAsset{GroupID: "org-id", AssetID: "orders-api", Version: "1.0.0"},
+			Endpoint:   anypointclient.ApiInstanceEndpoint{DeploymentType: "CH2", URI: "https://orders.example.com"},
+			Technology: "mule4",
+		}
+		created, err := client.CreateApiInstance("org-id", "env-id", request)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(created.ProductVersion).Should(Equal("v1"))
+
+		request.Spec.Version = "1.1.0"
+		_, err = client.UpdateApiInstance("org-id", "env-id", created.ID, request)
+		Ω(err).ShouldNot(HaveOccurred())
+		instance, err := client.GetApiInstance("org-id", "env-id", created.ID)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(instance.AssetVersion).Should(Equal("1.1.0"))
+		Ω(instance.Endpoint.URI).Should(Equal("https://orders.example.com"))
+
+		_, err = client.GetApiInstance("org-id", "env-id", 999)
+		Ω(err).Should(MatchError(anypointclient.ErrNotFound))
+	})
+
+	It("keeps API policies", func() {
+		api := server.AddApi(environment, anypointclient.ApiInstance{AssetID: "orders-api"})
+		apis, err := client.GetApis("org-id", "env-id", 0, 10)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(apis.Instances).Should(HaveLen(1))
+
+		Ω(client.CreateApiInstancePolicies("org-id", "env-id", api.ID, anypointclient.ApiPolicyRequest{GroupID: "g", AssetID: "rate-limiting", AssetVersion: "1.0.0"})).Should(Succeed())
+		policies, err := client.GetApiInstancePolicies("org-id", "env-id", api.ID)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(*policies).Should(HaveLen(1))
+
+		policy := (*policies)[0]
+		Ω(client.UpdateApiInstancePolicies("org-id", "env-id", api.ID, policy.PolicyID, anypointclient.ApiPolicyRequest{GroupID: "g", AssetID: "rate-limiting", AssetVersion: "1.1.0"})).Should(Succeed())
+		Ω(server.Policies(api.ID)[0].Template.AssetVersion).Should(Equal("1.1.0"))
+
+		Ω(client.ReorderApiInstancePolicies("org-id", "env-id", api.ID, []anypointclient.ApiPolicyOrder{{ID: policy.PolicyID, Order: 2}})).Should(Succeed())
+		Ω(server.Policies(api.ID)[0].Order).Should(Equal(2))
+		Ω(client.ReorderApiInstancePolicies("org-id", "env-id", api.ID, []anypointclient.ApiPolicyOrder{{ID: 999, Order: 1}})).Should(MatchError(anypointclient.ErrNotFound))
+
+		Ω(client.DeleteApiInstancePolicy("org-id", "env-id", api.ID, policy.PolicyID)).Should(Succeed())
+		Ω(server.Policies(api.ID)).Should(BeEmpty())
+	})
+
+	It("keeps MQ destinations and bindings", func() {
+		Ω(client.CreateMqQueue("org-id", "env-id", "us-east-1", anypointclient.MqQueue{QueueID: "orders", MaxDeliveries: 5})).Should(Succeed())
+		Ω(client.UpdateMqQueue("org-id", "env-id", "us-east-1", anypointclient.MqQueue{QueueID: "orders", MaxDeliveries: 10})).Should(Succeed())
+		queue, err := client.GetMqQueue("org-id", "env-id", "us-east-1", "orders")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(queue.MaxDeliveries).Should(Equal(10))
+		Ω(queue.Encrypted).Should(BeTrue())
+
+		Ω(client.CreateMqExchange("org-id", "env-id", "us-east-1", anypointclient.MqExchange{ExchangeID: "events"})).Should(Succeed())
+		Ω(client.CreateMqBinding("org-id", "env-id", "us-east-1", "events", "orders")).Should(Succeed())
+		rules := []anypointclient.MqRoutingRule{{PropertyName: "type", PropertyType: "STRING", MatcherType: "EQ", Value: "order"}}
+		Ω(client.UpdateMqBindingRoutingRules("org-id", "env-id", "us-east-1", "events", "orders", rules)).Should(Succeed())
+
+		bindings, err := client.GetMqExchangeBindings("org-id", "env-id", "us-east-1", "events")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(bindings).Should(Equal([]anypointclient.MqBinding{{QueueID: "orders", ExchangeID: "events", RoutingRules: rules}}))
+
+		destinations, err := client.GetMqDestinations("org-id", "env-id", "us-east-1")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(destinations).Should(HaveLen(2))
+
+		Ω(client.DeleteMqBinding("org-id", "env-id", "us-east-1", "events", "orders")).Should(Succeed())
+		Ω(server.MqBindings("org-id", "env-id", "us-east-1", "events")).Should(BeEmpty())
+	})
+
+	It("deletes MQ destinations and reports the messages in queues", func() {
+		server.AddMqDestination("org-id", "env-id", "us-east-1", anypointclient.MqDestination{Type: "queue", QueueID: "orders"})
+		server.AddMqDestination("org-id", "env-id", "us-east-1", anypointclient.MqDestination{Type: "exchange", ExchangeID: "events"})
+		server.SetMqQueueMessages("org-id", "env-id", "us-east-1", "orders", 3, 1)
+
+		stats, err := client.GetMqQueueStats("org-id", "env-id", "us-east-1", "orders")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(stats).Should(Equal(anypointclient.MqQueueStats{Destination: "orders", Messages: 3, InflightMessages: 1}))
+		Ω(stats.IsEmpty()).Should(BeFalse())
+
+		Ω(client.DeleteMqExchange("org-id", "env-id", "us-east-1", "events")).Should(Succeed())
+		Ω(client.DeleteMqQueue("org-id", "env-id", "us-east-1", "orders")).Should(Succeed())
+		Ω(client.DeleteMqQueue("org-id", "env-id", "us-east-1", "orders")).Should(Succeed(), "a deleted queue is already deleted")
+		destinations, err := client.GetMqDestinations("org-id", "env-id", "us-east-1")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(destinations).Should(BeEmpty())
+	})
+})